@@ -0,0 +1,11 @@
+package winlog
+
+import (
+	. "testing"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
@@ -0,0 +1,596 @@
+package winlog
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Binary XML tokens, see https://msdn.microsoft.com/en-us/library/cc231271.aspx
+const (
+	binxmlEndOfStream          = 0x00
+	binxmlOpenStartElement     = 0x01
+	binxmlCloseStartElement    = 0x02
+	binxmlCloseEmptyElement    = 0x03
+	binxmlEndElement           = 0x04
+	binxmlValue                = 0x05
+	binxmlAttribute            = 0x06
+	binxmlCDataSection         = 0x07
+	binxmlCharRef              = 0x08
+	binxmlEntityRef            = 0x09
+	binxmlPITarget             = 0x0a
+	binxmlPIData               = 0x0b
+	binxmlTemplateInstance     = 0x0c
+	binxmlNormalSubstitution   = 0x0d
+	binxmlOptionalSubstitution = 0x0e
+	binxmlFragmentHeader       = 0x0f
+
+	// Set on OpenStartElement if it has attributes, and on other tokens if
+	// more of the same kind follow
+	binxmlMoreFlag = 0x40
+)
+
+// Value types of substitutions
+const (
+	binxmlNullType     = 0x00
+	binxmlWStringType  = 0x01
+	binxmlStringType   = 0x02
+	binxmlInt8Type     = 0x03
+	binxmlUInt8Type    = 0x04
+	binxmlInt16Type    = 0x05
+	binxmlUInt16Type   = 0x06
+	binxmlInt32Type    = 0x07
+	binxmlUInt32Type   = 0x08
+	binxmlInt64Type    = 0x09
+	binxmlUInt64Type   = 0x0a
+	binxmlReal32Type   = 0x0b
+	binxmlReal64Type   = 0x0c
+	binxmlBoolType     = 0x0d
+	binxmlBinaryType   = 0x0e
+	binxmlGuidType     = 0x0f
+	binxmlSizeTType    = 0x10
+	binxmlFileTimeType = 0x11
+	binxmlSysTimeType  = 0x12
+	binxmlSidType      = 0x13
+	binxmlHexInt32Type = 0x14
+	binxmlHexInt64Type = 0x15
+	binxmlBinXmlType   = 0x21
+	binxmlArrayFlag    = 0x80
+)
+
+// Deepest nesting of BinXml values in templates, so a corrupt chunk can't
+// recurse forever
+const binxmlMaxDepth = 32
+
+// Panicked by the parser when the data is invalid, and recovered by parseRecord
+type binxmlError struct {
+	err error
+}
+
+func binxmlFail(format string, args ...interface{}) {
+	panic(binxmlError{fmt.Errorf(format, args...)})
+}
+
+// A node of a parsed fragment. Templates are parsed once per chunk and
+// rendered with each record's substitution values.
+type binxmlNode interface {
+	render(renderer *binxmlRenderer, values []binxmlSubstitution, out *strings.Builder)
+}
+
+type binxmlElement struct {
+	name     string
+	attrs    []binxmlAttr
+	children []binxmlNode
+	empty    bool
+}
+
+type binxmlAttr struct {
+	name  string
+	value []binxmlNode
+}
+
+// Text, escaped when rendered
+type binxmlText string
+
+// Character and entity references, CDATA and processing instructions, which
+// are rendered as they are
+type binxmlRaw string
+
+type binxmlSubstitutionRef struct {
+	index    int
+	optional bool
+}
+
+type binxmlInstance struct {
+	template *binxmlTemplate
+	values   []binxmlSubstitution
+}
+
+type binxmlTemplate struct {
+	nodes []binxmlNode
+}
+
+// A substitution value, located in the chunk so BinXml values can resolve
+// names and templates
+type binxmlSubstitution struct {
+	valueType byte
+	offset    int
+	size      int
+}
+
+// Parses the binary XML of the records in one chunk
+type binxmlParser struct {
+	chunk []byte
+	// Template definitions by offset
+	templates map[int]*binxmlTemplate
+	depth     int
+}
+
+func newBinxmlParser(chunk []byte) *binxmlParser {
+	return &binxmlParser{chunk: chunk, templates: make(map[int]*binxmlTemplate)}
+}
+
+func (self *binxmlParser) check(offset, size int) {
+	if offset < 0 || size < 0 || offset+size > len(self.chunk) {
+		binxmlFail("Binary XML at offset %v is truncated", offset)
+	}
+}
+
+func (self *binxmlParser) u8(offset int) byte {
+	self.check(offset, 1)
+	return self.chunk[offset]
+}
+
+func (self *binxmlParser) u16(offset int) uint16 {
+	self.check(offset, 2)
+	return binary.LittleEndian.Uint16(self.chunk[offset:])
+}
+
+func (self *binxmlParser) u32(offset int) uint32 {
+	self.check(offset, 4)
+	return binary.LittleEndian.Uint32(self.chunk[offset:])
+}
+
+// Read a UTF-16 string of count characters
+func (self *binxmlParser) utf16String(offset, count int) string {
+	self.check(offset, count*2)
+	return decodeUtf16(self.chunk[offset : offset+count*2])
+}
+
+func decodeUtf16(data []byte) string {
+	chars := make([]uint16, len(data)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(chars))
+}
+
+// Read the name at the offset in the string table. If the name is defined
+// inline, at position, returns the position after it.
+func (self *binxmlParser) name(nameOffset uint32, position int) (string, int) {
+	offset := int(nameOffset)
+	count := int(self.u16(offset + 6))
+	name := self.utf16String(offset+8, count)
+	if offset == position {
+		position = offset + 8 + count*2 + 2
+	}
+	return name, position
+}
+
+// Parse the fragment of a record, which is a template instance
+func (self *binxmlParser) parseRecord(offset, end int) (xml string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			failure, ok := recovered.(binxmlError)
+			if !ok {
+				panic(recovered)
+			}
+			err = failure.err
+		}
+	}()
+	nodes, _ := self.parseFragment(offset, end)
+	var out strings.Builder
+	renderer := &binxmlRenderer{parser: self}
+	for _, node := range nodes {
+		node.render(renderer, nil, &out)
+	}
+	return out.String(), nil
+}
+
+// Parse tokens up to the end of the fragment
+func (self *binxmlParser) parseFragment(offset, end int) ([]binxmlNode, int) {
+	var nodes []binxmlNode
+	for offset < end {
+		token := self.u8(offset)
+		switch token &^ binxmlMoreFlag {
+		case binxmlEndOfStream:
+			return nodes, offset + 1
+		case binxmlFragmentHeader:
+			offset += 4
+		case binxmlTemplateInstance:
+			var instance *binxmlInstance
+			instance, offset = self.parseTemplateInstance(offset)
+			nodes = append(nodes, instance)
+		case binxmlOpenStartElement:
+			var element *binxmlElement
+			element, offset = self.parseElement(offset)
+			nodes = append(nodes, element)
+		default:
+			var node binxmlNode
+			node, offset = self.parseContent(offset)
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, offset
+}
+
+func (self *binxmlParser) parseElement(offset int) (*binxmlElement, int) {
+	token := self.u8(offset)
+	// Skip the dependency identifier and data size
+	nameOffset := self.u32(offset + 7)
+	position := offset + 11
+	if token&binxmlMoreFlag != 0 {
+		// Attribute list size
+		position += 4
+	}
+	element := &binxmlElement{}
+	element.name, position = self.name(nameOffset, position)
+
+	if token&binxmlMoreFlag != 0 {
+		for {
+			attrToken := self.u8(position)
+			if attrToken&^binxmlMoreFlag != binxmlAttribute {
+				binxmlFail("Expected attribute at offset %v, got token 0x%02x", position, attrToken)
+			}
+			var attr binxmlAttr
+			attr.name, position = self.name(self.u32(position+1), position+5)
+			for isBinxmlValueToken(self.u8(position)) {
+				var node binxmlNode
+				node, position = self.parseContent(position)
+				attr.value = append(attr.value, node)
+			}
+			element.attrs = append(element.attrs, attr)
+			if attrToken&binxmlMoreFlag == 0 {
+				break
+			}
+		}
+	}
+
+	switch self.u8(position) {
+	case binxmlCloseEmptyElement:
+		element.empty = true
+		return element, position + 1
+	case binxmlCloseStartElement:
+		position++
+	default:
+		binxmlFail("Expected end of start element at offset %v, got token 0x%02x", position, self.u8(position))
+	}
+	for {
+		token := self.u8(position)
+		switch token &^ binxmlMoreFlag {
+		case binxmlEndElement:
+			return element, position + 1
+		case binxmlOpenStartElement:
+			var child *binxmlElement
+			child, position = self.parseElement(position)
+			element.children = append(element.children, child)
+		case binxmlTemplateInstance:
+			var instance *binxmlInstance
+			instance, position = self.parseTemplateInstance(position)
+			element.children = append(element.children, instance)
+		default:
+			var node binxmlNode
+			node, position = self.parseContent(position)
+			element.children = append(element.children, node)
+		}
+	}
+}
+
+func isBinxmlValueToken(token byte) bool {
+	switch token &^ binxmlMoreFlag {
+	case binxmlValue, binxmlCharRef, binxmlEntityRef, binxmlNormalSubstitution, binxmlOptionalSubstitution:
+		return true
+	}
+	return false
+}
+
+// Parse the text, references and substitutions in element content and
+// attribute values
+func (self *binxmlParser) parseContent(offset int) (binxmlNode, int) {
+	token := self.u8(offset)
+	switch token &^ binxmlMoreFlag {
+	case binxmlValue:
+		valueType := self.u8(offset + 1)
+		if valueType != binxmlWStringType {
+			binxmlFail("Unsupported value type 0x%02x at offset %v", valueType, offset)
+		}
+		count := int(self.u16(offset + 2))
+		return binxmlText(self.utf16String(offset+4, count)), offset + 4 + count*2
+	case binxmlNormalSubstitution, binxmlOptionalSubstitution:
+		return &binxmlSubstitutionRef{
+			index:    int(self.u16(offset + 1)),
+			optional: token == binxmlOptionalSubstitution,
+		}, offset + 4
+	case binxmlCharRef:
+		return binxmlRaw(fmt.Sprintf("&#%d;", self.u16(offset+1))), offset + 3
+	case binxmlEntityRef:
+		name, position := self.name(self.u32(offset+1), offset+5)
+		return binxmlRaw("&" + name + ";"), position
+	case binxmlCDataSection:
+		count := int(self.u16(offset + 1))
+		return binxmlRaw("<![CDATA[" + self.utf16String(offset+3, count) + "]]>"), offset + 3 + count*2
+	case binxmlPITarget:
+		target, position := self.name(self.u32(offset+1), offset+5)
+		data := ""
+		if self.u8(position) == binxmlPIData {
+			count := int(self.u16(position + 1))
+			data = " " + self.utf16String(position+3, count)
+			position += 3 + count*2
+		}
+		return binxmlRaw("<?" + target + data + "?>"), position
+	}
+	binxmlFail("Unexpected token 0x%02x at offset %v", token, offset)
+	return nil, 0
+}
+
+// Parse a template instance: a reference to a template definition, which may
+// follow inline, and the substitution values
+func (self *binxmlParser) parseTemplateInstance(offset int) (*binxmlInstance, int) {
+	definition := int(self.u32(offset + 6))
+	position := offset + 10
+	template := self.template(definition)
+	if definition == position {
+		position = definition + 24 + int(self.u32(definition+20))
+	}
+
+	count := int(self.u32(position))
+	position += 4
+	self.check(position, count*4)
+	values := make([]binxmlSubstitution, count)
+	data := position + count*4
+	for i := range values {
+		size := int(self.u16(position + i*4))
+		values[i] = binxmlSubstitution{valueType: self.u8(position + i*4 + 2), offset: data, size: size}
+		data += size
+	}
+	self.check(position, data-position)
+	return &binxmlInstance{template: template, values: values}, data
+}
+
+// Parse the template definition at the offset: the offset of the next
+// definition, a GUID, the data size and the fragment
+func (self *binxmlParser) template(offset int) *binxmlTemplate {
+	if template, ok := self.templates[offset]; ok {
+		if template == nil {
+			binxmlFail("Template at offset %v refers to itself", offset)
+		}
+		return template
+	}
+	self.templates[offset] = nil
+	start := offset + 24
+	end := start + int(self.u32(offset+20))
+	self.check(start, end-start)
+	nodes, _ := self.parseFragment(start, end)
+	template := &binxmlTemplate{nodes: nodes}
+	self.templates[offset] = template
+	return template
+}
+
+// Renders parsed nodes as XML text, in the form EvtRender produces
+type binxmlRenderer struct {
+	parser *binxmlParser
+}
+
+var binxmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+var binxmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "'", "&apos;")
+
+func (self *binxmlElement) render(renderer *binxmlRenderer, values []binxmlSubstitution, out *strings.Builder) {
+	out.WriteString("<" + self.name)
+	for _, attr := range self.attrs {
+		// Attributes set from empty optional substitutions are left out
+		if len(attr.value) == 1 {
+			if ref, ok := attr.value[0].(*binxmlSubstitutionRef); ok && ref.optional && ref.isNull(values) {
+				continue
+			}
+		}
+		var value strings.Builder
+		for _, node := range attr.value {
+			node.render(renderer, values, &value)
+		}
+		out.WriteString(" " + attr.name + "='" + value.String() + "'")
+	}
+	if self.empty {
+		out.WriteString("/>")
+		return
+	}
+	out.WriteString(">")
+	for _, child := range self.children {
+		child.render(renderer, values, out)
+	}
+	out.WriteString("</" + self.name + ">")
+}
+
+func (self binxmlText) render(renderer *binxmlRenderer, values []binxmlSubstitution, out *strings.Builder) {
+	out.WriteString(binxmlTextEscaper.Replace(string(self)))
+}
+
+func (self binxmlRaw) render(renderer *binxmlRenderer, values []binxmlSubstitution, out *strings.Builder) {
+	out.WriteString(string(self))
+}
+
+func (self *binxmlSubstitutionRef) isNull(values []binxmlSubstitution) bool {
+	return self.index >= len(values) || values[self.index].valueType == binxmlNullType || values[self.index].size == 0
+}
+
+func (self *binxmlSubstitutionRef) render(renderer *binxmlRenderer, values []binxmlSubstitution, out *strings.Builder) {
+	if self.index >= len(values) {
+		return
+	}
+	value := values[self.index]
+	if value.valueType == binxmlBinXmlType {
+		renderer.renderBinXml(value, out)
+		return
+	}
+	// Values are escaped for both text and attributes
+	out.WriteString(binxmlAttrEscaper.Replace(renderer.parser.formatValue(value)))
+}
+
+func (self *binxmlInstance) render(renderer *binxmlRenderer, values []binxmlSubstitution, out *strings.Builder) {
+	for _, node := range self.template.nodes {
+		node.render(renderer, self.values, out)
+	}
+}
+
+// Render a substitution value which is itself a binary XML fragment, such as
+// the EventData of an event
+func (self *binxmlRenderer) renderBinXml(value binxmlSubstitution, out *strings.Builder) {
+	parser := self.parser
+	if parser.depth >= binxmlMaxDepth {
+		binxmlFail("Binary XML at offset %v is nested too deeply", value.offset)
+	}
+	parser.depth++
+	defer func() { parser.depth-- }()
+	nodes, _ := parser.parseFragment(value.offset, value.offset+value.size)
+	for _, node := range nodes {
+		node.render(self, nil, out)
+	}
+}
+
+// Format a substitution value as EvtRender does
+func (self *binxmlParser) formatValue(value binxmlSubstitution) string {
+	self.check(value.offset, value.size)
+	data := self.chunk[value.offset : value.offset+value.size]
+	if value.valueType&binxmlArrayFlag != 0 {
+		return formatBinxmlArray(value.valueType&^binxmlArrayFlag, data)
+	}
+	return formatBinxmlValue(value.valueType, data)
+}
+
+// Sizes of the fixed size types, for splitting arrays
+var binxmlTypeSizes = map[byte]int{
+	binxmlInt8Type:     1,
+	binxmlUInt8Type:    1,
+	binxmlInt16Type:    2,
+	binxmlUInt16Type:   2,
+	binxmlInt32Type:    4,
+	binxmlUInt32Type:   4,
+	binxmlInt64Type:    8,
+	binxmlUInt64Type:   8,
+	binxmlReal32Type:   4,
+	binxmlReal64Type:   8,
+	binxmlBoolType:     4,
+	binxmlGuidType:     16,
+	binxmlFileTimeType: 8,
+	binxmlSysTimeType:  16,
+	binxmlHexInt32Type: 4,
+	binxmlHexInt64Type: 8,
+}
+
+// Format an array value, with the elements separated by commas
+func formatBinxmlArray(valueType byte, data []byte) string {
+	var elements []string
+	switch valueType {
+	case binxmlWStringType:
+		for _, element := range strings.Split(strings.TrimRight(decodeUtf16(data), "\x00"), "\x00") {
+			elements = append(elements, element)
+		}
+	case binxmlStringType:
+		for _, element := range strings.Split(strings.TrimRight(string(data), "\x00"), "\x00") {
+			elements = append(elements, element)
+		}
+	default:
+		size, ok := binxmlTypeSizes[valueType]
+		if !ok {
+			return strings.ToUpper(hex.EncodeToString(data))
+		}
+		for i := 0; i+size <= len(data); i += size {
+			elements = append(elements, formatBinxmlValue(valueType, data[i:i+size]))
+		}
+	}
+	return strings.Join(elements, ",")
+}
+
+func formatBinxmlValue(valueType byte, data []byte) string {
+	if size, ok := binxmlTypeSizes[valueType]; ok && len(data) < size {
+		binxmlFail("Value of type 0x%02x is truncated", valueType)
+	}
+	switch valueType {
+	case binxmlNullType:
+		return ""
+	case binxmlWStringType:
+		return strings.TrimRight(decodeUtf16(data), "\x00")
+	case binxmlStringType:
+		return strings.TrimRight(string(data), "\x00")
+	case binxmlInt8Type:
+		return strconv.FormatInt(int64(int8(data[0])), 10)
+	case binxmlUInt8Type:
+		return strconv.FormatUint(uint64(data[0]), 10)
+	case binxmlInt16Type:
+		return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(data))), 10)
+	case binxmlUInt16Type:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint16(data)), 10)
+	case binxmlInt32Type:
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(data))), 10)
+	case binxmlUInt32Type:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data)), 10)
+	case binxmlInt64Type:
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(data)), 10)
+	case binxmlUInt64Type:
+		return strconv.FormatUint(binary.LittleEndian.Uint64(data), 10)
+	case binxmlReal32Type:
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(data))), 'g', -1, 32)
+	case binxmlReal64Type:
+		return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(data)), 'g', -1, 64)
+	case binxmlBoolType:
+		return strconv.FormatBool(binary.LittleEndian.Uint32(data) != 0)
+	case binxmlGuidType:
+		return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint16(data[4:]),
+			binary.LittleEndian.Uint16(data[6:]), data[8:10], data[10:16])
+	case binxmlSizeTType, binxmlHexInt32Type, binxmlHexInt64Type:
+		if len(data) >= 8 {
+			return fmt.Sprintf("0x%x", binary.LittleEndian.Uint64(data))
+		}
+		if len(data) >= 4 {
+			return fmt.Sprintf("0x%x", binary.LittleEndian.Uint32(data))
+		}
+		binxmlFail("Value of type 0x%02x is truncated", valueType)
+	case binxmlFileTimeType:
+		return fileTimeToTime(binary.LittleEndian.Uint64(data)).Format("2006-01-02T15:04:05.000000000Z")
+	case binxmlSysTimeType:
+		field := func(i int) int { return int(binary.LittleEndian.Uint16(data[i*2:])) }
+		return fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02d.%03d000000Z", field(0), field(1), field(3), field(4), field(5), field(6), field(7))
+	case binxmlSidType:
+		return formatSid(data)
+	}
+	return strings.ToUpper(hex.EncodeToString(data))
+}
+
+// Seconds between the FILETIME epoch of 1601 and the Unix epoch
+const fileTimeEpochOffset = 11644473600
+
+// Convert a FILETIME, in 100ns intervals since 1601, to a UTC time
+func fileTimeToTime(fileTime uint64) time.Time {
+	seconds := int64(fileTime/10000000) - fileTimeEpochOffset
+	return time.Unix(seconds, int64(fileTime%10000000)*100).UTC()
+}
+
+// Format a binary SID in its S-1-5-... form
+func formatSid(data []byte) string {
+	if len(data) < 8 || len(data) < 8+int(data[1])*4 {
+		binxmlFail("SID is truncated")
+	}
+	var authority uint64
+	for _, b := range data[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+	sid := fmt.Sprintf("S-%d-%d", data[0], authority)
+	for i := 0; i < int(data[1]); i++ {
+		sid += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(data[8+i*4:]))
+	}
+	return sid
+}
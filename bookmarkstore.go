@@ -0,0 +1,224 @@
+package winlog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Keeps the latest bookmark XML for each subscribed channel, so processing can
+// resume where it left off. Sinks save an event's bookmark only after the event
+// has been delivered.
+type BookmarkStore interface {
+	// Save the bookmark for a channel, replacing any previous one
+	Save(channel, bookmark string) error
+	// Load the bookmark for a channel, or "" if there isn't one
+	Load(channel string) (string, error)
+}
+
+// Save the event's bookmark to the store under its subscribed channel. Events
+// without a bookmark (see RenderProfile.BookmarkInterval) are skipped.
+func CommitBookmark(store BookmarkStore, event *WinLogEvent) error {
+	if store == nil || event.Bookmark == "" {
+		return nil
+	}
+	return store.Save(event.SubscribedChannel, event.Bookmark)
+}
+
+// A BookmarkStore in memory, for tests or short-lived processes
+type MemoryBookmarkStore struct {
+	bookmarks map[string]string
+	mutex     sync.Mutex
+}
+
+func NewMemoryBookmarkStore() *MemoryBookmarkStore {
+	return &MemoryBookmarkStore{
+		bookmarks: make(map[string]string),
+	}
+}
+
+func (self *MemoryBookmarkStore) Save(channel, bookmark string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.bookmarks[channel] = bookmark
+	return nil
+}
+
+func (self *MemoryBookmarkStore) Load(channel string) (string, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.bookmarks[channel], nil
+}
+
+// A BookmarkStore backed by a JSON file mapping channel names to bookmark XML.
+// The file is rewritten through a temporary file and a rename, so a crash never
+// leaves it half-written.
+//
+// By default every Save rewrites and fsyncs the whole file, which takes a few
+// milliseconds on most disks and limits a sink that saves after every event to
+// a few hundred events a second. SetFlushInterval batches the writes instead.
+type FileBookmarkStore struct {
+	path      string
+	bookmarks map[string]string
+	mutex     sync.Mutex
+
+	flushInterval time.Duration
+	// Whether bookmarks have been saved since the file was last written
+	dirty bool
+	timer *time.Timer
+	// The error from the last write by the timer, returned by the next Save
+	flushErr error
+}
+
+// Open the store at path, loading any bookmarks already saved there. The file
+// is created on the first Save.
+func NewFileBookmarkStore(path string) (*FileBookmarkStore, error) {
+	store := &FileBookmarkStore{
+		path:      path,
+		bookmarks: make(map[string]string),
+	}
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &store.bookmarks); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Write the file at most once per interval rather than on every Save. Saved
+// bookmarks are kept in memory until the interval has passed, or Flush or Close
+// is called, so after a crash up to an interval of events are delivered again.
+// 0, the default, writes the file on every Save.
+func (self *FileBookmarkStore) SetFlushInterval(interval time.Duration) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.flushInterval = interval
+}
+
+func (self *FileBookmarkStore) Save(channel, bookmark string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.flushInterval > 0 {
+		self.bookmarks[channel] = bookmark
+		self.dirty = true
+		if self.timer == nil {
+			self.timer = time.AfterFunc(self.flushInterval, self.flushTimer)
+		}
+		err := self.flushErr
+		self.flushErr = nil
+		return err
+	}
+	if self.bookmarks[channel] == bookmark {
+		return nil
+	}
+	previous, existed := self.bookmarks[channel]
+	self.bookmarks[channel] = bookmark
+	if err := self.write(); err != nil {
+		if existed {
+			self.bookmarks[channel] = previous
+		} else {
+			delete(self.bookmarks, channel)
+		}
+		return err
+	}
+	self.dirty = false
+	return nil
+}
+
+// Write any bookmarks saved since the file was last written.
+func (self *FileBookmarkStore) Flush() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.timer != nil {
+		self.timer.Stop()
+		self.timer = nil
+	}
+	self.flushErr = nil
+	return self.flush()
+}
+
+// Flush the saved bookmarks. Later saves write the file immediately.
+func (self *FileBookmarkStore) Close() error {
+	self.mutex.Lock()
+	self.flushInterval = 0
+	self.mutex.Unlock()
+	return self.Flush()
+}
+
+func (self *FileBookmarkStore) flushTimer() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.timer = nil
+	if err := self.flush(); err != nil {
+		self.flushErr = err
+		// Try again, unless Close has been called
+		if self.flushInterval > 0 {
+			self.timer = time.AfterFunc(self.flushInterval, self.flushTimer)
+		}
+	}
+}
+
+func (self *FileBookmarkStore) flush() error {
+	if !self.dirty {
+		return nil
+	}
+	if err := self.write(); err != nil {
+		return err
+	}
+	self.dirty = false
+	return nil
+}
+
+func (self *FileBookmarkStore) Load(channel string) (string, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.bookmarks[channel], nil
+}
+
+// Channels with a saved bookmark, and their bookmarks
+func (self *FileBookmarkStore) Bookmarks() map[string]string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	bookmarks := make(map[string]string, len(self.bookmarks))
+	for channel, bookmark := range self.bookmarks {
+		bookmarks[channel] = bookmark
+	}
+	return bookmarks
+}
+
+func (self *FileBookmarkStore) write() error {
+	contents, err := json.MarshalIndent(self.bookmarks, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(self.path), filepath.Base(self.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(contents); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Rename(temp.Name(), self.path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package winlog
+
+import (
+	"os"
+	"path/filepath"
+	. "testing"
+	"time"
+)
+
+func TestFileBookmarkStore(t *T) {
+	path := filepath.Join(t.TempDir(), "bookmarks.json")
+	store, err := NewFileBookmarkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmark, err := store.Load("Application")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(bookmark, "", t)
+
+	event := &WinLogEvent{SubscribedChannel: "Application", Bookmark: "<BookmarkList/>"}
+	if err := CommitBookmark(store, event); err != nil {
+		t.Fatal(err)
+	}
+	// Events without a bookmark don't clear the saved one
+	if err := CommitBookmark(store, &WinLogEvent{SubscribedChannel: "Application"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileBookmarkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmark, err = reopened.Load("Application")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(bookmark, "<BookmarkList/>", t)
+	assertEqual(len(reopened.Bookmarks()), 1, t)
+}
+
+func TestFileBookmarkStoreInvalidFile(t *T) {
+	path := filepath.Join(t.TempDir(), "bookmarks.json")
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileBookmarkStore(path); err == nil {
+		t.Fatal("No error loading an invalid bookmark file")
+	}
+}
+
+func TestFileBookmarkStoreFlushInterval(t *T) {
+	path := filepath.Join(t.TempDir(), "bookmarks.json")
+	store, err := NewFileBookmarkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetFlushInterval(time.Hour)
+	for _, bookmark := range []string{"<first/>", "<second/>"} {
+		if err := store.Save("Application", bookmark); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saved in memory, but not written yet
+	bookmark, _ := store.Load("Application")
+	assertEqual(bookmark, "<second/>", t)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Bookmarks were written before the flush interval: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileBookmarkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmark, _ = reopened.Load("Application")
+	assertEqual(bookmark, "<second/>", t)
+}
+
+func TestFileBookmarkStoreFlushTimer(t *T) {
+	path := filepath.Join(t.TempDir(), "bookmarks.json")
+	store, err := NewFileBookmarkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetFlushInterval(10 * time.Millisecond)
+	if err := store.Save("Application", "<BookmarkList/>"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reopened, err := NewFileBookmarkStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bookmark, _ := reopened.Load("Application"); bookmark == "<BookmarkList/>" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Bookmarks weren't written after the flush interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	store.Close()
+}
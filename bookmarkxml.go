@@ -0,0 +1,83 @@
+package winlog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The position of one channel in a bookmark
+type BookmarkPosition struct {
+	Channel  string
+	RecordId uint64
+	// The channel of the last event the bookmark was updated with
+	IsCurrent bool
+}
+
+type bookmarkListDocument struct {
+	XMLName   xml.Name `xml:"BookmarkList"`
+	Bookmarks []struct {
+		Channel   *string `xml:"Channel,attr"`
+		RecordId  *string `xml:"RecordId,attr"`
+		IsCurrent string  `xml:"IsCurrent,attr"`
+	} `xml:"Bookmark"`
+}
+
+var bookmarkAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "'", "&apos;", "\"", "&quot;")
+
+// Format a bookmark in the same XML as RenderBookmark, so it can be used with
+// SubscribeFromBookmark without a Windows bookmark handle.
+func FormatBookmarkXml(positions ...BookmarkPosition) string {
+	var builder strings.Builder
+	builder.WriteString("<BookmarkList>\r\n")
+	for _, position := range positions {
+		fmt.Fprintf(&builder, "  <Bookmark Channel='%s' RecordId='%d'", bookmarkAttrEscaper.Replace(position.Channel), position.RecordId)
+		if position.IsCurrent {
+			builder.WriteString(" IsCurrent='true'")
+		}
+		builder.WriteString("/>\r\n")
+	}
+	builder.WriteString("</BookmarkList>")
+	return builder.String()
+}
+
+// Parse bookmark XML, as rendered by RenderBookmark or FormatBookmarkXml.
+// Every Bookmark element must have a Channel and a numeric RecordId, no
+// channel may appear twice, and at most one can be current.
+func ParseBookmarkXml(bookmarkXml string) ([]BookmarkPosition, error) {
+	var parsed bookmarkListDocument
+	if err := xml.Unmarshal([]byte(bookmarkXml), &parsed); err != nil {
+		return nil, fmt.Errorf("Invalid bookmark XML: %v", err)
+	}
+	positions := make([]BookmarkPosition, 0, len(parsed.Bookmarks))
+	channels := make(map[string]bool)
+	current := false
+	for i, bookmark := range parsed.Bookmarks {
+		if bookmark.Channel == nil || *bookmark.Channel == "" {
+			return nil, fmt.Errorf("Bookmark %v has no Channel", i+1)
+		}
+		if bookmark.RecordId == nil {
+			return nil, fmt.Errorf("Bookmark for channel %q has no RecordId", *bookmark.Channel)
+		}
+		recordId, err := strconv.ParseUint(*bookmark.RecordId, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Bookmark for channel %q has invalid RecordId %q", *bookmark.Channel, *bookmark.RecordId)
+		}
+		if channels[strings.ToLower(*bookmark.Channel)] {
+			return nil, fmt.Errorf("Bookmark has more than one position for channel %q", *bookmark.Channel)
+		}
+		channels[strings.ToLower(*bookmark.Channel)] = true
+		isCurrent := bookmark.IsCurrent == "true"
+		if isCurrent && current {
+			return nil, fmt.Errorf("Bookmark has more than one current channel")
+		}
+		current = current || isCurrent
+		positions = append(positions, BookmarkPosition{
+			Channel:   *bookmark.Channel,
+			RecordId:  recordId,
+			IsCurrent: isCurrent,
+		})
+	}
+	return positions, nil
+}
@@ -0,0 +1,52 @@
+package winlog
+
+import (
+	. "testing"
+)
+
+func TestFormatBookmarkXml(t *T) {
+	// The format RenderBookmark produces
+	expected := "<BookmarkList>\r\n  <Bookmark Channel='Application' RecordId='10811' IsCurrent='true'/>\r\n</BookmarkList>"
+	bookmark := FormatBookmarkXml(BookmarkPosition{Channel: "Application", RecordId: 10811, IsCurrent: true})
+	assertEqual(bookmark, expected, t)
+}
+
+func TestParseBookmarkXml(t *T) {
+	bookmark := FormatBookmarkXml(
+		BookmarkPosition{Channel: "Security", RecordId: 5},
+		BookmarkPosition{Channel: "Bob's <log>", RecordId: 18446744073709551615, IsCurrent: true},
+	)
+	positions, err := ParseBookmarkXml(bookmark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(positions), 2, t)
+	assertEqual(positions[0], BookmarkPosition{Channel: "Security", RecordId: 5}, t)
+	assertEqual(positions[1], BookmarkPosition{Channel: "Bob's <log>", RecordId: 18446744073709551615, IsCurrent: true}, t)
+}
+
+func TestParseInvalidBookmarkXml(t *T) {
+	for _, bookmark := range []string{
+		"",
+		"<BookmarkList>\r\n  <Bookmark Channel='Application' RecordId='10811' IsCurrent='true'/>",
+		"<Bookmarks><Bookmark Channel='Application' RecordId='1'/></Bookmarks>",
+		"<BookmarkList><Bookmark RecordId='1'/></BookmarkList>",
+		"<BookmarkList><Bookmark Channel='Application'/></BookmarkList>",
+		"<BookmarkList><Bookmark Channel='Application' RecordId='-1'/></BookmarkList>",
+		"<BookmarkList><Bookmark Channel='Application' RecordId='1'/><Bookmark Channel='application' RecordId='2'/></BookmarkList>",
+		"<BookmarkList><Bookmark Channel='A' RecordId='1' IsCurrent='true'/><Bookmark Channel='B' RecordId='2' IsCurrent='true'/></BookmarkList>",
+	} {
+		if _, err := ParseBookmarkXml(bookmark); err == nil {
+			t.Fatalf("%q should be invalid", bookmark)
+		}
+	}
+}
+
+func TestParseEmptyBookmarkXml(t *T) {
+	// A bookmark which hasn't been updated with an event yet
+	positions, err := ParseBookmarkXml("<BookmarkList>\r\n</BookmarkList>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(positions), 0, t)
+}
@@ -0,0 +1,306 @@
+package winlog
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// Version of the provider catalog file format written by ProviderCatalog.Save.
+const ProviderCatalogVersion = 1
+
+// A portable copy of the metadata for a set of event providers, which can be
+// exported on Windows with ExportProviderCatalog and used on any OS to fill in
+// the localized fields of events which were read without them.
+type ProviderCatalog struct {
+	Version   int                          `json:"version"`
+	Providers map[string]*ProviderManifest `json:"providers"`
+}
+
+// The localized metadata for a single provider
+type ProviderManifest struct {
+	Name        string            `json:"name"`
+	Guid        string            `json:"guid,omitempty"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Events      []EventDefinition `json:"events,omitempty"`
+	Levels      []MetadataName    `json:"levels,omitempty"`
+	Tasks       []MetadataName    `json:"tasks,omitempty"`
+	Opcodes     []MetadataName    `json:"opcodes,omitempty"`
+	Keywords    []MetadataName    `json:"keywords,omitempty"`
+	Channels    []MetadataName    `json:"channels,omitempty"`
+	// Parameter messages for resolving %%n references, by message ID
+	Parameters map[uint64]string `json:"parameters,omitempty"`
+	// The files the provider's resources, messages and parameter messages
+	// are loaded from, with environment variables such as %SystemRoot% left in
+	ResourceFilePath  string `json:"resource_file_path,omitempty"`
+	MessageFilePath   string `json:"message_file_path,omitempty"`
+	ParameterFilePath string `json:"parameter_file_path,omitempty"`
+	HelpLink          string `json:"help_link,omitempty"`
+}
+
+// A level, task, opcode, keyword or channel declared by a provider. For
+// opcodes, as in EvtPublisherMetadataOpcodeValue, the high word of Value is
+// the opcode and the low word the task it belongs to, or 0 for opcodes that
+// apply to all tasks. For keywords Value is the bit mask.
+type MetadataName struct {
+	Value   uint64 `json:"value"`
+	Name    string `json:"name"`
+	Message string `json:"message,omitempty"`
+}
+
+// An event declared by a provider, with its raw message template
+type EventDefinition struct {
+	Id       uint64 `json:"id"`
+	Version  uint64 `json:"version"`
+	Channel  uint64 `json:"channel"`
+	Level    uint64 `json:"level"`
+	Task     uint64 `json:"task"`
+	Opcode   uint64 `json:"opcode"`
+	Keywords uint64 `json:"keywords"`
+	Message  string `json:"message,omitempty"`
+	// The <template> XML declaring the event's payload, if it has one
+	Template string `json:"template,omitempty"`
+}
+
+// A field of an event's payload, from its template. Length and Count are
+// either a number or the name of an earlier field holding it.
+type TemplateField struct {
+	Name    string `xml:"name,attr"`
+	InType  string `xml:"inType,attr"`
+	OutType string `xml:"outType,attr"`
+	Length  string `xml:"length,attr"`
+	Count   string `xml:"count,attr"`
+	// The members of a <struct> field
+	Fields []TemplateField `xml:"data"`
+	// Whether the field is a <struct> or <binary> rather than <data>
+	Struct bool `xml:"-"`
+	Binary bool `xml:"-"`
+}
+
+// Parse the fields of the event's payload from its template, in order. An
+// event without a template has no fields.
+func (self *EventDefinition) TemplateFields() ([]TemplateField, error) {
+	if self.Template == "" {
+		return nil, nil
+	}
+	var template struct {
+		Fields []struct {
+			XMLName xml.Name
+			TemplateField
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal([]byte(self.Template), &template); err != nil {
+		return nil, fmt.Errorf("Failed to parse the template of event %v version %v: %v", self.Id, self.Version, err)
+	}
+	fields := make([]TemplateField, 0, len(template.Fields))
+	for _, element := range template.Fields {
+		field := element.TemplateField
+		switch element.XMLName.Local {
+		case "data":
+		case "struct":
+			field.Struct = true
+		case "binary":
+			field.Binary = true
+		default:
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Names from winmeta.xml, which are used when a provider doesn't declare its own
+var (
+	standardLevels = map[uint64]string{
+		1: "Critical",
+		2: "Error",
+		3: "Warning",
+		4: "Information",
+		5: "Verbose",
+	}
+	standardOpcodes = map[uint64]string{
+		0:   "Info",
+		1:   "Start",
+		2:   "Stop",
+		3:   "DCStart",
+		4:   "DCStop",
+		5:   "Extension",
+		6:   "Reply",
+		7:   "Resume",
+		8:   "Suspend",
+		9:   "Send",
+		240: "Receive",
+	}
+	standardKeywords = []MetadataName{
+		{Value: 0x1000000000000, Name: "Response Time"},
+		{Value: 0x2000000000000, Name: "WDI Context"},
+		{Value: 0x4000000000000, Name: "WDI Diag"},
+		{Value: 0x8000000000000, Name: "SQM"},
+		{Value: 0x10000000000000, Name: "Audit Failure"},
+		{Value: 0x20000000000000, Name: "Audit Success"},
+		{Value: 0x40000000000000, Name: "Correlation Hint"},
+		{Value: 0x80000000000000, Name: "Classic"},
+	}
+)
+
+func NewProviderCatalog() *ProviderCatalog {
+	return &ProviderCatalog{
+		Version:   ProviderCatalogVersion,
+		Providers: make(map[string]*ProviderManifest),
+	}
+}
+
+// Add or replace the manifest for a provider.
+func (self *ProviderCatalog) Add(manifest *ProviderManifest) {
+	self.Providers[manifest.Name] = manifest
+}
+
+// Load a catalog written by Save.
+func LoadProviderCatalog(path string) (*ProviderCatalog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	catalog := NewProviderCatalog()
+	if err := json.NewDecoder(file).Decode(catalog); err != nil {
+		return nil, fmt.Errorf("Failed to decode provider catalog %q: %v", path, err)
+	}
+	if catalog.Version != ProviderCatalogVersion {
+		return nil, fmt.Errorf("Unsupported provider catalog version %v in %q", catalog.Version, path)
+	}
+	return catalog, nil
+}
+
+// Write the catalog to a file as JSON.
+func (self *ProviderCatalog) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(self); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Fill in any empty localized fields of the event (Msg, IdText, LevelText,
+// TaskText, OpcodeText, ChannelText, ProviderText and Keywords) from the
+// catalog. The message is expanded from the event's Xml if it wasn't
+// forwarded, and is also used as the IdText. Fields that can't be rendered are
+// left empty, and the first failure is returned.
+func (self *ProviderCatalog) RenderEvent(event *WinLogEvent) error {
+	manifest, ok := self.Providers[event.ProviderName]
+	if !ok {
+		return fmt.Errorf("No manifest for provider %q", event.ProviderName)
+	}
+	if event.ProviderText == "" {
+		event.ProviderText = manifest.DisplayName
+	}
+	if event.LevelText == "" {
+		event.LevelText = lookupMetadataName(manifest.Levels, event.Level, standardLevels)
+	}
+	if event.TaskText == "" {
+		event.TaskText = lookupMetadataName(manifest.Tasks, event.Task, nil)
+	}
+	if event.OpcodeText == "" {
+		// An opcode declared for the event's task, then one for all tasks
+		event.OpcodeText = lookupMetadataName(manifest.Opcodes, event.Opcode<<16|event.Task, nil)
+		if event.OpcodeText == "" {
+			event.OpcodeText = lookupMetadataName(manifest.Opcodes, event.Opcode<<16, nil)
+		}
+		if event.OpcodeText == "" {
+			event.OpcodeText = standardOpcodes[event.Opcode]
+		}
+	}
+	if event.ChannelText == "" {
+		for _, channel := range manifest.Channels {
+			if channel.Name == event.Channel {
+				event.ChannelText = metadataText(channel)
+			}
+		}
+	}
+
+	var renderErr error
+	if event.Keywords == nil && event.Xml != "" {
+		mask, err := parseXmlKeywords(event.Xml)
+		if err == nil {
+			event.Keywords = keywordNames(manifest.Keywords, mask)
+		} else {
+			renderErr = err
+		}
+	}
+	if event.Msg == "" {
+		msg, err := manifest.formatMessage(event)
+		if err == nil {
+			event.Msg = msg
+		} else if renderErr == nil {
+			renderErr = err
+		}
+	}
+	if event.IdText == "" {
+		event.IdText = event.Msg
+	}
+	return renderErr
+}
+
+// Expand the message template for the event using its payload.
+func (self *ProviderManifest) formatMessage(event *WinLogEvent) (string, error) {
+	definition := self.eventDefinition(event.EventId, event.Version)
+	if definition == nil || definition.Message == "" {
+		return "", fmt.Errorf("No message for event %v version %v of provider %q", event.EventId, event.Version, self.Name)
+	}
+	fields, err := ParseEventData(event.Xml)
+	if err != nil {
+		return "", err
+	}
+	parameters := func(id uint64) (string, bool) {
+		parameter, ok := self.Parameters[id]
+		return parameter, ok
+	}
+	return FormatMessageTemplate(definition.Message, eventDataValues(fields), parameters)
+}
+
+func (self *ProviderManifest) eventDefinition(id, version uint64) *EventDefinition {
+	for i := range self.Events {
+		if self.Events[i].Id == id && self.Events[i].Version == version {
+			return &self.Events[i]
+		}
+	}
+	return nil
+}
+
+// The display text for a metadata value, which is the localized message if
+// there is one.
+func metadataText(name MetadataName) string {
+	if name.Message != "" {
+		return name.Message
+	}
+	return name.Name
+}
+
+func lookupMetadataName(names []MetadataName, value uint64, standard map[uint64]string) string {
+	for _, name := range names {
+		if name.Value == value {
+			return metadataText(name)
+		}
+	}
+	return standard[value]
+}
+
+// Get the names of all keywords set in the mask, including the standard ones.
+func keywordNames(names []MetadataName, mask uint64) []string {
+	var keywords []string
+	for _, list := range [][]MetadataName{names, standardKeywords} {
+		for _, keyword := range list {
+			if keyword.Value != 0 && mask&keyword.Value == keyword.Value {
+				keywords = append(keywords, metadataText(keyword))
+			}
+		}
+	}
+	return keywords
+}
@@ -0,0 +1,182 @@
+package winlog
+
+import (
+	"path/filepath"
+	. "testing"
+	"time"
+)
+
+const testServiceEventXml = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Service Control Manager' Guid='{555908d1-a6d7-4695-8e1e-26931d2012f4}' EventSourceName='Service Control Manager'/><EventID Qualifiers='16384'>7036</EventID><Version>0</Version><Level>4</Level><Task>0</Task><Opcode>0</Opcode><Keywords>0x8080000000000000</Keywords><TimeCreated SystemTime='2016-01-19T19:37:48.123456700Z'/><EventRecordID>10811</EventRecordID><Correlation/><Execution ProcessID='560' ThreadID='4884'/><Channel>System</Channel><Computer>WIN-HOST</Computer><Security/></System><EventData><Data Name='param1'>Windows Update</Data><Data Name='param2'>running</Data></EventData></Event>`
+
+const testRenderedEventXml = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Service Control Manager'/><EventID>7036</EventID><Level>4</Level><Channel>System</Channel></System><RenderingInfo Culture='en-US'><Message>Forwarded message</Message><Level>Information</Level><Task></Task><Opcode></Opcode><Channel>System</Channel><Provider>Microsoft-Windows-Service Control Manager</Provider><Keywords><Keyword>Classic</Keyword></Keywords></RenderingInfo></Event>`
+
+func testCatalog() *ProviderCatalog {
+	catalog := NewProviderCatalog()
+	catalog.Add(&ProviderManifest{
+		Name:        "Service Control Manager",
+		DisplayName: "Service Control Manager Provider",
+		Events: []EventDefinition{
+			{Id: 7036, Version: 0, Level: 4, Message: "The %1 service entered the %2 state.%n"},
+		},
+		Channels: []MetadataName{
+			{Value: 8, Name: "System", Message: "System Log"},
+		},
+	})
+	return catalog
+}
+
+func TestParseEventXml(t *T) {
+	event, err := ParseEventXml(testServiceEventXml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(event.ProviderName, "Service Control Manager", t)
+	assertEqual(event.EventId, uint64(7036), t)
+	assertEqual(event.Qualifiers, uint64(16384), t)
+	assertEqual(event.Level, uint64(4), t)
+	assertEqual(event.RecordId, uint64(10811), t)
+	assertEqual(event.ProcessId, uint64(560), t)
+	assertEqual(event.ThreadId, uint64(4884), t)
+	assertEqual(event.Channel, "System", t)
+	assertEqual(event.ComputerName, "WIN-HOST", t)
+	assertEqual(event.Created, time.Date(2016, 1, 19, 19, 37, 48, 123456700, time.UTC), t)
+	assertEqual(event.Xml, testServiceEventXml, t)
+	assertEqual(event.Msg, "", t)
+}
+
+func TestParseEventXmlRenderingInfo(t *T) {
+	event, err := ParseEventXml(testRenderedEventXml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(event.Msg, "Forwarded message", t)
+	assertEqual(event.LevelText, "Information", t)
+	assertEqual(event.ProviderText, "Microsoft-Windows-Service Control Manager", t)
+	assertEqual(len(event.Keywords), 1, t)
+	assertEqual(event.Keywords[0], "Classic", t)
+}
+
+func TestCatalogRendersEvent(t *T) {
+	event, err := ParseEventXml(testServiceEventXml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testCatalog().RenderEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(event.Msg, "The Windows Update service entered the running state.\r\n", t)
+	assertEqual(event.IdText, event.Msg, t)
+	assertEqual(event.LevelText, "Information", t)
+	assertEqual(event.OpcodeText, "Info", t)
+	assertEqual(event.ChannelText, "System Log", t)
+	assertEqual(event.ProviderText, "Service Control Manager Provider", t)
+	assertEqual(len(event.Keywords), 1, t)
+	assertEqual(event.Keywords[0], "Classic", t)
+}
+
+func TestCatalogRendersOpcodes(t *T) {
+	catalog := testCatalog()
+	catalog.Providers["Service Control Manager"].Opcodes = []MetadataName{
+		{Value: 10<<16 | 3, Name: "Connect", Message: "Connect to service"},
+		{Value: 11 << 16, Name: "Disconnect"},
+	}
+	render := func(task, opcode uint64) string {
+		event := &WinLogEvent{ProviderName: "Service Control Manager", Task: task, Opcode: opcode}
+		catalog.RenderEvent(event)
+		return event.OpcodeText
+	}
+	assertEqual(render(3, 10), "Connect to service", t)
+	// Declared for another task only
+	assertEqual(render(4, 10), "", t)
+	assertEqual(render(3, 11), "Disconnect", t)
+	assertEqual(render(3, 1), "Start", t)
+}
+
+func TestCatalogKeepsRenderingInfo(t *T) {
+	event, err := ParseEventXml(testRenderedEventXml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testCatalog().RenderEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(event.Msg, "Forwarded message", t)
+	assertEqual(event.ProviderText, "Microsoft-Windows-Service Control Manager", t)
+}
+
+func TestCatalogUnknownEvent(t *T) {
+	event := &WinLogEvent{ProviderName: "Service Control Manager", EventId: 1, Level: 2}
+	if err := testCatalog().RenderEvent(event); err == nil {
+		t.Fatal("No error rendering an event without a definition")
+	}
+	// Other fields are still filled in
+	assertEqual(event.LevelText, "Error", t)
+
+	event = &WinLogEvent{ProviderName: "Unknown"}
+	if err := testCatalog().RenderEvent(event); err == nil {
+		t.Fatal("No error rendering an event from an unknown provider")
+	}
+}
+
+func TestCatalogSaveAndLoad(t *T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	catalog := testCatalog()
+	catalog.Providers["Service Control Manager"].Parameters = map[uint64]string{1833: "Yes"}
+	catalog.Providers["Service Control Manager"].Guid = "{555908D1-A6D7-4695-8E1E-26931D2012F4}"
+	catalog.Providers["Service Control Manager"].MessageFilePath = `%SystemRoot%\system32\services.exe`
+	catalog.Providers["Service Control Manager"].Events[0].Template = testServiceTemplate
+	if err := catalog.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadProviderCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := loaded.Providers["Service Control Manager"]
+	assertEqual(manifest.DisplayName, "Service Control Manager Provider", t)
+	assertEqual(manifest.Events[0].Message, "The %1 service entered the %2 state.%n", t)
+	assertEqual(manifest.Channels[0].Message, "System Log", t)
+	assertEqual(manifest.Parameters[1833], "Yes", t)
+	assertEqual(manifest.Guid, "{555908D1-A6D7-4695-8E1E-26931D2012F4}", t)
+	assertEqual(manifest.MessageFilePath, `%SystemRoot%\system32\services.exe`, t)
+	assertEqual(manifest.Events[0].Template, testServiceTemplate, t)
+}
+
+const testServiceTemplate = `<template xmlns="http://schemas.microsoft.com/win/2004/08/events"><data name="param1" inType="win:UnicodeString" outType="xs:string"/><data name="param2" inType="win:UnicodeString" outType="xs:string"/><binary name="Binary"/></template>`
+
+func TestTemplateFields(t *T) {
+	event := &EventDefinition{Id: 7036, Template: testServiceTemplate}
+	fields, err := event.TemplateFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(fields), 3, t)
+	assertEqual(fields[0].Name, "param1", t)
+	assertEqual(fields[0].InType, "win:UnicodeString", t)
+	assertEqual(fields[1].OutType, "xs:string", t)
+	assertEqual(fields[2].Name, "Binary", t)
+	assertEqual(fields[2].Binary, true, t)
+}
+
+func TestTemplateFieldsStruct(t *T) {
+	event := &EventDefinition{Template: `<template><data name="Count" inType="win:UInt32"/><struct name="Entries" count="Count"><data name="Key" inType="win:UnicodeString"/><data name="Value" inType="win:UInt64" outType="win:HexInt64"/></struct></template>`}
+	fields, err := event.TemplateFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(fields), 2, t)
+	assertEqual(fields[1].Struct, true, t)
+	assertEqual(fields[1].Count, "Count", t)
+	assertEqual(len(fields[1].Fields), 2, t)
+	assertEqual(fields[1].Fields[1].OutType, "win:HexInt64", t)
+}
+
+func TestTemplateFieldsEmpty(t *T) {
+	fields, err := (&EventDefinition{}).TemplateFields()
+	assertEqual(err, nil, t)
+	assertEqual(len(fields), 0, t)
+	_, err = (&EventDefinition{Id: 1, Version: 2, Template: "<template><data"}).TemplateFields()
+	if err == nil {
+		t.Fatal("Expected an error parsing an invalid template")
+	}
+}
@@ -0,0 +1,80 @@
+// +build windows
+
+// Stub functions for discovering channels and reading their configuration.
+// Properties are returned as heap-allocated EVT_VARIANTs, like publisher
+// metadata.
+
+#include "channel.h"
+
+ULONGLONG OpenChannelEnum() {
+	return (ULONGLONG)EvtOpenChannelEnum(NULL, 0);
+}
+
+char* NextChannelPath(ULONGLONG hEnum, int* done) {
+	DWORD dwUsed = 0;
+	*done = 0;
+	if (!EvtNextChannelPath((EVT_HANDLE)hEnum, 0, NULL, &dwUsed)) {
+		DWORD status = GetLastError();
+		if (status == ERROR_NO_MORE_ITEMS) {
+			*done = 1;
+			return NULL;
+		}
+		if (status != ERROR_INSUFFICIENT_BUFFER) {
+			return NULL;
+		}
+	}
+	LPWSTR pathWide = malloc(dwUsed * sizeof(wchar_t));
+	if (!pathWide) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	if (!EvtNextChannelPath((EVT_HANDLE)hEnum, dwUsed, pathWide, &dwUsed)) {
+		free(pathWide);
+		return NULL;
+	}
+	size_t lenPath = wcstombs(NULL, pathWide, 0) + 1;
+	char* path = malloc(lenPath);
+	if (!path) {
+		free(pathWide);
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	wcstombs(path, pathWide, lenPath);
+	free(pathWide);
+	return path;
+}
+
+ULONGLONG OpenChannelConfig(char* channel) {
+	size_t wideChannelLen = mbstowcs(NULL, channel, 0) + 1;
+	LPWSTR lChannel = malloc(wideChannelLen * sizeof(wchar_t));
+	if (!lChannel) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return 0;
+	}
+	mbstowcs(lChannel, channel, wideChannelLen);
+	EVT_HANDLE hChannel = EvtOpenChannelConfig(NULL, lChannel, 0);
+	free(lChannel);
+	return (ULONGLONG)hChannel;
+}
+
+PVOID GetChannelConfigProperty(ULONGLONG hChannel, int propertyId) {
+	DWORD dwUsed = 0;
+	EvtGetChannelConfigProperty((EVT_HANDLE)hChannel, propertyId, 0, 0, NULL, &dwUsed);
+	if (GetLastError() != ERROR_INSUFFICIENT_BUFFER) {
+		return NULL;
+	}
+	PEVT_VARIANT pProperty = malloc(dwUsed);
+	if (!pProperty) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	if (!EvtGetChannelConfigProperty((EVT_HANDLE)hChannel, propertyId, 0, dwUsed, pProperty, &dwUsed)) {
+		free(pProperty);
+		return NULL;
+	}
+	return pProperty;
+}
+
+int GetRenderedBooleanValue(PVOID pRenderedValues, int property) {
+	return ((PEVT_VARIANT)pRenderedValues)[property].BooleanVal ? 1 : 0;
+}
@@ -0,0 +1,171 @@
+// +build windows
+
+package winlog
+
+/*
+#cgo LDFLAGS: -l wevtapi
+#include "channel.h"
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+/* Properties that can be read with GetChannelConfigProperty */
+type EVT_CHANNEL_CONFIG_PROPERTY_ID int
+
+const (
+	EvtChannelConfigEnabled = iota
+	EvtChannelConfigIsolation
+	EvtChannelConfigType
+	EvtChannelConfigOwningPublisher
+	EvtChannelConfigClassicEventlog
+	EvtChannelConfigAccess
+	EvtChannelLoggingConfigRetention
+	EvtChannelLoggingConfigAutoBackup
+	EvtChannelLoggingConfigMaxSize
+	EvtChannelLoggingConfigLogFilePath
+)
+
+// Get an enumerator over the channels on the local computer. The resulting
+// handle must be closed with CloseEventHandle.
+func OpenChannelEnum() (ChannelEnumHandle, error) {
+	handle := ChannelEnumHandle(C.OpenChannelEnum())
+	if handle == 0 {
+		return 0, GetLastError()
+	}
+	return handle, nil
+}
+
+// Get the next channel name from the enumerator. Returns false when there are
+// no more.
+func NextChannelPath(enumHandle ChannelEnumHandle) (string, bool, error) {
+	var done C.int
+	cString := C.NextChannelPath(C.ULONGLONG(enumHandle), &done)
+	if done != 0 {
+		return "", false, nil
+	}
+	if cString == nil {
+		return "", false, GetLastError()
+	}
+	value := C.GoString(cString)
+	C.free(unsafe.Pointer(cString))
+	return value, true, nil
+}
+
+// Open the configuration of the named channel. The resulting handle must be
+// closed with CloseEventHandle.
+func OpenChannelConfig(channel string) (ChannelConfigHandle, error) {
+	cChannel := C.CString(channel)
+	handle := ChannelConfigHandle(C.OpenChannelConfig(cChannel))
+	C.free(unsafe.Pointer(cChannel))
+	if handle == 0 {
+		return 0, GetLastError()
+	}
+	return handle, nil
+}
+
+// Get a property of the channel configuration. The result holds a single
+// value at index 0, and must be freed after use.
+func GetChannelConfigProperty(channelHandle ChannelConfigHandle, property EVT_CHANNEL_CONFIG_PROPERTY_ID) (RenderedFields, error) {
+	value := RenderedFields(C.GetChannelConfigProperty(C.ULONGLONG(channelHandle), C.int(property)))
+	if value == nil {
+		return nil, GetLastError()
+	}
+	return value, nil
+}
+
+// Get the boolean at the given index. Returns false if the type of the field
+// isn't EvtVarTypeBoolean.
+func RenderBoolField(fields RenderedFields, fieldIndex EVT_SYSTEM_PROPERTY_ID) (bool, bool) {
+	if C.GetRenderedValueType(C.PVOID(fields), C.int(fieldIndex)) != EvtVarTypeBoolean {
+		return false, false
+	}
+	return C.GetRenderedBooleanValue(C.PVOID(fields), C.int(fieldIndex)) != 0, true
+}
+
+// Read properties of the channel configuration, ignoring errors.
+func channelStringProperty(channelHandle ChannelConfigHandle, property EVT_CHANNEL_CONFIG_PROPERTY_ID) string {
+	value, err := GetChannelConfigProperty(channelHandle, property)
+	if err != nil {
+		return ""
+	}
+	defer Free(unsafe.Pointer(value))
+	text, _ := RenderStringField(value, 0)
+	return text
+}
+
+func channelUIntProperty(channelHandle ChannelConfigHandle, property EVT_CHANNEL_CONFIG_PROPERTY_ID) uint64 {
+	value, err := GetChannelConfigProperty(channelHandle, property)
+	if err != nil {
+		return 0
+	}
+	defer Free(unsafe.Pointer(value))
+	number, _ := RenderUIntField(value, 0)
+	return number
+}
+
+func channelBoolProperty(channelHandle ChannelConfigHandle, property EVT_CHANNEL_CONFIG_PROPERTY_ID) bool {
+	value, err := GetChannelConfigProperty(channelHandle, property)
+	if err != nil {
+		return false
+	}
+	defer Free(unsafe.Pointer(value))
+	flag, _ := RenderBoolField(value, 0)
+	return flag
+}
+
+// The channels of the local event log, as a ChannelBackend
+type EventLogChannels struct{}
+
+var _ ChannelBackend = EventLogChannels{}
+
+// The names of every channel registered on the local computer, including
+// disabled ones.
+func (EventLogChannels) ChannelNames() ([]string, error) {
+	enumHandle, err := OpenChannelEnum()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to enumerate channels: %v", err)
+	}
+	defer CloseEventHandle(uint64(enumHandle))
+	var names []string
+	for {
+		name, ok, err := NextChannelPath(enumHandle)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to enumerate channels: %v", err)
+		}
+		if !ok {
+			return names, nil
+		}
+		names = append(names, name)
+	}
+}
+
+// Read the configuration of a channel from the local computer.
+func (EventLogChannels) ChannelInfo(channel string) (*ChannelInfo, error) {
+	channelHandle, err := OpenChannelConfig(channel)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open channel configuration for %q: %v", channel, err)
+	}
+	defer CloseEventHandle(uint64(channelHandle))
+	return &ChannelInfo{
+		Name:            channel,
+		Enabled:         channelBoolProperty(channelHandle, EvtChannelConfigEnabled),
+		Type:            ChannelType(channelUIntProperty(channelHandle, EvtChannelConfigType)),
+		Isolation:       ChannelIsolation(channelUIntProperty(channelHandle, EvtChannelConfigIsolation)),
+		Classic:         channelBoolProperty(channelHandle, EvtChannelConfigClassicEventlog),
+		OwningPublisher: channelStringProperty(channelHandle, EvtChannelConfigOwningPublisher),
+		LogFilePath:     channelStringProperty(channelHandle, EvtChannelLoggingConfigLogFilePath),
+		MaxSize:         channelUIntProperty(channelHandle, EvtChannelLoggingConfigMaxSize),
+		Retention:       channelBoolProperty(channelHandle, EvtChannelLoggingConfigRetention),
+		AutoBackup:      channelBoolProperty(channelHandle, EvtChannelLoggingConfigAutoBackup),
+	}, nil
+}
+
+// Subscribe to every subscribable channel on the local computer whose name
+// matches the pattern, such as "Microsoft-Windows-*/Operational". See the
+// SubscribeMatching function.
+func (self *WinLogWatcher) SubscribeMatching(pattern, query string, bookmarks BookmarkStore) ([]string, error) {
+	return SubscribeMatching(self, EventLogChannels{}, pattern, query, bookmarks)
+}
@@ -0,0 +1,26 @@
+#define _WIN32_WINNT 0x0602
+
+#include <windows.h>
+#include "winevt.h"
+#include <stdio.h>
+#include <stdlib.h>
+
+// Get an enumerator over the channels registered on the local computer. The
+// handle must be closed by the caller.
+ULONGLONG OpenChannelEnum();
+
+// Get the next channel path from the enumerator as a newly allocated string,
+// which must be freed by the caller. Returns NULL on error, or with *done set
+// when there are no more channels.
+char* NextChannelPath(ULONGLONG hEnum, int* done);
+
+// Open the configuration of a channel by name. The handle must be closed by the caller.
+ULONGLONG OpenChannelConfig(char* channel);
+
+// Get a property of the channel configuration. Returns a single EVT_VARIANT
+// which can be read with GetRendered<type>Value at index 0, and must be freed
+// by the caller.
+PVOID GetChannelConfigProperty(ULONGLONG hChannel, int propertyId);
+
+// Get the boolean value of the variable at the given index.
+int GetRenderedBooleanValue(PVOID pRenderedValues, int property);
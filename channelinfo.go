@@ -0,0 +1,315 @@
+package winlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Version of the channel catalog file format written by ChannelCatalog.Save.
+const ChannelCatalogVersion = 1
+
+// The kind of a channel, from EvtChannelConfigType
+type ChannelType uint32
+
+const (
+	ChannelTypeAdmin ChannelType = iota
+	ChannelTypeOperational
+	ChannelTypeAnalytic
+	ChannelTypeDebug
+)
+
+var channelTypeNames = []string{"Admin", "Operational", "Analytic", "Debug"}
+
+func (self ChannelType) String() string {
+	if int(self) < len(channelTypeNames) {
+		return channelTypeNames[self]
+	}
+	return fmt.Sprintf("ChannelType(%v)", uint32(self))
+}
+
+func (self ChannelType) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+func (self *ChannelType) UnmarshalText(text []byte) error {
+	for i, name := range channelTypeNames {
+		if strings.EqualFold(name, string(text)) {
+			*self = ChannelType(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown channel type %q", text)
+}
+
+// Which security context a channel shares, from EvtChannelConfigIsolation
+type ChannelIsolation uint32
+
+const (
+	ChannelIsolationApplication ChannelIsolation = iota
+	ChannelIsolationSystem
+	ChannelIsolationCustom
+)
+
+var channelIsolationNames = []string{"Application", "System", "Custom"}
+
+func (self ChannelIsolation) String() string {
+	if int(self) < len(channelIsolationNames) {
+		return channelIsolationNames[self]
+	}
+	return fmt.Sprintf("ChannelIsolation(%v)", uint32(self))
+}
+
+func (self ChannelIsolation) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+func (self *ChannelIsolation) UnmarshalText(text []byte) error {
+	for i, name := range channelIsolationNames {
+		if strings.EqualFold(name, string(text)) {
+			*self = ChannelIsolation(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown channel isolation %q", text)
+}
+
+// The configuration of an event log channel
+type ChannelInfo struct {
+	Name      string           `json:"name"`
+	Enabled   bool             `json:"enabled"`
+	Type      ChannelType      `json:"type"`
+	Isolation ChannelIsolation `json:"isolation"`
+	// Whether it's a classic event log, such as Application or System
+	Classic bool `json:"classic,omitempty"`
+	// The provider which declares the channel
+	OwningPublisher string `json:"owning_publisher,omitempty"`
+	// The log file, with environment variables such as %SystemRoot% left in
+	LogFilePath string `json:"log_file_path,omitempty"`
+	// Largest size of the log file, in bytes
+	MaxSize uint64 `json:"max_size,omitempty"`
+	// What happens when the log is full. Without Retention the oldest events
+	// are overwritten. With it they're kept, and new events are dropped unless
+	// AutoBackup is set, which archives the full log and starts a new one.
+	Retention  bool `json:"retention,omitempty"`
+	AutoBackup bool `json:"auto_backup,omitempty"`
+}
+
+// Whether the channel can be subscribed to. Disabled channels log nothing,
+// and Analytic and Debug channels can only be read from their log files.
+func (self *ChannelInfo) Subscribable() bool {
+	return self.Enabled && (self.Type == ChannelTypeAdmin || self.Type == ChannelTypeOperational)
+}
+
+// Lists the channels of an event log and reads their configuration. On
+// Windows, EventLogChannels reads the local event log; a ChannelCatalog stands
+// in for it anywhere else.
+type ChannelBackend interface {
+	// The names of every channel
+	ChannelNames() ([]string, error)
+	// The configuration of the named channel
+	ChannelInfo(channel string) (*ChannelInfo, error)
+}
+
+// A portable list of channels and their configuration, which can be exported
+// on Windows with ExportChannelCatalog and used on any OS as a ChannelBackend.
+// Channel names are case-insensitive, as in the event log.
+type ChannelCatalog struct {
+	Version  int                     `json:"version"`
+	Channels map[string]*ChannelInfo `json:"channels"`
+}
+
+var _ ChannelBackend = (*ChannelCatalog)(nil)
+
+func NewChannelCatalog(channels ...ChannelInfo) *ChannelCatalog {
+	catalog := &ChannelCatalog{
+		Version:  ChannelCatalogVersion,
+		Channels: make(map[string]*ChannelInfo),
+	}
+	for _, channel := range channels {
+		catalog.Add(channel)
+	}
+	return catalog
+}
+
+// Add or replace a channel.
+func (self *ChannelCatalog) Add(channel ChannelInfo) {
+	for name := range self.Channels {
+		if strings.EqualFold(name, channel.Name) {
+			delete(self.Channels, name)
+		}
+	}
+	self.Channels[channel.Name] = &channel
+}
+
+// The names of the channels in the catalog, in order.
+func (self *ChannelCatalog) ChannelNames() ([]string, error) {
+	names := make([]string, 0, len(self.Channels))
+	for name := range self.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Get a copy of the named channel's configuration.
+func (self *ChannelCatalog) ChannelInfo(channel string) (*ChannelInfo, error) {
+	info, ok := self.Channels[channel]
+	if !ok {
+		for name, candidate := range self.Channels {
+			if strings.EqualFold(name, channel) {
+				info, ok = candidate, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("Channel %q not found", channel)
+	}
+	copied := *info
+	return &copied, nil
+}
+
+// Load a catalog written by Save.
+func LoadChannelCatalog(path string) (*ChannelCatalog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	catalog := NewChannelCatalog()
+	if err := json.NewDecoder(file).Decode(catalog); err != nil {
+		return nil, fmt.Errorf("Failed to decode channel catalog %q: %v", path, err)
+	}
+	if catalog.Version != ChannelCatalogVersion {
+		return nil, fmt.Errorf("Unsupported channel catalog version %v in %q", catalog.Version, path)
+	}
+	for name, info := range catalog.Channels {
+		info.Name = name
+	}
+	return catalog, nil
+}
+
+// Write the catalog to a file as JSON.
+func (self *ChannelCatalog) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(self); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Copy every channel of a backend, such as EventLogChannels, into a new catalog.
+func ExportChannelCatalog(backend ChannelBackend) (*ChannelCatalog, error) {
+	names, err := backend.ChannelNames()
+	if err != nil {
+		return nil, err
+	}
+	catalog := NewChannelCatalog()
+	for _, name := range names {
+		info, err := backend.ChannelInfo(name)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the configuration of channel %q: %v", name, err)
+		}
+		catalog.Add(*info)
+	}
+	return catalog, nil
+}
+
+// The channels of a backend whose names match a pattern, in order. In the
+// pattern, '*' matches any run of characters, including '/', and '?' any one
+// character, ignoring case, so "Microsoft-Windows-*/Operational" matches the
+// Operational channel of every Microsoft-Windows provider.
+func MatchChannels(backend ChannelBackend, pattern string) ([]string, error) {
+	names, err := backend.ChannelNames()
+	if err != nil {
+		return nil, err
+	}
+	var matched []string
+	for _, name := range names {
+		if matchChannel(strings.ToLower(pattern), strings.ToLower(name)) {
+			matched = append(matched, name)
+		}
+	}
+	sort.Strings(matched)
+	return matched, nil
+}
+
+// Match a name against a glob pattern of '*' and '?' wildcards
+func matchChannel(patternText, nameText string) bool {
+	pattern, name := []rune(patternText), []rune(nameText)
+	// Where the last '*' was, to retry with it matching one more character
+	star, retry := -1, 0
+	p, n := 0, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, retry = p, n
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case star >= 0:
+			retry++
+			p, n = star+1, retry
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// Subscribe the watcher to every subscribable channel of the backend whose
+// name matches the pattern (see MatchChannels), with the same query. Each
+// channel resumes from its bookmark in the store if it has one, and otherwise
+// starts from now; the store may be nil. Returns the channels subscribed, and
+// an error naming any that failed.
+func SubscribeMatching(watcher Watcher, backend ChannelBackend, pattern, query string, bookmarks BookmarkStore) ([]string, error) {
+	names, err := MatchChannels(backend, pattern)
+	if err != nil {
+		return nil, err
+	}
+	var subscribed, failures []string
+	for _, name := range names {
+		info, err := backend.ChannelInfo(name)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", name, err))
+			continue
+		}
+		if !info.Subscribable() {
+			continue
+		}
+		bookmark := ""
+		if bookmarks != nil {
+			if bookmark, err = bookmarks.Load(name); err != nil {
+				failures = append(failures, fmt.Sprintf("%v: %v", name, err))
+				continue
+			}
+		}
+		if bookmark != "" {
+			err = watcher.SubscribeFromBookmark(name, query, bookmark)
+		} else {
+			err = watcher.SubscribeFromNow(name, query)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", name, err))
+			continue
+		}
+		subscribed = append(subscribed, name)
+	}
+	if len(failures) > 0 {
+		return subscribed, fmt.Errorf("Failed to subscribe to %v", strings.Join(failures, "; "))
+	}
+	return subscribed, nil
+}
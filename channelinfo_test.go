@@ -0,0 +1,111 @@
+package winlog
+
+import (
+	"fmt"
+	"path/filepath"
+	. "testing"
+)
+
+func testChannelCatalog() *ChannelCatalog {
+	return NewChannelCatalog(
+		ChannelInfo{Name: "Application", Enabled: true, Classic: true, LogFilePath: `%SystemRoot%\System32\Winevt\Logs\Application.evtx`, MaxSize: 20971520},
+		ChannelInfo{Name: "Security", Enabled: true, Classic: true, Isolation: ChannelIsolationCustom, Retention: true, AutoBackup: true},
+		ChannelInfo{Name: "Microsoft-Windows-Sysmon/Operational", Enabled: true, Type: ChannelTypeOperational, OwningPublisher: "Microsoft-Windows-Sysmon"},
+		ChannelInfo{Name: "Microsoft-Windows-TaskScheduler/Operational", Type: ChannelTypeOperational},
+		ChannelInfo{Name: "Microsoft-Windows-Kernel-Power/Diagnostic", Enabled: true, Type: ChannelTypeAnalytic},
+		ChannelInfo{Name: "Microsoft-Windows-PowerShell/Operational", Enabled: true, Type: ChannelTypeOperational},
+	)
+}
+
+func TestMatchChannel(t *T) {
+	for _, testCase := range []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"*", "Microsoft-Windows-Sysmon/Operational", true},
+		{"microsoft-windows-*/operational", "microsoft-windows-sysmon/operational", true},
+		{"microsoft-windows-*/operational", "microsoft-windows-kernel-power/diagnostic", false},
+		{"*/operational", "microsoft-windows-sysmon/operational", true},
+		{"*power*", "microsoft-windows-kernel-power/diagnostic", true},
+		{"s?stem", "system", true},
+		{"s?stem", "sstem", false},
+		{"security", "security", true},
+		{"security", "security2", false},
+		{"*a*b", "aaab", true},
+		{"*a*b", "aaba", false},
+		{"", "", true},
+		{"?", "é", true},
+	} {
+		assertEqual(matchChannel(testCase.pattern, testCase.name), testCase.matches, t)
+	}
+}
+
+func TestChannelCatalog(t *T) {
+	catalog := testChannelCatalog()
+	matched, err := MatchChannels(catalog, "Microsoft-Windows-*/Operational")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(fmt.Sprint(matched), "[Microsoft-Windows-PowerShell/Operational Microsoft-Windows-Sysmon/Operational Microsoft-Windows-TaskScheduler/Operational]", t)
+
+	info, err := catalog.ChannelInfo("microsoft-windows-sysmon/operational")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(info.Name, "Microsoft-Windows-Sysmon/Operational", t)
+	assertEqual(info.OwningPublisher, "Microsoft-Windows-Sysmon", t)
+	assertEqual(info.Type.String(), "Operational", t)
+	assertEqual(info.Subscribable(), true, t)
+	_, err = catalog.ChannelInfo("Setup")
+	assertEqual(err.Error(), `Channel "Setup" not found`, t)
+
+	// Adding a channel again replaces it, whatever its case
+	catalog.Add(ChannelInfo{Name: "APPLICATION"})
+	names, _ := catalog.ChannelNames()
+	assertEqual(len(names), 6, t)
+	info, _ = catalog.ChannelInfo("Application")
+	assertEqual(info.Enabled, false, t)
+}
+
+func TestChannelCatalogSaveAndLoad(t *T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+	exported, err := ExportChannelCatalog(testChannelCatalog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exported.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadChannelCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, info := range testChannelCatalog().Channels {
+		assertEqual(*loaded.Channels[name], *info, t)
+	}
+	info, _ := loaded.ChannelInfo("Security")
+	assertEqual(info.Isolation, ChannelIsolationCustom, t)
+	assertEqual(info.Retention && info.AutoBackup, true, t)
+}
+
+func TestSubscribeMatching(t *T) {
+	watcher := testReplayWatcher(ReplayConfig{}, t)
+	defer watcher.Shutdown()
+	bookmarks := NewMemoryBookmarkStore()
+	bookmarks.Save("Microsoft-Windows-Sysmon/Operational", FormatBookmarkXml(BookmarkPosition{Channel: "Microsoft-Windows-Sysmon/Operational", RecordId: 7, IsCurrent: true}))
+	// Already subscribed, so subscribing again fails
+	watcher.SubscribeFromNow("Microsoft-Windows-PowerShell/Operational", "*")
+
+	// Disabled and analytic channels are skipped
+	subscribed, err := SubscribeMatching(watcher, testChannelCatalog(), "Microsoft-Windows-*", "*", bookmarks)
+	assertEqual(fmt.Sprint(subscribed), "[Microsoft-Windows-Sysmon/Operational]", t)
+	assertEqual(err.Error(), `Failed to subscribe to Microsoft-Windows-PowerShell/Operational: A watcher for channel "Microsoft-Windows-PowerShell/Operational" already exists`, t)
+	assertEqual(len(watcher.Stats()), 2, t)
+
+	subscribed, err = SubscribeMatching(watcher, testChannelCatalog(), "Security", "*", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(fmt.Sprint(subscribed), "[Security]", t)
+}
@@ -0,0 +1,292 @@
+// Command evtx2json converts EVTX files to NDJSON, one event per line in the
+// library's JSON encoding, on any OS.
+//
+// Usage:
+//
+//	evtx2json [flags] file.evtx|directory...
+//
+// Corrupt chunks and records are reported on stderr and skipped, and the
+// exit status is 1 if anything was skipped. With -o, each file is written to
+// its own .ndjson file in the directory, otherwise all events go to stdout.
+// Files with the same name in different directories are told apart by their
+// parent directories, so host1/Security.evtx is written to
+// host1_Security.ndjson.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/scalingdata/gowinlog"
+	"github.com/scalingdata/gowinlog/internal/xpath"
+)
+
+type options struct {
+	query    *xpath.XPath
+	catalog  *winlog.ProviderCatalog
+	outDir   string
+	parallel int
+	// The output file name for each input path, with outDir
+	outNames map[string]string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Convert the files, returning the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("evtx2json", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: evtx2json [flags] file.evtx|directory...\n")
+		flags.PrintDefaults()
+	}
+	query := flags.String("query", "", "Only convert events matching this XPath `query`")
+	catalog := flags.String("catalog", "", "Provider catalog `file` for rendering messages")
+	outDir := flags.String("o", "", "Write each file's events to a .ndjson file in this `directory`")
+	parallel := flags.Int("parallel", 1, "Number of files to convert at once")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *parallel < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	opts := &options{outDir: *outDir, parallel: *parallel}
+	if *query != "" {
+		compiled, err := xpath.Compile(*query)
+		if err != nil {
+			fmt.Fprintf(stderr, "evtx2json: %v\n", err)
+			return 2
+		}
+		opts.query = compiled
+	}
+	if *catalog != "" {
+		loaded, err := winlog.LoadProviderCatalog(*catalog)
+		if err != nil {
+			fmt.Fprintf(stderr, "evtx2json: Failed to load provider catalog: %v\n", err)
+			return 1
+		}
+		opts.catalog = loaded
+	}
+	paths, err := evtxPaths(flags.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "evtx2json: %v\n", err)
+		return 1
+	}
+	if opts.outDir != "" {
+		if opts.outNames, err = outputNames(paths); err != nil {
+			fmt.Fprintf(stderr, "evtx2json: %v\n", err)
+			return 2
+		}
+		if err := os.MkdirAll(opts.outDir, 0755); err != nil {
+			fmt.Fprintf(stderr, "evtx2json: %v\n", err)
+			return 1
+		}
+	}
+	if !convertAll(paths, opts, stdout, stderr) {
+		return 1
+	}
+	return 0
+}
+
+// Expand directories into the EVTX files in them
+func evtxPaths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.evtx"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// Name the .ndjson file for each path after the EVTX file, adding as many
+// parent directories as it takes to make the names unique, such as
+// host1_Security.ndjson. Names are compared case-insensitively, as on Windows.
+func outputNames(paths []string) (map[string]string, error) {
+	components := make([][]string, len(paths))
+	depths := make([]int, len(paths))
+	for i, path := range paths {
+		// The file name without its extension, then its parents from the nearest
+		base := filepath.Base(path)
+		parts := []string{strings.TrimSuffix(base, filepath.Ext(base))}
+		dirs := strings.Split(filepath.ToSlash(filepath.Dir(filepath.Clean(path))), "/")
+		for j := len(dirs) - 1; j >= 0; j-- {
+			if dir := dirs[j]; dir != "" && dir != "." && dir != ".." && !strings.HasSuffix(dir, ":") {
+				parts = append(parts, dir)
+			}
+		}
+		components[i] = parts
+		depths[i] = 1
+	}
+	name := func(i int) string {
+		parts := make([]string, depths[i])
+		for j := range parts {
+			parts[depths[i]-1-j] = components[i][j]
+		}
+		return strings.Join(parts, "_") + ".ndjson"
+	}
+	for {
+		byName := make(map[string][]int)
+		for i := range paths {
+			key := strings.ToLower(name(i))
+			byName[key] = append(byName[key], i)
+		}
+		collided := false
+		for _, same := range byName {
+			if len(same) < 2 {
+				continue
+			}
+			collided = true
+			extended := false
+			for _, i := range same {
+				if depths[i] < len(components[i]) {
+					depths[i]++
+					extended = true
+				}
+			}
+			if !extended {
+				return nil, fmt.Errorf("%v and %v would both be written to %v", paths[same[0]], paths[same[1]], name(same[0]))
+			}
+		}
+		if !collided {
+			break
+		}
+	}
+	names := make(map[string]string, len(paths))
+	for i, path := range paths {
+		names[path] = name(i)
+	}
+	return names, nil
+}
+
+// Writes whole lines to a shared output
+type lineWriter struct {
+	out   io.Writer
+	mutex sync.Mutex
+}
+
+func (self *lineWriter) writeLine(line []byte) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	_, err := self.out.Write(append(line, '\n'))
+	return err
+}
+
+// Convert the files on opts.parallel goroutines, returning false if anything
+// was skipped
+func convertAll(paths []string, opts *options, stdout, stderr io.Writer) bool {
+	out := &lineWriter{out: stdout}
+	errs := &lineWriter{out: stderr}
+	queue := make(chan string)
+	var failed bool
+	var failedMutex sync.Mutex
+	var wait sync.WaitGroup
+	for i := 0; i < opts.parallel; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for path := range queue {
+				report := func(err error) {
+					errs.writeLine([]byte(fmt.Sprintf("evtx2json: %v: %v", path, err)))
+					failedMutex.Lock()
+					failed = true
+					failedMutex.Unlock()
+				}
+				convertFile(path, opts, out, report)
+			}
+		}()
+	}
+	for _, path := range paths {
+		queue <- path
+	}
+	close(queue)
+	wait.Wait()
+	return !failed
+}
+
+// Convert one file, reporting each error
+func convertFile(path string, opts *options, out *lineWriter, report func(error)) {
+	reader, err := winlog.OpenEvtx(path)
+	if err != nil {
+		report(err)
+		return
+	}
+	defer reader.Close()
+	if opts.outDir != "" {
+		file, err := os.Create(filepath.Join(opts.outDir, opts.outNames[path]))
+		if err != nil {
+			report(err)
+			return
+		}
+		buffered := bufio.NewWriter(file)
+		defer func() {
+			if err := buffered.Flush(); err != nil {
+				report(err)
+			}
+			if err := file.Close(); err != nil {
+				report(err)
+			}
+		}()
+		out = &lineWriter{out: buffered}
+	}
+
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return
+		}
+		var evtxErr *winlog.EvtxError
+		if errors.As(err, &evtxErr) {
+			report(err)
+			continue
+		}
+		if err != nil {
+			report(err)
+			return
+		}
+		if opts.query != nil && !opts.query.Match(record.Xml) {
+			continue
+		}
+		event, err := record.Event()
+		if err != nil {
+			report(fmt.Errorf("Record %v: %v", record.RecordId, err))
+			continue
+		}
+		if opts.catalog != nil {
+			opts.catalog.RenderEvent(event)
+		}
+		line, err := json.Marshal(event)
+		if err != nil {
+			report(fmt.Errorf("Record %v: %v", record.RecordId, err))
+			continue
+		}
+		if err := out.writeLine(line); err != nil {
+			report(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	. "testing"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+// Chunks of testdata/security.evtx, each with a 4624 and a 4625 event
+const (
+	fixture    = "testdata/security.evtx"
+	chunkStart = 4096
+	chunkSize  = 65536
+)
+
+func runConvert(t *T, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func decodeLines(output string, t *T) []*winlog.WinLogEvent {
+	var events []*winlog.WinLogEvent
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		event := &winlog.WinLogEvent{}
+		if err := json.Unmarshal([]byte(line), event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestConvert(t *T) {
+	code, stdout, stderr := runConvert(t, fixture)
+	assertEqual(code, 0, t)
+	assertEqual(stderr, "", t)
+	events := decodeLines(stdout, t)
+	assertEqual(len(events), 4, t)
+	assertEqual(events[0].RecordId, uint64(100), t)
+	assertEqual(events[0].EventData[0].Value, "alice", t)
+	assertEqual(events[3].RecordId, uint64(103), t)
+	assertEqual(strings.Contains(stdout, `"schema_version":1`), true, t)
+}
+
+func TestConvertQuery(t *T) {
+	code, stdout, _ := runConvert(t, "-query", "*[System[EventID=4625]]", fixture)
+	assertEqual(code, 0, t)
+	events := decodeLines(stdout, t)
+	assertEqual(len(events), 2, t)
+	assertEqual(events[0].RecordId, uint64(101), t)
+	assertEqual(events[1].RecordId, uint64(103), t)
+}
+
+func TestConvertCorrupt(t *T) {
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Break the records checksum of the first chunk
+	data[chunkStart+600] ^= 0xff
+	path := filepath.Join(t.TempDir(), "corrupt.evtx")
+	os.WriteFile(path, data, 0644)
+
+	code, stdout, stderr := runConvert(t, path)
+	assertEqual(code, 1, t)
+	assertEqual(stderr, "evtx2json: "+path+": Skipped chunk 0: Chunk records checksum mismatch\n", t)
+	events := decodeLines(stdout, t)
+	assertEqual(len(events), 2, t)
+	assertEqual(events[0].RecordId, uint64(102), t)
+}
+
+func TestConvertParallel(t *T) {
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"a.evtx", "b.evtx", "c.evtx", "d.evtx"} {
+		os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+	os.WriteFile(filepath.Join(dir, "e.evtx"), []byte("not evtx"), 0644)
+	out := filepath.Join(t.TempDir(), "out")
+
+	code, _, stderr := runConvert(t, "-parallel", "3", "-o", out, dir)
+	assertEqual(code, 1, t)
+	assertEqual(strings.Contains(stderr, "e.evtx: "), true, t)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		converted, err := os.ReadFile(filepath.Join(out, name+".ndjson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(len(decodeLines(string(converted), t)), 4, t)
+	}
+
+	code, stdout, _ := runConvert(t, "-parallel", "4", filepath.Join(dir, "a.evtx"), filepath.Join(dir, "b.evtx"))
+	assertEqual(code, 0, t)
+	assertEqual(len(decodeLines(stdout, t)), 8, t)
+}
+
+func TestConvertSameNames(t *T) {
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	var paths []string
+	for _, host := range []string{"host1", "host2"} {
+		os.Mkdir(filepath.Join(dir, host), 0755)
+		path := filepath.Join(dir, host, "Security.evtx")
+		os.WriteFile(path, data, 0644)
+		paths = append(paths, path)
+	}
+	out := filepath.Join(t.TempDir(), "out")
+
+	code, _, stderr := runConvert(t, append([]string{"-parallel", "2", "-o", out}, paths...)...)
+	assertEqual(code, 0, t)
+	assertEqual(stderr, "", t)
+	for _, name := range []string{"host1_Security", "host2_Security"} {
+		converted, err := os.ReadFile(filepath.Join(out, name+".ndjson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(len(decodeLines(string(converted), t)), 4, t)
+	}
+
+	// The same file twice can't be told apart
+	code, _, stderr = runConvert(t, "-o", out, paths[0], paths[0])
+	assertEqual(code, 2, t)
+	assertEqual(strings.Contains(stderr, paths[0]+" and "+paths[0]+" would both be written to "), true, t)
+}
+
+func TestOutputNames(t *T) {
+	names, err := outputNames([]string{"Security.evtx", "a/System.evtx", "a/b/Security.evtx", "c/b/Security.evtx", "c/System.evtx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(names["Security.evtx"], "Security.ndjson", t)
+	assertEqual(names["a/System.evtx"], "a_System.ndjson", t)
+	assertEqual(names["a/b/Security.evtx"], "a_b_Security.ndjson", t)
+	assertEqual(names["c/b/Security.evtx"], "c_b_Security.ndjson", t)
+	assertEqual(names["c/System.evtx"], "c_System.ndjson", t)
+}
+
+func TestConvertUsage(t *T) {
+	for _, args := range [][]string{{}, {"-parallel", "0", fixture}, {"-query", "*[", fixture}} {
+		if code, _, _ := runConvert(t, args...); code != 2 {
+			t.Fatalf("Expected %v to be a usage error", args)
+		}
+	}
+	if code, _, _ := runConvert(t, "missing.evtx"); code != 1 {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// A position in a subscription's bookmark, as shown by show -json
+type positionJSON struct {
+	Subscription string `json:"subscription"`
+	Channel      string `json:"channel"`
+	RecordId     uint64 `json:"record_id"`
+	Current      bool   `json:"current"`
+}
+
+// Open a store which must already exist
+func openStore(path string) (*winlog.FileBookmarkStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return winlog.NewFileBookmarkStore(path)
+}
+
+func sortedSubscriptions(bookmarks map[string]string) []string {
+	subscriptions := make([]string, 0, len(bookmarks))
+	for subscription := range bookmarks {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Strings(subscriptions)
+	return subscriptions
+}
+
+// Print the channel and record ID of every position in the store
+func show(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("show", stderr)
+	asJSON := flags.Bool("json", false, "Print the positions as JSON")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	store, err := openStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	bookmarks := store.Bookmarks()
+	positions := []positionJSON{}
+	for _, subscription := range sortedSubscriptions(bookmarks) {
+		parsed, err := winlog.ParseBookmarkXml(bookmarks[subscription])
+		if err != nil {
+			return fmt.Errorf("%v: %v", subscription, err)
+		}
+		for _, position := range parsed {
+			positions = append(positions, positionJSON{subscription, position.Channel, position.RecordId, position.IsCurrent})
+		}
+	}
+	if *asJSON {
+		encoded, err := json.MarshalIndent(positions, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(stdout, "%s\n", encoded)
+		return err
+	}
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "SUBSCRIPTION\tCHANNEL\tRECORD ID\tCURRENT\n")
+	for _, position := range positions {
+		current := ""
+		if position.Current {
+			current = "*"
+		}
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\n", position.Subscription, position.Channel, position.RecordId, current)
+	}
+	return table.Flush()
+}
+
+// Move one channel of a subscription's bookmark
+func set(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("set", stderr)
+	subscription := flags.String("subscription", "", "The subscription whose bookmark to change")
+	channel := flags.String("channel", "", "The channel to move, by default the subscription's")
+	record := flags.Int64("record", -1, "Resume after this record `id`")
+	back := flags.Uint64("back", 0, "Move back this many records")
+	evtx := flags.String("evtx", "", "EVTX `file` of the channel, for -since and -before")
+	since := flags.Duration("since", 0, "Resume with the first record written within this `duration`")
+	before := flags.String("before", "", "Resume with the first record written at or after this RFC 3339 `time`")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	modes := 0
+	for _, set := range []bool{*record >= 0, *back > 0, *evtx != ""} {
+		if set {
+			modes++
+		}
+	}
+	if *subscription == "" || modes != 1 || (*evtx != "") != (*since > 0 || *before != "") || (*since > 0 && *before != "") {
+		flags.Usage()
+		return errUsage
+	}
+	if *channel == "" {
+		*channel = *subscription
+	}
+
+	store, err := winlog.NewFileBookmarkStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	bookmark, _ := store.Load(*subscription)
+	var positions []winlog.BookmarkPosition
+	if bookmark != "" {
+		if positions, err = winlog.ParseBookmarkXml(bookmark); err != nil {
+			return fmt.Errorf("%v: %v", *subscription, err)
+		}
+	}
+	index := -1
+	for i, position := range positions {
+		if strings.EqualFold(position.Channel, *channel) {
+			index = i
+		}
+	}
+
+	var recordId uint64
+	switch {
+	case *record >= 0:
+		recordId = uint64(*record)
+	case *back > 0:
+		if index < 0 {
+			return fmt.Errorf("%v has no position for channel %v to move back from", *subscription, *channel)
+		}
+		if current := positions[index].RecordId; current > *back {
+			recordId = current - *back
+		}
+	default:
+		cutoff := time.Now().Add(-*since)
+		if *before != "" {
+			if cutoff, err = time.Parse(time.RFC3339Nano, *before); err != nil {
+				return fmt.Errorf("Invalid -before time: %v", err)
+			}
+		}
+		if recordId, err = lastRecordBefore(*evtx, *channel, cutoff, stderr); err != nil {
+			return err
+		}
+	}
+
+	previous := "none"
+	if index < 0 {
+		positions = append(positions, winlog.BookmarkPosition{Channel: *channel, IsCurrent: !hasCurrent(positions)})
+		index = len(positions) - 1
+	} else {
+		previous = fmt.Sprint(positions[index].RecordId)
+	}
+	positions[index].RecordId = recordId
+	if err := store.Save(*subscription, winlog.FormatBookmarkXml(positions...)); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%v: %v record %v -> %v\n", *subscription, positions[index].Channel, previous, recordId)
+	return err
+}
+
+func hasCurrent(positions []winlog.BookmarkPosition) bool {
+	for _, position := range positions {
+		if position.IsCurrent {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the last record of the channel in the EVTX file written before the
+// cutoff, or 0 if there's none. Corrupt chunks are reported and skipped.
+func lastRecordBefore(path, channel string, cutoff time.Time, stderr io.Writer) (uint64, error) {
+	reader, err := winlog.OpenEvtx(path)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	var last uint64
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return last, nil
+		}
+		if _, ok := err.(*winlog.EvtxError); ok {
+			fmt.Fprintf(stderr, "winlogbookmark: %v: %v\n", path, err)
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !record.Written.Before(cutoff) || record.RecordId <= last {
+			continue
+		}
+		event, err := record.Event()
+		if err != nil || !strings.EqualFold(event.Channel, channel) {
+			continue
+		}
+		last = record.RecordId
+	}
+}
+
+// Merge bookmark files into the store. Where subscriptions overlap, each
+// channel keeps its earliest position, so nothing is skipped, or with
+// -latest its latest.
+func merge(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("merge", stderr)
+	latest := flags.Bool("latest", false, "Keep the latest position of each channel instead of the earliest")
+	if err := parseFlags(flags, args, 2); err != nil {
+		return err
+	}
+	store, err := winlog.NewFileBookmarkStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	merged := make(map[string][]winlog.BookmarkPosition)
+	add := func(bookmarks map[string]string, source string) error {
+		for subscription, bookmark := range bookmarks {
+			positions, err := winlog.ParseBookmarkXml(bookmark)
+			if err != nil {
+				return fmt.Errorf("%v: %v: %v", source, subscription, err)
+			}
+			merged[subscription] = mergePositions(merged[subscription], positions, *latest)
+		}
+		return nil
+	}
+	if err := add(store.Bookmarks(), flags.Arg(0)); err != nil {
+		return err
+	}
+	for _, path := range flags.Args()[1:] {
+		input, err := openStore(path)
+		if err != nil {
+			return err
+		}
+		if err := add(input.Bookmarks(), path); err != nil {
+			return err
+		}
+	}
+	for subscription, positions := range merged {
+		if err := store.Save(subscription, winlog.FormatBookmarkXml(positions...)); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(stdout, "Merged %v subscriptions into %v\n", len(merged), flags.Arg(0))
+	return err
+}
+
+// Add positions to a bookmark's, keeping the earliest or latest of each channel
+func mergePositions(positions, add []winlog.BookmarkPosition, latest bool) []winlog.BookmarkPosition {
+	for _, position := range add {
+		found := false
+		for i := range positions {
+			if !strings.EqualFold(positions[i].Channel, position.Channel) {
+				continue
+			}
+			found = true
+			if latest && position.RecordId > positions[i].RecordId || !latest && position.RecordId < positions[i].RecordId {
+				positions[i].RecordId = position.RecordId
+			}
+		}
+		if !found {
+			position.IsCurrent = position.IsCurrent && !hasCurrent(positions)
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
+// Check that bookmark stores, or files of bookmark XML, can be parsed
+func validate(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("validate", stderr)
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	invalid := 0
+	for _, path := range flags.Args() {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		bookmarks := make(map[string]string)
+		if trimmed := strings.TrimSpace(string(contents)); strings.HasPrefix(trimmed, "<") {
+			bookmarks[""] = trimmed
+		} else if err := json.Unmarshal(contents, &bookmarks); err != nil {
+			fmt.Fprintf(stdout, "%v: Not a bookmark store: %v\n", path, err)
+			invalid++
+			continue
+		}
+		for _, subscription := range sortedSubscriptions(bookmarks) {
+			if _, err := winlog.ParseBookmarkXml(bookmarks[subscription]); err != nil {
+				fmt.Fprintf(stdout, "%v: %v\n", strings.TrimSuffix(path+": "+subscription, ": "), err)
+				invalid++
+			}
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%v invalid bookmarks", invalid)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Command winlogbookmark inspects and edits the bookmark files written by
+// FileBookmarkStore, without Windows.
+//
+// Usage:
+//
+//	winlogbookmark show [-json] store
+//	winlogbookmark set -subscription name [-channel name] (-record id | -back count | -evtx file (-since duration | -before time)) store
+//	winlogbookmark merge [-latest] store input...
+//	winlogbookmark validate file...
+//
+// A bookmark resumes after the record it points at, so to re-send the last
+// two hours of Security, set its bookmark from the Security EVTX file:
+//
+//	winlogbookmark set -subscription Security -evtx Security.evtx -since 2h bookmarks.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `Usage:
+  winlogbookmark show [-json] store
+  winlogbookmark set -subscription name [-channel name] (-record id | -back count | -evtx file (-since duration | -before time)) store
+  winlogbookmark merge [-latest] store input...
+  winlogbookmark validate file...
+`
+
+// Returned by commands for invalid arguments, after printing the usage
+var errUsage = fmt.Errorf("Invalid arguments")
+
+type command func(args []string, stdout, stderr io.Writer) error
+
+var commands = map[string]command{
+	"show":     show,
+	"set":      set,
+	"merge":    merge,
+	"validate": validate,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Run a command, returning the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "winlogbookmark: Unknown command %q\n%v", args[0], usage)
+		return 2
+	}
+	if err := cmd(args[1:], stdout, stderr); err != nil {
+		if err == errUsage {
+			return 2
+		}
+		fmt.Fprintf(stderr, "winlogbookmark: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// A flag set for a command, which prints the usage on errors
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Parse a command's flags, which must leave at least min arguments
+func parseFlags(flags *flag.FlagSet, args []string, min int) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() < min {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
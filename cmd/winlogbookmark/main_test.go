@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	. "testing"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func runBookmark(t *T, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func bookmarkXml(positions ...winlog.BookmarkPosition) string {
+	return winlog.FormatBookmarkXml(positions...)
+}
+
+// Write a store with the given bookmarks
+func writeStore(t *T, bookmarks map[string]string) string {
+	path := filepath.Join(t.TempDir(), "bookmarks.json")
+	contents, _ := json.Marshal(bookmarks)
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadPositions(t *T, path, subscription string) []winlog.BookmarkPosition {
+	store, err := winlog.NewFileBookmarkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmark, _ := store.Load(subscription)
+	positions, err := winlog.ParseBookmarkXml(bookmark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return positions
+}
+
+func TestShow(t *T) {
+	path := writeStore(t, map[string]string{
+		"System":   bookmarkXml(winlog.BookmarkPosition{Channel: "System", RecordId: 7, IsCurrent: true}),
+		"Security": bookmarkXml(winlog.BookmarkPosition{Channel: "Security", RecordId: 12345, IsCurrent: true}),
+	})
+	code, stdout, _ := runBookmark(t, "show", path)
+	assertEqual(code, 0, t)
+	expected := "SUBSCRIPTION  CHANNEL   RECORD ID  CURRENT\n" +
+		"Security      Security  12345      *\n" +
+		"System        System    7          *\n"
+	assertEqual(stdout, expected, t)
+
+	code, stdout, _ = runBookmark(t, "show", "-json", path)
+	assertEqual(code, 0, t)
+	var positions []positionJSON
+	json.Unmarshal([]byte(stdout), &positions)
+	assertEqual(len(positions), 2, t)
+	assertEqual(positions[0], positionJSON{"Security", "Security", 12345, true}, t)
+
+	code, _, stderr := runBookmark(t, "show", filepath.Join(t.TempDir(), "missing.json"))
+	assertEqual(code, 1, t)
+	assertEqual(strings.HasPrefix(stderr, "winlogbookmark: "), true, t)
+}
+
+func TestSetRecord(t *T) {
+	path := writeStore(t, map[string]string{
+		"Security": bookmarkXml(winlog.BookmarkPosition{Channel: "Security", RecordId: 12345, IsCurrent: true}),
+	})
+	code, stdout, _ := runBookmark(t, "set", "-subscription", "Security", "-record", "12000", path)
+	assertEqual(code, 0, t)
+	assertEqual(stdout, "Security: Security record 12345 -> 12000\n", t)
+	assertEqual(loadPositions(t, path, "Security")[0], winlog.BookmarkPosition{Channel: "Security", RecordId: 12000, IsCurrent: true}, t)
+
+	code, _, _ = runBookmark(t, "set", "-subscription", "Security", "-back", "500", path)
+	assertEqual(code, 0, t)
+	assertEqual(loadPositions(t, path, "Security")[0].RecordId, uint64(11500), t)
+
+	// A new subscription, in a new store
+	newStore := filepath.Join(t.TempDir(), "new.json")
+	code, stdout, _ = runBookmark(t, "set", "-subscription", "Forwarded", "-channel", "ForwardedEvents", "-record", "10", newStore)
+	assertEqual(code, 0, t)
+	assertEqual(stdout, "Forwarded: ForwardedEvents record none -> 10\n", t)
+	assertEqual(loadPositions(t, newStore, "Forwarded")[0], winlog.BookmarkPosition{Channel: "ForwardedEvents", RecordId: 10, IsCurrent: true}, t)
+
+	code, _, _ = runBookmark(t, "set", "-subscription", "System", "-back", "5", path)
+	assertEqual(code, 1, t)
+}
+
+func TestSetFromEvtx(t *T) {
+	path := writeStore(t, map[string]string{})
+	evtx := "../evtx2json/testdata/security.evtx"
+	// Every record in the file was written at 2020-01-02T03:04:05.1234567Z
+	code, _, _ := runBookmark(t, "set", "-subscription", "Security", "-evtx", evtx, "-before", "2020-01-02T03:04:06Z", path)
+	assertEqual(code, 0, t)
+	assertEqual(loadPositions(t, path, "Security")[0].RecordId, uint64(103), t)
+
+	code, _, _ = runBookmark(t, "set", "-subscription", "Security", "-evtx", evtx, "-before", "2020-01-02T03:04:05Z", path)
+	assertEqual(code, 0, t)
+	assertEqual(loadPositions(t, path, "Security")[0].RecordId, uint64(0), t)
+
+	code, _, _ = runBookmark(t, "set", "-subscription", "Security", "-evtx", evtx, "-since", "1h", path)
+	assertEqual(code, 0, t)
+	assertEqual(loadPositions(t, path, "Security")[0].RecordId, uint64(103), t)
+
+	// Records from other channels don't count
+	code, _, _ = runBookmark(t, "set", "-subscription", "System", "-evtx", evtx, "-since", "1h", path)
+	assertEqual(code, 0, t)
+	assertEqual(loadPositions(t, path, "System")[0].RecordId, uint64(0), t)
+}
+
+func TestSetUsage(t *T) {
+	path := writeStore(t, map[string]string{})
+	for _, args := range [][]string{
+		{"set", path},
+		{"set", "-subscription", "Security", path},
+		{"set", "-subscription", "Security", "-record", "1", "-back", "1", path},
+		{"set", "-subscription", "Security", "-evtx", "Security.evtx", path},
+		{"set", "-subscription", "Security", "-record", "1", "-since", "1h", path},
+		{"set", "-subscription", "Security", "-record", "1"},
+		{"unknown"},
+		{},
+	} {
+		if code, _, _ := runBookmark(t, args...); code != 2 {
+			t.Fatalf("Expected %v to be a usage error", args)
+		}
+	}
+}
+
+func TestMerge(t *T) {
+	first := writeStore(t, map[string]string{
+		"Security": bookmarkXml(winlog.BookmarkPosition{Channel: "Security", RecordId: 100, IsCurrent: true}),
+	})
+	second := writeStore(t, map[string]string{
+		"Security": bookmarkXml(winlog.BookmarkPosition{Channel: "Security", RecordId: 50, IsCurrent: true}),
+		"System":   bookmarkXml(winlog.BookmarkPosition{Channel: "System", RecordId: 9, IsCurrent: true}),
+	})
+	earliest := filepath.Join(t.TempDir(), "earliest.json")
+	code, stdout, _ := runBookmark(t, "merge", earliest, first, second)
+	assertEqual(code, 0, t)
+	assertEqual(stdout, "Merged 2 subscriptions into "+earliest+"\n", t)
+	assertEqual(loadPositions(t, earliest, "Security")[0].RecordId, uint64(50), t)
+	assertEqual(loadPositions(t, earliest, "System")[0].RecordId, uint64(9), t)
+
+	// Merging into an existing store includes its bookmarks
+	code, _, _ = runBookmark(t, "merge", "-latest", first, second)
+	assertEqual(code, 0, t)
+	assertEqual(loadPositions(t, first, "Security")[0].RecordId, uint64(100), t)
+	assertEqual(loadPositions(t, first, "System")[0].RecordId, uint64(9), t)
+}
+
+func TestValidate(t *T) {
+	valid := writeStore(t, map[string]string{
+		"Security": "<BookmarkList>\r\n  <Bookmark Channel='Security' RecordId='10811' IsCurrent='true'/>\r\n</BookmarkList>",
+	})
+	code, stdout, _ := runBookmark(t, "validate", valid)
+	assertEqual(code, 0, t)
+	assertEqual(stdout, "", t)
+
+	invalid := writeStore(t, map[string]string{
+		"Security": "<BookmarkList><Bookmark Channel='Security'/></BookmarkList>",
+		"System":   "<BookmarkList>",
+	})
+	xmlFile := filepath.Join(t.TempDir(), "bookmark.xml")
+	os.WriteFile(xmlFile, []byte("<BookmarkList>\r\n  <Bookmark Channel='Security' RecordId='1' IsCurrent='true'/>\r\n</BookmarkList>\r\n"), 0644)
+	code, stdout, stderr := runBookmark(t, "validate", valid, invalid, xmlFile)
+	assertEqual(code, 1, t)
+	assertEqual(strings.Count(stdout, "\n"), 2, t)
+	assertEqual(strings.HasPrefix(stdout, invalid+": Security: Bookmark for channel \"Security\" has no RecordId\n"), true, t)
+	assertEqual(stderr, "winlogbookmark: 2 invalid bookmarks\n", t)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/scalingdata/gowinlog"
+	"github.com/scalingdata/gowinlog/siem"
+)
+
+// Writes an event to the output
+type formatter func(out io.Writer, event *winlog.WinLogEvent) error
+
+func newFormatter(format string) (formatter, error) {
+	switch format {
+	case "text":
+		return formatText, nil
+	case "json":
+		return formatJSON, nil
+	case "xml":
+		return formatXml, nil
+	case "cef":
+		cef := siem.NewCEFFormatter("Microsoft", "Windows", "")
+		return func(out io.Writer, event *winlog.WinLogEvent) error {
+			_, err := fmt.Fprintln(out, cef.Format(event))
+			return err
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown format %q", format)
+}
+
+// One line per event: when, where, what, and the message, or the payload if
+// there's no message
+func formatText(out io.Writer, event *winlog.WinLogEvent) error {
+	message := event.Msg
+	if message == "" {
+		var fields []string
+		for _, field := range event.EventData {
+			if field.Name != "" {
+				fields = append(fields, field.Name+"="+field.Value)
+			} else {
+				fields = append(fields, field.Value)
+			}
+		}
+		message = strings.Join(fields, " ")
+	}
+	_, err := fmt.Fprintf(out, "%v %v %v/%v %v %v: %v\n", createdTime(event), event.ComputerName, event.Channel,
+		event.EventId, levelName(event), event.ProviderName, strings.Join(strings.Fields(message), " "))
+	return err
+}
+
+func formatJSON(out io.Writer, event *winlog.WinLogEvent) error {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", encoded)
+	return err
+}
+
+func formatXml(out io.Writer, event *winlog.WinLogEvent) error {
+	if event.Xml == "" {
+		return fmt.Errorf("Event %v from %v has no XML", event.RecordId, event.SubscribedChannel)
+	}
+	_, err := fmt.Fprintln(out, event.Xml)
+	return err
+}
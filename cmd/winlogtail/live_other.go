@@ -0,0 +1,13 @@
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func newLiveWatcher() (winlog.Watcher, error) {
+	return nil, fmt.Errorf("The live event log is only available on Windows; use -replay to read recordings and EVTX files")
+}
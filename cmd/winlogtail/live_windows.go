@@ -0,0 +1,15 @@
+// +build windows
+
+package main
+
+import (
+	"github.com/scalingdata/gowinlog"
+)
+
+func newLiveWatcher() (winlog.Watcher, error) {
+	watcher, err := winlog.NewWinLogWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return watcher, nil
+}
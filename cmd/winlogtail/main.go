@@ -0,0 +1,247 @@
+// Command winlogtail prints events from Windows event log channels as they
+// arrive. With -replay it reads recordings, EVTX files and XML exports
+// instead, on any OS.
+//
+// Usage:
+//
+//	winlogtail [flags] channel...
+//
+// Filter with -query, or build a query from -id, -level, -provider and
+// -since. With -bookmarks, the last event printed from each channel is saved
+// on exit, and -from bookmark resumes after it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Values of a flag which can be repeated
+type stringList []string
+
+func (self *stringList) String() string {
+	return strings.Join(*self, ",")
+}
+
+func (self *stringList) Set(value string) error {
+	*self = append(*self, value)
+	return nil
+}
+
+type options struct {
+	query     string
+	filter    queryFilter
+	format    string
+	from      string
+	bookmarks string
+	replay    stringList
+	speed     float64
+	catalog   string
+}
+
+func main() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, signals))
+}
+
+func parseFlags(args []string, stderr io.Writer) (*options, []string, error) {
+	opts := &options{}
+	flags := flag.NewFlagSet("winlogtail", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: winlogtail [flags] channel...\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.query, "query", "", "XPath `query` selecting the events to print")
+	flags.StringVar(&opts.filter.ids, "id", "", "Event IDs to print, such as 4624,4634-4647")
+	flags.StringVar(&opts.filter.levels, "level", "", "Levels to print, by number or name, such as error,warning")
+	flags.StringVar(&opts.filter.providers, "provider", "", "Comma-separated provider names to print")
+	flags.DurationVar(&opts.filter.since, "since", 0, "Only print events created within this `duration`")
+	flags.StringVar(&opts.format, "format", "text", "Output `format`: text, json, xml or cef")
+	flags.StringVar(&opts.from, "from", "now", "Where to start: beginning, now or bookmark")
+	flags.StringVar(&opts.bookmarks, "bookmarks", "", "Bookmark `file` to resume from and save to on exit")
+	flags.Var(&opts.replay, "replay", "Replay a recording, EVTX or XML `file`, or a directory of them, instead of the live log (repeatable)")
+	flags.Float64Var(&opts.speed, "speed", 0, "Replay speed relative to the original, or 0 for as fast as possible")
+	flags.StringVar(&opts.catalog, "catalog", "", "Provider catalog `file` for rendering messages of replayed events")
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return nil, nil, fmt.Errorf("No channels given")
+	}
+	return opts, flags.Args(), nil
+}
+
+// Run winlogtail until the replay ends or a signal arrives, returning the exit code
+func run(args []string, stdout, stderr io.Writer, stop <-chan os.Signal) int {
+	opts, channels, err := parseFlags(args, stderr)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(stderr, "winlogtail: %v\n", err)
+		}
+		return 2
+	}
+	query := opts.query
+	if opts.filter.empty() {
+		if query == "" {
+			query = "*"
+		}
+	} else if query != "" {
+		fmt.Fprintf(stderr, "winlogtail: -query can't be combined with -id, -level, -provider or -since\n")
+		return 2
+	} else if query, err = opts.filter.build(); err != nil {
+		fmt.Fprintf(stderr, "winlogtail: %v\n", err)
+		return 2
+	}
+	format, err := newFormatter(opts.format)
+	if err != nil {
+		fmt.Fprintf(stderr, "winlogtail: %v\n", err)
+		return 2
+	}
+	if opts.from != "beginning" && opts.from != "now" && opts.from != "bookmark" {
+		fmt.Fprintf(stderr, "winlogtail: -from must be beginning, now or bookmark\n")
+		return 2
+	}
+	if opts.from == "bookmark" && opts.bookmarks == "" {
+		fmt.Fprintf(stderr, "winlogtail: -from bookmark needs a -bookmarks file\n")
+		return 2
+	}
+
+	var store *winlog.FileBookmarkStore
+	if opts.bookmarks != "" {
+		if store, err = winlog.NewFileBookmarkStore(opts.bookmarks); err != nil {
+			fmt.Fprintf(stderr, "winlogtail: Failed to load bookmarks: %v\n", err)
+			return 1
+		}
+	}
+	watcher, done, err := openWatcher(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "winlogtail: %v\n", err)
+		return 1
+	}
+	for _, channel := range channels {
+		if err := subscribe(watcher, store, opts.from, channel, query); err != nil {
+			watcher.Shutdown()
+			fmt.Fprintf(stderr, "winlogtail: Failed to subscribe to %v: %v\n", channel, err)
+			return 1
+		}
+	}
+	if replay, ok := watcher.(*winlog.ReplayWatcher); ok {
+		replay.Start()
+	}
+
+	// The bookmark of the last event printed from each channel
+	latest := make(map[string]string)
+	for running := true; running; {
+		select {
+		case event := <-watcher.Event():
+			if err := format(stdout, event); err != nil {
+				fmt.Fprintf(stderr, "winlogtail: %v\n", err)
+			}
+			if event.Bookmark != "" {
+				latest[event.SubscribedChannel] = event.Bookmark
+			}
+		case err := <-watcher.Error():
+			fmt.Fprintf(stderr, "winlogtail: %v\n", err)
+		case <-done:
+			running = false
+		case <-stop:
+			running = false
+		}
+	}
+	watcher.Shutdown()
+
+	status := 0
+	for channel, bookmark := range latest {
+		if store == nil {
+			break
+		}
+		if err := store.Save(channel, bookmark); err != nil {
+			fmt.Fprintf(stderr, "winlogtail: Failed to save bookmark for %v: %v\n", channel, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// Open the live event log, or a replay. The channel is closed when a replay
+// has finished.
+func openWatcher(opts *options) (winlog.Watcher, <-chan interface{}, error) {
+	if len(opts.replay) == 0 {
+		watcher, err := newLiveWatcher()
+		return watcher, nil, err
+	}
+	config := winlog.ReplayConfig{Paths: opts.replay, Speed: opts.speed}
+	if opts.catalog != "" {
+		catalog, err := winlog.LoadProviderCatalog(opts.catalog)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to load provider catalog: %v", err)
+		}
+		config.Catalog = catalog
+	}
+	replay, err := winlog.NewReplayWatcher(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return replay, replay.Done(), nil
+}
+
+// Subscribe to a channel from the start position. Channels without a saved
+// bookmark start from now.
+func subscribe(watcher winlog.Watcher, store winlog.BookmarkStore, from, channel, query string) error {
+	switch from {
+	case "beginning":
+		return watcher.SubscribeFromBeginning(channel, query)
+	case "bookmark":
+		bookmark, err := store.Load(channel)
+		if err != nil {
+			return err
+		}
+		if bookmark != "" {
+			return watcher.SubscribeFromBookmark(channel, query, bookmark)
+		}
+	}
+	return watcher.SubscribeFromNow(channel, query)
+}
+
+// Standard level names, for -level and text output
+var levelNames = map[string]uint64{
+	"critical":    1,
+	"error":       2,
+	"warning":     3,
+	"information": 4,
+	"verbose":     5,
+}
+
+func levelName(event *winlog.WinLogEvent) string {
+	if event.LevelText != "" {
+		return event.LevelText
+	}
+	if event.Level == 0 {
+		return "Information"
+	}
+	for name, level := range levelNames {
+		if level == event.Level {
+			return strings.ToUpper(name[:1]) + name[1:]
+		}
+	}
+	return fmt.Sprintf("Level %v", event.Level)
+}
+
+// Created time for text output
+func createdTime(event *winlog.WinLogEvent) string {
+	if event.Created.IsZero() {
+		return "-"
+	}
+	return event.Created.UTC().Format(time.RFC3339Nano)
+}
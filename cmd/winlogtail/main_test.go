@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	. "testing"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+const testEvents = `<Events>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Microsoft-Windows-Security-Auditing'/><EventID>4624</EventID><Level>0</Level><TimeCreated SystemTime='2020-01-02T03:04:05.000000000Z'/><EventRecordID>1</EventRecordID><Channel>Security</Channel><Computer>dc01</Computer></System><EventData><Data Name='TargetUserName'>alice</Data><Data Name='LogonType'>10</Data></EventData></Event>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Microsoft-Windows-Security-Auditing'/><EventID>4625</EventID><Level>0</Level><TimeCreated SystemTime='2020-01-02T03:04:06.000000000Z'/><EventRecordID>2</EventRecordID><Channel>Security</Channel><Computer>dc01</Computer></System><EventData><Data Name='TargetUserName'>bob</Data></EventData></Event>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Service Control Manager'/><EventID>7036</EventID><Level>4</Level><TimeCreated SystemTime='2020-01-02T03:04:07.000000000Z'/><EventRecordID>9</EventRecordID><Channel>System</Channel><Computer>dc01</Computer></System><EventData><Data>Print Spooler</Data><Data>stopped</Data></EventData></Event>
+</Events>`
+
+func writeEvents(t *T) string {
+	path := filepath.Join(t.TempDir(), "events.xml")
+	if err := os.WriteFile(path, []byte(testEvents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runTail(t *T, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr, nil)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestTailText(t *T) {
+	code, stdout, stderr := runTail(t, "-replay", writeEvents(t), "-from", "beginning", "Security", "System")
+	assertEqual(code, 0, t)
+	assertEqual(stderr, "", t)
+	expected := "2020-01-02T03:04:05Z dc01 Security/4624 Information Microsoft-Windows-Security-Auditing: TargetUserName=alice LogonType=10\n" +
+		"2020-01-02T03:04:06Z dc01 Security/4625 Information Microsoft-Windows-Security-Auditing: TargetUserName=bob\n" +
+		"2020-01-02T03:04:07Z dc01 System/7036 Information Service Control Manager: Print Spooler stopped\n"
+	assertEqual(stdout, expected, t)
+}
+
+func TestTailFilters(t *T) {
+	events := writeEvents(t)
+	code, stdout, _ := runTail(t, "-replay", events, "-id", "4620-4624,7036", "-format", "json", "Security", "System")
+	assertEqual(code, 0, t)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assertEqual(len(lines), 2, t)
+	var event winlog.WinLogEvent
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(event.EventId, uint64(7036), t)
+
+	code, stdout, _ = runTail(t, "-replay", events, "-query", "*[EventData[Data[@Name='TargetUserName']='bob']]", "-format", "xml", "Security")
+	assertEqual(code, 0, t)
+	assertEqual(strings.Count(stdout, "<Event "), 1, t)
+	assertEqual(strings.Contains(stdout, "<EventRecordID>2</EventRecordID>"), true, t)
+
+	code, stdout, _ = runTail(t, "-replay", events, "-level", "information", "-provider", "Service Control Manager", "-format", "cef", "System")
+	assertEqual(code, 0, t)
+	assertEqual(strings.HasPrefix(stdout, "CEF:0|Microsoft|Windows||7036|"), true, t)
+}
+
+func TestTailBookmarks(t *T) {
+	events := writeEvents(t)
+	bookmarks := filepath.Join(t.TempDir(), "bookmarks.json")
+	code, _, _ := runTail(t, "-replay", events, "-bookmarks", bookmarks, "-id", "4624", "Security")
+	assertEqual(code, 0, t)
+	store, err := winlog.NewFileBookmarkStore(bookmarks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, winlog.FormatBookmarkXml(winlog.BookmarkPosition{Channel: "Security", RecordId: 1, IsCurrent: true}), t)
+
+	code, stdout, _ := runTail(t, "-replay", events, "-bookmarks", bookmarks, "-from", "bookmark", "-format", "json", "Security")
+	assertEqual(code, 0, t)
+	assertEqual(strings.Count(stdout, "\n"), 1, t)
+	assertEqual(strings.Contains(stdout, `"record_id":2`), true, t)
+}
+
+func TestTailUsage(t *T) {
+	for _, args := range [][]string{
+		{},
+		{"-format", "yaml", "Security"},
+		{"-from", "yesterday", "Security"},
+		{"-from", "bookmark", "Security"},
+		{"-query", "*", "-id", "4624", "Security"},
+		{"-id", "4624-", "Security"},
+		{"-level", "loud", "Security"},
+		{"-provider", "O'Brien", "Security"},
+		{"-query", "*[", "-replay", ".", "Security"},
+	} {
+		code, _, stderr := runTail(t, args...)
+		if code == 0 || stderr == "" {
+			t.Fatalf("Expected %v to fail", args)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		code, _, stderr := runTail(t, "Security")
+		assertEqual(code, 1, t)
+		assertEqual(strings.Contains(stderr, "-replay"), true, t)
+	}
+}
+
+func TestQueryFilter(t *T) {
+	filter := queryFilter{ids: "4624, 4634-4647", levels: "error,3", providers: "A,B"}
+	query, err := filter.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(query, "*[System[(EventID=4624 or (EventID>=4634 and EventID<=4647)) and (Level=2 or Level=3) and Provider[@Name='A' or @Name='B']]]", t)
+}
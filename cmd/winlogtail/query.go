@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter flags, which are combined into an XPath query
+type queryFilter struct {
+	ids       string
+	levels    string
+	providers string
+	since     time.Duration
+}
+
+func (self *queryFilter) empty() bool {
+	return self.ids == "" && self.levels == "" && self.providers == "" && self.since == 0
+}
+
+// Build the query, such as
+//
+//	*[System[(EventID=4624 or (EventID>=4634 and EventID<=4647)) and (Level=2)]]
+func (self *queryFilter) build() (string, error) {
+	var conditions []string
+	if self.ids != "" {
+		condition, err := idCondition(self.ids)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	if self.levels != "" {
+		condition, err := levelCondition(self.levels)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	if self.providers != "" {
+		var names []string
+		for _, name := range splitList(self.providers) {
+			if strings.ContainsAny(name, "'\"") {
+				return "", fmt.Errorf("Invalid provider name %q", name)
+			}
+			names = append(names, "@Name='"+name+"'")
+		}
+		conditions = append(conditions, "Provider["+strings.Join(names, " or ")+"]")
+	}
+	if self.since < 0 {
+		return "", fmt.Errorf("-since must be positive")
+	}
+	if self.since > 0 {
+		conditions = append(conditions, fmt.Sprintf("TimeCreated[timediff(@SystemTime) <= %d]", self.since.Milliseconds()))
+	}
+	return "*[System[" + strings.Join(conditions, " and ") + "]]", nil
+}
+
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Match event IDs and ranges of IDs, such as 4624,4634-4647
+func idCondition(ids string) (string, error) {
+	var terms []string
+	for _, id := range splitList(ids) {
+		low, high, isRange := strings.Cut(id, "-")
+		first, err := strconv.ParseUint(low, 10, 16)
+		if err != nil {
+			return "", fmt.Errorf("Invalid event ID %q", id)
+		}
+		if !isRange {
+			terms = append(terms, fmt.Sprintf("EventID=%d", first))
+			continue
+		}
+		last, err := strconv.ParseUint(high, 10, 16)
+		if err != nil || last < first {
+			return "", fmt.Errorf("Invalid event ID range %q", id)
+		}
+		terms = append(terms, fmt.Sprintf("(EventID>=%d and EventID<=%d)", first, last))
+	}
+	return "(" + strings.Join(terms, " or ") + ")", nil
+}
+
+// Match levels by number or standard name
+func levelCondition(levels string) (string, error) {
+	var terms []string
+	for _, level := range splitList(levels) {
+		number, ok := levelNames[strings.ToLower(level)]
+		if !ok {
+			var err error
+			if number, err = strconv.ParseUint(level, 10, 8); err != nil {
+				return "", fmt.Errorf("Invalid level %q", level)
+			}
+		}
+		terms = append(terms, fmt.Sprintf("Level=%d", number))
+	}
+	return "(" + strings.Join(terms, " or ") + ")", nil
+}
@@ -0,0 +1,200 @@
+// Package config describes a watcher pipeline declaratively: the channels to
+// subscribe to and how to render their events, the sinks to send them to and
+// where to keep bookmarks. Configs are loaded from YAML or JSON, validated
+// strictly, and built into a running Pipeline.
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// A pipeline's configuration. Keys are snake_case in YAML and JSON, such as
+// "bookmark_interval".
+type Config struct {
+	Bookmarks BookmarksConfig `json:"bookmarks"`
+	// The default render settings, for subscriptions without their own
+	Render        *RenderConfig        `json:"render"`
+	Subscriptions []SubscriptionConfig `json:"subscriptions"`
+	Sinks         []SinkConfig         `json:"sinks"`
+}
+
+// Where bookmarks are kept
+type BookmarksConfig struct {
+	// "file" or "memory". The default is "file" if Path is set, and "memory"
+	// otherwise.
+	Type string `json:"type"`
+	// The FileBookmarkStore's file
+	Path string `json:"path"`
+	// How often the file is written, rather than on every saved bookmark.
+	// After a crash, up to this long of events are sent again.
+	FlushInterval Duration `json:"flush_interval"`
+}
+
+// Settings for a RenderProfile. Unset fields keep the value from Profile.
+type RenderConfig struct {
+	// "minimal", "standard" or "full", the default
+	Profile string `json:"profile"`
+	// System properties to render, by snake_case name such as "event_id" or
+	// "computer_name". All of them by default.
+	SystemFields []string `json:"system_fields"`
+
+	Message      *bool `json:"message"`
+	LevelText    *bool `json:"level_text"`
+	TaskText     *bool `json:"task_text"`
+	ProviderText *bool `json:"provider_text"`
+	OpcodeText   *bool `json:"opcode_text"`
+	ChannelText  *bool `json:"channel_text"`
+	IdText       *bool `json:"id_text"`
+	Xml          *bool `json:"xml"`
+	EventData    *bool `json:"event_data"`
+
+	BookmarkInterval *int `json:"bookmark_interval"`
+}
+
+// A channel to subscribe to
+type SubscriptionConfig struct {
+	Channel string `json:"channel"`
+	// XPath query, "*" by default
+	Query string `json:"query"`
+	// "beginning", "now" or "bookmark", the default. Channels without a saved
+	// bookmark start from now.
+	From string `json:"from"`
+	// Render settings for this channel, instead of the default
+	Render *RenderConfig `json:"render"`
+}
+
+// A sink and the route feeding it. Exactly one of Syslog, Gelf, HTTP and
+// OTLP must be set.
+type SinkConfig struct {
+	// Unique name, used as the route name
+	Name   string       `json:"name"`
+	Filter FilterConfig `json:"filter"`
+	// Events queued for the sink, 1000 by default
+	QueueSize int `json:"queue_size"`
+	// "block", the default, or "drop"
+	Overflow string `json:"overflow"`
+
+	Syslog *SyslogConfig `json:"syslog"`
+	Gelf   *GelfConfig   `json:"gelf"`
+	HTTP   *HTTPConfig   `json:"http"`
+	OTLP   *OTLPConfig   `json:"otlp"`
+}
+
+// Selects the events sent to a sink, like router.Filter
+type FilterConfig struct {
+	// Subscribed channels, case-insensitive
+	Channels []string `json:"channels"`
+	// Provider names, case-insensitive
+	Providers []string `json:"providers"`
+	EventIds  []uint64 `json:"event_ids"`
+	Levels    []uint64 `json:"levels"`
+	// XPath filter on the event XML
+	Query string `json:"query"`
+}
+
+// TLS client settings. The system roots are used without a CA file.
+type TLSConfig struct {
+	CAFile   string `json:"ca_file"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// Name to verify the server's certificate against, the host by default
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// Settings for a syslog.Sink
+type SyslogConfig struct {
+	// "udp", "tcp" or "tls"
+	Network string     `json:"network"`
+	Address string     `json:"address"`
+	TLS     *TLSConfig `json:"tls"`
+	// "rfc5424", the default, or "rfc3164"
+	Format string `json:"format"`
+	// Facility name, such as "user" (the default), "auth" or "local0"
+	Facility     string `json:"facility"`
+	Hostname     string `json:"hostname"`
+	AppName      string `json:"app_name"`
+	EnterpriseId int    `json:"enterprise_id"`
+
+	DialTimeout       Duration `json:"dial_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	Retries           int      `json:"retries"`
+	ReconnectDelay    Duration `json:"reconnect_delay"`
+	MaxReconnectDelay Duration `json:"max_reconnect_delay"`
+}
+
+// Settings for a gelf.Sink
+type GelfConfig struct {
+	// "udp" or "tcp"
+	Network string `json:"network"`
+	Address string `json:"address"`
+	// "gzip", the default, "zlib" or "none"
+	Compression string `json:"compression"`
+	ChunkSize   int    `json:"chunk_size"`
+	Host        string `json:"host"`
+
+	DialTimeout       Duration `json:"dial_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	Retries           int      `json:"retries"`
+	ReconnectDelay    Duration `json:"reconnect_delay"`
+	MaxReconnectDelay Duration `json:"max_reconnect_delay"`
+}
+
+// Settings for an httpsink.Sink
+type HTTPConfig struct {
+	URL string `json:"url"`
+	// "ndjson", the default, or "json"
+	Format  string            `json:"format"`
+	Gzip    bool              `json:"gzip"`
+	Headers map[string]string `json:"headers"`
+	Token   string            `json:"token"`
+	TLS     *TLSConfig        `json:"tls"`
+	Timeout Duration          `json:"timeout"`
+
+	BatchSize     int      `json:"batch_size"`
+	FlushInterval Duration `json:"flush_interval"`
+	Retries       int      `json:"retries"`
+	RetryDelay    Duration `json:"retry_delay"`
+	MaxRetryDelay Duration `json:"max_retry_delay"`
+
+	QueueDir      string `json:"queue_dir"`
+	MaxQueueBytes int64  `json:"max_queue_bytes"`
+}
+
+// Settings for an otlp.Exporter
+type OTLPConfig struct {
+	Endpoint string `json:"endpoint"`
+	// "http/protobuf", the default, "http/json" or "grpc", as in the
+	// OpenTelemetry exporter settings
+	Protocol string            `json:"protocol"`
+	Headers  map[string]string `json:"headers"`
+	TLS      *TLSConfig        `json:"tls"`
+	Timeout  Duration          `json:"timeout"`
+
+	BatchSize     int      `json:"batch_size"`
+	FlushInterval Duration `json:"flush_interval"`
+	Retries       int      `json:"retries"`
+	RetryDelay    Duration `json:"retry_delay"`
+	MaxRetryDelay Duration `json:"max_retry_delay"`
+}
+
+// A time.Duration written as a string such as "500ms" or "1m30s"
+type Duration time.Duration
+
+func (self Duration) String() string {
+	return time.Duration(self).String()
+}
+
+func (self Duration) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+func (self *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("Invalid duration %q, expected a duration such as \"10s\"", text)
+	}
+	*self = Duration(duration)
+	return nil
+}
@@ -0,0 +1,321 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+const testYAML = `
+# Forward security events to the SIEM
+bookmarks:
+  path: C:\ProgramData\shipper\bookmarks.json
+
+render:
+  profile: standard
+  id_text: true
+
+subscriptions:
+  - channel: Security
+    query: "*[System[(EventID=4624 or EventID=4625)]]"
+  - channel: System
+    from: beginning
+    render:
+      profile: minimal
+      system_fields: [event_id, level, created, record_id]
+      bookmark_interval: 10
+
+sinks:
+- name: siem
+  filter:
+    channels: [Security]
+    event_ids: [4624, 4625]
+  overflow: drop
+  syslog:
+    network: tls
+    address: 'siem.example.com:6514'
+    tls: {ca_file: ca.pem, server_name: siem}
+    facility: authpriv
+    reconnect_delay: 1s
+- name: archive
+  queue_size: 5000
+  http:
+    url: https://archive.example.com/events
+    headers:
+      X-Source: "workstation\t01"
+    batch_size: 100
+    flush_interval: 250ms
+`
+
+func TestParseYAMLConfig(t *T) {
+	config, err := ParseYAML([]byte(testYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(config.Bookmarks.Path, `C:\ProgramData\shipper\bookmarks.json`, t)
+	assertEqual(len(config.Subscriptions), 2, t)
+	assertEqual(config.Subscriptions[0].Query, "*[System[(EventID=4624 or EventID=4625)]]", t)
+	assertEqual(config.Subscriptions[1].From, "beginning", t)
+
+	profile := config.Render.RenderProfile()
+	assertEqual(profile.IdText, true, t)
+	assertEqual(profile.Message, winlog.RenderProfileStandard.Message, t)
+	profile = config.Subscriptions[1].Render.RenderProfile()
+	assertEqual(profile.SystemFields, winlog.SystemFieldEventId|winlog.SystemFieldLevel|winlog.SystemFieldCreated|winlog.SystemFieldRecordId, t)
+	assertEqual(profile.BookmarkInterval, 10, t)
+	assertEqual(profile.Xml, false, t)
+	assertEqual((*RenderConfig)(nil).RenderProfile(), winlog.RenderProfileFull, t)
+
+	siem := config.Sinks[0]
+	assertEqual(siem.Filter.EventIds[1], uint64(4625), t)
+	assertEqual(siem.Overflow, "drop", t)
+	assertEqual(siem.Syslog.Address, "siem.example.com:6514", t)
+	assertEqual(siem.Syslog.TLS.ServerName, "siem", t)
+	assertEqual(siem.Syslog.ReconnectDelay, Duration(time.Second), t)
+	archive := config.Sinks[1]
+	assertEqual(archive.QueueSize, 5000, t)
+	assertEqual(archive.HTTP.Headers["X-Source"], "workstation\t01", t)
+	assertEqual(archive.HTTP.FlushInterval, Duration(250*time.Millisecond), t)
+}
+
+func TestParseJSONConfig(t *T) {
+	config, err := ParseJSON([]byte(`{
+		"subscriptions": [{"channel": "Application"}],
+		"sinks": [{"name": "graylog", "gelf": {"network": "udp", "address": "graylog:12201", "compression": "zlib"}}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(config.Subscriptions[0].Channel, "Application", t)
+	assertEqual(config.Sinks[0].Gelf.Compression, "zlib", t)
+
+	_, err = ParseJSON([]byte(`{"subscriptions": []} {}`))
+	assertEqual(err.Error(), "Invalid JSON: Unexpected data after the config", t)
+}
+
+func TestDecodeProblems(t *T) {
+	_, err := ParseYAML([]byte(`
+subscriptions:
+  - channel: Security
+    form: beginning
+sinks:
+  - name: siem
+    queue_size: lots
+    filter:
+      event_ids: [4624, -1]
+    syslog:
+      network: tcp
+      address: [siem]
+      dial_timeout: 10
+      write_timeout: ten seconds
+`))
+	problems := err.(*Error).Problems
+	assertEqual(strings.Join(problems, "\n"), strings.Join([]string{
+		`sinks[0].filter.event_ids[1]: Expected a non-negative integer, got -1`,
+		`sinks[0].queue_size: Expected an integer, got "lots"`,
+		`sinks[0].syslog.address: Expected a string, got a list`,
+		`sinks[0].syslog.dial_timeout: Expected a string, got 10`,
+		`sinks[0].syslog.write_timeout: Invalid duration "ten seconds", expected a duration such as "10s"`,
+		`subscriptions[0]: Unknown setting "form"`,
+	}, "\n"), t)
+	assertEqual(strings.HasPrefix(err.Error(), "Invalid config, 6 problems:\n  sinks[0].filter"), true, t)
+
+	_, err = ParseJSON([]byte(`[]`))
+	assertEqual(err.Error(), "Invalid config: config: Expected a mapping, got a list", t)
+}
+
+func TestValidate(t *T) {
+	config := &Config{
+		Bookmarks: BookmarksConfig{Type: "file"},
+		Render:    &RenderConfig{Profile: "fast", SystemFields: []string{"event_id", "eventid"}},
+		Subscriptions: []SubscriptionConfig{
+			{Channel: "Security", From: "start"},
+			{Channel: "security"},
+			{},
+		},
+		Sinks: []SinkConfig{
+			{Name: "siem", Filter: FilterConfig{Channels: []string{"Sytem"}, Query: "Event[System"},
+				Syslog: &SyslogConfig{Address: "siem", TLS: &TLSConfig{CertFile: "cert.pem"}, Facility: "kern"}},
+			{Name: "siem", Overflow: "wait", HTTP: &HTTPConfig{URL: "ftp://archive"}, OTLP: &OTLPConfig{Protocol: "grpc"}},
+			{Name: "graylog", Gelf: &GelfConfig{Network: "udp", Address: "graylog:12201", ChunkSize: 8, WriteTimeout: -1}},
+			{Name: "nothing"},
+		},
+	}
+	err := config.Validate()
+	expected := []string{
+		`bookmarks.path: Required for a file bookmark store`,
+		`render.profile: Unknown value "fast", expected "minimal", "standard" or "full"`,
+		`render.system_fields[1]: Unknown System property "eventid"`,
+		`subscriptions[0].from: Unknown value "start", expected "beginning", "now" or "bookmark"`,
+		`subscriptions[1].channel: "security" is already subscribed`,
+		`subscriptions[2].channel: Required`,
+		`sinks[0].filter.channels[0]: "Sytem" isn't subscribed`,
+	}
+	problems := err.(*Error).Problems
+	for i, problem := range expected {
+		assertEqual(problems[i], problem, t)
+	}
+	assertEqual(strings.HasPrefix(problems[len(expected)], "sinks[0].filter.query: "), true, t)
+	assertEqual(strings.Join(problems[len(expected)+1:], "\n"), strings.Join([]string{
+		`sinks[0].syslog.network: Required`,
+		`sinks[0].syslog.address: Expected host:port, got "siem"`,
+		`sinks[0].syslog.tls: Only used with network "tls"`,
+		`sinks[0].syslog.tls.key_file: Required with cert_file`,
+		`sinks[0].syslog.facility: Unknown facility "kern"`,
+		`sinks[1].name: Sink "siem" is already defined`,
+		`sinks[1].overflow: Unknown value "wait", expected "block" or "drop"`,
+		`sinks[1].http.url: Expected an http or https URL, got "ftp://archive"`,
+		`sinks[1].otlp.endpoint: Required`,
+		`sinks[1]: Has more than one sink type: http, otlp`,
+		`sinks[2].gelf.chunk_size: Must be more than 12 bytes`,
+		`sinks[2].gelf.write_timeout: Can't be negative`,
+		`sinks[3]: Needs one of syslog, gelf, http or otlp`,
+	}, "\n"), t)
+
+	err = (&Config{}).Validate()
+	assertEqual(err.Error(), "Invalid config, 2 problems:\n  subscriptions: At least one subscription is required\n  sinks: At least one sink is required", t)
+}
+
+func TestLoad(t *T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "shipper.yml")
+	os.WriteFile(yamlPath, []byte(testYAML), 0644)
+	config, err := Load(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(config.Sinks[1].Name, "archive", t)
+
+	jsonPath := filepath.Join(dir, "shipper.json")
+	os.WriteFile(jsonPath, []byte(`{"subscriptions": [{"channel": "System"}], "sinks": [{"name": "a", "otlp": {"endpoint": "http://collector:4318/v1/logs", "protocol": "grpc"}}]}`), 0644)
+	config, err = Load(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(config.Sinks[0].OTLP.Protocol, "grpc", t)
+
+	badPath := filepath.Join(dir, "bad.yaml")
+	os.WriteFile(badPath, []byte("subscriptions:\n  - channel: System\n\tquery: '*'\n"), 0644)
+	_, err = Load(badPath)
+	assertEqual(err.Error(), badPath+": Invalid YAML: Line 3: Tabs can't be used for indentation", t)
+
+	_, err = Load(filepath.Join(dir, "shipper.toml"))
+	assertEqual(err != nil, true, t)
+}
+
+func TestPipeline(t *T) {
+	dir := t.TempDir()
+	exported := filepath.Join(dir, "application.xml")
+	os.WriteFile(exported, []byte(`<Events>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='App'/><EventID>1</EventID><Level>4</Level><EventRecordID>1</EventRecordID><Channel>Application</Channel></System></Event>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='App'/><EventID>2</EventID><Level>2</Level><EventRecordID>2</EventRecordID><Channel>Application</Channel></System></Event>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='App'/><EventID>3</EventID><Level>4</Level><EventRecordID>3</EventRecordID><Channel>Application</Channel></System></Event>
+</Events>`), 0644)
+
+	var mutex sync.Mutex
+	received := make(map[string][]uint64)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			var event winlog.WinLogEvent
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Error(err)
+			}
+			received[r.URL.Path] = append(received[r.URL.Path], event.EventId)
+		}
+	}))
+	defer server.Close()
+
+	bookmarksPath := filepath.Join(dir, "bookmarks.json")
+	config, err := ParseYAML([]byte(`
+bookmarks:
+  path: ` + bookmarksPath + `
+  flush_interval: 1h
+subscriptions:
+  - channel: Application
+sinks:
+  - name: all
+    http:
+      url: ` + server.URL + `/all
+      flush_interval: 10ms
+  - name: errors
+    filter: {levels: [1, 2]}
+    http:
+      url: ` + server.URL + `/errors
+      flush_interval: 10ms
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start from the beginning of the export the first time
+	bookmark := winlog.FormatBookmarkXml(winlog.BookmarkPosition{Channel: "Application", RecordId: 0, IsCurrent: true})
+	store, _ := winlog.NewFileBookmarkStore(bookmarksPath)
+	store.Save("Application", bookmark)
+
+	watcher, err := winlog.NewReplayWatcher(winlog.ReplayConfig{Paths: []string{exported}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := NewPipeline(config, watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error)
+	go func() {
+		result <- pipeline.Run()
+	}()
+	watcher.Start()
+	<-watcher.Done()
+	if err := pipeline.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assertEqual(len(received["/all"]), 3, t)
+	assertEqual(len(received["/errors"]), 1, t)
+	assertEqual(received["/errors"][0], uint64(2), t)
+
+	// Both sinks have every event, so the store has the last one, which
+	// Close wrote before the flush interval
+	store, _ = winlog.NewFileBookmarkStore(bookmarksPath)
+	saved, _ := store.Load("Application")
+	positions, _ := winlog.ParseBookmarkXml(saved)
+	assertEqual(positions[0].RecordId, uint64(3), t)
+}
+
+func TestPipelineSinkError(t *T) {
+	config := &Config{
+		Subscriptions: []SubscriptionConfig{{Channel: "Application"}},
+		Sinks: []SinkConfig{{Name: "broken", HTTP: &HTTPConfig{
+			URL:   "http://127.0.0.1:1/events",
+			TLS:   &TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+			Token: "secret",
+		}}},
+	}
+	watcher, _ := winlog.NewReplayWatcher(winlog.ReplayConfig{})
+	defer watcher.Shutdown()
+	_, err := NewPipeline(config, watcher)
+	assertEqual(strings.HasPrefix(err.Error(), `Failed to create sink "broken": `), true, t)
+}
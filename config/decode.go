@@ -0,0 +1,246 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// Problems found decoding or validating a config. Each starts with the path
+// of the setting, such as "sinks[0].syslog.address: Required".
+type Error struct {
+	Problems []string
+}
+
+func (self *Error) Error() string {
+	if len(self.Problems) == 1 {
+		return "Invalid config: " + self.Problems[0]
+	}
+	return fmt.Sprintf("Invalid config, %v problems:\n  %v", len(self.Problems), strings.Join(self.Problems, "\n  "))
+}
+
+// Load and validate a config from a YAML (.yaml or .yml) or JSON (.json) file
+func Load(path string) (*Config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config *Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		config, err = ParseYAML(contents)
+	case ".json":
+		config, err = ParseJSON(contents)
+	default:
+		return nil, fmt.Errorf("Unknown config format %q in %v, expected .yaml, .yml or .json", ext, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return config, nil
+}
+
+// Parse and validate a JSON config
+func ParseJSON(data []byte) (*Config, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Invalid JSON: Unexpected data after the config")
+	}
+	return decodeDocument(document)
+}
+
+// Parse and validate a YAML config. The common subset of YAML is supported:
+// block and flow mappings and lists, plain and quoted scalars, and comments.
+// Anchors, aliases, tags, block scalars and multiple documents are not.
+func ParseYAML(data []byte) (*Config, error) {
+	document, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid YAML: %v", err)
+	}
+	return decodeDocument(document)
+}
+
+// Decode a document of maps, lists and scalars, as produced by encoding/json
+// with UseNumber or by parseYAML, into a Config and validate it
+func decodeDocument(document interface{}) (*Config, error) {
+	config := &Config{}
+	var problems []string
+	decodeValue(document, reflect.ValueOf(config).Elem(), "", &problems)
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Decode a value into target, the way encoding/json would, except that
+// unknown keys and type mismatches are reported with their path
+func decodeValue(value interface{}, target reflect.Value, path string, problems *[]string) {
+	if value == nil {
+		return
+	}
+	fail := func(expected string) {
+		*problems = append(*problems, fmt.Sprintf("%v: Expected %v, got %v", pathName(path), expected, describeValue(value)))
+	}
+
+	if target.Kind() == reflect.Ptr {
+		elem := reflect.New(target.Type().Elem())
+		decodeValue(value, elem.Elem(), path, problems)
+		target.Set(elem)
+		return
+	}
+	if reflect.PtrTo(target.Type()).Implements(textUnmarshalerType) {
+		text, ok := value.(string)
+		if !ok {
+			fail("a string")
+			return
+		}
+		if err := target.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text)); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%v: %v", pathName(path), err))
+		}
+		return
+	}
+
+	switch target.Kind() {
+	case reflect.Struct:
+		mapping, ok := value.(map[string]interface{})
+		if !ok {
+			fail("a mapping")
+			return
+		}
+		fields := make(map[string]int)
+		for i := 0; i < target.NumField(); i++ {
+			if name := strings.Split(target.Type().Field(i).Tag.Get("json"), ",")[0]; name != "" {
+				fields[name] = i
+			}
+		}
+		for _, key := range sortedKeys(mapping) {
+			field, ok := fields[key]
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("%v: Unknown setting %q", pathName(path), key))
+				continue
+			}
+			decodeValue(mapping[key], target.Field(field), joinPath(path, key), problems)
+		}
+	case reflect.Map:
+		mapping, ok := value.(map[string]interface{})
+		if !ok {
+			fail("a mapping")
+			return
+		}
+		result := reflect.MakeMapWithSize(target.Type(), len(mapping))
+		for _, key := range sortedKeys(mapping) {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			decodeValue(mapping[key], elem, joinPath(path, key), problems)
+			result.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+		target.Set(result)
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			fail("a list")
+			return
+		}
+		result := reflect.MakeSlice(target.Type(), len(list), len(list))
+		for i, item := range list {
+			decodeValue(item, result.Index(i), fmt.Sprintf("%v[%v]", path, i), problems)
+		}
+		target.Set(result)
+	case reflect.String:
+		text, ok := value.(string)
+		if !ok {
+			fail("a string")
+			return
+		}
+		target.SetString(text)
+	case reflect.Bool:
+		boolean, ok := value.(bool)
+		if !ok {
+			fail("true or false")
+			return
+		}
+		target.SetBool(boolean)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := value.(json.Number)
+		if !ok {
+			fail("an integer")
+			return
+		}
+		integer, err := number.Int64()
+		if err != nil || target.OverflowInt(integer) {
+			fail("an integer")
+			return
+		}
+		target.SetInt(integer)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := value.(json.Number)
+		if !ok {
+			fail("a non-negative integer")
+			return
+		}
+		integer, err := strconv.ParseUint(number.String(), 10, 64)
+		if err != nil || target.OverflowUint(integer) {
+			fail("a non-negative integer")
+			return
+		}
+		target.SetUint(integer)
+	default:
+		panic(fmt.Sprintf("Can't decode config field of type %v", target.Type()))
+	}
+}
+
+func describeValue(value interface{}) string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return "a mapping"
+	case []interface{}:
+		return "a list"
+	case string:
+		return fmt.Sprintf("%q", value)
+	case bool, json.Number:
+		return fmt.Sprint(value)
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func sortedKeys(mapping map[string]interface{}) []string {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// The path for messages, which is "config" for the whole document
+func pathName(path string) string {
+	if path == "" {
+		return "config"
+	}
+	return path
+}
@@ -0,0 +1,503 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+	"github.com/scalingdata/gowinlog/gelf"
+	"github.com/scalingdata/gowinlog/httpsink"
+	"github.com/scalingdata/gowinlog/otlp"
+	"github.com/scalingdata/gowinlog/router"
+	"github.com/scalingdata/gowinlog/syslog"
+)
+
+// A watcher whose render profiles can be set, such as WinLogWatcher
+type renderProfiler interface {
+	SetRenderProfile(profile winlog.RenderProfile)
+	SetChannelRenderProfile(channel string, profile *winlog.RenderProfile)
+}
+
+// The API shared by the sinks
+type sink interface {
+	Run(events <-chan *winlog.WinLogEvent) error
+	Close() error
+}
+
+type pipelineSink struct {
+	name  string
+	route *router.Route
+	sink  sink
+}
+
+// A watcher subscribed to the configured channels, and a router sending its
+// events to the configured sinks
+type Pipeline struct {
+	watcher   winlog.Watcher
+	bookmarks winlog.BookmarkStore
+	router    *router.Router
+	sinks     []*pipelineSink
+
+	// The config the pipeline was built from, with the subscriptions and
+	// render settings of the last reload
+	config *Config
+	// The latest bookmark delivered from each subscribed channel, by lower
+	// case channel name
+	positions   map[string]string
+	reloadMutex sync.Mutex
+	logger      winlog.Logger
+
+	shutdownOnce sync.Once
+	running      bool
+	done         chan interface{}
+	closed       chan interface{}
+	mutex        sync.Mutex
+}
+
+// Build the bookmark store, router and sinks described by the config,
+// set the watcher's render profiles if it has them, and subscribe it to the
+// channels. Events are delivered once Run is called, and a ReplayWatcher must
+// also be started. If subscribing fails, the watcher may have some of the
+// subscriptions, so it should be shut down.
+func NewPipeline(config *Config, watcher winlog.Watcher) (*Pipeline, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	bookmarks, err := config.Bookmarks.open()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bookmark store: %v", err)
+	}
+	pipeline := &Pipeline{
+		watcher:   watcher,
+		config:    config,
+		positions: make(map[string]string),
+		logger:    winlog.NopLogger{},
+		done:      make(chan interface{}),
+		closed:    make(chan interface{}),
+	}
+	pipeline.bookmarks = &loggedBookmarkStore{bookmarks, pipeline}
+	pipeline.router = router.New(router.Config{Bookmarks: pipeline.bookmarks})
+	for _, sinkConfig := range config.Sinks {
+		if err := pipeline.addSink(sinkConfig); err != nil {
+			pipeline.closeSinks()
+			return nil, fmt.Errorf("Failed to create sink %q: %v", sinkConfig.Name, err)
+		}
+	}
+
+	if profiler, ok := watcher.(renderProfiler); ok {
+		profiler.SetRenderProfile(config.Render.RenderProfile())
+		for _, subscription := range config.Subscriptions {
+			if subscription.Render != nil {
+				profile := subscription.Render.RenderProfile()
+				profiler.SetChannelRenderProfile(subscription.Channel, &profile)
+			}
+		}
+	}
+	for _, subscription := range config.Subscriptions {
+		bookmark := ""
+		if subscription.From == "" || subscription.From == "bookmark" {
+			if bookmark, err = pipeline.bookmarks.Load(subscription.Channel); err != nil {
+				pipeline.closeSinks()
+				return nil, fmt.Errorf("Failed to load bookmark for %q: %v", subscription.Channel, err)
+			}
+		}
+		if err := subscription.subscribe(watcher, bookmark); err != nil {
+			pipeline.closeSinks()
+			return nil, fmt.Errorf("Failed to subscribe to %q: %v", subscription.Channel, err)
+		}
+	}
+	return pipeline, nil
+}
+
+func (self *Pipeline) addSink(config SinkConfig) error {
+	overflow := router.OverflowBlock
+	if config.Overflow == "drop" {
+		overflow = router.OverflowDrop
+	}
+	route, err := self.router.AddRoute(router.RouteConfig{
+		Name: config.Name,
+		Filter: router.Filter{
+			Channels:  config.Filter.Channels,
+			Providers: config.Filter.Providers,
+			EventIds:  config.Filter.EventIds,
+			Levels:    config.Filter.Levels,
+			XPath:     config.Filter.Query,
+		},
+		QueueSize: config.QueueSize,
+		Overflow:  overflow,
+	})
+	if err != nil {
+		return err
+	}
+	sink, err := config.build(route)
+	if err != nil {
+		return err
+	}
+	self.sinks = append(self.sinks, &pipelineSink{config.Name, route, sink})
+	return nil
+}
+
+// Log bookmark saves, sink failures, reloads and shutdown to `logger`, such
+// as a *slog.Logger. Give the watcher a logger too, for its subscriptions.
+// Must be called before Run.
+func (self *Pipeline) SetLogger(logger winlog.Logger) {
+	if logger == nil {
+		logger = winlog.NopLogger{}
+	}
+	self.logger = logger
+}
+
+// The bookmark store. The watcher should resume from its bookmarks, which
+// every sink has committed up to.
+func (self *Pipeline) Bookmarks() winlog.BookmarkStore {
+	return self.bookmarks
+}
+
+// Deliver events to the sinks until the pipeline is closed. If a sink fails,
+// or a bookmark can't be saved to the store, the pipeline shuts down and Run
+// returns the error once the other sinks have written the events they were
+// sent.
+func (self *Pipeline) Run() error {
+	self.mutex.Lock()
+	if self.running {
+		self.mutex.Unlock()
+		return fmt.Errorf("Pipeline is already running")
+	}
+	self.running = true
+	self.mutex.Unlock()
+	defer close(self.done)
+
+	errs := make([]error, len(self.sinks))
+	var wait sync.WaitGroup
+	for i, sink := range self.sinks {
+		wait.Add(1)
+		go func(i int, sink *pipelineSink) {
+			defer wait.Done()
+			if err := sink.sink.Run(sink.route.Events()); err != nil {
+				errs[i] = fmt.Errorf("Sink %q failed: %v", sink.name, err)
+				self.logger.Error("Sink failed, shutting down", "sink", sink.name, "error", err)
+				self.shutdown()
+				// Discard the rest, so the router isn't held up. They're
+				// not committed, so they're delivered again on restart.
+				for range sink.route.Events() {
+				}
+			}
+		}(i, sink)
+	}
+	events := self.track(self.watcher.Event())
+	routerErr := self.router.Run(events)
+	if routerErr != nil {
+		self.logger.Error("Router failed, shutting down", "error", routerErr)
+		self.shutdown()
+		// Drain the watcher so it can shut down
+		for range events {
+		}
+	}
+	wait.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return routerErr
+}
+
+// Forward events to the router, keeping the latest bookmark from each channel
+// so a reload can resubscribe from it
+func (self *Pipeline) track(events <-chan *winlog.WinLogEvent) <-chan *winlog.WinLogEvent {
+	tracked := make(chan *winlog.WinLogEvent)
+	go func() {
+		defer close(tracked)
+		for event := range events {
+			if event.Bookmark != "" {
+				self.mutex.Lock()
+				self.positions[strings.ToLower(event.SubscribedChannel)] = event.Bookmark
+				self.mutex.Unlock()
+			}
+			tracked <- event
+		}
+	}()
+	return tracked
+}
+
+// The bookmark to resume a subscribed channel from: the latest one delivered,
+// or the one saved before the pipeline started
+func (self *Pipeline) position(channel string) (string, error) {
+	self.mutex.Lock()
+	bookmark := self.positions[strings.ToLower(channel)]
+	self.mutex.Unlock()
+	if bookmark != "" {
+		return bookmark, nil
+	}
+	return self.bookmarks.Load(channel)
+}
+
+func (self *Pipeline) shutdown() {
+	self.shutdownOnce.Do(self.watcher.Shutdown)
+}
+
+// Shut down the watcher, wait for Run to deliver the events already read,
+// close the sinks and flush the bookmark store
+func (self *Pipeline) Close() error {
+	self.logger.Info("Shutting down pipeline")
+	self.shutdown()
+	self.mutex.Lock()
+	running := self.running
+	select {
+	case <-self.closed:
+	default:
+		close(self.closed)
+	}
+	self.mutex.Unlock()
+	if running {
+		<-self.done
+	}
+	err := self.closeSinks()
+	if closer, ok := self.bookmarks.(*loggedBookmarkStore).BookmarkStore.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("Failed to flush bookmarks: %v", closeErr)
+		}
+	}
+	return err
+}
+
+func (self *Pipeline) closeSinks() error {
+	var firstErr error
+	for _, sink := range self.sinks {
+		if err := sink.sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Logs the bookmarks the router saves
+type loggedBookmarkStore struct {
+	winlog.BookmarkStore
+	pipeline *Pipeline
+}
+
+func (self *loggedBookmarkStore) Save(channel, bookmark string) error {
+	if err := self.BookmarkStore.Save(channel, bookmark); err != nil {
+		self.pipeline.logger.Error("Failed to save bookmark", "channel", channel, "error", err)
+		return err
+	}
+	attributes := []interface{}{"channel", channel}
+	if positions, err := winlog.ParseBookmarkXml(bookmark); err == nil && len(positions) > 0 {
+		attributes = append(attributes, "record_id", positions[0].RecordId)
+	}
+	self.pipeline.logger.Debug("Saved bookmark", attributes...)
+	return nil
+}
+
+func (self BookmarksConfig) open() (winlog.BookmarkStore, error) {
+	if self.Type == "file" || (self.Type == "" && self.Path != "") {
+		store, err := winlog.NewFileBookmarkStore(self.Path)
+		if err != nil {
+			return nil, err
+		}
+		store.SetFlushInterval(time.Duration(self.FlushInterval))
+		return store, nil
+	}
+	return winlog.NewMemoryBookmarkStore(), nil
+}
+
+// The RenderProfile for the settings: the named profile, RenderProfileFull by
+// default, with any fields that are set replaced
+func (self *RenderConfig) RenderProfile() winlog.RenderProfile {
+	profile := winlog.RenderProfileFull
+	if self == nil {
+		return profile
+	}
+	if named, ok := renderProfiles[self.Profile]; ok {
+		profile = named
+	}
+	if self.SystemFields != nil {
+		profile.SystemFields = 0
+		for _, name := range self.SystemFields {
+			profile.SystemFields |= systemFieldNames[name]
+		}
+	}
+	for _, setting := range []struct {
+		value  *bool
+		target *bool
+	}{
+		{self.Message, &profile.Message},
+		{self.LevelText, &profile.LevelText},
+		{self.TaskText, &profile.TaskText},
+		{self.ProviderText, &profile.ProviderText},
+		{self.OpcodeText, &profile.OpcodeText},
+		{self.ChannelText, &profile.ChannelText},
+		{self.IdText, &profile.IdText},
+		{self.Xml, &profile.Xml},
+		{self.EventData, &profile.EventData},
+	} {
+		if setting.value != nil {
+			*setting.target = *setting.value
+		}
+	}
+	if self.BookmarkInterval != nil {
+		profile.BookmarkInterval = *self.BookmarkInterval
+	}
+	return profile
+}
+
+// The query, with "*" for all events
+func (self SubscriptionConfig) query() string {
+	if self.Query == "" {
+		return "*"
+	}
+	return self.Query
+}
+
+// Subscribe after the bookmark or, without one, from the beginning or from
+// now as configured
+func (self SubscriptionConfig) subscribe(watcher winlog.Watcher, bookmark string) error {
+	if bookmark != "" {
+		return watcher.SubscribeFromBookmark(self.Channel, self.query(), bookmark)
+	}
+	if self.From == "beginning" {
+		return watcher.SubscribeFromBeginning(self.Channel, self.query())
+	}
+	return watcher.SubscribeFromNow(self.Channel, self.query())
+}
+
+func (self *TLSConfig) build() (*tls.Config, error) {
+	if self == nil {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         self.ServerName,
+		InsecureSkipVerify: self.InsecureSkipVerify,
+	}
+	if self.CAFile != "" {
+		contents, err := os.ReadFile(self.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(contents) {
+			return nil, fmt.Errorf("No certificates in %v", self.CAFile)
+		}
+	}
+	if self.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(self.CertFile, self.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// Create the sink, saving bookmarks to the store
+func (self SinkConfig) build(bookmarks winlog.BookmarkStore) (sink, error) {
+	switch {
+	case self.Syslog != nil:
+		config := self.Syslog
+		tlsConfig, err := config.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		format := syslog.RFC5424
+		if config.Format == "rfc3164" {
+			format = syslog.RFC3164
+		}
+		return syslog.NewSink(syslog.Config{
+			Network:           config.Network,
+			Address:           config.Address,
+			TLSConfig:         tlsConfig,
+			Format:            format,
+			Facility:          syslogFacilities[config.Facility],
+			Hostname:          config.Hostname,
+			AppName:           config.AppName,
+			EnterpriseId:      config.EnterpriseId,
+			Bookmarks:         bookmarks,
+			DialTimeout:       time.Duration(config.DialTimeout),
+			WriteTimeout:      time.Duration(config.WriteTimeout),
+			Retries:           config.Retries,
+			ReconnectDelay:    time.Duration(config.ReconnectDelay),
+			MaxReconnectDelay: time.Duration(config.MaxReconnectDelay),
+		})
+	case self.Gelf != nil:
+		config := self.Gelf
+		compression := map[string]gelf.Compression{
+			"":     gelf.CompressionGzip,
+			"gzip": gelf.CompressionGzip,
+			"zlib": gelf.CompressionZlib,
+			"none": gelf.CompressionNone,
+		}[config.Compression]
+		return gelf.NewSink(gelf.Config{
+			Network:           config.Network,
+			Address:           config.Address,
+			Compression:       compression,
+			ChunkSize:         config.ChunkSize,
+			Host:              config.Host,
+			Bookmarks:         bookmarks,
+			DialTimeout:       time.Duration(config.DialTimeout),
+			WriteTimeout:      time.Duration(config.WriteTimeout),
+			Retries:           config.Retries,
+			ReconnectDelay:    time.Duration(config.ReconnectDelay),
+			MaxReconnectDelay: time.Duration(config.MaxReconnectDelay),
+		})
+	case self.HTTP != nil:
+		config := self.HTTP
+		tlsConfig, err := config.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		formatter := httpsink.NDJSON
+		if config.Format == "json" {
+			formatter = httpsink.JSON
+		}
+		return httpsink.NewSink(httpsink.Config{
+			URL:           config.URL,
+			Formatter:     formatter,
+			Gzip:          config.Gzip,
+			Headers:       config.Headers,
+			Token:         config.Token,
+			TLSConfig:     tlsConfig,
+			Timeout:       time.Duration(config.Timeout),
+			BatchSize:     config.BatchSize,
+			FlushInterval: time.Duration(config.FlushInterval),
+			Retries:       config.Retries,
+			RetryDelay:    time.Duration(config.RetryDelay),
+			MaxRetryDelay: time.Duration(config.MaxRetryDelay),
+			QueueDir:      config.QueueDir,
+			MaxQueueBytes: config.MaxQueueBytes,
+			Bookmarks:     bookmarks,
+		})
+	case self.OTLP != nil:
+		config := self.OTLP
+		tlsConfig, err := config.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		protocol := map[string]otlp.Protocol{
+			"":              otlp.ProtocolHTTPProtobuf,
+			"http/protobuf": otlp.ProtocolHTTPProtobuf,
+			"http/json":     otlp.ProtocolHTTPJSON,
+			"grpc":          otlp.ProtocolGRPC,
+		}[config.Protocol]
+		return otlp.NewExporter(otlp.Config{
+			Endpoint:      config.Endpoint,
+			Protocol:      protocol,
+			Headers:       config.Headers,
+			TLSConfig:     tlsConfig,
+			Timeout:       time.Duration(config.Timeout),
+			BatchSize:     config.BatchSize,
+			FlushInterval: time.Duration(config.FlushInterval),
+			Retries:       config.Retries,
+			RetryDelay:    time.Duration(config.RetryDelay),
+			MaxRetryDelay: time.Duration(config.MaxRetryDelay),
+			Bookmarks:     bookmarks,
+		})
+	}
+	return nil, fmt.Errorf("No sink type")
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// What a reload changed, by subscribed channel
+type ReloadResult struct {
+	Added   []string
+	Removed []string
+	// Subscriptions whose query or render settings changed. A new query is
+	// resubscribed from the latest bookmark delivered, so no events are
+	// missed; render settings apply to the next event rendered.
+	Modified  []string
+	Unchanged []string
+	// Settings which changed but only take effect when the pipeline is
+	// rebuilt: "bookmarks" or "sinks"
+	RestartRequired []string
+	// Subscriptions which couldn't be changed. A subscription whose new query
+	// failed keeps the old one.
+	Failed map[string]error
+}
+
+func (self *ReloadResult) String() string {
+	summary := fmt.Sprintf("%v added, %v removed, %v modified, %v unchanged", len(self.Added), len(self.Removed), len(self.Modified), len(self.Unchanged))
+	if len(self.Failed) > 0 {
+		summary += fmt.Sprintf(", %v failed", len(self.Failed))
+	}
+	if len(self.RestartRequired) > 0 {
+		summary += fmt.Sprintf("; changes to %v need a restart", strings.Join(self.RestartRequired, " and "))
+	}
+	return summary
+}
+
+// Apply a new config to the running pipeline. Only the subscriptions which
+// changed are touched: removed channels are unsubscribed, added ones are
+// subscribed as configured, and channels whose query changed are resubscribed
+// from their latest bookmark. Render settings are updated in place. Changes to
+// the sinks or bookmark store are reported, but need a new pipeline. An
+// invalid config is rejected without changing anything; otherwise the result
+// is returned, with an error if any subscription couldn't be changed.
+func (self *Pipeline) Reload(config *Config) (*ReloadResult, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	self.reloadMutex.Lock()
+	defer self.reloadMutex.Unlock()
+	select {
+	case <-self.closed:
+		return nil, fmt.Errorf("Pipeline is closed")
+	default:
+	}
+
+	current := self.config
+	result := &ReloadResult{Failed: make(map[string]error)}
+	if !reflect.DeepEqual(current.Bookmarks, config.Bookmarks) {
+		result.RestartRequired = append(result.RestartRequired, "bookmarks")
+	}
+	if !reflect.DeepEqual(current.Sinks, config.Sinks) {
+		result.RestartRequired = append(result.RestartRequired, "sinks")
+	}
+
+	profiler, hasProfiles := self.watcher.(renderProfiler)
+	if hasProfiles {
+		profiler.SetRenderProfile(config.Render.RenderProfile())
+	}
+	// The render profile in effect for a channel
+	channelProfile := func(config *Config, subscription SubscriptionConfig) winlog.RenderProfile {
+		if subscription.Render != nil {
+			return subscription.Render.RenderProfile()
+		}
+		return config.Render.RenderProfile()
+	}
+
+	previous := make(map[string]SubscriptionConfig)
+	for _, subscription := range current.Subscriptions {
+		previous[strings.ToLower(subscription.Channel)] = subscription
+	}
+	next := make(map[string]bool)
+	for _, subscription := range config.Subscriptions {
+		next[strings.ToLower(subscription.Channel)] = true
+	}
+	// The subscriptions actually running after the reload
+	var running []SubscriptionConfig
+
+	for _, subscription := range current.Subscriptions {
+		if next[strings.ToLower(subscription.Channel)] {
+			continue
+		}
+		if err := self.watcher.Unsubscribe(subscription.Channel); err != nil {
+			self.logger.Warn("Failed to unsubscribe from removed channel", "channel", subscription.Channel, "error", err)
+			result.Failed[subscription.Channel] = err
+			running = append(running, subscription)
+			continue
+		}
+		if hasProfiles {
+			profiler.SetChannelRenderProfile(subscription.Channel, nil)
+		}
+		self.mutex.Lock()
+		delete(self.positions, strings.ToLower(subscription.Channel))
+		self.mutex.Unlock()
+		result.Removed = append(result.Removed, subscription.Channel)
+	}
+
+	for _, subscription := range config.Subscriptions {
+		old, exists := previous[strings.ToLower(subscription.Channel)]
+		if !exists {
+			if err := self.add(subscription); err != nil {
+				self.logger.Warn("Failed to subscribe to added channel", "channel", subscription.Channel, "error", err)
+				result.Failed[subscription.Channel] = err
+				continue
+			}
+			result.Added = append(result.Added, subscription.Channel)
+			running = append(running, subscription)
+			continue
+		}
+
+		resubscribe := old.query() != subscription.query() || old.Channel != subscription.Channel
+		if !resubscribe && channelProfile(current, old) == channelProfile(config, subscription) {
+			result.Unchanged = append(result.Unchanged, subscription.Channel)
+			running = append(running, subscription)
+			continue
+		}
+		self.setChannelProfile(subscription)
+		if resubscribe {
+			if err := self.resubscribe(old, subscription); err != nil {
+				self.setChannelProfile(old)
+				result.Failed[subscription.Channel] = err
+				running = append(running, old)
+				continue
+			}
+		}
+		result.Modified = append(result.Modified, subscription.Channel)
+		running = append(running, subscription)
+	}
+
+	reloaded := *current
+	reloaded.Render = config.Render
+	reloaded.Subscriptions = running
+	self.config = &reloaded
+
+	self.logger.Info("Reloaded config", "added", result.Added, "removed", result.Removed, "modified", result.Modified, "failed", len(result.Failed), "restart_required", result.RestartRequired)
+	if len(result.Failed) > 0 {
+		channels := make([]string, 0, len(result.Failed))
+		for channel, err := range result.Failed {
+			channels = append(channels, fmt.Sprintf("%v: %v", channel, err))
+		}
+		sort.Strings(channels)
+		return result, fmt.Errorf("Failed to reload %v", strings.Join(channels, "; "))
+	}
+	return result, nil
+}
+
+// Subscribe to a new channel as configured
+func (self *Pipeline) add(subscription SubscriptionConfig) error {
+	bookmark := ""
+	if subscription.From == "" || subscription.From == "bookmark" {
+		var err error
+		if bookmark, err = self.bookmarks.Load(subscription.Channel); err != nil {
+			return err
+		}
+	}
+	self.setChannelProfile(subscription)
+	if err := subscription.subscribe(self.watcher, bookmark); err != nil {
+		self.setChannelProfile(SubscriptionConfig{Channel: subscription.Channel})
+		return err
+	}
+	return nil
+}
+
+// Set the channel's render profile, if the watcher has them
+func (self *Pipeline) setChannelProfile(subscription SubscriptionConfig) {
+	profiler, ok := self.watcher.(renderProfiler)
+	if !ok {
+		return
+	}
+	if subscription.Render == nil {
+		profiler.SetChannelRenderProfile(subscription.Channel, nil)
+		return
+	}
+	profile := subscription.Render.RenderProfile()
+	profiler.SetChannelRenderProfile(subscription.Channel, &profile)
+}
+
+// Replace a subscription, resuming after the latest bookmark delivered from
+// it. If the new subscription fails, the old one is restored.
+func (self *Pipeline) resubscribe(old, subscription SubscriptionConfig) error {
+	if err := self.watcher.Unsubscribe(old.Channel); err != nil {
+		return err
+	}
+	bookmark, err := self.position(old.Channel)
+	if err != nil {
+		return err
+	}
+	if err := subscription.subscribe(self.watcher, bookmark); err != nil {
+		if restoreErr := old.subscribe(self.watcher, bookmark); restoreErr != nil {
+			self.logger.Error("Failed to resubscribe or restore the previous subscription", "channel", subscription.Channel, "error", err, "restore_error", restoreErr)
+			return fmt.Errorf("%v, and failed to restore the previous subscription: %v", err, restoreErr)
+		}
+		self.logger.Warn("Failed to resubscribe, keeping the previous query", "channel", subscription.Channel, "query", subscription.query(), "error", err)
+		return err
+	}
+	self.logger.Info("Resubscribed to channel", "channel", subscription.Channel, "query", subscription.query(), "from_bookmark", bookmark != "")
+	return nil
+}
+
+// Load the config file and apply it with Reload
+func (self *Pipeline) ReloadFile(path string) (*ReloadResult, error) {
+	config, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return self.Reload(config)
+}
+
+// Reload the config file whenever a signal arrives, such as SIGHUP from
+// signal.Notify, until the pipeline is closed. Each reload's result or error
+// is passed to report.
+func (self *Pipeline) ReloadOnSignal(path string, signals <-chan os.Signal, report func(*ReloadResult, error)) {
+	for {
+		select {
+		case <-signals:
+			report(self.ReloadFile(path))
+		case <-self.closed:
+			return
+		}
+	}
+}
+
+// Reload the config file whenever its modification time or size changes,
+// checking every interval, until the pipeline is closed. A change is only
+// reloaded once the file has stayed the same for an interval, so a file that's
+// still being written isn't read half-way. Each reload's result or error is
+// passed to report.
+func (self *Pipeline) WatchFile(path string, interval time.Duration, report func(*ReloadResult, error)) {
+	var modified time.Time
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		modified, size = info.ModTime(), info.Size()
+	}
+	// Set when a change has been seen but the file hasn't settled yet
+	changed := false
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-self.closed:
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(modified) || info.Size() != size {
+			modified, size = info.ModTime(), info.Size()
+			changed = true
+			continue
+		}
+		if changed {
+			changed = false
+			report(self.ReloadFile(path))
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// A Watcher which logs subscription calls, for checking what a reload does
+type fakeWatcher struct {
+	events   chan *winlog.WinLogEvent
+	errors   chan error
+	calls    []string
+	channels map[string]bool
+	profiles map[string]*winlog.RenderProfile
+	// Channels whose subscriptions fail
+	failing map[string]bool
+	mutex   sync.Mutex
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{
+		events:   make(chan *winlog.WinLogEvent),
+		errors:   make(chan error),
+		channels: make(map[string]bool),
+		profiles: make(map[string]*winlog.RenderProfile),
+		failing:  make(map[string]bool),
+	}
+}
+
+func (self *fakeWatcher) Event() <-chan *winlog.WinLogEvent {
+	return self.events
+}
+
+func (self *fakeWatcher) Error() <-chan error {
+	return self.errors
+}
+
+func (self *fakeWatcher) subscribe(channel, call string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.failing[channel] {
+		return fmt.Errorf("Channel %q not found", channel)
+	}
+	if self.channels[channel] {
+		return fmt.Errorf("A watcher for channel %q already exists", channel)
+	}
+	self.channels[channel] = true
+	self.calls = append(self.calls, call)
+	return nil
+}
+
+func (self *fakeWatcher) SubscribeFromBeginning(channel, query string) error {
+	return self.subscribe(channel, fmt.Sprintf("%v %v from beginning", channel, query))
+}
+
+func (self *fakeWatcher) SubscribeFromNow(channel, query string) error {
+	return self.subscribe(channel, fmt.Sprintf("%v %v from now", channel, query))
+}
+
+func (self *fakeWatcher) SubscribeFromBookmark(channel, query, bookmark string) error {
+	positions, _ := winlog.ParseBookmarkXml(bookmark)
+	return self.subscribe(channel, fmt.Sprintf("%v %v after %v", channel, query, positions[0].RecordId))
+}
+
+func (self *fakeWatcher) Unsubscribe(channel string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.channels[channel] {
+		return fmt.Errorf("No watcher for channel %q", channel)
+	}
+	delete(self.channels, channel)
+	self.calls = append(self.calls, "unsubscribe "+channel)
+	return nil
+}
+
+func (self *fakeWatcher) SetRenderProfile(profile winlog.RenderProfile) {
+	self.SetChannelRenderProfile("", &profile)
+}
+
+func (self *fakeWatcher) SetChannelRenderProfile(channel string, profile *winlog.RenderProfile) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.profiles[channel] = profile
+}
+
+func (self *fakeWatcher) Shutdown() {
+	close(self.events)
+	close(self.errors)
+}
+
+// The calls since the last time they were taken
+func (self *fakeWatcher) takeCalls() string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	calls := strings.Join(self.calls, "; ")
+	self.calls = nil
+	return calls
+}
+
+func testEvent(channel string, recordId uint64) *winlog.WinLogEvent {
+	return &winlog.WinLogEvent{
+		Channel:           channel,
+		SubscribedChannel: channel,
+		RecordId:          recordId,
+		Bookmark:          winlog.FormatBookmarkXml(winlog.BookmarkPosition{Channel: channel, RecordId: recordId, IsCurrent: true}),
+	}
+}
+
+func reloadConfig(subscriptions string, t *T) *Config {
+	config, err := ParseYAML([]byte(`
+subscriptions:
+` + subscriptions + `
+sinks:
+  - name: archive
+    filter: {event_ids: [1]}
+    http: {url: "http://127.0.0.1:1/events"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestReload(t *T) {
+	watcher := newFakeWatcher()
+	pipeline, err := NewPipeline(reloadConfig(`
+  - channel: Security
+    query: "*[System[EventID=4624]]"
+  - channel: System
+  - channel: Application
+    from: beginning
+`, t), watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(watcher.takeCalls(), "Security *[System[EventID=4624]] from now; System * from now; Application * from beginning", t)
+	go pipeline.Run()
+	defer pipeline.Close()
+
+	// Security is resubscribed after the latest event delivered
+	watcher.events <- testEvent("Security", 10)
+	watcher.events <- testEvent("Security", 11)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if bookmark, _ := pipeline.position("Security"); bookmark == testEvent("Security", 11).Bookmark {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The events weren't delivered")
+		}
+	}
+	result, err := pipeline.Reload(reloadConfig(`
+  - channel: Security
+    query: "*[System[(EventID=4624 or EventID=4625)]]"
+  - channel: Application
+    from: beginning
+    render: {profile: minimal}
+  - channel: Setup
+`, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(fmt.Sprint(result.Added, result.Removed, result.Modified, result.Unchanged, len(result.RestartRequired)), "[Setup] [System] [Security Application] [] 0", t)
+	assertEqual(result.String(), "1 added, 1 removed, 2 modified, 0 unchanged", t)
+	assertEqual(watcher.takeCalls(), "unsubscribe System; unsubscribe Security; Security *[System[(EventID=4624 or EventID=4625)]] after 11; Setup * from now", t)
+	assertEqual(watcher.profiles["Application"].BookmarkInterval, winlog.RenderProfileMinimal.BookmarkInterval, t)
+
+	// Reloading the same config changes nothing
+	result, err = pipeline.Reload(reloadConfig(`
+  - channel: Security
+    query: "*[System[(EventID=4624 or EventID=4625)]]"
+  - channel: Application
+    from: now
+    render: {profile: minimal}
+  - channel: Setup
+`, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(fmt.Sprint(result.Unchanged), "[Security Application Setup]", t)
+	assertEqual(watcher.takeCalls(), "", t)
+}
+
+func TestReloadFailures(t *T) {
+	watcher := newFakeWatcher()
+	pipeline, err := NewPipeline(reloadConfig(`
+  - channel: Security
+`, t), watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher.takeCalls()
+	defer pipeline.Close()
+
+	// An invalid config changes nothing
+	_, err = pipeline.Reload(&Config{})
+	assertEqual(strings.HasPrefix(err.Error(), "Invalid config"), true, t)
+	assertEqual(watcher.takeCalls(), "", t)
+
+	// A failed query keeps the old subscription, from its saved bookmark
+	pipeline.Bookmarks().Save("Security", testEvent("Security", 5).Bookmark)
+	watcher.failing["Security"] = true
+	watcher.failing["Missing"] = true
+	config := reloadConfig(`
+  - channel: Security
+    query: "*[System[Level=1]]"
+  - channel: Missing
+`, t)
+	config.Sinks[0].QueueSize = 10
+	result, err := pipeline.Reload(config)
+	assertEqual(err.Error(), `Failed to reload Missing: Channel "Missing" not found; Security: Channel "Security" not found, and failed to restore the previous subscription: Channel "Security" not found`, t)
+	assertEqual(len(result.Failed), 2, t)
+	assertEqual(fmt.Sprint(result.RestartRequired), "[sinks]", t)
+	assertEqual(result.String(), "0 added, 0 removed, 0 modified, 0 unchanged, 2 failed; changes to sinks need a restart", t)
+	assertEqual(watcher.takeCalls(), "unsubscribe Security", t)
+
+	watcher.failing["Security"] = false
+	watcher.SubscribeFromNow("Security", "*")
+	watcher.takeCalls()
+	delete(watcher.failing, "Missing")
+	result, err = pipeline.Reload(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(watcher.takeCalls(), "unsubscribe Security; Security *[System[Level=1]] after 5; Missing * from now", t)
+
+	pipeline.Close()
+	_, err = pipeline.Reload(config)
+	assertEqual(err.Error(), "Pipeline is closed", t)
+}
+
+// A logger writing messages to a buffer without their times
+func testLogger(buffer *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buffer, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, attribute slog.Attr) slog.Attr {
+			if attribute.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attribute
+		},
+	}))
+}
+
+func TestPipelineLogging(t *T) {
+	watcher := newFakeWatcher()
+	pipeline, err := NewPipeline(reloadConfig(`
+  - channel: Security
+`, t), watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	pipeline.SetLogger(testLogger(&buffer))
+
+	pipeline.Bookmarks().Save("Security", testEvent("Security", 5).Bookmark)
+	watcher.failing["Missing"] = true
+	pipeline.Reload(reloadConfig(`
+  - channel: Security
+    query: "*[System[Level=1]]"
+  - channel: Missing
+`, t))
+	pipeline.Close()
+	assertEqual(buffer.String(), `level=DEBUG msg="Saved bookmark" channel=Security record_id=5
+level=INFO msg="Resubscribed to channel" channel=Security query="*[System[Level=1]]" from_bookmark=true
+level=WARN msg="Failed to subscribe to added channel" channel=Missing error="Channel \"Missing\" not found"
+level=INFO msg="Reloaded config" added=[] removed=[] modified=[Security] failed=1 restart_required=[]
+level=INFO msg="Shutting down pipeline"
+`, t)
+}
+
+func TestWatchFile(t *T) {
+	path := filepath.Join(t.TempDir(), "shipper.yaml")
+	write := func(subscriptions string) {
+		os.WriteFile(path, []byte("subscriptions:\n"+subscriptions+"\nsinks:\n  - name: archive\n    http: {url: \"http://127.0.0.1:1/events\"}\n"), 0644)
+	}
+	write("  - channel: Security")
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := newFakeWatcher()
+	pipeline, err := NewPipeline(config, watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher.takeCalls()
+
+	reports := make(chan string, 10)
+	done := make(chan interface{})
+	go func() {
+		pipeline.WatchFile(path, 10*time.Millisecond, func(result *ReloadResult, err error) {
+			if err != nil {
+				reports <- err.Error()
+			} else {
+				reports <- result.String()
+			}
+		})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	write("  - channel: Security\n  - channel: System")
+	select {
+	case report := <-reports:
+		assertEqual(report, "1 added, 0 removed, 0 modified, 1 unchanged", t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The change wasn't noticed")
+	}
+	write("  - chanel: System")
+	select {
+	case report := <-reports:
+		assertEqual(strings.HasPrefix(report, path+": Invalid config"), true, t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The change wasn't noticed")
+	}
+	pipeline.Close()
+	<-done
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/scalingdata/gowinlog"
+	"github.com/scalingdata/gowinlog/internal/xpath"
+)
+
+// System property names for RenderConfig.SystemFields
+var systemFieldNames = map[string]winlog.SystemFields{
+	"provider_name": winlog.SystemFieldProviderName,
+	"event_id":      winlog.SystemFieldEventId,
+	"qualifiers":    winlog.SystemFieldQualifiers,
+	"level":         winlog.SystemFieldLevel,
+	"task":          winlog.SystemFieldTask,
+	"opcode":        winlog.SystemFieldOpcode,
+	"created":       winlog.SystemFieldCreated,
+	"record_id":     winlog.SystemFieldRecordId,
+	"process_id":    winlog.SystemFieldProcessId,
+	"thread_id":     winlog.SystemFieldThreadId,
+	"channel":       winlog.SystemFieldChannel,
+	"computer_name": winlog.SystemFieldComputerName,
+	"version":       winlog.SystemFieldVersion,
+}
+
+var renderProfiles = map[string]winlog.RenderProfile{
+	"minimal":  winlog.RenderProfileMinimal,
+	"standard": winlog.RenderProfileStandard,
+	"full":     winlog.RenderProfileFull,
+}
+
+// Syslog facility names, from RFC 5424. kern can't be sent by user processes.
+var syslogFacilities = map[string]int{
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// Collects the problems in a config
+type validator struct {
+	problems []string
+}
+
+func (self *validator) fail(path, format string, args ...interface{}) {
+	self.problems = append(self.problems, pathName(path)+": "+fmt.Sprintf(format, args...))
+}
+
+// Report a problem unless value is one of the options. The empty string is
+// allowed, as the default.
+func (self *validator) oneOf(path, value string, options ...string) {
+	if value == "" {
+		return
+	}
+	for _, option := range options {
+		if value == option {
+			return
+		}
+	}
+	quoted := make([]string, len(options))
+	for i, option := range options {
+		quoted[i] = fmt.Sprintf("%q", option)
+	}
+	self.fail(path, "Unknown value %q, expected %v or %v", value, strings.Join(quoted[:len(quoted)-1], ", "), quoted[len(quoted)-1])
+}
+
+func (self *validator) nonNegative(path string, value int64) {
+	if value < 0 {
+		self.fail(path, "Can't be negative")
+	}
+}
+
+// Check the config for missing, invalid and inconsistent settings. Every
+// problem is reported in the returned *Error, not just the first.
+func (self *Config) Validate() error {
+	v := &validator{}
+	v.oneOf("bookmarks.type", self.Bookmarks.Type, "file", "memory")
+	if self.Bookmarks.Type == "file" && self.Bookmarks.Path == "" {
+		v.fail("bookmarks.path", "Required for a file bookmark store")
+	}
+	if self.Bookmarks.Type == "memory" && self.Bookmarks.Path != "" {
+		v.fail("bookmarks.path", "Not used by a memory bookmark store")
+	}
+	v.nonNegative("bookmarks.flush_interval", int64(self.Bookmarks.FlushInterval))
+	v.render("render", self.Render)
+
+	if len(self.Subscriptions) == 0 {
+		v.fail("subscriptions", "At least one subscription is required")
+	}
+	subscribed := make(map[string]bool)
+	for i, subscription := range self.Subscriptions {
+		path := fmt.Sprintf("subscriptions[%v]", i)
+		if subscription.Channel == "" {
+			v.fail(path+".channel", "Required")
+		} else if subscribed[strings.ToLower(subscription.Channel)] {
+			v.fail(path+".channel", "%q is already subscribed", subscription.Channel)
+		}
+		subscribed[strings.ToLower(subscription.Channel)] = true
+		v.oneOf(path+".from", subscription.From, "beginning", "now", "bookmark")
+		v.render(path+".render", subscription.Render)
+	}
+
+	if len(self.Sinks) == 0 {
+		v.fail("sinks", "At least one sink is required")
+	}
+	names := make(map[string]bool)
+	for i, sink := range self.Sinks {
+		path := fmt.Sprintf("sinks[%v]", i)
+		if sink.Name == "" {
+			v.fail(path+".name", "Required")
+		} else if names[sink.Name] {
+			v.fail(path+".name", "Sink %q is already defined", sink.Name)
+		}
+		names[sink.Name] = true
+		v.nonNegative(path+".queue_size", int64(sink.QueueSize))
+		v.oneOf(path+".overflow", sink.Overflow, "block", "drop")
+		v.filter(path+".filter", sink.Filter, subscribed)
+
+		var types []string
+		if sink.Syslog != nil {
+			types = append(types, "syslog")
+			v.syslog(path+".syslog", sink.Syslog)
+		}
+		if sink.Gelf != nil {
+			types = append(types, "gelf")
+			v.gelf(path+".gelf", sink.Gelf)
+		}
+		if sink.HTTP != nil {
+			types = append(types, "http")
+			v.http(path+".http", sink.HTTP)
+		}
+		if sink.OTLP != nil {
+			types = append(types, "otlp")
+			v.otlp(path+".otlp", sink.OTLP)
+		}
+		if len(types) == 0 {
+			v.fail(path, "Needs one of syslog, gelf, http or otlp")
+		} else if len(types) > 1 {
+			v.fail(path, "Has more than one sink type: %v", strings.Join(types, ", "))
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &Error{Problems: v.problems}
+	}
+	return nil
+}
+
+func (self *validator) render(path string, render *RenderConfig) {
+	if render == nil {
+		return
+	}
+	self.oneOf(path+".profile", render.Profile, "minimal", "standard", "full")
+	for i, name := range render.SystemFields {
+		if _, ok := systemFieldNames[name]; !ok {
+			self.fail(fmt.Sprintf("%v.system_fields[%v]", path, i), "Unknown System property %q", name)
+		}
+	}
+	if render.BookmarkInterval != nil {
+		self.nonNegative(path+".bookmark_interval", int64(*render.BookmarkInterval))
+	}
+}
+
+func (self *validator) filter(path string, filter FilterConfig, subscribed map[string]bool) {
+	for i, channel := range filter.Channels {
+		if !subscribed[strings.ToLower(channel)] {
+			self.fail(fmt.Sprintf("%v.channels[%v]", path, i), "%q isn't subscribed", channel)
+		}
+	}
+	if filter.Query != "" {
+		if _, err := xpath.Compile(filter.Query); err != nil {
+			self.fail(path+".query", "%v", err)
+		}
+	}
+}
+
+func (self *validator) address(path, address string) {
+	if address == "" {
+		self.fail(path, "Required")
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		self.fail(path, "Expected host:port, got %q", address)
+	}
+}
+
+func (self *validator) url(path, value string) {
+	if value == "" {
+		self.fail(path, "Required")
+		return
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		self.fail(path, "Expected an http or https URL, got %q", value)
+	}
+}
+
+func (self *validator) tls(path string, tls *TLSConfig) {
+	if tls == nil {
+		return
+	}
+	if tls.CertFile != "" && tls.KeyFile == "" {
+		self.fail(path+".key_file", "Required with cert_file")
+	}
+	if tls.KeyFile != "" && tls.CertFile == "" {
+		self.fail(path+".cert_file", "Required with key_file")
+	}
+}
+
+func (self *validator) durations(path string, durations map[string]Duration) {
+	names := make([]string, 0, len(durations))
+	for name := range durations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		self.nonNegative(path+"."+name, int64(durations[name]))
+	}
+}
+
+func (self *validator) syslog(path string, config *SyslogConfig) {
+	if config.Network == "" {
+		self.fail(path+".network", "Required")
+	}
+	self.oneOf(path+".network", config.Network, "udp", "tcp", "tls")
+	self.address(path+".address", config.Address)
+	if config.TLS != nil && config.Network != "tls" {
+		self.fail(path+".tls", "Only used with network \"tls\"")
+	}
+	self.tls(path+".tls", config.TLS)
+	self.oneOf(path+".format", config.Format, "rfc5424", "rfc3164")
+	if _, ok := syslogFacilities[config.Facility]; config.Facility != "" && !ok {
+		self.fail(path+".facility", "Unknown facility %q", config.Facility)
+	}
+	self.nonNegative(path+".enterprise_id", int64(config.EnterpriseId))
+	self.durations(path, map[string]Duration{
+		"dial_timeout":        config.DialTimeout,
+		"write_timeout":       config.WriteTimeout,
+		"reconnect_delay":     config.ReconnectDelay,
+		"max_reconnect_delay": config.MaxReconnectDelay,
+	})
+}
+
+func (self *validator) gelf(path string, config *GelfConfig) {
+	if config.Network == "" {
+		self.fail(path+".network", "Required")
+	}
+	self.oneOf(path+".network", config.Network, "udp", "tcp")
+	self.address(path+".address", config.Address)
+	self.oneOf(path+".compression", config.Compression, "gzip", "zlib", "none")
+	if config.ChunkSize < 0 || (config.ChunkSize > 0 && config.ChunkSize <= 12) {
+		self.fail(path+".chunk_size", "Must be more than 12 bytes")
+	}
+	self.durations(path, map[string]Duration{
+		"dial_timeout":        config.DialTimeout,
+		"write_timeout":       config.WriteTimeout,
+		"reconnect_delay":     config.ReconnectDelay,
+		"max_reconnect_delay": config.MaxReconnectDelay,
+	})
+}
+
+func (self *validator) http(path string, config *HTTPConfig) {
+	self.url(path+".url", config.URL)
+	self.oneOf(path+".format", config.Format, "ndjson", "json")
+	self.tls(path+".tls", config.TLS)
+	self.nonNegative(path+".batch_size", int64(config.BatchSize))
+	self.nonNegative(path+".max_queue_bytes", config.MaxQueueBytes)
+	self.durations(path, map[string]Duration{
+		"timeout":         config.Timeout,
+		"flush_interval":  config.FlushInterval,
+		"retry_delay":     config.RetryDelay,
+		"max_retry_delay": config.MaxRetryDelay,
+	})
+}
+
+func (self *validator) otlp(path string, config *OTLPConfig) {
+	self.url(path+".endpoint", config.Endpoint)
+	self.oneOf(path+".protocol", config.Protocol, "http/protobuf", "http/json", "grpc")
+	self.tls(path+".tls", config.TLS)
+	self.nonNegative(path+".batch_size", int64(config.BatchSize))
+	self.durations(path, map[string]Duration{
+		"timeout":         config.Timeout,
+		"flush_interval":  config.FlushInterval,
+		"retry_delay":     config.RetryDelay,
+		"max_retry_delay": config.MaxRetryDelay,
+	})
+}
@@ -0,0 +1,492 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	yamlIntPattern   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlHexPattern   = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+	yamlFloatPattern = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// A line of YAML with its comment and indentation removed
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlError struct {
+	line    int
+	message string
+}
+
+func (self *yamlError) Error() string {
+	return fmt.Sprintf("Line %v: %v", self.line, self.message)
+}
+
+func yamlErrorf(line int, format string, args ...interface{}) error {
+	return &yamlError{line, fmt.Sprintf(format, args...)}
+}
+
+// Parse a YAML document into the values encoding/json produces with
+// UseNumber: map[string]interface{}, []interface{}, string, bool,
+// json.Number and nil. Keys must be strings and can't be repeated.
+func parseYAML(data []byte) (interface{}, error) {
+	lines, err := splitYAMLLines(string(data))
+	if err != nil {
+		return nil, err
+	}
+	parser := &yamlParser{lines: lines}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	value, err := parser.parseNode(0)
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(lines) {
+		return nil, yamlErrorf(lines[parser.pos].number, "Unexpected indentation")
+	}
+	return value, nil
+}
+
+// Split the document into non-empty lines, without comments
+func splitYAMLLines(data string) ([]yamlLine, error) {
+	var lines []yamlLine
+	started := false
+	for i, text := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		number := i + 1
+		if i == 0 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if !utf8.ValidString(text) {
+			return nil, yamlErrorf(number, "Invalid UTF-8")
+		}
+		indent := 0
+		for indent < len(text) && text[indent] == ' ' {
+			indent++
+		}
+		text = strings.TrimRight(stripYAMLComment(text[indent:]), " \t")
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, yamlErrorf(number, "Tabs can't be used for indentation")
+		}
+		if indent == 0 && (text == "---" || strings.HasPrefix(text, "--- ")) {
+			if started {
+				return nil, yamlErrorf(number, "Only one document is supported")
+			}
+			started = true
+			if text = strings.TrimSpace(text[3:]); text == "" {
+				continue
+			}
+		}
+		if indent == 0 && text == "..." {
+			break
+		}
+		if strings.HasPrefix(text, "%") && indent == 0 {
+			return nil, yamlErrorf(number, "Directives aren't supported")
+		}
+		started = true
+		lines = append(lines, yamlLine{number, indent, text})
+	}
+	return lines, nil
+}
+
+// Remove a comment from a line. '#' starts a comment at the start of the
+// line or after whitespace, outside quoted scalars.
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+			} else {
+				quote = 0
+			}
+		case quote != 0:
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[{,:-", text[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// Parse the node starting at the current line, which is indented by at least
+// minIndent
+func (self *yamlParser) parseNode(minIndent int) (interface{}, error) {
+	if self.pos >= len(self.lines) || self.lines[self.pos].indent < minIndent {
+		return nil, nil
+	}
+	line := self.lines[self.pos]
+	if isYAMLListItem(line.text) {
+		return self.parseList(line.indent, false)
+	}
+	if _, _, ok, err := splitYAMLKey(line); err != nil {
+		return nil, err
+	} else if ok {
+		return self.parseMapping(line.indent)
+	}
+	self.pos++
+	return parseYAMLInline(line.text, line.number)
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// Parse a list at indent. A list indented as far as the key it's the value
+// of ends at the next line which isn't an item.
+func (self *yamlParser) parseList(indent int, underKey bool) (interface{}, error) {
+	list := []interface{}{}
+	for self.pos < len(self.lines) {
+		line := self.lines[self.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, yamlErrorf(line.number, "Unexpected indentation")
+		}
+		if !isYAMLListItem(line.text) {
+			if underKey {
+				break
+			}
+			return nil, yamlErrorf(line.number, "Expected a list item")
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if rest == "" {
+			self.pos++
+		} else {
+			// Parse the rest of the line as if it started a line of its own,
+			// so a mapping can continue on the following lines
+			self.lines[self.pos] = yamlLine{line.number, indent + len(line.text) - len(rest), rest}
+		}
+		item, err := self.parseNode(indent + 1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func (self *yamlParser) parseMapping(indent int) (interface{}, error) {
+	mapping := make(map[string]interface{})
+	for self.pos < len(self.lines) {
+		line := self.lines[self.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, yamlErrorf(line.number, "Unexpected indentation")
+		}
+		key, rest, ok, err := splitYAMLKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, yamlErrorf(line.number, "Expected \"key: value\"")
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, yamlErrorf(line.number, "Duplicate key %q", key)
+		}
+		self.pos++
+		var value interface{}
+		if rest != "" {
+			value, err = parseYAMLInline(rest, line.number)
+		} else if self.pos < len(self.lines) && self.lines[self.pos].indent == indent && isYAMLListItem(self.lines[self.pos].text) {
+			// A list may be indented as far as its key
+			value, err = self.parseList(indent, true)
+		} else {
+			value, err = self.parseNode(indent + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		mapping[key] = value
+	}
+	return mapping, nil
+}
+
+// Split a "key: value" line. ok is false if the line isn't a mapping entry.
+func splitYAMLKey(line yamlLine) (key, rest string, ok bool, err error) {
+	text := line.text
+	if strings.HasPrefix(text, "? ") || text == "?" {
+		return "", "", false, yamlErrorf(line.number, "Complex keys aren't supported")
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end, err := quotedYAMLEnd(text, line.number)
+		if err != nil {
+			return "", "", false, err
+		}
+		after := text[end:]
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", false, nil
+		}
+		key, err := parseYAMLQuoted(text[:end], line.number)
+		if err != nil {
+			return "", "", false, err
+		}
+		return key, strings.TrimSpace(after[1:]), true, nil
+	}
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false, nil
+	}
+	colon := strings.Index(text, ": ")
+	if colon < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false, nil
+		}
+		colon = len(text) - 1
+	}
+	return strings.TrimRight(text[:colon], " "), strings.TrimSpace(text[colon+1:]), true, nil
+}
+
+// Parse a value on a single line: a scalar, or a flow list or mapping
+func parseYAMLInline(text string, number int) (interface{}, error) {
+	switch text[0] {
+	case '[', '{':
+		flow := &yamlFlowParser{text: text, number: number}
+		value, err := flow.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if flow.skipSpaces(); flow.pos < len(text) {
+			return nil, yamlErrorf(number, "Unexpected %q after the value", text[flow.pos:])
+		}
+		return value, nil
+	case '"', '\'':
+		end, err := quotedYAMLEnd(text, number)
+		if err != nil {
+			return nil, err
+		}
+		if end != len(text) {
+			return nil, yamlErrorf(number, "Unexpected %q after the quoted value", text[end:])
+		}
+		return parseYAMLQuoted(text, number)
+	}
+	return parseYAMLPlain(text, number)
+}
+
+// Resolve a plain scalar to null, a boolean, a number or a string
+func parseYAMLPlain(text string, number int) (interface{}, error) {
+	switch text[0] {
+	case '&', '*':
+		return nil, yamlErrorf(number, "Anchors and aliases aren't supported; quote values starting with %q", text[0])
+	case '!':
+		return nil, yamlErrorf(number, "Tags aren't supported; quote values starting with '!'")
+	case '|', '>':
+		return nil, yamlErrorf(number, "Block scalars aren't supported; use a quoted string")
+	case '@', '`':
+		return nil, yamlErrorf(number, "Plain values can't start with %q; quote the value", text[0])
+	}
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if yamlHexPattern.MatchString(text) {
+		value, err := strconv.ParseUint(text[2:], 16, 64)
+		if err != nil {
+			return nil, yamlErrorf(number, "Number %v is too large", text)
+		}
+		return json.Number(strconv.FormatUint(value, 10)), nil
+	}
+	if yamlIntPattern.MatchString(text) || yamlFloatPattern.MatchString(text) {
+		return json.Number(strings.TrimPrefix(text, "+")), nil
+	}
+	return text, nil
+}
+
+// The offset just past the closing quote of the quoted scalar at the start of
+// text
+func quotedYAMLEnd(text string, number int) (int, error) {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i + 1, nil
+		}
+	}
+	return 0, yamlErrorf(number, "Unterminated quoted value; multi-line values aren't supported")
+}
+
+// Unquote a single- or double-quoted scalar, including its quotes
+func parseYAMLQuoted(text string, number int) (string, error) {
+	body := text[1 : len(text)-1]
+	if text[0] == '\'' {
+		return strings.ReplaceAll(body, "''", "'"), nil
+	}
+	var out strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' {
+			out.WriteByte(body[i])
+			continue
+		}
+		i++
+		switch c := body[i]; c {
+		case '\\', '"', '/':
+			out.WriteByte(c)
+		case ' ':
+			out.WriteByte(' ')
+		case '0':
+			out.WriteByte(0)
+		case 't':
+			out.WriteByte('\t')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 'x', 'u', 'U':
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+			if i+1+size > len(body) {
+				return "", yamlErrorf(number, "Invalid escape sequence %q", body[i-1:])
+			}
+			code, err := strconv.ParseUint(body[i+1:i+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", yamlErrorf(number, "Invalid escape sequence %q", body[i-1:i+1+size])
+			}
+			out.WriteRune(rune(code))
+			i += size
+		default:
+			return "", yamlErrorf(number, "Invalid escape sequence \"\\%c\"; use \"\\\\\" for a backslash, or single quotes", c)
+		}
+	}
+	return out.String(), nil
+}
+
+// Parses flow lists and mappings, such as [4624, 4625] or {a: 1, b: 2}, on a
+// single line
+type yamlFlowParser struct {
+	text   string
+	pos    int
+	number int
+}
+
+func (self *yamlFlowParser) skipSpaces() {
+	for self.pos < len(self.text) && self.text[self.pos] == ' ' {
+		self.pos++
+	}
+}
+
+func (self *yamlFlowParser) parseValue() (interface{}, error) {
+	self.skipSpaces()
+	if self.pos >= len(self.text) {
+		return nil, yamlErrorf(self.number, "Unterminated flow collection; multi-line collections aren't supported")
+	}
+	switch self.text[self.pos] {
+	case '[':
+		list := []interface{}{}
+		err := self.parseItems(']', func() error {
+			item, err := self.parseValue()
+			list = append(list, item)
+			return err
+		})
+		return list, err
+	case '{':
+		mapping := make(map[string]interface{})
+		err := self.parseItems('}', func() error {
+			return self.parseEntry(mapping)
+		})
+		return mapping, err
+	case '"', '\'':
+		end, err := quotedYAMLEnd(self.text[self.pos:], self.number)
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseYAMLQuoted(self.text[self.pos:self.pos+end], self.number)
+		self.pos += end
+		return value, err
+	}
+	start := self.pos
+	for self.pos < len(self.text) && strings.IndexByte(",]}", self.text[self.pos]) < 0 &&
+		!(self.text[self.pos] == ':' && (self.pos+1 == len(self.text) || self.text[self.pos+1] == ' ')) {
+		self.pos++
+	}
+	text := strings.TrimRight(self.text[start:self.pos], " ")
+	if text == "" {
+		return nil, yamlErrorf(self.number, "Missing value in flow collection")
+	}
+	return parseYAMLPlain(text, self.number)
+}
+
+// Parse the items of a list or mapping up to the closing bracket. A
+// trailing comma is allowed.
+func (self *yamlFlowParser) parseItems(close byte, parseItem func() error) error {
+	self.pos++
+	for {
+		self.skipSpaces()
+		if self.pos < len(self.text) && self.text[self.pos] == close {
+			self.pos++
+			return nil
+		}
+		if err := parseItem(); err != nil {
+			return err
+		}
+		self.skipSpaces()
+		if self.pos >= len(self.text) {
+			return yamlErrorf(self.number, "Unterminated flow collection; multi-line collections aren't supported")
+		}
+		if self.text[self.pos] == ',' {
+			self.pos++
+		} else if self.text[self.pos] != close {
+			return yamlErrorf(self.number, "Expected ',' or %q in flow collection", close)
+		}
+	}
+}
+
+func (self *yamlFlowParser) parseEntry(mapping map[string]interface{}) error {
+	keyValue, err := self.parseValue()
+	if err != nil {
+		return err
+	}
+	key, ok := keyValue.(string)
+	if !ok {
+		key = fmt.Sprint(keyValue)
+	}
+	if _, exists := mapping[key]; exists {
+		return yamlErrorf(self.number, "Duplicate key %q", key)
+	}
+	self.skipSpaces()
+	if self.pos >= len(self.text) || self.text[self.pos] != ':' {
+		return yamlErrorf(self.number, "Expected \"key: value\" in flow mapping")
+	}
+	self.pos++
+	self.skipSpaces()
+	if self.pos < len(self.text) && (self.text[self.pos] == ',' || self.text[self.pos] == '}') {
+		mapping[key] = nil
+		return nil
+	}
+	value, err := self.parseValue()
+	if err != nil {
+		return err
+	}
+	mapping[key] = value
+	return nil
+}
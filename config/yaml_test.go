@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	. "testing"
+)
+
+// Parse YAML and encode the result as JSON, for comparison
+func yamlToJSON(text string, t *T) string {
+	value, err := parseYAML([]byte(text))
+	if err != nil {
+		t.Fatalf("%q: %v", text, err)
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+func TestYAMLScalars(t *T) {
+	assertEqual(yamlToJSON(`
+a: 1
+b: -2.5e3
+c: 0x1F
+d: true
+e: False
+f: ~
+g:
+h: 10s
+i: C:\Windows\System32
+j: 'it''s # not a comment'
+k: "tab\there \u00e9 \\ \"q\"" # a comment
+l: don't
+m: http://example.com:80/path
+"n o": x
+`, t), `{"a":1,"b":-2.5e3,"c":31,"d":true,"e":false,"f":null,"g":null,"h":"10s","i":"C:\\Windows\\System32","j":"it's # not a comment","k":"tab\there é \\ \"q\"","l":"don't","m":"http://example.com:80/path","n o":"x"}`, t)
+}
+
+func TestYAMLCollections(t *T) {
+	assertEqual(yamlToJSON(`
+---
+list:
+- a
+- - b
+  - c
+-
+  d: 1
+- e: 2
+  f: [3, 'x, y', {g: h}, []]
+indented:
+  - one
+  -   two: 2
+      three: 3
+flow: {a: 1, b: [2, 3], c: }
+empty: {}
+`, t), `{"empty":{},"flow":{"a":1,"b":[2,3],"c":null},"indented":["one",{"three":3,"two":2}],"list":["a",["b","c"],{"d":1},{"e":2,"f":[3,"x, y",{"g":"h"},[]]}]}`, t)
+
+	assertEqual(yamlToJSON("- 1\r\n- 2\r\n...\r\nignored: true\r\n", t), "[1,2]", t)
+	assertEqual(yamlToJSON("# only a comment\n", t), "null", t)
+}
+
+func TestYAMLErrors(t *T) {
+	for _, testCase := range []struct {
+		text    string
+		message string
+	}{
+		{"a: 1\na: 2", `Line 2: Duplicate key "a"`},
+		{"a:\n  b: 1\n c: 2", `Line 3: Unexpected indentation`},
+		{"a: 1\n  b: 2", `Line 2: Unexpected indentation`},
+		{"- a\nb: 1", `Line 2: Expected a list item`},
+		{"a: 1\n- b", `Line 2: Expected "key: value"`},
+		{"a: *alias", `Line 1: Anchors and aliases aren't supported; quote values starting with '*'`},
+		{"a: |\n  text", `Line 1: Block scalars aren't supported; use a quoted string`},
+		{"a: !!str 1", `Line 1: Tags aren't supported; quote values starting with '!'`},
+		{`a: "C:\Windows"`, `Line 1: Invalid escape sequence "\W"; use "\\" for a backslash, or single quotes`},
+		{`a: "unterminated`, `Line 1: Unterminated quoted value; multi-line values aren't supported`},
+		{`a: 'x' y`, `Line 1: Unexpected " y" after the quoted value`},
+		{"a: [1, 2", `Line 1: Unterminated flow collection; multi-line collections aren't supported`},
+		{"a: [1 2] x", `Line 1: Unexpected "x" after the value`},
+		{"a: {b: 1, b: 2}", `Line 1: Duplicate key "b"`},
+		{"a: 1\n---\nb: 2", `Line 2: Only one document is supported`},
+		{"? a\n: 1", `Line 1: Complex keys aren't supported`},
+	} {
+		_, err := parseYAML([]byte(testCase.text))
+		if err == nil {
+			t.Fatalf("%q parsed without error", testCase.text)
+		}
+		assertEqual(err.Error(), testCase.message, t)
+	}
+}
@@ -0,0 +1,167 @@
+// Package ecs maps events from the Windows Event Log to documents in the
+// Elastic Common Schema (https://www.elastic.co/guide/en/ecs/current/).
+package ecs
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// The ECS version that documents conform to, included as ecs.version.
+const Version = "8.11.0"
+
+// An ECS document, as nested maps keyed by field name. Documents encode
+// directly to JSON.
+type Document map[string]interface{}
+
+// Set the field at a dotted path such as "event.code", creating objects as needed.
+func (self Document) Put(path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	object := self
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := object[part].(Document)
+		if !ok {
+			child = Document{}
+			object[part] = child
+		}
+		object = child
+	}
+	object[parts[len(parts)-1]] = value
+}
+
+// Get the field at a dotted path, or nil if it isn't set.
+func (self Document) Get(path string) interface{} {
+	parts := strings.Split(path, ".")
+	object := self
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := object[part].(Document)
+		if !ok {
+			return nil
+		}
+		object = child
+	}
+	return object[parts[len(parts)-1]]
+}
+
+// Set the field only if the value isn't empty.
+func (self Document) putString(path, value string) {
+	if value != "" && value != "-" {
+		self.Put(path, value)
+	}
+}
+
+// Append values to an array field, such as event.category.
+func (self Document) appendStrings(path string, values ...string) {
+	existing, _ := self.Get(path).([]string)
+	self.Put(path, append(existing, values...))
+}
+
+// Standard level names, used when the event has no LevelText
+var levelNames = map[uint64]string{
+	0: "information",
+	1: "critical",
+	2: "error",
+	3: "warning",
+	4: "information",
+	5: "verbose",
+}
+
+// The Security element of the event XML, which has the user SID
+type securityXml struct {
+	System struct {
+		Security struct {
+			UserID string `xml:"UserID,attr"`
+		}
+	}
+}
+
+// Convert an event to an ECS document. The payload is taken from the
+// EventData field, or parsed from the Xml if that isn't set. Well-known
+// events from the Security log and Sysmon get extra fields such as
+// source.ip, user.name and process.executable.
+func FromEvent(event *winlog.WinLogEvent) Document {
+	doc := Document{}
+	doc.Put("ecs.version", Version)
+	if !event.Created.IsZero() {
+		doc.Put("@timestamp", event.Created.UTC().Format(time.RFC3339Nano))
+	}
+	doc.putString("message", event.Msg)
+
+	doc.Put("event.kind", "event")
+	doc.Put("event.code", strconv.FormatUint(event.EventId, 10))
+	doc.putString("event.provider", event.ProviderName)
+	doc.putString("event.action", event.TaskText)
+	doc.Put("event.module", "windows")
+	for _, keyword := range event.Keywords {
+		switch keyword {
+		case "Audit Success":
+			doc.Put("event.outcome", "success")
+		case "Audit Failure":
+			doc.Put("event.outcome", "failure")
+		}
+	}
+
+	level := strings.ToLower(event.LevelText)
+	if level == "" {
+		level = levelNames[event.Level]
+	}
+	doc.putString("log.level", level)
+	doc.putString("host.name", event.ComputerName)
+	if event.ProcessId != 0 {
+		doc.Put("process.pid", event.ProcessId)
+		doc.Put("process.thread.id", event.ThreadId)
+	}
+
+	doc.putString("winlog.channel", event.Channel)
+	doc.putString("winlog.provider_name", event.ProviderName)
+	doc.putString("winlog.computer_name", event.ComputerName)
+	doc.Put("winlog.event_id", strconv.FormatUint(event.EventId, 10))
+	doc.Put("winlog.record_id", strconv.FormatUint(event.RecordId, 10))
+	doc.Put("winlog.version", event.Version)
+	doc.putString("winlog.task", event.TaskText)
+	doc.putString("winlog.opcode", event.OpcodeText)
+	if len(event.Keywords) > 0 {
+		doc.Put("winlog.keywords", event.Keywords)
+	}
+	if event.ProcessId != 0 {
+		doc.Put("winlog.process.pid", event.ProcessId)
+		doc.Put("winlog.process.thread.id", event.ThreadId)
+	}
+
+	if event.Xml != "" {
+		var security securityXml
+		if xml.Unmarshal([]byte(event.Xml), &security) == nil && security.System.Security.UserID != "" {
+			doc.Put("winlog.user.identifier", security.System.Security.UserID)
+			doc.Put("user.id", security.System.Security.UserID)
+		}
+	}
+
+	data := eventData(winlog.EventPayload(event))
+	if len(data) > 0 {
+		doc.Put("winlog.event_data", data)
+	}
+	if enrich, ok := enrichments[enrichmentKey{event.ProviderName, event.EventId}]; ok {
+		enrich(doc, data)
+	}
+	return doc
+}
+
+// Key the payload by field name, naming unnamed fields by position as the
+// JSON encoding does.
+func eventData(fields []winlog.EventDataField) map[string]string {
+	data := make(map[string]string, len(fields))
+	for i, field := range fields {
+		name := field.Name
+		if name == "" {
+			name = "param" + strconv.Itoa(i+1)
+		}
+		if _, ok := data[name]; !ok {
+			data[name] = field.Value
+		}
+	}
+	return data
+}
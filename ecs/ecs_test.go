@@ -0,0 +1,131 @@
+package ecs
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	. "testing"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func loadFixture(name string, t *T) Document {
+	xml, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := winlog.ParseEventXml(string(xml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return FromEvent(event)
+}
+
+func TestPutAndGet(t *T) {
+	doc := Document{}
+	doc.Put("a.b.c", 1)
+	doc.Put("a.d", "x")
+	doc.putString("a.e", "-")
+	assertEqual(doc.Get("a.b.c"), 1, t)
+	assertEqual(doc.Get("a.d"), "x", t)
+	assertEqual(doc.Get("a.e"), nil, t)
+	assertEqual(doc.Get("a.d.f"), nil, t)
+	doc.appendStrings("event.type", "start")
+	doc.appendStrings("event.type", "connection")
+	assertEqual(doc.Get("event.type"), []string{"start", "connection"}, t)
+}
+
+func TestSecurityLogon(t *T) {
+	doc := loadFixture("security-4624.xml", t)
+	assertEqual(doc.Get("ecs.version"), Version, t)
+	assertEqual(doc.Get("@timestamp"), "2016-01-19T19:37:48.1234567Z", t)
+	assertEqual(doc.Get("event.code"), "4624", t)
+	assertEqual(doc.Get("event.action"), "logged-in", t)
+	assertEqual(doc.Get("event.outcome"), "success", t)
+	assertEqual(doc.Get("event.category"), []string{"authentication"}, t)
+	assertEqual(doc.Get("host.name"), "WIN-HOST.corp.example.com", t)
+	assertEqual(doc.Get("user.name"), "alice", t)
+	assertEqual(doc.Get("user.domain"), "CORP", t)
+	assertEqual(doc.Get("user.id"), "S-1-5-21-1-2-3-1104", t)
+	assertEqual(doc.Get("source.ip"), "10.1.2.3", t)
+	assertEqual(doc.Get("source.port"), uint64(51234), t)
+	assertEqual(doc.Get("winlog.logon.type"), "RemoteInteractive", t)
+	assertEqual(doc.Get("process.pid"), uint64(0x1c8), t)
+	assertEqual(doc.Get("process.name"), "svchost.exe", t)
+	assertEqual(doc.Get("winlog.event_data").(map[string]string)["LogonProcessName"], "User32 ", t)
+}
+
+func TestSecurityProcessCreated(t *T) {
+	doc := loadFixture("security-4688.xml", t)
+	assertEqual(doc.Get("event.action"), "created-process", t)
+	assertEqual(doc.Get("event.type"), []string{"start"}, t)
+	assertEqual(doc.Get("user.name"), "alice", t)
+	assertEqual(doc.Get("process.pid"), uint64(0x1a4), t)
+	assertEqual(doc.Get("process.executable"), `C:\Windows\System32\cmd.exe`, t)
+	assertEqual(doc.Get("process.name"), "cmd.exe", t)
+	assertEqual(doc.Get("process.command_line"), "cmd.exe /c whoami", t)
+	assertEqual(doc.Get("process.parent.pid"), uint64(0xf00), t)
+	assertEqual(doc.Get("process.parent.name"), "explorer.exe", t)
+}
+
+func TestSysmonProcessCreated(t *T) {
+	doc := loadFixture("sysmon-1.xml", t)
+	assertEqual(doc.Get("log.level"), "information", t)
+	assertEqual(doc.Get("event.category"), []string{"process"}, t)
+	assertEqual(doc.Get("process.entity_id"), "{747f3d96-e9b0-5eaf-0000-0010e1b51e00}", t)
+	assertEqual(doc.Get("process.pid"), uint64(6452), t)
+	assertEqual(doc.Get("process.name"), "powershell.exe", t)
+	assertEqual(doc.Get("process.working_directory"), `C:\Users\alice\`, t)
+	assertEqual(doc.Get("process.parent.pid"), uint64(5120), t)
+	assertEqual(doc.Get("process.hash.md5"), "7353f60b1739074eb17c5f4dddefe239", t)
+	assertEqual(doc.Get("process.hash.sha1"), "6cbce4a295c163791b60fc23d285e6d84f28ee4c", t)
+	assertEqual(doc.Get("user.domain"), "CORP", t)
+	assertEqual(doc.Get("user.name"), "alice", t)
+	// The SID of the event's Security element is replaced by the payload user
+	assertEqual(doc.Get("winlog.user.identifier"), "S-1-5-18", t)
+}
+
+func TestSysmonNetworkConnection(t *T) {
+	doc := loadFixture("sysmon-3.xml", t)
+	assertEqual(doc.Get("event.type"), []string{"connection", "start"}, t)
+	assertEqual(doc.Get("network.transport"), "tcp", t)
+	assertEqual(doc.Get("network.direction"), "egress", t)
+	assertEqual(doc.Get("source.ip"), "10.1.2.3", t)
+	assertEqual(doc.Get("source.port"), uint64(50123), t)
+	assertEqual(doc.Get("destination.ip"), "93.184.216.34", t)
+	assertEqual(doc.Get("destination.port"), uint64(443), t)
+	assertEqual(doc.Get("destination.domain"), "example.com", t)
+}
+
+func TestUnknownEventAndJson(t *T) {
+	event := &winlog.WinLogEvent{
+		ProviderName: "Application Error",
+		EventId:      1000,
+		Level:        2,
+		Channel:      "Application",
+		Msg:          "Faulting application name: app.exe",
+		EventData:    []winlog.EventDataField{{Value: "app.exe"}},
+	}
+	doc := FromEvent(event)
+	assertEqual(doc.Get("log.level"), "error", t)
+	assertEqual(doc.Get("message"), event.Msg, t)
+	assertEqual(doc.Get("event.category"), nil, t)
+	assertEqual(doc.Get("@timestamp"), nil, t)
+
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	winlogFields := decoded["winlog"].(map[string]interface{})
+	assertEqual(winlogFields["event_data"], map[string]interface{}{"param1": "app.exe"}, t)
+}
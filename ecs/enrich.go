@@ -0,0 +1,256 @@
+package ecs
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	securityProvider = "Microsoft-Windows-Security-Auditing"
+	eventlogProvider = "Microsoft-Windows-Eventlog"
+	sysmonProvider   = "Microsoft-Windows-Sysmon"
+)
+
+type enrichmentKey struct {
+	provider string
+	eventId  uint64
+}
+
+// Adds fields for a specific event to the document, given its payload
+type enrichment func(doc Document, data map[string]string)
+
+var enrichments = map[enrichmentKey]enrichment{
+	{securityProvider, 4624}: logon("logged-in", "success"),
+	{securityProvider, 4625}: logon("logon-failed", "failure"),
+	{securityProvider, 4634}: logoff,
+	{securityProvider, 4647}: logoff,
+	{securityProvider, 4648}: logon("logged-in-explicit", "success"),
+	{securityProvider, 4672}: specialLogon,
+	{securityProvider, 4688}: securityProcessCreated,
+	{securityProvider, 4689}: securityProcessExited,
+	{securityProvider, 4720}: userAccount("added-user-account", "creation"),
+	{securityProvider, 4726}: userAccount("deleted-user-account", "deletion"),
+	{securityProvider, 4740}: userAccount("locked-out-user-account", "change"),
+	{securityProvider, 1102}: auditLogCleared,
+	{eventlogProvider, 1102}: auditLogCleared,
+	{sysmonProvider, 1}:      sysmonProcessCreated,
+	{sysmonProvider, 3}:      sysmonNetworkConnection,
+	{sysmonProvider, 5}:      sysmonProcessTerminated,
+	{sysmonProvider, 11}:     sysmonFileCreated,
+	{sysmonProvider, 22}:     sysmonDnsQuery,
+}
+
+// Logon types from the LogonType field of Security events
+var logonTypes = map[string]string{
+	"2":  "Interactive",
+	"3":  "Network",
+	"4":  "Batch",
+	"5":  "Service",
+	"7":  "Unlock",
+	"8":  "NetworkCleartext",
+	"9":  "NewCredentials",
+	"10": "RemoteInteractive",
+	"11": "CachedInteractive",
+}
+
+// Parse a decimal or 0x-prefixed hex number, as used for PIDs in event payloads.
+func parseNumber(value string) (uint64, bool) {
+	number, err := strconv.ParseUint(strings.TrimSpace(value), 0, 64)
+	return number, err == nil
+}
+
+func (self Document) putNumber(fieldPath, value string) {
+	if number, ok := parseNumber(value); ok {
+		self.Put(fieldPath, number)
+	}
+}
+
+// Set process.executable and process.name (or the parent's) from a path.
+func (self Document) putExecutable(prefix, executable string) {
+	if executable == "" || executable == "-" {
+		return
+	}
+	self.Put(prefix+".executable", executable)
+	self.Put(prefix+".name", path.Base(strings.Replace(executable, "\\", "/", -1)))
+}
+
+// Set user fields from a "DOMAIN\name" string, as used by Sysmon.
+func (self Document) putDomainUser(prefix, user string) {
+	if user == "" {
+		return
+	}
+	if slash := strings.Index(user, "\\"); slash >= 0 {
+		self.Put(prefix+".domain", user[:slash])
+		self.Put(prefix+".name", user[slash+1:])
+	} else {
+		self.Put(prefix+".name", user)
+	}
+}
+
+func (self Document) putSubjectUser(data map[string]string) {
+	self.putString("user.name", data["SubjectUserName"])
+	self.putString("user.domain", data["SubjectDomainName"])
+	self.putString("user.id", data["SubjectUserSid"])
+}
+
+func (self Document) putTargetUser(data map[string]string) {
+	self.putString("user.name", data["TargetUserName"])
+	self.putString("user.domain", data["TargetDomainName"])
+	self.putString("user.id", data["TargetUserSid"])
+}
+
+func logon(action, outcome string) enrichment {
+	return func(doc Document, data map[string]string) {
+		doc.appendStrings("event.category", "authentication")
+		doc.appendStrings("event.type", "start")
+		doc.Put("event.action", action)
+		doc.Put("event.outcome", outcome)
+		doc.putTargetUser(data)
+		doc.putString("source.ip", data["IpAddress"])
+		if data["IpPort"] != "0" {
+			doc.putNumber("source.port", data["IpPort"])
+		}
+		doc.putString("source.domain", data["WorkstationName"])
+		if logonType, ok := logonTypes[data["LogonType"]]; ok {
+			doc.Put("winlog.logon.type", logonType)
+		}
+		doc.putString("winlog.logon.id", data["TargetLogonId"])
+		doc.putNumber("process.pid", data["ProcessId"])
+		doc.putExecutable("process", data["ProcessName"])
+	}
+}
+
+func logoff(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "authentication")
+	doc.appendStrings("event.type", "end")
+	doc.Put("event.action", "logged-out")
+	doc.Put("event.outcome", "success")
+	doc.putTargetUser(data)
+	if logonType, ok := logonTypes[data["LogonType"]]; ok {
+		doc.Put("winlog.logon.type", logonType)
+	}
+	doc.putString("winlog.logon.id", data["TargetLogonId"])
+}
+
+func specialLogon(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "iam")
+	doc.appendStrings("event.type", "admin")
+	doc.Put("event.action", "logged-in-special")
+	doc.Put("event.outcome", "success")
+	doc.putSubjectUser(data)
+	doc.putString("winlog.logon.id", data["SubjectLogonId"])
+}
+
+func securityProcessCreated(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "process")
+	doc.appendStrings("event.type", "start")
+	doc.Put("event.action", "created-process")
+	doc.putSubjectUser(data)
+	doc.putNumber("process.pid", data["NewProcessId"])
+	doc.putExecutable("process", data["NewProcessName"])
+	doc.putString("process.command_line", data["CommandLine"])
+	doc.putNumber("process.parent.pid", data["ProcessId"])
+	doc.putExecutable("process.parent", data["ParentProcessName"])
+}
+
+func securityProcessExited(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "process")
+	doc.appendStrings("event.type", "end")
+	doc.Put("event.action", "exited-process")
+	doc.putSubjectUser(data)
+	doc.putNumber("process.pid", data["ProcessId"])
+	doc.putExecutable("process", data["ProcessName"])
+}
+
+func userAccount(action, eventType string) enrichment {
+	return func(doc Document, data map[string]string) {
+		doc.appendStrings("event.category", "iam")
+		doc.appendStrings("event.type", "user", eventType)
+		doc.Put("event.action", action)
+		doc.putSubjectUser(data)
+		doc.putString("user.target.name", data["TargetUserName"])
+		doc.putString("user.target.domain", data["TargetDomainName"])
+		doc.putString("user.target.id", data["TargetSid"])
+	}
+}
+
+func auditLogCleared(doc Document, data map[string]string) {
+	doc.appendStrings("event.type", "change")
+	doc.Put("event.action", "audit-log-cleared")
+	doc.putSubjectUser(data)
+}
+
+// Set process fields common to Sysmon events
+func (self Document) putSysmonProcess(data map[string]string) {
+	self.putString("process.entity_id", data["ProcessGuid"])
+	self.putNumber("process.pid", data["ProcessId"])
+	self.putExecutable("process", data["Image"])
+}
+
+func sysmonProcessCreated(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "process")
+	doc.appendStrings("event.type", "start")
+	doc.Put("event.action", "Process Create (rule: ProcessCreate)")
+	doc.putSysmonProcess(data)
+	doc.putString("process.command_line", data["CommandLine"])
+	doc.putString("process.working_directory", data["CurrentDirectory"])
+	doc.putString("process.parent.entity_id", data["ParentProcessGuid"])
+	doc.putNumber("process.parent.pid", data["ParentProcessId"])
+	doc.putExecutable("process.parent", data["ParentImage"])
+	doc.putString("process.parent.command_line", data["ParentCommandLine"])
+	doc.putDomainUser("user", data["User"])
+	// Hashes are "SHA1=...,MD5=...,SHA256=..."
+	for _, hash := range strings.Split(data["Hashes"], ",") {
+		if equals := strings.Index(hash, "="); equals > 0 {
+			doc.Put("process.hash."+strings.ToLower(hash[:equals]), strings.ToLower(hash[equals+1:]))
+		}
+	}
+}
+
+func sysmonNetworkConnection(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "network")
+	doc.appendStrings("event.type", "connection", "start")
+	doc.Put("event.action", "Network connection detected (rule: NetworkConnect)")
+	doc.putSysmonProcess(data)
+	doc.putDomainUser("user", data["User"])
+	doc.putString("network.transport", strings.ToLower(data["Protocol"]))
+	if data["Initiated"] == "true" {
+		doc.Put("network.direction", "egress")
+	} else if data["Initiated"] == "false" {
+		doc.Put("network.direction", "ingress")
+	}
+	doc.putString("source.ip", data["SourceIp"])
+	doc.putNumber("source.port", data["SourcePort"])
+	doc.putString("source.domain", data["SourceHostname"])
+	doc.putString("destination.ip", data["DestinationIp"])
+	doc.putNumber("destination.port", data["DestinationPort"])
+	doc.putString("destination.domain", data["DestinationHostname"])
+}
+
+func sysmonProcessTerminated(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "process")
+	doc.appendStrings("event.type", "end")
+	doc.Put("event.action", "Process terminated (rule: ProcessTerminate)")
+	doc.putSysmonProcess(data)
+}
+
+func sysmonFileCreated(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "file")
+	doc.appendStrings("event.type", "creation")
+	doc.Put("event.action", "File created (rule: FileCreate)")
+	doc.putSysmonProcess(data)
+	if target := data["TargetFilename"]; target != "" {
+		doc.Put("file.path", target)
+		doc.Put("file.name", path.Base(strings.Replace(target, "\\", "/", -1)))
+	}
+}
+
+func sysmonDnsQuery(doc Document, data map[string]string) {
+	doc.appendStrings("event.category", "network")
+	doc.appendStrings("event.type", "protocol", "info")
+	doc.Put("event.action", "Dns query (rule: DnsQuery)")
+	doc.putSysmonProcess(data)
+	doc.Put("network.protocol", "dns")
+	doc.putString("dns.question.name", data["QueryName"])
+}
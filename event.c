@@ -71,8 +71,9 @@ char* RenderEventXML(ULONGLONG hEvent) {
 	return xml;
 }
 
-char* GetLastErrorString() {
+char* GetLastErrorString(DWORD* code) {
 	DWORD dwErr = GetLastError();
+	*code = dwErr;
 	LPSTR lpszMsgBuf;
 	FormatMessage(FORMAT_MESSAGE_FROM_SYSTEM | FORMAT_MESSAGE_ALLOCATE_BUFFER | FORMAT_MESSAGE_IGNORE_INSERTS, 0, dwErr, 0, (LPSTR)&lpszMsgBuf, 0, NULL);
 	return (char *)lpszMsgBuf;
@@ -109,9 +110,86 @@ char* GetFormattedMessage(ULONGLONG hEventPublisher, ULONGLONG hEvent, int forma
 	return message;
 }
 
+// Convert a wide string to a newly allocated multibyte string, which must be freed by the caller.
+static char* WideToMultiByte(LPCWSTR wide) {
+	size_t lenNarrow = wcstombs(NULL, wide, 0) + 1;
+	char* narrow = malloc(lenNarrow);
+	if (!narrow) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	wcstombs(narrow, wide, lenNarrow);
+	return narrow;
+}
+
+char* GetMessageString(ULONGLONG hEventPublisher, ULONGLONG messageId) {
+	DWORD dwBufferSize = 0;
+	DWORD dwBufferUsed = 0;
+	EvtFormatMessage((EVT_HANDLE)hEventPublisher, NULL, (DWORD)messageId, 0, NULL, EvtFormatMessageId, 0, NULL, &dwBufferUsed);
+	if (GetLastError() != ERROR_INSUFFICIENT_BUFFER) {
+		return NULL;
+	}
+	dwBufferSize = dwBufferUsed + 1;
+	LPWSTR messageWide = malloc(dwBufferSize * sizeof(wchar_t));
+	if (!messageWide) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	// Without values the inserts can't be resolved, but the buffer is still
+	// filled with the template, which is what we want.
+	if (!EvtFormatMessage((EVT_HANDLE)hEventPublisher, NULL, (DWORD)messageId, 0, NULL, EvtFormatMessageId, dwBufferSize, messageWide, &dwBufferUsed)) {
+		DWORD status = GetLastError();
+		if (status != ERROR_EVT_UNRESOLVED_VALUE_INSERT && status != ERROR_EVT_UNRESOLVED_PARAMETER_INSERT) {
+			free(messageWide);
+			return NULL;
+		}
+	}
+	char* message = WideToMultiByte(messageWide);
+	free(messageWide);
+	return message;
+}
+
+char* GetMessageTemplate(ULONGLONG hEventPublisher, ULONGLONG eventId, ULONGLONG version) {
+	EVT_HANDLE hEnum = EvtOpenEventMetadataEnum((EVT_HANDLE)hEventPublisher, 0);
+	if (!hEnum) {
+		return NULL;
+	}
+	EVT_HANDLE hEvent = NULL;
+	EVT_VARIANT property;
+	DWORD dwUsed = 0;
+	DWORD messageId = (DWORD)-1;
+	BOOL found = FALSE;
+	while (!found && (hEvent = EvtNextEventMetadata(hEnum, 0)) != NULL) {
+		if (EvtGetEventMetadataProperty(hEvent, EventMetadataEventID, 0, sizeof(property), &property, &dwUsed) &&
+			(property.UInt32Val & 0xFFFF) == eventId &&
+			EvtGetEventMetadataProperty(hEvent, EventMetadataEventVersion, 0, sizeof(property), &property, &dwUsed) &&
+			property.UInt32Val == version &&
+			EvtGetEventMetadataProperty(hEvent, EventMetadataEventMessageID, 0, sizeof(property), &property, &dwUsed)) {
+			found = TRUE;
+			messageId = property.UInt32Val;
+		}
+		EvtClose(hEvent);
+	}
+	EvtClose(hEnum);
+	// Events without a message have an ID of -1
+	if (!found || messageId == (DWORD)-1) {
+		SetLastError(ERROR_NOT_FOUND);
+		return NULL;
+	}
+	return GetMessageString(hEventPublisher, messageId);
+}
+
 ULONGLONG GetEventPublisherHandle(PVOID pRenderedValues) { 
+	return GetEventPublisherHandleForLocale(pRenderedValues, 0);
+}
+
+ULONGLONG GetEventPublisherHandleForLocale(PVOID pRenderedValues, DWORD locale) {
 	LPCWSTR publisher = ((PEVT_VARIANT)pRenderedValues)[EvtSystemProviderName].StringVal;
-	return (ULONGLONG)EvtOpenPublisherMetadata(NULL, publisher, NULL, 0, 0);
+	return (ULONGLONG)EvtOpenPublisherMetadata(NULL, publisher, NULL, locale, 0);
+}
+
+DWORD GetDefaultLocale() {
+	return (DWORD)GetThreadUILanguage();
 }
 
 ULONGLONG CreateSystemRenderContext() {
@@ -171,7 +249,7 @@ ULONGLONG GetRenderedFileTimeValue(PVOID pRenderedValues, int property) {
 	FILETIME* ft = (FILETIME*) &(((PEVT_VARIANT)pRenderedValues)[property].FileTimeVal); 
 	ULONGLONG time = ft->dwHighDateTime;
 	time = (time << 32) | ft->dwLowDateTime;
-	return (time / 10000000) - 11644473600;
+	return time;
 }
 
 // Dispatch events and errors appropriately
@@ -234,6 +312,68 @@ ULONGLONG CreateListenerFromBookmark(char* channel, char* query, PVOID pWatcher,
 	return SetupListener(channel, query, pWatcher, (EVT_HANDLE)hBookmark, EvtSubscribeStartAfterBookmark);
 }
 
+ULONGLONG CreatePullListener(char* channel, char* query, int startPos, ULONGLONG hBookmark, ULONGLONG hSignal) {
+	size_t maxWideChannelLen = mbstowcs(NULL, channel, 0) + 1;
+	LPWSTR lChannel = malloc(maxWideChannelLen * sizeof(wchar_t));
+	if (!lChannel) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return 0;
+	}
+	size_t maxWideQueryLen = mbstowcs(NULL, query, 0) + 1;
+	LPWSTR lQuery = malloc(maxWideQueryLen * sizeof(wchar_t));
+	if (!lQuery) {
+		free(lChannel);
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return 0;
+	}
+	mbstowcs(lChannel, channel, maxWideChannelLen);
+	mbstowcs(lQuery, query, maxWideQueryLen);
+
+	EVT_HANDLE hStart = (startPos == EvtSubscribeStartAfterBookmark) ? (EVT_HANDLE)hBookmark : NULL;
+	EVT_HANDLE hSubscription = EvtSubscribe(NULL, (HANDLE)hSignal, lChannel, lQuery, hStart, NULL, NULL, startPos);
+	free(lChannel);
+	free(lQuery);
+	return (ULONGLONG)hSubscription;
+}
+
+int NextEvents(ULONGLONG hSubscription, ULONGLONG* pEvents, int maxEvents) {
+	DWORD dwReturned = 0;
+	EVT_HANDLE* hEvents = malloc(maxEvents * sizeof(EVT_HANDLE));
+	if (!hEvents) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return -1;
+	}
+	if (!EvtNext((EVT_HANDLE)hSubscription, maxEvents, hEvents, 0, 0, &dwReturned)) {
+		free(hEvents);
+		return GetLastError() == ERROR_NO_MORE_ITEMS ? 0 : -1;
+	}
+	for (DWORD i = 0; i < dwReturned; i++) {
+		pEvents[i] = (ULONGLONG)hEvents[i];
+	}
+	free(hEvents);
+	return (int)dwReturned;
+}
+
+ULONGLONG CreateSignalEvent() {
+	return (ULONGLONG)CreateEvent(NULL, TRUE, TRUE, NULL);
+}
+
+int WaitForSignalEvent(ULONGLONG hSignal, DWORD timeoutMs) {
+	return WaitForSingleObject((HANDLE)hSignal, timeoutMs) == WAIT_OBJECT_0;
+}
+
+int SetSignalEvent(ULONGLONG hSignal) {
+	return SetEvent((HANDLE)hSignal);
+}
+
+int ResetSignalEvent(ULONGLONG hSignal) {
+	return ResetEvent((HANDLE)hSignal);
+}
+
+int CloseSignalEvent(ULONGLONG hSignal) {
+	return CloseHandle((HANDLE)hSignal);
+}
+
 ULONGLONG GetTestEventHandle() {
 	DWORD status = ERROR_SUCCESS;
 	EVT_HANDLE record = 0;
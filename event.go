@@ -134,6 +134,74 @@ func CreateListenerFromBookmark(channel, query string, watcher *LogEventCallback
 	return ListenerHandle(listenerHandle), nil
 }
 
+// Get a handle for an event log subscription which signals `signal` when events are
+// available, rather than calling back. Events are read with NextEvents. `bookmarkHandle`
+// is only used when `startpos` is EvtSubscribeStartAfterBookmark.
+// The resulting handle must be closed with CloseEventHandle.
+func CreatePullListener(channel, query string, startpos EVT_SUBSCRIBE_FLAGS, bookmarkHandle BookmarkHandle, signal SignalHandle) (ListenerHandle, error) {
+	cChan := C.CString(channel)
+	cQuery := C.CString(query)
+	listenerHandle := C.CreatePullListener(cChan, cQuery, C.int(startpos), C.ULONGLONG(bookmarkHandle), C.ULONGLONG(signal))
+	C.free(unsafe.Pointer(cChan))
+	C.free(unsafe.Pointer(cQuery))
+	if listenerHandle == 0 {
+		return 0, GetLastError()
+	}
+	return ListenerHandle(listenerHandle), nil
+}
+
+// Read the waiting events from a pull listener into `events`, returning the number
+// read. Unlike events passed to a callback, these handles remain valid until they
+// are closed with CloseEventHandle.
+func NextEvents(listenerHandle ListenerHandle, events []EventHandle) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+	// EventHandle has the same layout as ULONGLONG, so the C code can fill the slice directly
+	cEvents := (*C.ULONGLONG)(unsafe.Pointer(&events[0]))
+	count := int(C.NextEvents(C.ULONGLONG(listenerHandle), cEvents, C.int(len(events))))
+	if count < 0 {
+		return 0, GetLastError()
+	}
+	return count, nil
+}
+
+// Create the manual-reset Win32 event used to signal a pull listener. The
+// resulting handle must be closed with CloseSignalEvent.
+func CreateSignalEvent() (SignalHandle, error) {
+	handle := SignalHandle(C.CreateSignalEvent())
+	if handle == 0 {
+		return 0, GetLastError()
+	}
+	return handle, nil
+}
+
+// Wait for the signal to be set, returning false on timeout.
+func WaitForSignalEvent(signal SignalHandle, timeout time.Duration) bool {
+	return C.WaitForSignalEvent(C.ULONGLONG(signal), C.DWORD(timeout/time.Millisecond)) != 0
+}
+
+func SetSignalEvent(signal SignalHandle) error {
+	if C.SetSignalEvent(C.ULONGLONG(signal)) == 0 {
+		return GetLastError()
+	}
+	return nil
+}
+
+func ResetSignalEvent(signal SignalHandle) error {
+	if C.ResetSignalEvent(C.ULONGLONG(signal)) == 0 {
+		return GetLastError()
+	}
+	return nil
+}
+
+func CloseSignalEvent(signal SignalHandle) error {
+	if C.CloseSignalEvent(C.ULONGLONG(signal)) == 0 {
+		return GetLastError()
+	}
+	return nil
+}
+
 // Get the Go string for the field at the given index. Returns
 // false if the type of the field isn't EvtVarTypeString.
 func RenderStringField(fields RenderedFields, fieldIndex EVT_SYSTEM_PROPERTY_ID) (string, bool) {
@@ -160,7 +228,7 @@ func RenderFileTimeField(fields RenderedFields, fieldIndex EVT_SYSTEM_PROPERTY_I
 		return time.Time{}, false
 	}
 	field := C.GetRenderedFileTimeValue(C.PVOID(fields), C.int(fieldIndex))
-	return time.Unix(int64(field), 0), true
+	return fileTimeToTime(uint64(field)), true
 }
 
 // Get the unsigned integer at the given index. Returns false if the field
@@ -216,10 +284,59 @@ func FormatMessage(eventPublisherHandle PublisherHandle, eventHandle EventHandle
 	return value, nil
 }
 
-// Get the formatted string for the last error which occurred. Wraps GetLastError and FormatMessage.
+// Get the raw message template for the event with the given ID and version from the
+// publisher metadata, with insertion strings such as %1 left in place. This can be
+// expanded in Go with FormatMessageTemplate.
+func GetMessageTemplate(eventPublisherHandle PublisherHandle, eventId, version uint64) (string, error) {
+	cString := C.GetMessageTemplate(C.ULONGLONG(eventPublisherHandle), C.ULONGLONG(eventId), C.ULONGLONG(version))
+	if cString == nil {
+		return "", GetLastError()
+	}
+	value := C.GoString(cString)
+	C.free(unsafe.Pointer(cString))
+	return value, nil
+}
+
+// Get a message from the publisher's message file by ID, with insertion strings
+// left in place. Parameter references (%%n) are in the parameter file instead,
+// see LoadPublisherParameters.
+func GetMessageString(eventPublisherHandle PublisherHandle, messageId uint64) (string, error) {
+	cString := C.GetMessageString(C.ULONGLONG(eventPublisherHandle), C.ULONGLONG(messageId))
+	if cString == nil {
+		return "", GetLastError()
+	}
+	value := C.GoString(cString)
+	C.free(unsafe.Pointer(cString))
+	return value, nil
+}
+
+// System error codes checked by the library
+const (
+	ERROR_EVT_MESSAGE_LOCALE_NOT_FOUND = 15033
+)
+
+// An error from a Windows API, with its system error code
+type SystemError struct {
+	Code    uint32
+	Message string
+}
+
+func (self *SystemError) Error() string {
+	return self.Message
+}
+
+// Whether err is a SystemError with the given code.
+func IsSystemError(err error, code uint32) bool {
+	var systemErr *SystemError
+	return errors.As(err, &systemErr) && systemErr.Code == code
+}
+
+// Get the last error which occurred as a SystemError with its formatted
+// message. Wraps GetLastError and FormatMessage.
 func GetLastError() error {
-	errStr := C.GetLastErrorString()
-	err := errors.New(C.GoString(errStr))
+	var code C.DWORD
+	errStr := C.GetLastErrorString(&code)
+	err := &SystemError{Code: uint32(code), Message: C.GoString(errStr)}
 	C.LocalFree(C.HLOCAL(errStr))
 	return err
 }
@@ -255,6 +372,22 @@ func GetEventPublisherHandle(renderedFields RenderedFields) (PublisherHandle, er
 	return handle, nil
 }
 
+// Get a handle that represents the publisher of the event, which formats messages in
+// the given locale (LCID). A locale of 0 uses the UI language of the calling thread.
+func GetEventPublisherHandleForLocale(renderedFields RenderedFields, locale uint32) (PublisherHandle, error) {
+	handle := PublisherHandle(C.GetEventPublisherHandleForLocale(C.PVOID(renderedFields), C.DWORD(locale)))
+	if handle == 0 {
+		return 0, GetLastError()
+	}
+	return handle, nil
+}
+
+// Get the locale (LCID) used to format messages when none is specified, which is
+// the UI language of the calling thread.
+func GetDefaultLocale() uint32 {
+	return uint32(C.GetDefaultLocale())
+}
+
 // Close an event handle.
 func CloseEventHandle(handle uint64) error {
 	if C.CloseEvtHandle(C.ULONGLONG(handle)) != 1 {
@@ -289,10 +422,18 @@ func getTestEventHandle() (EventHandle, error) {
 
 //export eventCallbackError
 func eventCallbackError(errCode C.ULONGLONG, logWatcher unsafe.Pointer) {
-	watcher := (*LogEventCallbackWrapper)(logWatcher).callback
+	wrapper := (*LogEventCallbackWrapper)(logWatcher)
+	watcher := wrapper.callback
 	// The provided errCode can be looked up in the Microsoft System Error Code table:
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms681382(v=vs.85).aspx
-	watcher.PublishError(fmt.Errorf("Event log callback got error code: %v", errCode))
+	err := fmt.Errorf("Event log callback got error code: %v", errCode)
+	if winLogWatcher, ok := watcher.(*WinLogWatcher); ok {
+		winLogWatcher.metrics.CallbackError(uint64(errCode))
+		winLogWatcher.logger.Error("Event log callback failed", winLogWatcher.subscriptionAttributes(wrapper.subscribedChannel, "code", uint64(errCode))...)
+		winLogWatcher.publishChannelError(wrapper.subscribedChannel, err)
+		return
+	}
+	watcher.PublishError(err)
 }
 
 //export eventCallback
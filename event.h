@@ -19,8 +19,26 @@ ULONGLONG CreateListener(char* channel, char* query, int startpos, PVOID pWatche
 // the bookmark and now, it'll continue silently from the earliest event.
 ULONGLONG CreateListenerFromBookmark(char* channel, char* query, PVOID pWatcher, ULONGLONG hBookmark);
 
-// Get the string for the last error code
-char* GetLastErrorString();
+// Create a listener on the given channel which signals hSignal when events are
+// available, instead of calling back. Events are read with NextEvents, and the
+// caller owns the returned event handles. hBookmark is only used if startPos
+// is EvtSubscribeStartAfterBookmark.
+ULONGLONG CreatePullListener(char* channel, char* query, int startPos, ULONGLONG hBookmark, ULONGLONG hSignal);
+
+// Read up to maxEvents event handles from a pull listener into pEvents. Returns
+// the number read, 0 if there are none waiting, or -1 on error.
+int NextEvents(ULONGLONG hSubscription, ULONGLONG* pEvents, int maxEvents);
+
+// Manage the manual-reset Win32 event used to signal a pull listener.
+// These are closed with CloseSignalEvent, not CloseEvtHandle.
+ULONGLONG CreateSignalEvent();
+int WaitForSignalEvent(ULONGLONG hSignal, DWORD timeoutMs);
+int SetSignalEvent(ULONGLONG hSignal);
+int ResetSignalEvent(ULONGLONG hSignal);
+int CloseSignalEvent(ULONGLONG hSignal);
+
+// Get the string for the last error code, and store the code in *code
+char* GetLastErrorString(DWORD* code);
 
 // Render the fields for the given context. Allocates an array
 // of values based on the context, these can be accessed using
@@ -47,17 +65,34 @@ ULONGLONG GetRenderedUInt32Value(PVOID pRenderedValues, int property);
 ULONGLONG GetRenderedUInt64Value(PVOID pRenderedValues, int property);
 // Returns a pointer to a string that must be freed by the caller
 char* GetRenderedStringValue(PVOID pRenderedValues, int property);
-// Returns a unix epoch timestamp in milliseconds, not a FileTime
+// Returns the FileTime as 100-nanosecond intervals since January 1, 1601 UTC
 ULONGLONG GetRenderedFileTimeValue(PVOID pRenderedValues, int property);
 
 // Format the event into a string using details from the event publisher. 
 // Valid formats are EvtFormatMessage*
 char* GetFormattedMessage(ULONGLONG hEventPublisher, ULONGLONG hEvent, int format);
 
+// Get the raw message template for the event with the given ID and version
+// from the publisher metadata. Insertion strings are left unexpanded.
+// Returned string must be freed by the caller.
+char* GetMessageTemplate(ULONGLONG hEventPublisher, ULONGLONG eventId, ULONGLONG version);
+
+// Get a message from the publisher's message table by message ID. Insertion
+// strings are left unexpanded. Returned string must be freed by the caller.
+char* GetMessageString(ULONGLONG hEventPublisher, ULONGLONG messageId);
+
 // Get the handle for the publisher, this must be closed by the caller.
 // Needed to format messages since schema is publisher-specific.
 ULONGLONG GetEventPublisherHandle(PVOID pRenderedValues);
 
+// Get the handle for the publisher, with messages in the given locale (LCID).
+// A locale of 0 uses the UI language of the calling thread.
+ULONGLONG GetEventPublisherHandleForLocale(PVOID pRenderedValues, DWORD locale);
+
+// Get the UI language of the calling thread, which is used for messages when
+// no locale is given.
+DWORD GetDefaultLocale();
+
 // Cast the ULONGLONG back to a pointer and close it
 int CloseEvtHandle(ULONGLONG hEvent);
 
@@ -60,12 +60,6 @@ type WinLogEventXml struct {
 	RenderingInfo RenderingInfoXml
 }
 
-func assertEqual(a, b interface{}, t *T) {
-	if a != b {
-		t.Fatalf("%v != %v", a, b)
-	}
-}
-
 func TestXmlRenderMatchesOurs(t *T) {
 	testEvent, err := getTestEventHandle()
 	if err != nil {
@@ -123,6 +117,28 @@ func TestXmlRenderMatchesOurs(t *T) {
 	assertEqual(event.SubscribedChannel, SUBSCRIBED_CHANNEL, t)
 }
 
+func TestConvertEventKeepsSubsecondTime(t *T) {
+	testEvent, err := getTestEventHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseEventHandle(uint64(testEvent))
+	logWatcher, err := NewWinLogWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logWatcher.Shutdown()
+	event, err := logWatcher.convertEvent(testEvent, SUBSCRIBED_CHANNEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The FileTime has 100ns precision, so whole seconds mean it was truncated
+	if event.Created.Nanosecond() == 0 {
+		t.Fatalf("Created time %v has no fraction of a second", event.Created)
+	}
+	assertEqual(event.Created.Nanosecond()%100, 0, t)
+}
+
 func BenchmarkXmlDecode(b *B) {
 	testEvent, err := getTestEventHandle()
 	if err != nil {
@@ -0,0 +1,92 @@
+package winlog
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// A single named value from the EventData or UserData section of an event
+type EventDataField struct {
+	Name  string
+	Value string
+}
+
+// The event's payload: its EventData if it was rendered, and otherwise parsed
+// from its Xml, as when the render profile has Xml but not EventData. Returns
+// nil if neither is available.
+func EventPayload(event *WinLogEvent) []EventDataField {
+	if event.EventData != nil || event.Xml == "" {
+		return event.EventData
+	}
+	fields, _ := ParseEventData(event.Xml)
+	return fields
+}
+
+// Extract the payload values from an event's XML, in document order. Values
+// come from the <Data> elements of EventData, or from the leaf elements of the
+// first UserData child. Data elements without a Name attribute have an empty
+// Name. Returns nil if the event has no payload.
+func ParseEventData(eventXml string) ([]EventDataField, error) {
+	decoder := xml.NewDecoder(strings.NewReader(eventXml))
+	var fields []EventDataField
+	// Element names from the root of the document to the current element
+	var path []string
+	var text strings.Builder
+	var dataName string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF && len(path) == 0 {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch elem := token.(type) {
+		case xml.StartElement:
+			path = append(path, elem.Name.Local)
+			text.Reset()
+			if isEventDataPath(path) {
+				dataName = ""
+				for _, attr := range elem.Attr {
+					if attr.Name.Local == "Name" {
+						dataName = attr.Value
+					}
+				}
+			}
+		case xml.CharData:
+			text.Write(elem)
+		case xml.EndElement:
+			if isEventDataPath(path) {
+				fields = append(fields, EventDataField{Name: dataName, Value: text.String()})
+			} else if isUserDataPath(path) {
+				fields = append(fields, EventDataField{Name: elem.Name.Local, Value: text.String()})
+			}
+			text.Reset()
+			path = path[:len(path)-1]
+			if len(path) == 0 {
+				return fields, nil
+			}
+		}
+	}
+}
+
+// Event/EventData/Data
+func isEventDataPath(path []string) bool {
+	return len(path) == 3 && path[1] == "EventData" && path[2] == "Data"
+}
+
+// Event/UserData/<schema element>/<field>
+func isUserDataPath(path []string) bool {
+	return len(path) == 4 && path[1] == "UserData"
+}
+
+// Get just the values of the event payload, in order. These are the
+// insertion strings for the event's message template.
+func eventDataValues(fields []EventDataField) []string {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = field.Value
+	}
+	return values
+}
@@ -0,0 +1,108 @@
+package winlog
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parts of the event XML schema that map onto WinLogEvent.
+// See https://msdn.microsoft.com/en-us/library/windows/desktop/aa385201(v=vs.85).aspx
+type eventXml struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		}
+		EventID struct {
+			Value      string `xml:",chardata"`
+			Qualifiers string `xml:"Qualifiers,attr"`
+		}
+		Version     string
+		Level       string
+		Task        string
+		Opcode      string
+		Keywords    string
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		}
+		EventRecordID string
+		Execution     struct {
+			ProcessID string `xml:"ProcessID,attr"`
+			ThreadID  string `xml:"ThreadID,attr"`
+		}
+		Channel  string
+		Computer string
+	}
+	RenderingInfo *struct {
+		Message  string
+		Level    string
+		Task     string
+		Opcode   string
+		Channel  string
+		Provider string
+		Keywords struct {
+			Keyword []string
+		}
+	}
+}
+
+// Parse an unsigned integer from the event XML, which may be decimal or
+// hex with a 0x prefix. Invalid or missing values are 0, matching convertEvent.
+func parseXmlUint(value string) uint64 {
+	number, _ := strconv.ParseUint(strings.TrimSpace(value), 0, 64)
+	return number
+}
+
+// Build a WinLogEvent from the XML representation of an event, as produced by
+// EvtRender, EVTX files or forwarded events. Localized fields are only set if
+// the XML contains a RenderingInfo section. Payload values can be extracted from
+// the Xml field with ParseEventData.
+func ParseEventXml(eventXmlString string) (*WinLogEvent, error) {
+	var parsed eventXml
+	if err := xml.Unmarshal([]byte(eventXmlString), &parsed); err != nil {
+		return nil, err
+	}
+	system := parsed.System
+	event := &WinLogEvent{
+		Xml:          eventXmlString,
+		ProviderName: system.Provider.Name,
+		EventId:      parseXmlUint(system.EventID.Value),
+		Qualifiers:   parseXmlUint(system.EventID.Qualifiers),
+		Level:        parseXmlUint(system.Level),
+		Task:         parseXmlUint(system.Task),
+		Opcode:       parseXmlUint(system.Opcode),
+		RecordId:     parseXmlUint(system.EventRecordID),
+		ProcessId:    parseXmlUint(system.Execution.ProcessID),
+		ThreadId:     parseXmlUint(system.Execution.ThreadID),
+		Channel:      system.Channel,
+		ComputerName: system.Computer,
+		Version:      parseXmlUint(system.Version),
+	}
+	if system.TimeCreated.SystemTime != "" {
+		created, err := time.Parse(time.RFC3339Nano, system.TimeCreated.SystemTime)
+		if err != nil {
+			return nil, err
+		}
+		event.Created = created
+	}
+	if info := parsed.RenderingInfo; info != nil {
+		event.Msg = info.Message
+		event.LevelText = info.Level
+		event.TaskText = info.Task
+		event.OpcodeText = info.Opcode
+		event.ChannelText = info.Channel
+		event.ProviderText = info.Provider
+		event.Keywords = info.Keywords.Keyword
+	}
+	return event, nil
+}
+
+// Get the keyword mask from the System element of the event XML.
+func parseXmlKeywords(eventXmlString string) (uint64, error) {
+	var parsed eventXml
+	if err := xml.Unmarshal([]byte(eventXmlString), &parsed); err != nil {
+		return 0, err
+	}
+	return parseXmlUint(parsed.System.Keywords), nil
+}
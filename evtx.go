@@ -0,0 +1,211 @@
+package winlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	evtxFileMagic      = "ElfFile\x00"
+	evtxChunkMagic     = "ElfChnk\x00"
+	evtxRecordMagic    = "\x2a\x2a\x00\x00"
+	evtxFileHeaderSize = 4096
+	evtxChunkSize      = 65536
+	evtxChunkHeader    = 512
+	// Magic, size, record ID and written time
+	evtxRecordHeader = 24
+)
+
+// A record read from an EVTX file
+type EvtxRecord struct {
+	RecordId uint64
+	// When the record was written to the log
+	Written time.Time
+	// The event rendered as XML, in the same form as EvtRender
+	Xml string
+}
+
+// Build a WinLogEvent from the record's XML, including the payload, with a
+// bookmark for the record. Localized fields are empty; use
+// ProviderCatalog.RenderEvent to fill them in.
+func (self *EvtxRecord) Event() (*WinLogEvent, error) {
+	event, err := ParseEventXml(self.Xml)
+	if err != nil {
+		return nil, err
+	}
+	event.EventData, _ = ParseEventData(self.Xml)
+	if event.RecordId == 0 {
+		event.RecordId = self.RecordId
+	}
+	event.Bookmark = FormatBookmarkXml(BookmarkPosition{Channel: event.Channel, RecordId: event.RecordId, IsCurrent: true})
+	return event, nil
+}
+
+// A corrupt chunk or record in an EVTX file. The reader skips what it
+// couldn't read and carries on with the next record or chunk.
+type EvtxError struct {
+	// Index of the chunk in the file
+	Chunk int
+	// The record, or 0 if the whole chunk was skipped
+	RecordId uint64
+	Err      error
+}
+
+func (self *EvtxError) Error() string {
+	if self.RecordId != 0 {
+		return fmt.Sprintf("Skipped record %v in chunk %v: %v", self.RecordId, self.Chunk, self.Err)
+	}
+	return fmt.Sprintf("Skipped chunk %v: %v", self.Chunk, self.Err)
+}
+
+// Reads the records of an EVTX file, on any OS
+type EvtxReader struct {
+	reader io.ReaderAt
+	closer io.Closer
+	// Offset of the first chunk
+	start  int64
+	chunks int
+	// The next chunk to read
+	nextChunk int
+
+	// The chunk being read
+	chunk      []byte
+	chunkIndex int
+	parser     *binxmlParser
+	offset     int
+	freeOffset int
+}
+
+// Open an EVTX file. Close the reader when done.
+func OpenEvtx(path string) (*EvtxReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := NewEvtxReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	reader.closer = file
+	return reader, nil
+}
+
+// Read EVTX data of the given size. Chunks are read whole, so the file can
+// have chunks beyond the count in its header, such as after a crash.
+func NewEvtxReader(reader io.ReaderAt, size int64) (*EvtxReader, error) {
+	header := make([]byte, 128)
+	if _, err := reader.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("Failed to read EVTX header: %v", err)
+	}
+	if string(header[:8]) != evtxFileMagic {
+		return nil, fmt.Errorf("Not an EVTX file")
+	}
+	start := int64(binary.LittleEndian.Uint16(header[40:]))
+	if start == 0 {
+		start = evtxFileHeaderSize
+	}
+	chunks := 0
+	if size > start {
+		chunks = int((size - start) / evtxChunkSize)
+	}
+	return &EvtxReader{reader: reader, start: start, chunks: chunks}, nil
+}
+
+// Get the next record, or io.EOF at the end of the file. Corrupt chunks and
+// records are returned as *EvtxError, after which Next can be called again.
+func (self *EvtxReader) Next() (*EvtxRecord, error) {
+	for {
+		if self.chunk == nil {
+			if self.nextChunk >= self.chunks {
+				return nil, io.EOF
+			}
+			index := self.nextChunk
+			self.nextChunk++
+			if err := self.readChunk(index); err != nil {
+				return nil, &EvtxError{Chunk: index, Err: err}
+			}
+			continue
+		}
+		record, err := self.nextRecord()
+		if err == io.EOF {
+			self.chunk = nil
+			continue
+		}
+		return record, err
+	}
+}
+
+// Read and check a chunk. Unused chunks are skipped without an error.
+func (self *EvtxReader) readChunk(index int) error {
+	chunk := make([]byte, evtxChunkSize)
+	if _, err := self.reader.ReadAt(chunk, self.start+int64(index)*evtxChunkSize); err != nil {
+		return err
+	}
+	if bytes.Equal(chunk[:8], make([]byte, 8)) {
+		return nil
+	}
+	if string(chunk[:8]) != evtxChunkMagic {
+		return fmt.Errorf("Invalid chunk signature %q", chunk[:8])
+	}
+	headerCrc := crc32.ChecksumIEEE(append(append([]byte{}, chunk[:120]...), chunk[128:evtxChunkHeader]...))
+	if headerCrc != binary.LittleEndian.Uint32(chunk[124:]) {
+		return fmt.Errorf("Chunk header checksum mismatch")
+	}
+	freeOffset := int(binary.LittleEndian.Uint32(chunk[48:]))
+	if freeOffset < evtxChunkHeader || freeOffset > evtxChunkSize {
+		return fmt.Errorf("Invalid free space offset %v", freeOffset)
+	}
+	if crc32.ChecksumIEEE(chunk[evtxChunkHeader:freeOffset]) != binary.LittleEndian.Uint32(chunk[52:]) {
+		return fmt.Errorf("Chunk records checksum mismatch")
+	}
+	self.chunk = chunk
+	self.chunkIndex = index
+	self.parser = newBinxmlParser(chunk)
+	self.offset = evtxChunkHeader
+	self.freeOffset = freeOffset
+	return nil
+}
+
+func (self *EvtxReader) nextRecord() (*EvtxRecord, error) {
+	offset := self.offset
+	if offset+evtxRecordHeader > self.freeOffset {
+		return nil, io.EOF
+	}
+	chunk := self.chunk
+	size := int(binary.LittleEndian.Uint32(chunk[offset+4:]))
+	if string(chunk[offset:offset+4]) != evtxRecordMagic || size < evtxRecordHeader+4 || offset+size > self.freeOffset {
+		// Without a valid size there's no way to find the next record
+		self.offset = self.freeOffset
+		return nil, &EvtxError{Chunk: self.chunkIndex, Err: fmt.Errorf("Invalid record header at offset %v", offset)}
+	}
+	self.offset = offset + size
+	record := &EvtxRecord{
+		RecordId: binary.LittleEndian.Uint64(chunk[offset+8:]),
+		Written:  fileTimeToTime(binary.LittleEndian.Uint64(chunk[offset+16:])),
+	}
+	xml, err := self.parser.parseRecord(offset+evtxRecordHeader, offset+size-4)
+	if err != nil {
+		return nil, &EvtxError{Chunk: self.chunkIndex, RecordId: record.RecordId, Err: err}
+	}
+	record.Xml = xml
+	return record, nil
+}
+
+// Close the file, if the reader was opened with OpenEvtx
+func (self *EvtxReader) Close() error {
+	if self.closer == nil {
+		return nil
+	}
+	return self.closer.Close()
+}
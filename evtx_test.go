@@ -0,0 +1,417 @@
+package winlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	. "testing"
+	"time"
+	"unicode/utf16"
+)
+
+// Writes a chunk of binary XML records, with names and template definitions
+// inline the first time they're used, as the event log does
+type evtxBuilder struct {
+	chunk     []byte
+	names     map[string]int
+	templates map[string]int
+}
+
+type evtxAttr struct {
+	name  string
+	value func()
+}
+
+type evtxValue struct {
+	valueType byte
+	write     func()
+}
+
+func newEvtxBuilder() *evtxBuilder {
+	return &evtxBuilder{
+		chunk:     make([]byte, evtxChunkHeader),
+		names:     make(map[string]int),
+		templates: make(map[string]int),
+	}
+}
+
+func (b *evtxBuilder) pos() int {
+	return len(b.chunk)
+}
+
+func (b *evtxBuilder) u8(v byte) {
+	b.chunk = append(b.chunk, v)
+}
+
+func (b *evtxBuilder) u16(v uint16) {
+	b.chunk = binary.LittleEndian.AppendUint16(b.chunk, v)
+}
+
+func (b *evtxBuilder) u32(v uint32) {
+	b.chunk = binary.LittleEndian.AppendUint32(b.chunk, v)
+}
+
+func (b *evtxBuilder) u64(v uint64) {
+	b.chunk = binary.LittleEndian.AppendUint64(b.chunk, v)
+}
+
+func (b *evtxBuilder) utf16(s string) {
+	for _, c := range utf16.Encode([]rune(s)) {
+		b.u16(c)
+	}
+}
+
+// Write a name reference and `extra` zero bytes, then the name if it's new
+func (b *evtxBuilder) name(name string, extra int) {
+	offset, known := b.names[name]
+	if !known {
+		offset = b.pos() + 4 + extra
+		b.names[name] = offset
+	}
+	b.u32(uint32(offset))
+	b.chunk = append(b.chunk, make([]byte, extra)...)
+	if !known {
+		b.u32(0)
+		b.u16(0)
+		b.u16(uint16(len(utf16.Encode([]rune(name)))))
+		b.utf16(name)
+		b.u16(0)
+	}
+}
+
+func (b *evtxBuilder) element(name string, attrs []evtxAttr, content func()) {
+	token := byte(binxmlOpenStartElement)
+	extra := 0
+	if len(attrs) > 0 {
+		token |= binxmlMoreFlag
+		extra = 4
+	}
+	b.u8(token)
+	b.u16(0xffff)
+	b.u32(0)
+	b.name(name, extra)
+	for i, attr := range attrs {
+		token := byte(binxmlAttribute)
+		if i < len(attrs)-1 {
+			token |= binxmlMoreFlag
+		}
+		b.u8(token)
+		b.name(attr.name, 0)
+		attr.value()
+	}
+	if content == nil {
+		b.u8(binxmlCloseEmptyElement)
+		return
+	}
+	b.u8(binxmlCloseStartElement)
+	content()
+	b.u8(binxmlEndElement)
+}
+
+func (b *evtxBuilder) text(s string) {
+	b.u8(binxmlValue)
+	b.u8(binxmlWStringType)
+	b.u16(uint16(len(utf16.Encode([]rune(s)))))
+	b.utf16(s)
+}
+
+func (b *evtxBuilder) sub(index int, optional bool) {
+	if optional {
+		b.u8(binxmlOptionalSubstitution)
+	} else {
+		b.u8(binxmlNormalSubstitution)
+	}
+	b.u16(uint16(index))
+	b.u8(0)
+}
+
+func (b *evtxBuilder) fragment(content func()) {
+	b.u8(binxmlFragmentHeader)
+	b.u8(1)
+	b.u8(1)
+	b.u8(0)
+	content()
+	b.u8(binxmlEndOfStream)
+}
+
+func (b *evtxBuilder) instance(template string, define func(), values ...evtxValue) {
+	b.u8(binxmlTemplateInstance)
+	b.u8(1)
+	b.u32(0)
+	offset, known := b.templates[template]
+	if !known {
+		offset = b.pos() + 4
+		b.templates[template] = offset
+	}
+	b.u32(uint32(offset))
+	if !known {
+		b.u32(0)
+		b.chunk = append(b.chunk, make([]byte, 16)...)
+		sizeAt := b.pos()
+		b.u32(0)
+		b.fragment(define)
+		binary.LittleEndian.PutUint32(b.chunk[sizeAt:], uint32(b.pos()-sizeAt-4))
+	}
+	b.u32(uint32(len(values)))
+	descriptors := b.pos()
+	for _, value := range values {
+		b.u16(0)
+		b.u8(value.valueType)
+		b.u8(0)
+	}
+	for i, value := range values {
+		start := b.pos()
+		value.write()
+		binary.LittleEndian.PutUint16(b.chunk[descriptors+i*4:], uint16(b.pos()-start))
+	}
+}
+
+func (b *evtxBuilder) record(recordId uint64, written uint64, content func()) {
+	start := b.pos()
+	b.chunk = append(b.chunk, evtxRecordMagic...)
+	b.u32(0)
+	b.u64(recordId)
+	b.u64(written)
+	b.fragment(content)
+	b.u32(0)
+	size := uint32(b.pos() - start)
+	binary.LittleEndian.PutUint32(b.chunk[start+4:], size)
+	binary.LittleEndian.PutUint32(b.chunk[b.pos()-4:], size)
+}
+
+func (b *evtxBuilder) finish() []byte {
+	chunk := make([]byte, evtxChunkSize)
+	copy(chunk, b.chunk)
+	copy(chunk, evtxChunkMagic)
+	binary.LittleEndian.PutUint32(chunk[40:], 128)
+	binary.LittleEndian.PutUint32(chunk[48:], uint32(len(b.chunk)))
+	binary.LittleEndian.PutUint32(chunk[52:], crc32.ChecksumIEEE(chunk[evtxChunkHeader:len(b.chunk)]))
+	headerCrc := crc32.ChecksumIEEE(append(append([]byte{}, chunk[:120]...), chunk[128:evtxChunkHeader]...))
+	binary.LittleEndian.PutUint32(chunk[124:], headerCrc)
+	return chunk
+}
+
+func evtxFile(chunks ...[]byte) []byte {
+	header := make([]byte, evtxFileHeaderSize)
+	copy(header, evtxFileMagic)
+	binary.LittleEndian.PutUint32(header[32:], 128)
+	binary.LittleEndian.PutUint16(header[38:], 3)
+	binary.LittleEndian.PutUint16(header[40:], evtxFileHeaderSize)
+	binary.LittleEndian.PutUint16(header[42:], uint16(len(chunks)))
+	return append(header, bytes.Join(chunks, nil)...)
+}
+
+func wstringValue(b *evtxBuilder, s string) evtxValue {
+	return evtxValue{binxmlWStringType, func() { b.utf16(s + "\x00") }}
+}
+
+func uintValue(b *evtxBuilder, valueType byte, v uint64) evtxValue {
+	return evtxValue{valueType, func() {
+		switch valueType {
+		case binxmlUInt8Type:
+			b.u8(byte(v))
+		case binxmlUInt16Type:
+			b.u16(uint16(v))
+		case binxmlUInt32Type:
+			b.u32(uint32(v))
+		default:
+			b.u64(v)
+		}
+	}}
+}
+
+func bytesValue(b *evtxBuilder, valueType byte, data []byte) evtxValue {
+	return evtxValue{valueType, func() { b.chunk = append(b.chunk, data...) }}
+}
+
+var nullValue = evtxValue{binxmlNullType, func() {}}
+
+// 2020-01-02T03:04:05.1234567Z
+const testFileTime = 132224078451234567
+
+// Write a Security 4624 or 4625 event
+func writeLogonRecord(b *evtxBuilder, recordId, eventId uint64, user string, threadId, sid evtxValue) {
+	system := func() {
+		b.element("Event", []evtxAttr{{"xmlns", func() { b.text("http://schemas.microsoft.com/win/2004/08/events/event") }}}, func() {
+			b.element("System", nil, func() {
+				b.element("Provider", []evtxAttr{{"Name", func() { b.sub(0, false) }}}, nil)
+				b.element("EventID", nil, func() { b.sub(1, false) })
+				b.element("Level", nil, func() { b.sub(2, false) })
+				b.element("Keywords", nil, func() { b.sub(3, false) })
+				b.element("TimeCreated", []evtxAttr{{"SystemTime", func() { b.sub(4, false) }}}, nil)
+				b.element("EventRecordID", nil, func() { b.sub(5, false) })
+				b.element("Execution", []evtxAttr{
+					{"ProcessID", func() { b.sub(6, false) }},
+					{"ThreadID", func() { b.sub(7, true) }},
+				}, nil)
+				b.element("Channel", nil, func() { b.sub(8, false) })
+				b.element("Computer", nil, func() { b.sub(9, false) })
+				b.element("Security", []evtxAttr{{"UserID", func() { b.sub(10, true) }}}, nil)
+			})
+			b.sub(11, true)
+		})
+	}
+	data := func(name string, content func()) {
+		b.element("Data", []evtxAttr{{"Name", func() { b.text(name) }}}, content)
+	}
+	eventData := func() {
+		b.element("EventData", nil, func() {
+			data("TargetUserName", func() { b.sub(0, false) })
+			data("LogonType", func() { b.sub(1, false) })
+			data("LogonGuid", func() { b.sub(2, false) })
+			data("Note", func() {
+				b.text("a<b")
+				b.u8(binxmlCharRef)
+				b.u16(65)
+			})
+			data("Ports", func() { b.sub(3, false) })
+		})
+	}
+	guid := []byte{4, 3, 2, 1, 6, 5, 8, 7, 9, 10, 11, 12, 13, 14, 15, 16}
+	b.record(recordId, testFileTime, func() {
+		b.instance("system", system,
+			wstringValue(b, "Microsoft-Windows-Security-Auditing"),
+			uintValue(b, binxmlUInt16Type, eventId),
+			uintValue(b, binxmlUInt8Type, 0),
+			uintValue(b, binxmlHexInt64Type, 0x8020000000000000),
+			uintValue(b, binxmlFileTimeType, testFileTime),
+			uintValue(b, binxmlUInt64Type, recordId),
+			uintValue(b, binxmlUInt32Type, 560),
+			threadId,
+			wstringValue(b, "Security"),
+			wstringValue(b, "dc01.example.com"),
+			sid,
+			evtxValue{binxmlBinXmlType, func() {
+				b.fragment(func() {
+					b.instance("logon", eventData,
+						wstringValue(b, user),
+						uintValue(b, binxmlUInt32Type, 10),
+						bytesValue(b, binxmlGuidType, guid),
+						bytesValue(b, binxmlUInt16Type|binxmlArrayFlag, []byte{80, 0, 0xbb, 1}),
+					)
+				})
+			}},
+		)
+	})
+}
+
+func testEvtxChunk(firstRecord uint64) []byte {
+	b := newEvtxBuilder()
+	writeLogonRecord(b, firstRecord, 4624, "alice", nullValue, bytesValue(b, binxmlSidType, []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}))
+	writeLogonRecord(b, firstRecord+1, 4625, "bob", uintValue(b, binxmlUInt32Type, 8), nullValue)
+	return b.finish()
+}
+
+func readEvtx(data []byte, t *T) ([]*EvtxRecord, []error) {
+	reader, err := NewEvtxReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []*EvtxRecord
+	var errs []error
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, errs
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		records = append(records, record)
+	}
+}
+
+func TestEvtxRecordXml(t *T) {
+	records, errs := readEvtx(evtxFile(testEvtxChunk(100)), t)
+	assertEqual(len(errs), 0, t)
+	assertEqual(len(records), 2, t)
+	expected := "<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System>" +
+		"<Provider Name='Microsoft-Windows-Security-Auditing'/><EventID>4624</EventID><Level>0</Level>" +
+		"<Keywords>0x8020000000000000</Keywords><TimeCreated SystemTime='2020-01-02T03:04:05.123456700Z'/>" +
+		"<EventRecordID>100</EventRecordID><Execution ProcessID='560'/><Channel>Security</Channel>" +
+		"<Computer>dc01.example.com</Computer><Security UserID='S-1-5-18'/></System>" +
+		"<EventData><Data Name='TargetUserName'>alice</Data><Data Name='LogonType'>10</Data>" +
+		"<Data Name='LogonGuid'>{01020304-0506-0708-090A-0B0C0D0E0F10}</Data><Data Name='Note'>a&lt;b&#65;</Data>" +
+		"<Data Name='Ports'>80,443</Data></EventData></Event>"
+	assertEqual(records[0].Xml, expected, t)
+	assertEqual(records[0].RecordId, uint64(100), t)
+	assertEqual(records[0].Written.Format(time.RFC3339Nano), "2020-01-02T03:04:05.1234567Z", t)
+}
+
+func TestEvtxRecordEvent(t *T) {
+	records, _ := readEvtx(evtxFile(testEvtxChunk(100)), t)
+	// The second record re-uses the names and templates of the first
+	event, err := records[1].Event()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(event.EventId, uint64(4625), t)
+	assertEqual(event.RecordId, uint64(101), t)
+	assertEqual(event.ThreadId, uint64(8), t)
+	assertEqual(event.Channel, "Security", t)
+	assertEqual(event.ComputerName, "dc01.example.com", t)
+	assertEqual(event.Created.Format(time.RFC3339Nano), "2020-01-02T03:04:05.1234567Z", t)
+	assertEqual(len(event.EventData), 5, t)
+	assertEqual(event.EventData[0], EventDataField{"TargetUserName", "bob"}, t)
+	assertEqual(event.EventData[3], EventDataField{"Note", "a<bA"}, t)
+	assertEqual(event.Bookmark, "<BookmarkList>\r\n  <Bookmark Channel='Security' RecordId='101' IsCurrent='true'/>\r\n</BookmarkList>", t)
+}
+
+func TestEvtxCorruptChunk(t *T) {
+	corrupt := testEvtxChunk(200)
+	corrupt[600] ^= 0xff
+	unused := make([]byte, evtxChunkSize)
+	records, errs := readEvtx(evtxFile(testEvtxChunk(100), unused, corrupt, testEvtxChunk(300)), t)
+	assertEqual(len(records), 4, t)
+	assertEqual(records[2].RecordId, uint64(300), t)
+	assertEqual(len(errs), 1, t)
+	evtxErr, ok := errs[0].(*EvtxError)
+	if !ok {
+		t.Fatalf("Expected an EvtxError, got %v", errs[0])
+	}
+	assertEqual(evtxErr.Chunk, 2, t)
+	assertEqual(evtxErr.Error(), "Skipped chunk 2: Chunk records checksum mismatch", t)
+}
+
+func TestEvtxCorruptRecord(t *T) {
+	b := newEvtxBuilder()
+	writeLogonRecord(b, 100, 4624, "alice", nullValue, nullValue)
+	start := b.pos()
+	writeLogonRecord(b, 101, 4624, "bob", nullValue, nullValue)
+	// Point the second record's template at garbage
+	binary.LittleEndian.PutUint32(b.chunk[start+evtxRecordHeader+10:], 0xfff0)
+	writeLogonRecord(b, 102, 4624, "carol", nullValue, nullValue)
+	records, errs := readEvtx(evtxFile(b.finish()), t)
+	assertEqual(len(records), 2, t)
+	assertEqual(records[1].RecordId, uint64(102), t)
+	assertEqual(len(errs), 1, t)
+	assertEqual(errs[0].(*EvtxError).RecordId, uint64(101), t)
+}
+
+func TestOpenEvtx(t *T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Security.evtx")
+	if err := os.WriteFile(path, evtxFile(testEvtxChunk(100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := OpenEvtx(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(record.RecordId, uint64(100), t)
+
+	notEvtx := filepath.Join(dir, "events.xml")
+	os.WriteFile(notEvtx, []byte("<Events/>"), 0644)
+	if _, err := OpenEvtx(notEvtx); err == nil {
+		t.Fatal("Expected an error opening a file which isn't EVTX")
+	}
+}
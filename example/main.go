@@ -1,3 +1,5 @@
+// +build windows
+
 package main
 
 import (
@@ -11,7 +13,7 @@ func main() {
 		fmt.Printf("Couldn't create watcher: %v\n", err)
 		return
 	}
-	err = watcher.SubscribeFromBeginning("Application")
+	err = watcher.SubscribeFromBeginning("Application", "*")
 	if err != nil {
 		fmt.Printf("Couldn't subscribe to Application: %v", err)
 	}
@@ -19,8 +21,7 @@ func main() {
 		select {
 		case evt := <-watcher.Event():
 			fmt.Printf("Event: %v\n", evt)
-			bookmark := evt.GetBookmark()
-			fmt.Printf("Bookmark: %v\n", bookmark)
+			fmt.Printf("Bookmark: %v\n", evt.Bookmark)
 		case err := <-watcher.Error():
 			fmt.Printf("Error: %v\n\n", err)
 		}
@@ -0,0 +1,94 @@
+// Package gelf sends events to Graylog as GELF 1.1 messages, over UDP with
+// chunking and compression, or over TCP with null-byte delimiters.
+package gelf
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scalingdata/gowinlog"
+	"github.com/scalingdata/gowinlog/syslog"
+)
+
+// Additional field names allowed by GELF
+var fieldNamePattern = regexp.MustCompile(`[^\w.\-]`)
+
+// Replace characters GELF doesn't allow in additional field names with '_'
+func fieldName(name string) string {
+	return "_" + fieldNamePattern.ReplaceAllString(name, "_")
+}
+
+// Encode the event as a GELF 1.1 JSON message. short_message is the first line
+// of the message, and full_message the whole message if it has more than one.
+// The level is the syslog severity of the event Level. System properties are
+// additional fields such as _event_id, _channel and _record_id, and payload
+// fields are added by name, with unnamed fields as _param1, _param2... A
+// payload field whose name is already used gets an _event_data_ prefix.
+// defaultHost is used for events without a ComputerName.
+func Encode(event *winlog.WinLogEvent, defaultHost string) ([]byte, error) {
+	host := event.ComputerName
+	if host == "" {
+		host = defaultHost
+	}
+	message := strings.TrimSpace(event.Msg)
+	short := message
+	if newline := strings.IndexAny(message, "\r\n"); newline >= 0 {
+		short = strings.TrimSpace(message[:newline])
+	}
+	if short == "" {
+		// short_message is required
+		short = event.ProviderName + " " + strconv.FormatUint(event.EventId, 10)
+	}
+
+	fields := map[string]interface{}{
+		"version":        "1.1",
+		"host":           host,
+		"short_message":  short,
+		"level":          syslog.Severity(event.Level),
+		"_event_id":      event.EventId,
+		"_channel":       event.Channel,
+		"_record_id":     event.RecordId,
+		"_provider_name": event.ProviderName,
+		"_task":          event.Task,
+		"_opcode":        event.Opcode,
+	}
+	if short != message && message != "" {
+		fields["full_message"] = message
+	}
+	if !event.Created.IsZero() {
+		micros := event.Created.UnixNano() / 1000
+		fields["timestamp"] = json.Number(fmt.Sprintf("%d.%06d", micros/1e6, micros%1e6))
+	}
+	if event.ProcessId != 0 {
+		fields["_process_id"] = event.ProcessId
+		fields["_thread_id"] = event.ThreadId
+	}
+	if event.LevelText != "" {
+		fields["_level_text"] = event.LevelText
+	}
+	if event.TaskText != "" {
+		fields["_task_text"] = event.TaskText
+	}
+	if len(event.Keywords) > 0 {
+		fields["_keywords"] = strings.Join(event.Keywords, ",")
+	}
+	for i, field := range event.EventData {
+		name := field.Name
+		if name == "" {
+			name = "param" + strconv.Itoa(i+1)
+		}
+		key := fieldName(name)
+		// "_id" is reserved by Graylog
+		if _, ok := fields[key]; ok || key == "_id" {
+			key = fieldName("event_data_" + name)
+		}
+		if _, ok := fields[key]; ok {
+			continue
+		}
+		fields[key] = field.Value
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,253 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func testEvent() *winlog.WinLogEvent {
+	return &winlog.WinLogEvent{
+		ProviderName: "Microsoft-Windows-Security-Auditing",
+		EventId:      4624,
+		Level:        0,
+		Task:         12544,
+		Created:      time.Date(2016, 1, 19, 19, 37, 48, 123456789, time.UTC),
+		RecordId:     10811,
+		ProcessId:    560,
+		ThreadId:     4884,
+		Channel:      "Security",
+		ComputerName: "WIN-HOST",
+		Msg:          "An account was successfully logged on.\r\n\r\nSubject:\r\n",
+		Keywords:     []string{"Audit Success"},
+		EventData: []winlog.EventDataField{
+			{Name: "TargetUserName", Value: "alice"},
+			{Name: "", Value: "unnamed"},
+			{Name: "channel", Value: "payload"},
+			{Name: "id", Value: "reserved"},
+			{Name: "Bad Name!", Value: "x"},
+		},
+		Bookmark:          "<BookmarkList/>",
+		SubscribedChannel: "Security",
+	}
+}
+
+func decode(message []byte, t *T) map[string]interface{} {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestEncode(t *T) {
+	message, err := Encode(testEvent(), "fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := decode(message, t)
+	assertEqual(fields["version"], "1.1", t)
+	assertEqual(fields["host"], "WIN-HOST", t)
+	assertEqual(fields["short_message"], "An account was successfully logged on.", t)
+	assertEqual(fields["full_message"], "An account was successfully logged on.\r\n\r\nSubject:", t)
+	assertEqual(fields["timestamp"], json.Number("1453232268.123456"), t)
+	assertEqual(fields["level"], json.Number("6"), t)
+	assertEqual(fields["_event_id"], json.Number("4624"), t)
+	assertEqual(fields["_channel"], "Security", t)
+	assertEqual(fields["_record_id"], json.Number("10811"), t)
+	assertEqual(fields["_keywords"], "Audit Success", t)
+	assertEqual(fields["_TargetUserName"], "alice", t)
+	assertEqual(fields["_param2"], "unnamed", t)
+	assertEqual(fields["_event_data_channel"], "payload", t)
+	assertEqual(fields["_event_data_id"], "reserved", t)
+	assertEqual(fields["_Bad_Name_"], "x", t)
+	if _, ok := fields["_id"]; ok {
+		t.Fatal("Reserved _id field was set")
+	}
+}
+
+func TestEncodeWithoutMessage(t *T) {
+	event := &winlog.WinLogEvent{ProviderName: "Application Error", EventId: 1000, Level: 2}
+	message, err := Encode(event, "fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := decode(message, t)
+	assertEqual(fields["host"], "fallback", t)
+	assertEqual(fields["short_message"], "Application Error 1000", t)
+	assertEqual(fields["level"], json.Number("3"), t)
+	if _, ok := fields["full_message"]; ok {
+		t.Fatal("full_message was set without a message")
+	}
+}
+
+func TestChunk(t *T) {
+	message := bytes.Repeat([]byte("x"), 25)
+	chunks, err := chunk(message, 22)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(chunks), 3, t)
+	for i, c := range chunks {
+		assertEqual(c[0], byte(0x1e), t)
+		assertEqual(c[1], byte(0x0f), t)
+		assertEqual(string(c[2:10]), string(chunks[0][2:10]), t)
+		assertEqual(c[10], byte(i), t)
+		assertEqual(c[11], byte(3), t)
+	}
+	assertEqual(len(chunks[2]), 12+5, t)
+
+	if _, err := chunk(bytes.Repeat([]byte("x"), 129), 13); err == nil {
+		t.Fatal("No error for a message with too many chunks")
+	}
+}
+
+func TestSinkTCP(t *T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			message, err := reader.ReadString(0)
+			if err != nil {
+				return
+			}
+			received <- strings.TrimSuffix(message, "\x00")
+		}
+	}()
+
+	store := winlog.NewMemoryBookmarkStore()
+	sink, err := NewSink(Config{Network: "tcp", Address: listener.Addr().String(), Bookmarks: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for i := 0; i < 2; i++ {
+		if err := sink.Write(testEvent()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case message := <-received:
+			assertEqual(decode([]byte(message), t)["_channel"], "Security", t)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a message")
+		}
+	}
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "<BookmarkList/>", t)
+}
+
+// Read datagrams until a whole message has arrived, and return it
+func receiveUDP(conn net.PacketConn, t *T) []byte {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var parts [][]byte
+	for {
+		buf := make([]byte, 65536)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		datagram := buf[:n]
+		if datagram[0] != 0x1e || datagram[1] != 0x0f {
+			return datagram
+		}
+		if parts == nil {
+			parts = make([][]byte, datagram[11])
+		}
+		parts[datagram[10]] = datagram[12:]
+		complete := true
+		for _, part := range parts {
+			complete = complete && part != nil
+		}
+		if complete {
+			return bytes.Join(parts, nil)
+		}
+	}
+}
+
+func TestSinkUDPChunkedGzip(t *T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sink, err := NewSink(Config{Network: "udp", Address: conn.LocalAddr().String(), ChunkSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(receiveUDP(conn, t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(decode(message, t)["_TargetUserName"], "alice", t)
+}
+
+func TestSinkUDPZlib(t *T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sink, err := NewSink(Config{Network: "udp", Address: conn.LocalAddr().String(), Compression: CompressionZlib})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(receiveUDP(conn, t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(decode(message, t)["_record_id"], json.Number("10811"), t)
+}
+
+func TestNewSinkValidation(t *T) {
+	if _, err := NewSink(Config{Network: "tls", Address: "x"}); err == nil {
+		t.Fatal("No error for an unsupported network")
+	}
+	if _, err := NewSink(Config{Network: "udp", Address: "x", ChunkSize: 12}); err == nil {
+		t.Fatal("No error for a chunk size without room for data")
+	}
+}
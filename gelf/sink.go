@@ -0,0 +1,209 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+	"github.com/scalingdata/gowinlog/internal/netconn"
+)
+
+// Compression for UDP messages. Graylog doesn't accept compressed TCP messages.
+type Compression int
+
+const (
+	CompressionGzip Compression = iota
+	CompressionZlib
+	CompressionNone
+)
+
+const (
+	// Chunk size that fits in a typical Ethernet MTU
+	DefaultChunkSize = 1420
+	// Graylog drops messages with more chunks than this
+	maxChunks = 128
+	// Magic bytes, message ID, sequence number and count
+	chunkHeaderSize = 12
+)
+
+// Settings for a Sink. Only Network and Address are required.
+type Config struct {
+	// "udp" or "tcp"
+	Network string
+	// Graylog input address as host:port
+	Address string
+
+	// UDP compression, gzip by default
+	Compression Compression
+	// Largest UDP datagram, including the chunk header. Larger messages are
+	// split into chunks. DefaultChunkSize by default.
+	ChunkSize int
+	// Host for events without a ComputerName, from os.Hostname by default
+	Host string
+
+	// Bookmarks are saved here after each successful write, if it's set
+	Bookmarks winlog.BookmarkStore
+
+	// Timeouts for connecting and for each write, 10 seconds by default
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Attempts to reconnect and resend a message before Write fails, 3 by
+	// default. Negative values retry forever, until the sink is closed.
+	Retries int
+	// Delay before the first reconnect, doubling up to MaxReconnectDelay.
+	// 500ms and 30 seconds by default.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
+
+// Writes events to a Graylog GELF input
+type Sink struct {
+	config Config
+	writer *netconn.Writer
+}
+
+func NewSink(config Config) (*Sink, error) {
+	if config.Network != "udp" && config.Network != "tcp" {
+		return nil, fmt.Errorf("Unsupported GELF network %q", config.Network)
+	}
+	if config.Address == "" {
+		return nil, fmt.Errorf("No GELF server address")
+	}
+	if config.Compression < CompressionGzip || config.Compression > CompressionNone {
+		return nil, fmt.Errorf("Unknown GELF compression %v", config.Compression)
+	}
+	if config.ChunkSize == 0 {
+		config.ChunkSize = DefaultChunkSize
+	}
+	if config.ChunkSize <= chunkHeaderSize {
+		return nil, fmt.Errorf("GELF chunk size %v is too small", config.ChunkSize)
+	}
+	if config.Host == "" {
+		config.Host, _ = os.Hostname()
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 10 * time.Second
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = 10 * time.Second
+	}
+	if config.Retries == 0 {
+		config.Retries = 3
+	}
+	if config.ReconnectDelay == 0 {
+		config.ReconnectDelay = 500 * time.Millisecond
+	}
+	if config.MaxReconnectDelay == 0 {
+		config.MaxReconnectDelay = 30 * time.Second
+	}
+	return &Sink{
+		config: config,
+		writer: netconn.NewWriter(netconn.Config{
+			Network:           config.Network,
+			Address:           config.Address,
+			DialTimeout:       config.DialTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			Retries:           config.Retries,
+			ReconnectDelay:    config.ReconnectDelay,
+			MaxReconnectDelay: config.MaxReconnectDelay,
+		}),
+	}, nil
+}
+
+// Write events from the channel, such as WinLogWatcher.Event(), until it's
+// closed. Returns the first error from Write.
+func (self *Sink) Run(events <-chan *winlog.WinLogEvent) error {
+	for event := range events {
+		if err := self.Write(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send one event, reconnecting and retrying as configured. The event's
+// bookmark is saved only once the message has been written.
+func (self *Sink) Write(event *winlog.WinLogEvent) error {
+	message, err := Encode(event, self.config.Host)
+	if err != nil {
+		return err
+	}
+	var datagrams [][]byte
+	if self.config.Network == "tcp" {
+		datagrams = [][]byte{append(message, 0)}
+	} else {
+		compressed, err := compress(message, self.config.Compression)
+		if err != nil {
+			return err
+		}
+		datagrams, err = chunk(compressed, self.config.ChunkSize)
+		if err != nil {
+			return err
+		}
+	}
+	if err := self.writer.Write(datagrams...); err != nil {
+		return err
+	}
+	return winlog.CommitBookmark(self.config.Bookmarks, event)
+}
+
+// Close the connection. Writes in progress stop retrying and fail.
+func (self *Sink) Close() error {
+	return self.writer.Close()
+}
+
+func compress(message []byte, compression Compression) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch compression {
+	case CompressionGzip:
+		writer := gzip.NewWriter(&buf)
+		if _, err = writer.Write(message); err == nil {
+			err = writer.Close()
+		}
+	case CompressionZlib:
+		writer := zlib.NewWriter(&buf)
+		if _, err = writer.Write(message); err == nil {
+			err = writer.Close()
+		}
+	default:
+		return message, nil
+	}
+	return buf.Bytes(), err
+}
+
+// Split a message into GELF chunks of at most chunkSize bytes, or return it
+// whole if it fits in one datagram.
+func chunk(message []byte, chunkSize int) ([][]byte, error) {
+	if len(message) <= chunkSize {
+		return [][]byte{message}, nil
+	}
+	dataSize := chunkSize - chunkHeaderSize
+	count := (len(message) + dataSize - 1) / dataSize
+	if count > maxChunks {
+		return nil, fmt.Errorf("GELF message of %v bytes needs %v chunks, more than the limit of %v", len(message), count, maxChunks)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(message) {
+			end = len(message)
+		}
+		chunk := make([]byte, 0, chunkHeaderSize+end-i*dataSize)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, message[i*dataSize:end]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,49 @@
+package httpsink
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Encodes a batch of events as a request body
+type Formatter interface {
+	ContentType() string
+	Format(events []*winlog.WinLogEvent) ([]byte, error)
+}
+
+var (
+	// A JSON array of events, in the WinLogEvent JSON encoding
+	JSON Formatter = jsonFormatter{}
+	// One JSON event per line
+	NDJSON Formatter = ndjsonFormatter{}
+)
+
+type jsonFormatter struct{}
+
+func (jsonFormatter) ContentType() string {
+	return "application/json"
+}
+
+func (jsonFormatter) Format(events []*winlog.WinLogEvent) ([]byte, error) {
+	return json.Marshal(events)
+}
+
+type ndjsonFormatter struct{}
+
+func (ndjsonFormatter) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (ndjsonFormatter) Format(events []*winlog.WinLogEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,161 @@
+package httpsink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	queueSuffix    = ".batch"
+	rejectedSuffix = ".rejected"
+)
+
+// An encoded request, with the bookmarks to save once it has been accepted
+type batch struct {
+	ContentType     string            `json:"content_type"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Bookmarks       []channelBookmark `json:"bookmarks,omitempty"`
+	body            []byte
+}
+
+type channelBookmark struct {
+	Channel  string `json:"channel"`
+	Bookmark string `json:"bookmark"`
+}
+
+// Batches that couldn't be sent, as files in a directory named by sequence
+// number. Each file is a line of JSON metadata followed by the body.
+type retryQueue struct {
+	dir      string
+	maxBytes int64
+	files    []string
+	size     int64
+	next     uint64
+}
+
+// Open the queue in dir, creating it if needed, and load the names of the
+// batches left by a previous run.
+func openRetryQueue(dir string, maxBytes int64) (*retryQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	queue := &retryQueue{dir: dir, maxBytes: maxBytes}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, queueSuffix) {
+			continue
+		}
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(name, queueSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		queue.files = append(queue.files, name)
+		queue.size += info.Size()
+		if sequence >= queue.next {
+			queue.next = sequence + 1
+		}
+	}
+	// Names are zero-padded, so they sort in sequence order
+	sort.Strings(queue.files)
+	return queue, nil
+}
+
+func (self *retryQueue) Len() int {
+	return len(self.files)
+}
+
+// Write the batch to the end of the queue
+func (self *retryQueue) Push(b *batch) error {
+	metadata, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	size := int64(len(metadata) + 1 + len(b.body))
+	if self.maxBytes > 0 && self.size+size > self.maxBytes {
+		return fmt.Errorf("Retry queue in %v is full (%v bytes)", self.dir, self.size)
+	}
+	name := fmt.Sprintf("%020d%s", self.next, queueSuffix)
+	contents := append(append(metadata, '\n'), b.body...)
+	temp := filepath.Join(self.dir, name+".tmp")
+	if err := os.WriteFile(temp, contents, 0644); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, filepath.Join(self.dir, name)); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	self.next++
+	self.files = append(self.files, name)
+	self.size += size
+	return nil
+}
+
+// Read the batch at the front of the queue
+func (self *retryQueue) Peek() (*batch, error) {
+	return self.read(self.files[0])
+}
+
+func (self *retryQueue) read(name string) (*batch, error) {
+	contents, err := os.ReadFile(filepath.Join(self.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(bytes.NewReader(contents))
+	metadata, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("Invalid retry queue file %v: %v", name, err)
+	}
+	b := &batch{}
+	if err := json.Unmarshal(metadata, b); err != nil {
+		return nil, fmt.Errorf("Invalid retry queue file %v: %v", name, err)
+	}
+	b.body = contents[len(metadata):]
+	return b, nil
+}
+
+// Remove the batch at the front of the queue once it's been sent
+func (self *retryQueue) Pop() error {
+	path := filepath.Join(self.dir, self.files[0])
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	self.size -= info.Size()
+	self.files = self.files[1:]
+	return nil
+}
+
+// Move the batch at the front of the queue aside, keeping it for inspection,
+// when the server won't ever accept it.
+func (self *retryQueue) Reject() error {
+	path := filepath.Join(self.dir, self.files[0])
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path, strings.TrimSuffix(path, queueSuffix)+rejectedSuffix); err != nil {
+		return err
+	}
+	self.size -= info.Size()
+	self.files = self.files[1:]
+	return nil
+}
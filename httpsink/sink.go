@@ -0,0 +1,422 @@
+// Package httpsink POSTs batches of events to an HTTP(S) endpoint, saving
+// bookmarks only once the server has accepted them.
+package httpsink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Settings for a Sink. Only URL is required.
+type Config struct {
+	URL string
+	// Body encoding, NDJSON by default
+	Formatter Formatter
+	// Compress request bodies with gzip
+	Gzip bool
+	// Extra request headers
+	Headers map[string]string
+	// Sent as "Authorization: Bearer <Token>", if it's set
+	Token     string
+	TLSConfig *tls.Config
+	// Timeout for each request, 30 seconds by default
+	Timeout time.Duration
+
+	// Run sends a batch when it has BatchSize events, or FlushInterval after
+	// the first event in the batch. 500 events and 1 second by default.
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// Attempts to resend a batch after a retryable failure (a network error,
+	// 408, 429 or 5xx), 5 by default. Negative values retry forever, until
+	// the sink is closed; to move on from a failing batch instead, set a
+	// QueueDir.
+	Retries int
+	// Delay before the first retry, doubling up to MaxRetryDelay, with random
+	// jitter of up to half the delay either way. A Retry-After header from the
+	// server is used instead when there is one. 1 and 60 seconds by default.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// Directory for batches that still fail after all retries, if it's set.
+	// Queued batches are resent, oldest first, before each new batch, and
+	// new batches are queued behind them while the server is failing, so
+	// delivery continues across outages and restarts. Without a queue, Export
+	// returns the error instead.
+	QueueDir string
+	// Largest total size of the queue, 100MB by default. Export fails once
+	// it's full.
+	MaxQueueBytes int64
+
+	// Bookmarks are saved here once batches are accepted, if it's set. While
+	// batches are queued, bookmarks are held back until the queue is empty.
+	Bookmarks winlog.BookmarkStore
+}
+
+// Sends batches of events to an HTTP(S) endpoint
+type Sink struct {
+	config Config
+	client *http.Client
+	queue  *retryQueue
+	// The latest bookmarks of batches which have been sent or queued, saved
+	// once the queue is empty
+	pending map[string]string
+	order   []string
+
+	closed    chan interface{}
+	closeOnce sync.Once
+	mutex     sync.Mutex
+}
+
+func NewSink(config Config) (*Sink, error) {
+	endpoint, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL: %v", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported URL scheme %q", endpoint.Scheme)
+	}
+	if config.Formatter == nil {
+		config.Formatter = NDJSON
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Retries == 0 {
+		config.Retries = 5
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = time.Second
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = time.Minute
+	}
+	if config.MaxQueueBytes <= 0 {
+		config.MaxQueueBytes = 100 * 1024 * 1024
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.TLSConfig
+	sink := &Sink{
+		config:  config,
+		client:  &http.Client{Transport: transport, Timeout: config.Timeout},
+		pending: make(map[string]string),
+		closed:  make(chan interface{}),
+	}
+	if config.QueueDir != "" {
+		if sink.queue, err = openRetryQueue(config.QueueDir, config.MaxQueueBytes); err != nil {
+			return nil, err
+		}
+		// Hold back the bookmarks of batches queued by a previous run
+		for _, name := range sink.queue.files {
+			if b, err := sink.queue.read(name); err == nil {
+				sink.hold(b.Bookmarks)
+			}
+		}
+	}
+	return sink, nil
+}
+
+// Queued batches waiting to be resent
+func (self *Sink) Queued() int {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.queue == nil {
+		return 0
+	}
+	return self.queue.Len()
+}
+
+// Send events from the channel, such as WinLogWatcher.Event(), in batches
+// until it's closed. Returns the first error from Export.
+func (self *Sink) Run(events <-chan *winlog.WinLogEvent) error {
+	var batch []*winlog.WinLogEvent
+	var flush <-chan time.Time
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				if len(batch) > 0 {
+					return self.Export(batch)
+				}
+				return nil
+			}
+			batch = append(batch, event)
+			if len(batch) == 1 {
+				timer = time.NewTimer(self.config.FlushInterval)
+				flush = timer.C
+			}
+			if len(batch) < self.config.BatchSize {
+				continue
+			}
+			timer.Stop()
+		case <-flush:
+		case <-self.closed:
+			return fmt.Errorf("HTTP sink is closed")
+		}
+		flush = nil
+		if err := self.Export(batch); err != nil {
+			return err
+		}
+		batch = nil
+	}
+}
+
+// Send the events as one request, after any queued batches. If it still
+// fails after retrying, the batch is queued when there's a QueueDir.
+func (self *Sink) Export(events []*winlog.WinLogEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	b, err := self.encode(events)
+	if err != nil {
+		return err
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	rejectErr := self.drainQueue()
+	if self.queue != nil && self.queue.Len() > 0 {
+		// The server is still failing, so don't try the new batch yet
+		if err := self.queue.Push(b); err != nil {
+			return err
+		}
+		self.hold(b.Bookmarks)
+		return rejectErr
+	}
+
+	err = self.sendWithRetries(b)
+	if err == nil {
+		self.hold(b.Bookmarks)
+		if err := self.commitBookmarks(); err != nil {
+			return err
+		}
+		return rejectErr
+	}
+	if sendErr, ok := err.(*sendError); ok && sendErr.retryable && self.queue != nil {
+		if err := self.queue.Push(b); err != nil {
+			return err
+		}
+		self.hold(b.Bookmarks)
+		return rejectErr
+	}
+	return err
+}
+
+// Resend queued batches, oldest first, until one fails with a retryable error.
+// Batches the server rejects are moved aside, and an error is returned for them.
+func (self *Sink) drainQueue() error {
+	if self.queue == nil {
+		return nil
+	}
+	var rejectErr error
+	for self.queue.Len() > 0 {
+		b, err := self.queue.Peek()
+		if err == nil {
+			err = self.send(b)
+			if err == nil {
+				if err := self.queue.Pop(); err != nil {
+					return err
+				}
+				continue
+			}
+			if sendErr, ok := err.(*sendError); ok && sendErr.retryable {
+				return rejectErr
+			}
+		}
+		rejectErr = fmt.Errorf("Queued batch %v was rejected: %v", self.queue.files[0], err)
+		if err := self.queue.Reject(); err != nil {
+			return err
+		}
+	}
+	if err := self.commitBookmarks(); err != nil {
+		return err
+	}
+	return rejectErr
+}
+
+// Remember the bookmarks of a batch that's been sent or queued
+func (self *Sink) hold(bookmarks []channelBookmark) {
+	for _, bookmark := range bookmarks {
+		if _, ok := self.pending[bookmark.Channel]; !ok {
+			self.order = append(self.order, bookmark.Channel)
+		}
+		self.pending[bookmark.Channel] = bookmark.Bookmark
+	}
+}
+
+// Save the held bookmarks, if nothing is queued
+func (self *Sink) commitBookmarks() error {
+	if self.queue != nil && self.queue.Len() > 0 {
+		return nil
+	}
+	if self.config.Bookmarks != nil {
+		for _, channel := range self.order {
+			if err := self.config.Bookmarks.Save(channel, self.pending[channel]); err != nil {
+				return err
+			}
+		}
+	}
+	self.pending = make(map[string]string)
+	self.order = nil
+	return nil
+}
+
+func (self *Sink) encode(events []*winlog.WinLogEvent) (*batch, error) {
+	body, err := self.config.Formatter.Format(events)
+	if err != nil {
+		return nil, err
+	}
+	b := &batch{ContentType: self.config.Formatter.ContentType(), body: body}
+	if self.config.Gzip {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(body); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		b.ContentEncoding = "gzip"
+		b.body = buf.Bytes()
+	}
+	// The last bookmark for each channel
+	index := make(map[string]int)
+	for _, event := range events {
+		if event.Bookmark == "" {
+			continue
+		}
+		if i, ok := index[event.SubscribedChannel]; ok {
+			b.Bookmarks[i].Bookmark = event.Bookmark
+			continue
+		}
+		index[event.SubscribedChannel] = len(b.Bookmarks)
+		b.Bookmarks = append(b.Bookmarks, channelBookmark{event.SubscribedChannel, event.Bookmark})
+	}
+	return b, nil
+}
+
+// A failed request, with whether it can be resent
+type sendError struct {
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+func (self *sendError) Error() string {
+	return self.err.Error()
+}
+
+func (self *Sink) sendWithRetries(b *batch) error {
+	delay := self.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := self.send(b)
+		if err == nil {
+			return nil
+		}
+		sendErr, ok := err.(*sendError)
+		if !ok || !sendErr.retryable || (self.config.Retries >= 0 && attempt >= self.config.Retries) {
+			return err
+		}
+		wait := sendErr.retryAfter
+		if wait == 0 {
+			// Jitter of up to half the delay either way
+			wait = delay/2 + time.Duration(rand.Int63n(int64(delay)+1))
+		}
+		delay *= 2
+		if delay > self.config.MaxRetryDelay {
+			delay = self.config.MaxRetryDelay
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-self.closed:
+			timer.Stop()
+			return fmt.Errorf("HTTP sink is closed: %v", err)
+		}
+	}
+}
+
+func (self *Sink) send(b *batch) error {
+	request, err := http.NewRequest("POST", self.config.URL, bytes.NewReader(b.body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", b.ContentType)
+	if b.ContentEncoding != "" {
+		request.Header.Set("Content-Encoding", b.ContentEncoding)
+	}
+	if self.config.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+self.config.Token)
+	}
+	for name, value := range self.config.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := self.client.Do(request)
+	if err != nil {
+		return &sendError{err: err, retryable: true}
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	sendErr := &sendError{
+		err: fmt.Errorf("Server returned %v: %s", response.Status, bytes.TrimSpace(body)),
+	}
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests, response.StatusCode == http.StatusRequestTimeout:
+		sendErr.retryable = true
+	case response.StatusCode >= 500:
+		sendErr.retryable = true
+	}
+	if sendErr.retryable {
+		sendErr.retryAfter = parseRetryAfter(response.Header.Get("Retry-After"), self.config.MaxRetryDelay)
+	}
+	return sendErr
+}
+
+// Parse Retry-After as seconds or an HTTP date, capped at max
+func parseRetryAfter(value string, max time.Duration) time.Duration {
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = time.Until(date)
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
+
+// Stop retrying and fail any export in progress. Queued batches stay on disk
+// for the next run.
+func (self *Sink) Close() error {
+	self.closeOnce.Do(func() {
+		close(self.closed)
+	})
+	self.client.CloseIdleConnections()
+	return nil
+}
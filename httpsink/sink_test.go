@@ -0,0 +1,331 @@
+package httpsink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func testEvents(first uint64, count int) []*winlog.WinLogEvent {
+	var events []*winlog.WinLogEvent
+	for i := 0; i < count; i++ {
+		record := first + uint64(i)
+		events = append(events, &winlog.WinLogEvent{
+			ProviderName:      "Microsoft-Windows-Security-Auditing",
+			EventId:           4624,
+			RecordId:          record,
+			Channel:           "Security",
+			Bookmark:          "bookmark " + strconv.FormatUint(record, 10),
+			SubscribedChannel: "Security",
+		})
+	}
+	return events
+}
+
+// An HTTP server which responds with the next status from a list, then 200,
+// and records the record IDs in each accepted request
+type testServer struct {
+	*httptest.Server
+	statuses []int
+	accepted [][]uint64
+	requests int
+	mutex    sync.Mutex
+}
+
+func newTestServer(t *T, statuses ...int) *testServer {
+	server := &testServer{statuses: statuses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.requests++
+		if len(server.statuses) > 0 {
+			status := server.statuses[0]
+			server.statuses = server.statuses[1:]
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0")
+			}
+			if status != http.StatusOK {
+				http.Error(w, "failed", status)
+				return
+			}
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body = reader
+		}
+		var records []uint64
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			var event winlog.WinLogEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Error(err)
+				return
+			}
+			records = append(records, event.RecordId)
+		}
+		server.accepted = append(server.accepted, records)
+	}))
+	return server
+}
+
+func (self *testServer) setStatuses(statuses ...int) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.statuses = statuses
+}
+
+func (self *testServer) acceptedRecords() [][]uint64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.accepted
+}
+
+func TestRunGzipWithHeaders(t *T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, _ := io.ReadAll(reader)
+		var events []winlog.WinLogEvent
+		if err := json.Unmarshal(body, &events); err != nil {
+			t.Error(err)
+		}
+		assertEqual(len(events), 3, t)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	store := winlog.NewMemoryBookmarkStore()
+	sink, err := NewSink(Config{
+		URL:       server.URL,
+		Formatter: JSON,
+		Gzip:      true,
+		Token:     "secret",
+		Headers:   map[string]string{"X-Source": "test"},
+		Bookmarks: store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	events := make(chan *winlog.WinLogEvent, 3)
+	for _, event := range testEvents(1, 3) {
+		events <- event
+	}
+	close(events)
+	if err := sink.Run(events); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(header.Get("Content-Type"), "application/json", t)
+	assertEqual(header.Get("Content-Encoding"), "gzip", t)
+	assertEqual(header.Get("Authorization"), "Bearer secret", t)
+	assertEqual(header.Get("X-Source"), "test", t)
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "bookmark 3", t)
+}
+
+func TestRetriesAfter429(t *T) {
+	server := newTestServer(t, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	defer server.Close()
+	store := winlog.NewMemoryBookmarkStore()
+	sink, err := NewSink(Config{URL: server.URL, Bookmarks: store, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Export(testEvents(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(server.requests, 3, t)
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "bookmark 2", t)
+}
+
+func TestFailureKeepsBookmark(t *T) {
+	server := newTestServer(t, 500, 500, 500)
+	defer server.Close()
+	store := winlog.NewMemoryBookmarkStore()
+	sink, err := NewSink(Config{URL: server.URL, Bookmarks: store, Retries: 2, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Export(testEvents(1, 2)); err == nil {
+		t.Fatal("No error after retries were exhausted")
+	}
+	assertEqual(server.requests, 3, t)
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "", t)
+
+	// 4xx responses other than 408 and 429 aren't retried
+	server.setStatuses(http.StatusBadRequest)
+	if err := sink.Export(testEvents(1, 2)); err == nil {
+		t.Fatal("No error for a rejected batch")
+	}
+	assertEqual(server.requests, 4, t)
+}
+
+func TestNegativeRetriesRetryForever(t *T) {
+	server := newTestServer(t, 503, 503, 503, 503, 503, 503, 503, 503)
+	defer server.Close()
+	store := winlog.NewMemoryBookmarkStore()
+	sink, err := NewSink(Config{URL: server.URL, Bookmarks: store, Retries: -1, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Export(testEvents(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(server.requests, 9, t)
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "bookmark 1", t)
+}
+
+func TestRetryQueue(t *T) {
+	server := newTestServer(t, 503, 503, 503)
+	defer server.Close()
+	dir := t.TempDir()
+	store := winlog.NewMemoryBookmarkStore()
+	sink, err := NewSink(Config{URL: server.URL, Bookmarks: store, Retries: 1, RetryDelay: time.Millisecond, QueueDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	// The first batch fails after a retry and is queued. The second isn't
+	// tried, because the queued batch still fails.
+	if err := sink.Export(testEvents(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Export(testEvents(3, 2)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(sink.Queued(), 2, t)
+	assertEqual(server.requests, 3, t)
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "", t)
+
+	// Once the server recovers, queued batches are sent first
+	if err := sink.Export(testEvents(5, 1)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(sink.Queued(), 0, t)
+	accepted := server.acceptedRecords()
+	assertEqual(len(accepted), 3, t)
+	assertEqual(accepted[0][0], uint64(1), t)
+	assertEqual(accepted[1][0], uint64(3), t)
+	assertEqual(accepted[2][0], uint64(5), t)
+	bookmark, _ = store.Load("Security")
+	assertEqual(bookmark, "bookmark 5", t)
+}
+
+func TestRetryQueueSurvivesRestart(t *T) {
+	server := newTestServer(t, 503, 503)
+	defer server.Close()
+	dir := t.TempDir()
+	sink, err := NewSink(Config{URL: server.URL, Retries: 1, RetryDelay: time.Millisecond, QueueDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Export(testEvents(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	store := winlog.NewMemoryBookmarkStore()
+	sink, err = NewSink(Config{URL: server.URL, Bookmarks: store, Retries: 1, RetryDelay: time.Millisecond, QueueDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	assertEqual(sink.Queued(), 1, t)
+	if err := sink.Export(testEvents(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	accepted := server.acceptedRecords()
+	assertEqual(len(accepted), 2, t)
+	assertEqual(accepted[0][0], uint64(1), t)
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "bookmark 2", t)
+}
+
+func TestRejectedQueuedBatch(t *T) {
+	server := newTestServer(t, 503, 503, http.StatusBadRequest)
+	defer server.Close()
+	dir := t.TempDir()
+	sink, err := NewSink(Config{URL: server.URL, Retries: 1, RetryDelay: time.Millisecond, QueueDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Export(testEvents(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// The queued batch is moved aside, and the new one is still sent
+	if err := sink.Export(testEvents(2, 1)); err == nil {
+		t.Fatal("No error for a rejected queued batch")
+	}
+	assertEqual(sink.Queued(), 0, t)
+	assertEqual(server.acceptedRecords()[0][0], uint64(2), t)
+	rejected, _ := filepath.Glob(filepath.Join(dir, "*"+rejectedSuffix))
+	assertEqual(len(rejected), 1, t)
+}
+
+func TestRetryQueueLimit(t *T) {
+	server := newTestServer(t, 503, 503)
+	defer server.Close()
+	sink, err := NewSink(Config{URL: server.URL, Retries: 1, RetryDelay: time.Millisecond, QueueDir: t.TempDir(), MaxQueueBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Export(testEvents(1, 1)); err == nil {
+		t.Fatal("No error when the queue is full")
+	}
+}
+
+func TestQueueIgnoresOtherFiles(t *T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "00000000000000000007.batch"), []byte("{\"content_type\":\"text/plain\"}\nbody"), 0644)
+	queue, err := openRetryQueue(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(queue.Len(), 1, t)
+	assertEqual(queue.next, uint64(8), t)
+	b, err := queue.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(b.ContentType, "text/plain", t)
+	assertEqual(string(b.body), "body", t)
+}
@@ -0,0 +1,168 @@
+// Package netconn has the reconnecting connection shared by the stream sinks.
+package netconn
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Settings for a Writer. Zero durations and retries must be filled in by the
+// caller; the sinks document their own defaults.
+type Config struct {
+	// "udp", "tcp" or "tls"
+	Network   string
+	Address   string
+	TLSConfig *tls.Config
+
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Attempts to reconnect and resend after a failed write. Negative values
+	// retry until the writer is closed.
+	Retries           int
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
+
+// Writes messages to a server, connecting on the first write and
+// reconnecting with backoff when a write fails.
+type Writer struct {
+	config Config
+
+	conn net.Conn
+	// Closed when the server closes a stream connection
+	connClosed chan interface{}
+	delay      time.Duration
+
+	closed    chan interface{}
+	closeOnce sync.Once
+	mutex     sync.Mutex
+}
+
+func NewWriter(config Config) *Writer {
+	return &Writer{
+		config: config,
+		delay:  config.ReconnectDelay,
+		closed: make(chan interface{}),
+	}
+}
+
+// Write each message in order, as one datagram each for UDP. If a write
+// fails, the connection is reopened and the remaining messages resent, up to
+// the configured number of retries.
+func (self *Writer) Write(messages ...[]byte) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	var err error
+	for attempt := 0; self.config.Retries < 0 || attempt <= self.config.Retries; attempt++ {
+		if attempt > 0 && !self.backoff() {
+			break
+		}
+		for len(messages) > 0 {
+			if err = self.write(messages[0]); err != nil {
+				break
+			}
+			messages = messages[1:]
+		}
+		if err == nil {
+			self.delay = self.config.ReconnectDelay
+			return nil
+		}
+		self.disconnect()
+	}
+	select {
+	case <-self.closed:
+		return fmt.Errorf("Connection to %v is closed", self.config.Address)
+	default:
+	}
+	return fmt.Errorf("Failed to write to %v: %v", self.config.Address, err)
+}
+
+// Wait before reconnecting. Returns false if the writer was closed.
+func (self *Writer) backoff() bool {
+	timer := time.NewTimer(self.delay)
+	defer timer.Stop()
+	self.delay *= 2
+	if self.delay > self.config.MaxReconnectDelay {
+		self.delay = self.config.MaxReconnectDelay
+	}
+	select {
+	case <-timer.C:
+		return true
+	case <-self.closed:
+		return false
+	}
+}
+
+func (self *Writer) write(message []byte) error {
+	select {
+	case <-self.closed:
+		return fmt.Errorf("Connection to %v is closed", self.config.Address)
+	default:
+	}
+	if self.conn != nil && self.connClosed != nil {
+		select {
+		case <-self.connClosed:
+			self.disconnect()
+		default:
+		}
+	}
+	if self.conn == nil {
+		if err := self.connect(); err != nil {
+			return err
+		}
+	}
+	self.conn.SetWriteDeadline(time.Now().Add(self.config.WriteTimeout))
+	_, err := self.conn.Write(message)
+	return err
+}
+
+func (self *Writer) connect() error {
+	dialer := &net.Dialer{Timeout: self.config.DialTimeout}
+	var conn net.Conn
+	var err error
+	if self.config.Network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", self.config.Address, self.config.TLSConfig)
+	} else {
+		conn, err = dialer.Dial(self.config.Network, self.config.Address)
+	}
+	if err != nil {
+		return err
+	}
+	self.conn = conn
+	self.connClosed = nil
+	if self.config.Network != "udp" {
+		// Servers don't send anything, so a read only returns when the
+		// connection is closed. Noticing that before the next write avoids
+		// losing a message on a half-closed connection.
+		connClosed := make(chan interface{})
+		go func() {
+			io.Copy(io.Discard, conn)
+			close(connClosed)
+		}()
+		self.connClosed = connClosed
+	}
+	return nil
+}
+
+func (self *Writer) disconnect() {
+	if self.conn != nil {
+		self.conn.Close()
+		self.conn = nil
+		self.connClosed = nil
+	}
+}
+
+// Close the connection. Writes in progress stop retrying and fail.
+func (self *Writer) Close() error {
+	self.closeOnce.Do(func() {
+		close(self.closed)
+	})
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.disconnect()
+	return nil
+}
@@ -0,0 +1,504 @@
+// Package xpath evaluates the XPath queries used to filter Windows events.
+package xpath
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A compiled XPath filter in the subset of XPath 1.0 used by Windows event
+// log queries, such as
+//
+//	*[System[Provider[@Name='Microsoft-Windows-Security-Auditing'] and (EventID=4624 or EventID=4625)]]
+//	*[EventData[Data[@Name='LogonType']='10']]
+//	*[System[band(Keywords,4503599627370496) and TimeCreated[timediff(@SystemTime) <= 86400000]]]
+//
+// Supported are element and @attribute steps separated by '/', predicates in
+// brackets, and, or, not(), parentheses, the comparisons = != < <= > >= against
+// string and number literals, and the band() and timediff() functions.
+type XPath struct {
+	expr string
+	root xpathExpr
+}
+
+func Compile(expr string) (*XPath, error) {
+	parser := &xpathParser{tokens: tokenizeXPath(expr)}
+	root, err := parser.parseQuery()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid XPath %q: %v", expr, err)
+	}
+	return &XPath{expr: expr, root: root}, nil
+}
+
+func (self *XPath) String() string {
+	return self.expr
+}
+
+// Whether the event XML matches the filter. Invalid XML never matches.
+func (self *XPath) Match(eventXml string) bool {
+	root, err := ParseTree(eventXml)
+	if err != nil {
+		return false
+	}
+	return self.MatchTree(root)
+}
+
+// Whether an event parsed with ParseTree matches the filter, so that one
+// parse can be matched against several filters.
+func (self *XPath) MatchTree(root *Node) bool {
+	// The query's '*' step selects the Event element from the document
+	return self.root.eval(&Node{children: []*Node{root}})
+}
+
+// An element of the event XML, with local names
+type Node struct {
+	name     string
+	attrs    map[string]string
+	children []*Node
+	text     strings.Builder
+}
+
+// Parse event XML for MatchTree
+func ParseTree(document string) (*Node, error) {
+	decoder := xml.NewDecoder(strings.NewReader(document))
+	var stack []*Node
+	var root *Node
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if root != nil && len(stack) == 0 {
+				return root, nil
+			}
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &Node{name: t.Name.Local, attrs: make(map[string]string, len(t.Attr))}
+			for _, attr := range t.Attr {
+				node.attrs[attr.Name.Local] = attr.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root == nil {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+}
+
+// Expressions are evaluated with an element as the context
+type xpathExpr interface {
+	eval(context *Node) bool
+}
+
+type andExpr struct{ left, right xpathExpr }
+type orExpr struct{ left, right xpathExpr }
+type notExpr struct{ expr xpathExpr }
+
+func (self andExpr) eval(context *Node) bool {
+	return self.left.eval(context) && self.right.eval(context)
+}
+
+func (self orExpr) eval(context *Node) bool {
+	return self.left.eval(context) || self.right.eval(context)
+}
+
+func (self notExpr) eval(context *Node) bool {
+	return !self.expr.eval(context)
+}
+
+// A path from the context, with an optional predicate on the selected
+// elements and an optional comparison of their values. True if any selected
+// node passes.
+type pathExpr struct {
+	steps     []string
+	attribute string
+	predicate xpathExpr
+	compare   *comparison
+}
+
+type comparison struct {
+	op      string
+	literal string
+	number  float64
+	numeric bool
+}
+
+// A function whose numeric result is compared, or tested for being non-zero
+type funcExpr struct {
+	name    string
+	path    *pathExpr
+	operand uint64
+	compare *comparison
+}
+
+// Select the values of the path's nodes: element text, or attribute values
+func (self *pathExpr) selectNodes(context *Node) ([]*Node, []string) {
+	nodes := []*Node{context}
+	for _, step := range self.steps {
+		var next []*Node
+		for _, node := range nodes {
+			for _, child := range node.children {
+				if step == "*" || child.name == step {
+					next = append(next, child)
+				}
+			}
+		}
+		nodes = next
+	}
+	if self.predicate != nil {
+		var kept []*Node
+		for _, node := range nodes {
+			if self.predicate.eval(node) {
+				kept = append(kept, node)
+			}
+		}
+		nodes = kept
+	}
+	values := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if self.attribute != "" {
+			if value, ok := node.attrs[self.attribute]; ok {
+				values = append(values, value)
+			}
+		} else {
+			values = append(values, strings.TrimSpace(node.text.String()))
+		}
+	}
+	return nodes, values
+}
+
+func (self *pathExpr) eval(context *Node) bool {
+	_, values := self.selectNodes(context)
+	if self.compare == nil {
+		return len(values) > 0
+	}
+	for _, value := range values {
+		if self.compare.test(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *comparison) test(value string) bool {
+	if self.numeric {
+		number, ok := parseXPathNumber(value)
+		if !ok {
+			return self.op == "!="
+		}
+		switch self.op {
+		case "=":
+			return number == self.number
+		case "!=":
+			return number != self.number
+		case "<":
+			return number < self.number
+		case "<=":
+			return number <= self.number
+		case ">":
+			return number > self.number
+		case ">=":
+			return number >= self.number
+		}
+		return false
+	}
+	switch self.op {
+	case "=":
+		return value == self.literal
+	case "!=":
+		return value != self.literal
+	case "<":
+		return value < self.literal
+	case "<=":
+		return value <= self.literal
+	case ">":
+		return value > self.literal
+	case ">=":
+		return value >= self.literal
+	}
+	return false
+}
+
+func parseXPathNumber(value string) (float64, bool) {
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		number, err := strconv.ParseUint(value[2:], 16, 64)
+		return float64(number), err == nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	return number, err == nil
+}
+
+func (self *funcExpr) eval(context *Node) bool {
+	_, values := self.path.selectNodes(context)
+	for _, value := range values {
+		var result float64
+		switch self.name {
+		case "band":
+			number, err := strconv.ParseUint(value, 0, 64)
+			if err != nil {
+				continue
+			}
+			result = float64(number & self.operand)
+		case "timediff":
+			created, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				continue
+			}
+			result = float64(time.Since(created) / time.Millisecond)
+		}
+		if self.compare == nil {
+			if result != 0 {
+				return true
+			}
+		} else if self.compare.test(strconv.FormatFloat(result, 'f', -1, 64)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Split an expression into names, literals and operators
+func tokenizeXPath(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				tokens = append(tokens, expr[i:])
+				return tokens
+			}
+			tokens = append(tokens, expr[i:i+end+2])
+			i += end + 2
+		case strings.HasPrefix(expr[i:], "!=") || strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case strings.IndexByte("[]()/@=<>,*", c) >= 0:
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		default:
+			start := i
+			for i < len(expr) && (unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i])) ||
+				strings.IndexByte("_-.:", expr[i]) >= 0 || expr[i] >= 0x80) {
+				i++
+			}
+			if i == start {
+				// An unexpected character, which the parser rejects
+				i++
+			}
+			tokens = append(tokens, expr[start:i])
+		}
+	}
+	return tokens
+}
+
+type xpathParser struct {
+	tokens []string
+	pos    int
+}
+
+func (self *xpathParser) peek() string {
+	if self.pos < len(self.tokens) {
+		return self.tokens[self.pos]
+	}
+	return ""
+}
+
+func (self *xpathParser) next() string {
+	token := self.peek()
+	self.pos++
+	return token
+}
+
+func (self *xpathParser) expect(token string) error {
+	if next := self.next(); next != token {
+		return fmt.Errorf("Expected %q, got %q", token, next)
+	}
+	return nil
+}
+
+// query := ('*' | 'Event') '[' expr ']'
+func (self *xpathParser) parseQuery() (xpathExpr, error) {
+	path, err := self.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	if len(path.steps) != 1 || (path.steps[0] != "*" && path.steps[0] != "Event") || path.attribute != "" {
+		return nil, fmt.Errorf("Query must start with * or Event")
+	}
+	if self.pos != len(self.tokens) {
+		return nil, fmt.Errorf("Unexpected %q", self.peek())
+	}
+	return path, nil
+}
+
+func (self *xpathParser) parseOr() (xpathExpr, error) {
+	left, err := self.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for self.peek() == "or" {
+		self.next()
+		right, err := self.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (self *xpathParser) parseAnd() (xpathExpr, error) {
+	left, err := self.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for self.peek() == "and" {
+		self.next()
+		right, err := self.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (self *xpathParser) parseUnary() (xpathExpr, error) {
+	switch self.peek() {
+	case "(":
+		self.next()
+		expr, err := self.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, self.expect(")")
+	case "not":
+		self.next()
+		if err := self.expect("("); err != nil {
+			return nil, err
+		}
+		expr, err := self.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, self.expect(")")
+	case "band", "timediff":
+		return self.parseFunc()
+	}
+	return self.parsePath()
+}
+
+// band(path, number) or timediff(path), with an optional comparison
+func (self *xpathParser) parseFunc() (xpathExpr, error) {
+	function := &funcExpr{name: self.next()}
+	if err := self.expect("("); err != nil {
+		return nil, err
+	}
+	path, err := self.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	function.path = path
+	if function.name == "band" {
+		if err := self.expect(","); err != nil {
+			return nil, err
+		}
+		operand, err := strconv.ParseUint(self.next(), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid band operand: %v", err)
+		}
+		function.operand = operand
+	}
+	if err := self.expect(")"); err != nil {
+		return nil, err
+	}
+	function.compare, err = self.parseComparison()
+	return function, err
+}
+
+// path := step ('/' step)* ('[' expr ']')? comparison?
+// step := name | '*' | '@' name
+func (self *xpathParser) parsePath() (*pathExpr, error) {
+	path := &pathExpr{}
+	for {
+		token := self.next()
+		if token == "@" {
+			path.attribute = self.next()
+			if !isXPathName(path.attribute) {
+				return nil, fmt.Errorf("Expected an attribute name, got %q", path.attribute)
+			}
+			break
+		}
+		if token != "*" && !isXPathName(token) {
+			return nil, fmt.Errorf("Expected an element name, got %q", token)
+		}
+		path.steps = append(path.steps, token)
+		if self.peek() != "/" {
+			break
+		}
+		self.next()
+	}
+	if self.peek() == "[" {
+		if path.attribute != "" {
+			return nil, fmt.Errorf("Attributes can't have predicates")
+		}
+		self.next()
+		predicate, err := self.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := self.expect("]"); err != nil {
+			return nil, err
+		}
+		path.predicate = predicate
+	}
+	var err error
+	path.compare, err = self.parseComparison()
+	return path, err
+}
+
+func (self *xpathParser) parseComparison() (*comparison, error) {
+	switch self.peek() {
+	case "=", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, nil
+	}
+	compare := &comparison{op: self.next()}
+	literal := self.next()
+	if len(literal) >= 2 && (literal[0] == '\'' || literal[0] == '"') && literal[len(literal)-1] == literal[0] {
+		compare.literal = literal[1 : len(literal)-1]
+		return compare, nil
+	}
+	number, ok := parseXPathNumber(literal)
+	if !ok {
+		return nil, fmt.Errorf("Expected a string or number, got %q", literal)
+	}
+	compare.number = number
+	compare.numeric = true
+	return compare, nil
+}
+
+func isXPathName(token string) bool {
+	if token == "" || token == "and" || token == "or" {
+		return false
+	}
+	c := rune(token[0])
+	return unicode.IsLetter(c) || c == '_' || c >= 0x80
+}
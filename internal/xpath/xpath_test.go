@@ -0,0 +1,92 @@
+package xpath
+
+import (
+	"fmt"
+	. "testing"
+	"time"
+)
+
+const logonXml = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'>
+<System>
+<Provider Name='Microsoft-Windows-Security-Auditing' Guid='{54849625-5478-4994-A5BA-3E3B0328C30D}'/>
+<EventID>4624</EventID>
+<Level>0</Level>
+<Keywords>0x8020000000000000</Keywords>
+<TimeCreated SystemTime='%s'/>
+<Channel>Security</Channel>
+</System>
+<EventData>
+<Data Name='TargetUserName'>alice</Data>
+<Data Name='LogonType'>10</Data>
+</EventData>
+</Event>`
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func testXml(created time.Time) string {
+	return fmt.Sprintf(logonXml, created.UTC().Format(time.RFC3339Nano))
+}
+
+func TestXPathMatch(t *T) {
+	event := testXml(time.Now().Add(-time.Hour))
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{"*", true},
+		{"Event[System[Level=0]]", true},
+		{"*[System[EventID=4624]]", true},
+		{"*[System[EventID=4625]]", false},
+		{"*[System[Provider[@Name='Microsoft-Windows-Security-Auditing'] and (EventID=4624 or EventID=4625)]]", true},
+		{"*[System[Provider[@Name='Microsoft-Windows-Sysmon'] and (EventID=4624 or EventID=4625)]]", false},
+		{"*[System[EventID!=4625]]", true},
+		{"*[System[EventID>=4624 and EventID<4625]]", true},
+		{"*[System[not(Level=2)]]", true},
+		{"*[EventData[Data[@Name='LogonType']='10']]", true},
+		{"*[EventData[Data[@Name='LogonType']='2']]", false},
+		{"*[EventData[Data='alice']]", true},
+		{"*[System[band(Keywords,9007199254740992)]]", true},
+		{"*[System[band(Keywords,4503599627370496)]]", false},
+		{"*[System[TimeCreated[timediff(@SystemTime) <= 86400000]]]", true},
+		{"*[System[TimeCreated[timediff(@SystemTime) <= 60000]]]", false},
+		{"*[System[Channel='Security'] and EventData[Data[@Name='TargetUserName']='alice']]", true},
+		{"*[Missing]", false},
+	}
+	for _, c := range cases {
+		xpath, err := Compile(c.expr)
+		if err != nil {
+			t.Fatalf("%v: %v", c.expr, err)
+		}
+		if xpath.Match(event) != c.match {
+			t.Fatalf("%v should match: %v", c.expr, c.match)
+		}
+	}
+}
+
+func TestXPathInvalidXml(t *T) {
+	xpath, err := Compile("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(xpath.Match("<Event><System>"), false, t)
+}
+
+func TestXPathInvalid(t *T) {
+	for _, expr := range []string{
+		"",
+		"*[",
+		"*[System[EventID=]]",
+		"*[System[EventID=4624]",
+		"*[System[unknown(EventID)]]",
+		"*[System[EventID='4624]]",
+		"*]",
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Fatalf("%q should be invalid", expr)
+		}
+	}
+}
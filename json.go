@@ -0,0 +1,240 @@
+package winlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Version of the JSON encoding of WinLogEvent, which is included in every
+// encoded event as "schema_version". Fields may be added without changing the
+// version; renaming or removing fields, or changing their types, requires a new
+// one. The schema is described in schema/winlogevent.v1.json.
+const EventJSONSchemaVersion = 1
+
+// The JSON encoding of WinLogEvent
+type eventJSON struct {
+	SchemaVersion int `json:"schema_version"`
+
+	ProviderName      string `json:"provider_name"`
+	EventId           uint64 `json:"event_id"`
+	Qualifiers        uint64 `json:"qualifiers"`
+	Level             uint64 `json:"level"`
+	Task              uint64 `json:"task"`
+	Opcode            uint64 `json:"opcode"`
+	Created           string `json:"created,omitempty"`
+	RecordId          uint64 `json:"record_id"`
+	ProcessId         uint64 `json:"process_id"`
+	ThreadId          uint64 `json:"thread_id"`
+	Channel           string `json:"channel"`
+	ComputerName      string `json:"computer_name"`
+	Version           uint64 `json:"version"`
+	RenderedFieldsErr string `json:"rendered_fields_error,omitempty"`
+
+	Msg                string   `json:"message,omitempty"`
+	LevelText          string   `json:"level_text,omitempty"`
+	TaskText           string   `json:"task_text,omitempty"`
+	OpcodeText         string   `json:"opcode_text,omitempty"`
+	Keywords           []string `json:"keywords,omitempty"`
+	ChannelText        string   `json:"channel_text,omitempty"`
+	ProviderText       string   `json:"provider_text,omitempty"`
+	IdText             string   `json:"id_text,omitempty"`
+	PublisherHandleErr string   `json:"publisher_handle_error,omitempty"`
+	EnglishMsg         string   `json:"english_message,omitempty"`
+	Locale             uint32   `json:"locale,omitempty"`
+
+	Xml    string `json:"xml,omitempty"`
+	XmlErr string `json:"xml_error,omitempty"`
+
+	EventData eventDataJSON `json:"event_data,omitempty"`
+
+	Bookmark          string `json:"bookmark,omitempty"`
+	SubscribedChannel string `json:"subscribed_channel"`
+}
+
+// Event payload fields, encoded as a JSON object in their original order.
+// Unnamed fields are keyed by position as param1, param2..., and repeated
+// names get a numeric suffix, so every key is unique.
+type eventDataJSON []EventDataField
+
+func (self eventDataJSON) MarshalJSON() ([]byte, error) {
+	if self == nil {
+		return []byte("null"), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	// Every key used so far, and the last suffix tried for each name
+	seen := make(map[string]bool)
+	suffixes := make(map[string]int)
+	for i, field := range self {
+		name := field.Name
+		if name == "" {
+			name = "param" + strconv.Itoa(i+1)
+		}
+		if seen[name] {
+			// The suffixed name may itself be a field, such as a, a and a_2
+			base, suffix := name, suffixes[name]
+			if suffix == 0 {
+				suffix = 1
+			}
+			for seen[name] {
+				suffix++
+				name = base + "_" + strconv.Itoa(suffix)
+			}
+			suffixes[base] = suffix
+		}
+		seen[name] = true
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		value, _ := json.Marshal(field.Value)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (self *eventDataJSON) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		*self = nil
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("Expected event_data to be an object, got %v", token)
+	}
+	fields := eventDataJSON{}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		var value string
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("Invalid value for event_data field %q: %v", key, err)
+		}
+		fields = append(fields, EventDataField{Name: key.(string), Value: value})
+	}
+	*self = fields
+	return nil
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func stringError(str string) error {
+	if str == "" {
+		return nil
+	}
+	return errors.New(str)
+}
+
+// Encode the event as JSON. Field names are snake_case, Created is an RFC 3339
+// timestamp with nanoseconds, errors are strings, and the payload is an object
+// under "event_data". Empty optional fields are omitted.
+func (self WinLogEvent) MarshalJSON() ([]byte, error) {
+	encoded := eventJSON{
+		SchemaVersion:      EventJSONSchemaVersion,
+		ProviderName:       self.ProviderName,
+		EventId:            self.EventId,
+		Qualifiers:         self.Qualifiers,
+		Level:              self.Level,
+		Task:               self.Task,
+		Opcode:             self.Opcode,
+		RecordId:           self.RecordId,
+		ProcessId:          self.ProcessId,
+		ThreadId:           self.ThreadId,
+		Channel:            self.Channel,
+		ComputerName:       self.ComputerName,
+		Version:            self.Version,
+		RenderedFieldsErr:  errorString(self.RenderedFieldsErr),
+		Msg:                self.Msg,
+		LevelText:          self.LevelText,
+		TaskText:           self.TaskText,
+		OpcodeText:         self.OpcodeText,
+		Keywords:           self.Keywords,
+		ChannelText:        self.ChannelText,
+		ProviderText:       self.ProviderText,
+		IdText:             self.IdText,
+		PublisherHandleErr: errorString(self.PublisherHandleErr),
+		EnglishMsg:         self.EnglishMsg,
+		Locale:             self.Locale,
+		Xml:                self.Xml,
+		XmlErr:             errorString(self.XmlErr),
+		EventData:          eventDataJSON(self.EventData),
+		Bookmark:           self.Bookmark,
+		SubscribedChannel:  self.SubscribedChannel,
+	}
+	if !self.Created.IsZero() {
+		encoded.Created = self.Created.UTC().Format(time.RFC3339Nano)
+	}
+	return json.Marshal(encoded)
+}
+
+// Decode an event encoded with MarshalJSON. Errors are restored as plain
+// errors with the same message, and payload fields are named by their keys, so
+// unnamed fields come back as param1, param2...
+func (self *WinLogEvent) UnmarshalJSON(data []byte) error {
+	var decoded eventJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	if decoded.SchemaVersion != EventJSONSchemaVersion {
+		return fmt.Errorf("Unsupported event schema version %v", decoded.SchemaVersion)
+	}
+	var created time.Time
+	if decoded.Created != "" {
+		var err error
+		created, err = time.Parse(time.RFC3339Nano, decoded.Created)
+		if err != nil {
+			return fmt.Errorf("Invalid created timestamp: %v", err)
+		}
+	}
+	*self = WinLogEvent{
+		ProviderName:       decoded.ProviderName,
+		EventId:            decoded.EventId,
+		Qualifiers:         decoded.Qualifiers,
+		Level:              decoded.Level,
+		Task:               decoded.Task,
+		Opcode:             decoded.Opcode,
+		Created:            created,
+		RecordId:           decoded.RecordId,
+		ProcessId:          decoded.ProcessId,
+		ThreadId:           decoded.ThreadId,
+		Channel:            decoded.Channel,
+		ComputerName:       decoded.ComputerName,
+		Version:            decoded.Version,
+		RenderedFieldsErr:  stringError(decoded.RenderedFieldsErr),
+		Msg:                decoded.Msg,
+		LevelText:          decoded.LevelText,
+		TaskText:           decoded.TaskText,
+		OpcodeText:         decoded.OpcodeText,
+		Keywords:           decoded.Keywords,
+		ChannelText:        decoded.ChannelText,
+		ProviderText:       decoded.ProviderText,
+		IdText:             decoded.IdText,
+		PublisherHandleErr: stringError(decoded.PublisherHandleErr),
+		EnglishMsg:         decoded.EnglishMsg,
+		Locale:             decoded.Locale,
+		Xml:                decoded.Xml,
+		XmlErr:             stringError(decoded.XmlErr),
+		EventData:          []EventDataField(decoded.EventData),
+		Bookmark:           decoded.Bookmark,
+		SubscribedChannel:  decoded.SubscribedChannel,
+	}
+	return nil
+}
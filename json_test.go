@@ -0,0 +1,158 @@
+package winlog
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	. "testing"
+	"time"
+)
+
+func testJsonEvent() *WinLogEvent {
+	return &WinLogEvent{
+		ProviderName:       "Microsoft-Windows-Security-Auditing",
+		EventId:            4624,
+		Level:              0,
+		Task:               12544,
+		Created:            time.Date(2016, 1, 19, 19, 37, 48, 123456789, time.UTC),
+		RecordId:           10811,
+		ProcessId:          560,
+		ThreadId:           4884,
+		Channel:            "Security",
+		ComputerName:       "WIN-HOST",
+		Version:            2,
+		Msg:                "An account was successfully logged on.",
+		Keywords:           []string{"Audit Success"},
+		PublisherHandleErr: errors.New("The system cannot find the file specified."),
+		Locale:             LocaleEnglishUS,
+		EventData: []EventDataField{
+			{Name: "TargetUserName", Value: "alice"},
+			{Name: "", Value: "unnamed"},
+			{Name: "TargetUserName", Value: "repeated"},
+		},
+		Bookmark:          "<BookmarkList/>",
+		SubscribedChannel: "Security",
+	}
+}
+
+func TestEventJsonFields(t *T) {
+	encoded, err := json.Marshal(testJsonEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(fields["schema_version"], float64(EventJSONSchemaVersion), t)
+	assertEqual(fields["event_id"], float64(4624), t)
+	assertEqual(fields["created"], "2016-01-19T19:37:48.123456789Z", t)
+	assertEqual(fields["publisher_handle_error"], "The system cannot find the file specified.", t)
+	assertEqual(fields["subscribed_channel"], "Security", t)
+	// Zero numbers are kept, empty strings and nil errors are omitted
+	assertEqual(fields["level"], float64(0), t)
+	if _, ok := fields["xml_error"]; ok {
+		t.Fatal("Nil error was encoded")
+	}
+	if _, ok := fields["level_text"]; ok {
+		t.Fatal("Empty string was encoded")
+	}
+
+	// The payload keeps its order, with unique keys
+	if !strings.Contains(string(encoded), `"event_data":{"TargetUserName":"alice","param2":"unnamed","TargetUserName_2":"repeated"}`) {
+		t.Fatalf("Unexpected event_data encoding: %s", encoded)
+	}
+}
+
+func TestEventJsonRoundTrip(t *T) {
+	event := testJsonEvent()
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded WinLogEvent
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(decoded.ProviderName, event.ProviderName, t)
+	assertEqual(decoded.RecordId, event.RecordId, t)
+	assertEqual(decoded.Created.Equal(event.Created), true, t)
+	assertEqual(decoded.PublisherHandleErr.Error(), event.PublisherHandleErr.Error(), t)
+	assertEqual(decoded.XmlErr, nil, t)
+	assertEqual(decoded.Keywords[0], "Audit Success", t)
+	assertEqual(decoded.Locale, uint32(LocaleEnglishUS), t)
+	assertEqual(len(decoded.EventData), 3, t)
+	assertEqual(decoded.EventData[0].Value, "alice", t)
+	assertEqual(decoded.EventData[1].Name, "param2", t)
+	assertEqual(decoded.EventData[2].Name, "TargetUserName_2", t)
+	assertEqual(decoded.Bookmark, event.Bookmark, t)
+}
+
+func TestEventDataJsonKeysAreUnique(t *T) {
+	data := eventDataJSON{
+		{Name: "a", Value: "1"},
+		{Name: "a", Value: "2"},
+		{Name: "a_2", Value: "3"},
+		{Name: "a", Value: "4"},
+		{Name: "param6", Value: "5"},
+		{Value: "6"},
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(string(encoded), `{"a":"1","a_2":"2","a_2_2":"3","a_3":"4","param6":"5","param6_2":"6"}`, t)
+}
+
+func TestEventJsonRejectsOtherVersions(t *T) {
+	var decoded WinLogEvent
+	if err := json.Unmarshal([]byte(`{"schema_version": 2}`), &decoded); err == nil {
+		t.Fatal("No error decoding an unknown schema version")
+	}
+	if err := json.Unmarshal([]byte(`{"schema_version": 1, "created": "yesterday"}`), &decoded); err == nil {
+		t.Fatal("No error decoding an invalid timestamp")
+	}
+	if err := json.Unmarshal([]byte(`{"schema_version": 1, "event_data": ["a"]}`), &decoded); err == nil {
+		t.Fatal("No error decoding an invalid payload")
+	}
+}
+
+func TestEventJsonMatchesSchema(t *T) {
+	schemaFile, err := os.ReadFile("schema/winlogevent.v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Required   []string
+		Properties map[string]interface{}
+	}
+	if err := json.Unmarshal(schemaFile, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	event := testJsonEvent()
+	event.XmlErr = errors.New("xml")
+	event.RenderedFieldsErr = errors.New("values")
+	event.LevelText, event.TaskText, event.OpcodeText = "level", "task", "opcode"
+	event.ChannelText, event.ProviderText, event.IdText = "channel", "provider", "id"
+	event.EnglishMsg, event.Xml = "message", "<Event/>"
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for name := range fields {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Fatalf("Field %q is not in the schema", name)
+		}
+	}
+	for _, name := range schema.Required {
+		if _, ok := fields[name]; !ok {
+			t.Fatalf("Required field %q was not encoded", name)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package winlog
+
+// Receives diagnostic messages about the lifecycle of a watcher or pipeline,
+// such as subscriptions being made and events failing to render. The
+// arguments after the message are alternating keys and values, as in log/slog,
+// so a *slog.Logger can be used directly. Common keys are "channel",
+// "subscription" (an ID unique within the watcher), "error" and "code" (a
+// system error code).
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// A Logger which discards everything, the default for watchers
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+func (NopLogger) Info(msg string, args ...interface{})  {}
+func (NopLogger) Warn(msg string, args ...interface{})  {}
+func (NopLogger) Error(msg string, args ...interface{}) {}
@@ -0,0 +1,296 @@
+package winlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// Returned when a message template can't be expanded with certainty in Go,
+// for example when an insert refers to a missing value. Callers should fall back
+// to EvtFormatMessage.
+var ErrAmbiguousMessage = errors.New("Message template can't be formatted without EvtFormatMessage")
+
+// Look up a parameter message (%%n) by ID. Returns false if the ID is unknown.
+type ParameterLookup func(id uint64) (string, bool)
+
+// Expand a raw message template as EvtFormatMessage would: %1..%99 are replaced
+// with the corresponding insertion string, optionally formatted with a printf-style
+// !format! specifier, %%n references inside insertion strings are resolved using
+// `parameters`, and the escape sequences %n, %r, %t, %%, %., %! and %<space> are
+// converted. Returns ErrAmbiguousMessage if any part can't be expanded.
+func FormatMessageTemplate(template string, values []string, parameters ParameterLookup) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '%' {
+			out.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(template) {
+			return "", ErrAmbiguousMessage
+		}
+		i++
+		switch next := template[i]; {
+		case next >= '1' && next <= '9':
+			// Inserts are %1 to %99
+			end := i + 1
+			if end < len(template) && isDigit(template[end]) {
+				end++
+			}
+			index, _ := strconv.Atoi(template[i:end])
+			if index > len(values) {
+				return "", ErrAmbiguousMessage
+			}
+			value, err := resolveParameters(values[index-1], parameters)
+			if err != nil {
+				return "", err
+			}
+			// An optional printf-style specifier follows the insert, i.e. %1!08x!
+			if end < len(template) && template[end] == '!' {
+				specEnd := strings.IndexByte(template[end+1:], '!')
+				if specEnd < 0 {
+					return "", ErrAmbiguousMessage
+				}
+				value, err = formatInsert(value, template[end+1:end+1+specEnd])
+				if err != nil {
+					return "", err
+				}
+				end += specEnd + 2
+			}
+			out.WriteString(value)
+			i = end - 1
+		case next == '0':
+			// %0 ends the message without a trailing newline
+			return out.String(), nil
+		case next == 'n':
+			out.WriteString("\r\n")
+		case next == 'r':
+			out.WriteByte('\r')
+		case next == 't':
+			out.WriteByte('\t')
+		case next == '%' || next == '.' || next == '!' || next == ' ':
+			out.WriteByte(next)
+		default:
+			return "", ErrAmbiguousMessage
+		}
+	}
+	return out.String(), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Replace each %%n parameter reference in an insertion string with the
+// parameter message it refers to.
+func resolveParameters(value string, parameters ParameterLookup) (string, error) {
+	if !strings.Contains(value, "%%") {
+		return value, nil
+	}
+	var out strings.Builder
+	for {
+		start := strings.Index(value, "%%")
+		if start < 0 {
+			out.WriteString(value)
+			return out.String(), nil
+		}
+		end := start + 2
+		for end < len(value) && isDigit(value[end]) {
+			end++
+		}
+		if end == start+2 {
+			// A literal %% with no ID
+			out.WriteString(value[:end])
+			value = value[end:]
+			continue
+		}
+		if parameters == nil {
+			return "", ErrAmbiguousMessage
+		}
+		id, err := strconv.ParseUint(value[start+2:end], 10, 32)
+		if err != nil {
+			return "", ErrAmbiguousMessage
+		}
+		parameter, ok := parameters(id)
+		if !ok {
+			return "", ErrAmbiguousMessage
+		}
+		out.WriteString(value[:start])
+		out.WriteString(strings.TrimRight(parameter, "\r\n"))
+		value = value[end:]
+	}
+}
+
+// Apply a FormatMessage printf specifier (the part between the !s) to an insertion
+// string. Insertion strings from the event XML are already rendered, so only
+// specifiers which can be reproduced from that text are supported.
+func formatInsert(value, spec string) (string, error) {
+	if spec == "" || strings.Contains(spec, "*") {
+		return "", ErrAmbiguousMessage
+	}
+	verb := spec[len(spec)-1]
+	flags := spec[:len(spec)-1]
+	// Drop size prefixes, which don't matter once the value is in Go
+	for _, prefix := range []string{"I64", "I32", "ll", "l", "h", "w", "I"} {
+		flags = strings.TrimSuffix(flags, prefix)
+	}
+	switch verb {
+	case 's', 'S':
+		return fmt.Sprintf("%"+flags+"s", value), nil
+	case 'd', 'i':
+		number, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return "", ErrAmbiguousMessage
+		}
+		return fmt.Sprintf("%"+flags+"d", number), nil
+	case 'u', 'x', 'X', 'o':
+		number, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return "", ErrAmbiguousMessage
+		}
+		if verb == 'u' {
+			verb = 'd'
+		}
+		return fmt.Sprintf("%"+flags+string(verb), number), nil
+	}
+	return "", ErrAmbiguousMessage
+}
+
+type messageTemplateKey struct {
+	provider string
+	eventId  uint64
+	version  uint64
+}
+
+// A message looked up from publisher metadata. Failed lookups are cached
+// too, so we don't repeatedly query templates that don't exist.
+type cachedMessage struct {
+	text string
+	ok   bool
+}
+
+// Caches raw message templates and parameter messages by provider, so the
+// message for each event can be formatted in Go rather than with EvtFormatMessage.
+// Safe for concurrent use.
+type MessageTemplateCache struct {
+	mutex     sync.Mutex
+	templates map[messageTemplateKey]cachedMessage
+	// Each provider's parameter messages, nil if they couldn't be loaded
+	parameters map[string]map[uint64]string
+}
+
+func NewMessageTemplateCache() *MessageTemplateCache {
+	return &MessageTemplateCache{
+		templates:  make(map[messageTemplateKey]cachedMessage),
+		parameters: make(map[string]map[uint64]string),
+	}
+}
+
+// Get the template for the given event, calling `load` to fetch it the first time.
+func (self *MessageTemplateCache) Template(provider string, eventId, version uint64, load func() (string, error)) (string, bool) {
+	key := messageTemplateKey{provider, eventId, version}
+	self.mutex.Lock()
+	cached, ok := self.templates[key]
+	self.mutex.Unlock()
+	if ok {
+		return cached.text, cached.ok
+	}
+	// Load outside the lock, a duplicate load is cheaper than blocking other renders
+	text, err := load()
+	cached = cachedMessage{text: text, ok: err == nil}
+	self.mutex.Lock()
+	self.templates[key] = cached
+	self.mutex.Unlock()
+	return cached.text, cached.ok
+}
+
+// Get the provider's parameter messages (%%n), calling `load` to fetch all of
+// them the first time. Returns nil if they couldn't be loaded.
+func (self *MessageTemplateCache) Parameters(provider string, load func() (map[uint64]string, error)) map[uint64]string {
+	self.mutex.Lock()
+	parameters, ok := self.parameters[provider]
+	self.mutex.Unlock()
+	if ok {
+		return parameters
+	}
+	parameters, err := load()
+	if err != nil {
+		parameters = nil
+	}
+	self.mutex.Lock()
+	self.parameters[provider] = parameters
+	self.mutex.Unlock()
+	return parameters
+}
+
+// Number of templates in the cache, including failed lookups.
+func (self *MessageTemplateCache) Len() int {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return len(self.templates)
+}
+
+// Parse an RT_MESSAGETABLE resource, as the message compiler builds into a
+// provider's message and parameter files, into its messages by ID. Messages
+// keep their trailing newlines.
+func ParseMessageTable(data []byte) (map[uint64]string, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("Message table is truncated")
+	}
+	blocks := binary.LittleEndian.Uint32(data)
+	if uint64(blocks)*12+4 > uint64(len(data)) {
+		return nil, fmt.Errorf("Message table has %v blocks, which don't fit in %v bytes", blocks, len(data))
+	}
+	messages := make(map[uint64]string)
+	for i := uint32(0); i < blocks; i++ {
+		block := data[4+i*12:]
+		lowId := binary.LittleEndian.Uint32(block)
+		highId := binary.LittleEndian.Uint32(block[4:])
+		offset := uint64(binary.LittleEndian.Uint32(block[8:]))
+		for id := uint64(lowId); id <= uint64(highId); id++ {
+			if offset+4 > uint64(len(data)) {
+				return nil, fmt.Errorf("Message %v is outside the message table", id)
+			}
+			length := uint64(binary.LittleEndian.Uint16(data[offset:]))
+			flags := binary.LittleEndian.Uint16(data[offset+2:])
+			if length < 4 || offset+length > uint64(len(data)) {
+				return nil, fmt.Errorf("Message %v has an invalid length of %v", id, length)
+			}
+			messages[id] = decodeMessageTableEntry(data[offset+4:offset+length], flags&messageTableUnicode != 0)
+			offset += length
+		}
+	}
+	return messages, nil
+}
+
+// Set in the flags of message table entries which are UTF-16
+const messageTableUnicode = 0x0001
+
+// Decode the text of a message table entry, dropping the NUL padding.
+func decodeMessageTableEntry(text []byte, unicode bool) string {
+	if !unicode {
+		// An ANSI code page, which is ASCII for the messages that matter
+		runes := make([]rune, 0, len(text))
+		for _, b := range text {
+			if b == 0 {
+				break
+			}
+			runes = append(runes, rune(b))
+		}
+		return string(runes)
+	}
+	units := make([]uint16, 0, len(text)/2)
+	for i := 0; i+1 < len(text); i += 2 {
+		unit := binary.LittleEndian.Uint16(text[i:])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,219 @@
+package winlog
+
+import (
+	"encoding/binary"
+	"errors"
+	. "testing"
+	"unicode/utf16"
+)
+
+const testEventDataXml = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Microsoft-Windows-Security-Auditing'/><EventID>4663</EventID></System><EventData><Data Name='SubjectUserName'>alice</Data><Data Name='AccessList'>%%4416
+				%%4417</Data><Data Name='AccessMask'>0x3</Data><Data>12</Data></EventData></Event>`
+
+const testUserDataXml = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><EventID>1102</EventID></System><UserData><LogFileCleared xmlns='http://manifests.microsoft.com/win/2004/08/windows/eventlog'><SubjectUserName>bob</SubjectUserName><SubjectDomainName>CORP</SubjectDomainName></LogFileCleared></UserData></Event>`
+
+func testParameters(id uint64) (string, bool) {
+	switch id {
+	case 4416:
+		return "ReadData (or ListDirectory)\r\n", true
+	case 4417:
+		return "WriteData (or AddFile)", true
+	}
+	return "", false
+}
+
+func TestParseEventData(t *T) {
+	fields, err := ParseEventData(testEventDataXml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(fields), 4, t)
+	assertEqual(fields[0].Name, "SubjectUserName", t)
+	assertEqual(fields[0].Value, "alice", t)
+	assertEqual(fields[2].Value, "0x3", t)
+	assertEqual(fields[3].Name, "", t)
+	assertEqual(fields[3].Value, "12", t)
+}
+
+func TestParseUserData(t *T) {
+	fields, err := ParseEventData(testUserDataXml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(fields), 2, t)
+	assertEqual(fields[0].Name, "SubjectUserName", t)
+	assertEqual(fields[1].Value, "CORP", t)
+}
+
+func TestEventPayload(t *T) {
+	event := &WinLogEvent{Xml: testEventDataXml}
+	fields := EventPayload(event)
+	assertEqual(len(fields), 4, t)
+	assertEqual(fields[0].Value, "alice", t)
+
+	// Rendered fields are used as they are
+	event.EventData = []EventDataField{{Name: "SubjectUserName", Value: "bob"}}
+	assertEqual(EventPayload(event)[0].Value, "bob", t)
+
+	assertEqual(len(EventPayload(&WinLogEvent{})), 0, t)
+}
+
+func TestParseEventDataInvalidXml(t *T) {
+	if _, err := ParseEventData("<Event><EventData>"); err == nil {
+		t.Fatal("No error from truncated XML")
+	}
+}
+
+func TestFormatMessageTemplateInserts(t *T) {
+	msg, err := FormatMessageTemplate("User %1 logged on.%n%tCount: %4!d!%0 ignored", []string{"alice", "", "", "12"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(msg, "User alice logged on.\r\n\tCount: 12", t)
+}
+
+func TestFormatMessageTemplateSpecifiers(t *T) {
+	msg, err := FormatMessageTemplate("%1!08X! %1!u! %2!-4s!| 100%% done%.", []string{"0x1f", "ab"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(msg, "0000001F 31 ab  | 100% done.", t)
+}
+
+func TestFormatMessageTemplateParameters(t *T) {
+	values := []string{"alice", "%%4416\r\n\t\t\t\t%%4417"}
+	msg, err := FormatMessageTemplate("%1 requested %2", values, testParameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(msg, "alice requested ReadData (or ListDirectory)\r\n\t\t\t\tWriteData (or AddFile)", t)
+}
+
+func TestFormatMessageTemplateAmbiguous(t *T) {
+	templates := []struct {
+		template string
+		values   []string
+	}{
+		{"Missing %3", []string{"a"}},
+		{"Unknown parameter %1", []string{"%%9999"}},
+		{"Bad number %1!d!", []string{"abc"}},
+		{"Star width %1!*d!", []string{"1"}},
+		{"Unterminated %1!d", []string{"1"}},
+		{"Trailing %", nil},
+		{"Unknown escape %q", nil},
+	}
+	for _, test := range templates {
+		_, err := FormatMessageTemplate(test.template, test.values, testParameters)
+		if !errors.Is(err, ErrAmbiguousMessage) {
+			t.Fatalf("Expected ErrAmbiguousMessage for %q, got %v", test.template, err)
+		}
+	}
+}
+
+func TestMessageTemplateCacheLoadsOnce(t *T) {
+	cache := NewMessageTemplateCache()
+	loads := 0
+	load := func() (string, error) {
+		loads++
+		return "Template %1", nil
+	}
+	for i := 0; i < 3; i++ {
+		template, ok := cache.Template("provider", 1, 0, load)
+		assertEqual(ok, true, t)
+		assertEqual(template, "Template %1", t)
+	}
+	assertEqual(loads, 1, t)
+
+	// Failed lookups are cached as well
+	failedLoad := func() (string, error) {
+		loads++
+		return "", errors.New("not found")
+	}
+	for i := 0; i < 3; i++ {
+		_, ok := cache.Template("provider", 2, 0, failedLoad)
+		assertEqual(ok, false, t)
+	}
+	assertEqual(loads, 2, t)
+	assertEqual(cache.Len(), 2, t)
+}
+
+// Build a message table with one block per entry of `blocks`, each holding
+// consecutive messages from its first ID. Even IDs are UTF-16, odd ones ANSI.
+func buildMessageTable(blocks map[uint32][]string, order []uint32) []byte {
+	header := make([]byte, 4+len(order)*12)
+	binary.LittleEndian.PutUint32(header, uint32(len(order)))
+	var entries []byte
+	for i, lowId := range order {
+		messages := blocks[lowId]
+		binary.LittleEndian.PutUint32(header[4+i*12:], lowId)
+		binary.LittleEndian.PutUint32(header[8+i*12:], lowId+uint32(len(messages))-1)
+		binary.LittleEndian.PutUint32(header[12+i*12:], uint32(len(header)+len(entries)))
+		for j, message := range messages {
+			var text []byte
+			var flags uint16
+			if (lowId+uint32(j))%2 == 0 {
+				flags = messageTableUnicode
+				for _, unit := range utf16.Encode([]rune(message)) {
+					text = binary.LittleEndian.AppendUint16(text, unit)
+				}
+				text = append(text, 0, 0)
+			} else {
+				text = append([]byte(message), 0)
+			}
+			// Entries are padded to 4 bytes
+			for len(text)%4 != 0 {
+				text = append(text, 0)
+			}
+			entry := binary.LittleEndian.AppendUint16(nil, uint16(len(text)+4))
+			entry = binary.LittleEndian.AppendUint16(entry, flags)
+			entries = append(entries, append(entry, text...)...)
+		}
+	}
+	return append(header, entries...)
+}
+
+func TestParseMessageTable(t *T) {
+	data := buildMessageTable(map[uint32][]string{
+		1537: {"DELETE\r\n", "READ_CONTROL\r\n"},
+		1833: {"Yes\r\n"},
+		4416: {"ReadData (or ListDirectory)\r\n", "WriteData (or AddFile)\r\n", "Zugriff prüfen\r\n"},
+	}, []uint32{1537, 1833, 4416})
+	messages, err := ParseMessageTable(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(messages), 6, t)
+	assertEqual(messages[1537], "DELETE\r\n", t)
+	assertEqual(messages[1538], "READ_CONTROL\r\n", t)
+	assertEqual(messages[1833], "Yes\r\n", t)
+	assertEqual(messages[4418], "Zugriff prüfen\r\n", t)
+
+	for _, truncated := range [][]byte{nil, data[:10], data[:len(data)-8]} {
+		if _, err := ParseMessageTable(truncated); err == nil {
+			t.Fatalf("No error parsing a truncated message table of %v bytes", len(truncated))
+		}
+	}
+}
+
+func TestMessageTemplateCacheParameters(t *T) {
+	cache := NewMessageTemplateCache()
+	loads := 0
+	load := func() (map[uint64]string, error) {
+		loads++
+		return map[uint64]string{1833: "Yes\r\n"}, nil
+	}
+	for i := 0; i < 3; i++ {
+		assertEqual(cache.Parameters("provider", load)[1833], "Yes\r\n", t)
+	}
+	assertEqual(loads, 1, t)
+
+	// Providers without a parameter file are cached as well
+	failedLoad := func() (map[uint64]string, error) {
+		loads++
+		return nil, errors.New("no parameter file")
+	}
+	for i := 0; i < 3; i++ {
+		assertEqual(len(cache.Parameters("other", failedLoad)), 0, t)
+	}
+	assertEqual(loads, 2, t)
+}
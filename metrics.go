@@ -0,0 +1,62 @@
+package winlog
+
+import (
+	"time"
+)
+
+// A step of rendering an event, for timing with Metrics
+type RenderPhase string
+
+const (
+	// EvtRender of the event XML
+	RenderPhaseXml RenderPhase = "xml"
+	// EvtRender of the System property values
+	RenderPhaseValues RenderPhase = "values"
+	// Opening the publisher and formatting the message and other localized fields
+	RenderPhaseFormatMessage RenderPhase = "format_message"
+	// Updating and serializing the channel's bookmark
+	RenderPhaseBookmark RenderPhase = "bookmark"
+)
+
+// Why an event was dropped instead of being delivered
+const (
+	// Neither the values nor the XML of the event could be rendered
+	DropReasonRenderError = "render_error"
+	// The channel's bookmark couldn't be serialized
+	DropReasonBookmarkError = "bookmark_error"
+	// The channel was unsubscribed while the event was being rendered
+	DropReasonUnsubscribed = "unsubscribed"
+	// The watcher shut down before the event was consumed
+	DropReasonShutdown = "shutdown"
+)
+
+// Receives measurements from a WinLogWatcher. The methods are called while
+// events are being rendered, possibly from several goroutines at once, so they
+// must be safe for concurrent use and should return quickly.
+type Metrics interface {
+	// An event arrived from a subscribed channel
+	EventReceived(channel string)
+	// An event was sent on the Event channel
+	EventDelivered(channel string)
+	// An event was discarded, for one of the DropReason values
+	EventDropped(channel, reason string)
+	// The event log called back with a system error code instead of an event
+	CallbackError(code uint64)
+	// Time taken by one phase of rendering an event
+	RenderDuration(phase RenderPhase, duration time.Duration)
+	// Events from a channel waiting for a render worker or for the consumer
+	QueueDepth(channel string, depth int)
+	// Time from an event being created to it being delivered
+	EventLag(channel string, lag time.Duration)
+}
+
+// Metrics which are discarded, the default for a WinLogWatcher
+type NopMetrics struct{}
+
+func (NopMetrics) EventReceived(channel string)                             {}
+func (NopMetrics) EventDelivered(channel string)                            {}
+func (NopMetrics) EventDropped(channel, reason string)                      {}
+func (NopMetrics) CallbackError(code uint64)                                {}
+func (NopMetrics) RenderDuration(phase RenderPhase, duration time.Duration) {}
+func (NopMetrics) QueueDepth(channel string, depth int)                     {}
+func (NopMetrics) EventLag(channel string, lag time.Duration)               {}
@@ -0,0 +1,248 @@
+// Package metrics exposes the measurements of a WinLogWatcher in the
+// Prometheus text exposition format, for scraping over HTTP.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+var (
+	// Render phases mostly take microseconds, but EvtFormatMessage can take
+	// much longer for a provider with slow resources
+	DefaultRenderBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	// Events read from the beginning of a channel can be days old
+	DefaultLagBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600, 86400}
+)
+
+// Settings for a Prometheus adapter. Everything is optional.
+type Config struct {
+	// Prefix for metric names, "winlog" by default
+	Namespace string
+	// Upper bounds, in seconds, of the render duration histogram buckets.
+	// DefaultRenderBuckets by default.
+	RenderBuckets []float64
+	// Upper bounds, in seconds, of the event lag histogram buckets.
+	// DefaultLagBuckets by default.
+	LagBuckets []float64
+}
+
+// Collects a watcher's measurements and writes them in the Prometheus text
+// format. It's a winlog.Metrics for WinLogWatcher.SetMetrics, and an
+// http.Handler for serving the metrics endpoint.
+type Prometheus struct {
+	received       *family
+	delivered      *family
+	dropped        *family
+	callbackErrors *family
+	renderDuration *family
+	queueDepth     *family
+	lag            *family
+	families       []*family
+
+	mutex sync.Mutex
+}
+
+var _ winlog.Metrics = (*Prometheus)(nil)
+
+func NewPrometheus(config Config) *Prometheus {
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = "winlog"
+	}
+	renderBuckets := config.RenderBuckets
+	if renderBuckets == nil {
+		renderBuckets = DefaultRenderBuckets
+	}
+	lagBuckets := config.LagBuckets
+	if lagBuckets == nil {
+		lagBuckets = DefaultLagBuckets
+	}
+	self := &Prometheus{}
+	self.received = self.add(namespace+"_events_received_total", "counter", "Events received from each subscribed channel.", nil, "channel")
+	self.delivered = self.add(namespace+"_events_delivered_total", "counter", "Events sent to the consumer from each subscribed channel.", nil, "channel")
+	self.dropped = self.add(namespace+"_events_dropped_total", "counter", "Events discarded instead of being delivered, by reason.", nil, "channel", "reason")
+	self.callbackErrors = self.add(namespace+"_callback_errors_total", "counter", "Errors reported by the event log callback, by system error code.", nil, "code")
+	self.renderDuration = self.add(namespace+"_render_duration_seconds", "histogram", "Time taken by each phase of rendering an event.", renderBuckets, "phase")
+	self.queueDepth = self.add(namespace+"_queue_depth", "gauge", "Events from each channel waiting for a render worker or the consumer.", nil, "channel")
+	self.lag = self.add(namespace+"_event_lag_seconds", "histogram", "Time from an event being created to it being delivered.", lagBuckets, "channel")
+	return self
+}
+
+func (self *Prometheus) add(name, kind, help string, buckets []float64, labels ...string) *family {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	metric := &family{
+		name:    name,
+		kind:    kind,
+		help:    help,
+		labels:  labels,
+		buckets: sorted,
+		series:  make(map[string]*series),
+	}
+	self.families = append(self.families, metric)
+	return metric
+}
+
+func (self *Prometheus) EventReceived(channel string) {
+	self.mutex.Lock()
+	self.received.get(channel).value++
+	self.mutex.Unlock()
+}
+
+func (self *Prometheus) EventDelivered(channel string) {
+	self.mutex.Lock()
+	self.delivered.get(channel).value++
+	self.mutex.Unlock()
+}
+
+func (self *Prometheus) EventDropped(channel, reason string) {
+	self.mutex.Lock()
+	self.dropped.get(channel, reason).value++
+	self.mutex.Unlock()
+}
+
+func (self *Prometheus) CallbackError(code uint64) {
+	self.mutex.Lock()
+	self.callbackErrors.get(strconv.FormatUint(code, 10)).value++
+	self.mutex.Unlock()
+}
+
+func (self *Prometheus) RenderDuration(phase winlog.RenderPhase, duration time.Duration) {
+	self.mutex.Lock()
+	self.renderDuration.observe(duration.Seconds(), string(phase))
+	self.mutex.Unlock()
+}
+
+func (self *Prometheus) QueueDepth(channel string, depth int) {
+	self.mutex.Lock()
+	self.queueDepth.get(channel).value = float64(depth)
+	self.mutex.Unlock()
+}
+
+func (self *Prometheus) EventLag(channel string, lag time.Duration) {
+	self.mutex.Lock()
+	self.lag.observe(lag.Seconds(), channel)
+	self.mutex.Unlock()
+}
+
+// Write the metrics in the Prometheus text exposition format, version 0.0.4.
+// Metrics with no measurements yet are left out.
+func (self *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	var buffer bytes.Buffer
+	self.mutex.Lock()
+	for _, metric := range self.families {
+		metric.write(&buffer)
+	}
+	self.mutex.Unlock()
+	return buffer.WriteTo(w)
+}
+
+// Serve the metrics for a Prometheus scrape
+func (self *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	self.WriteTo(w)
+}
+
+// A metric and its series, one for each set of label values
+type family struct {
+	name   string
+	kind   string
+	help   string
+	labels []string
+	// Upper bounds of a histogram's buckets, in ascending order
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labels []string
+	// A counter or gauge's value, or the sum of a histogram's observations
+	value float64
+	// A histogram's observations, and the number in each bucket
+	count   uint64
+	buckets []uint64
+}
+
+// The series for a set of label values, created if it's new
+func (self *family) get(labels ...string) *series {
+	key := strings.Join(labels, "\xff")
+	values, ok := self.series[key]
+	if !ok {
+		values = &series{labels: labels, buckets: make([]uint64, len(self.buckets))}
+		self.series[key] = values
+	}
+	return values
+}
+
+func (self *family) observe(value float64, labels ...string) {
+	values := self.get(labels...)
+	values.value += value
+	values.count++
+	for i, bound := range self.buckets {
+		if value <= bound {
+			values.buckets[i]++
+		}
+	}
+}
+
+func (self *family) write(buffer *bytes.Buffer) {
+	if len(self.series) == 0 {
+		return
+	}
+	fmt.Fprintf(buffer, "# HELP %v %v\n", self.name, self.help)
+	fmt.Fprintf(buffer, "# TYPE %v %v\n", self.name, self.kind)
+	keys := make([]string, 0, len(self.series))
+	for key := range self.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := self.series[key]
+		if self.kind != "histogram" {
+			fmt.Fprintf(buffer, "%v%v %v\n", self.name, self.formatLabels(values.labels, ""), formatFloat(values.value))
+			continue
+		}
+		for i, bound := range self.buckets {
+			fmt.Fprintf(buffer, "%v_bucket%v %v\n", self.name, self.formatLabels(values.labels, formatFloat(bound)), values.buckets[i])
+		}
+		fmt.Fprintf(buffer, "%v_bucket%v %v\n", self.name, self.formatLabels(values.labels, "+Inf"), values.count)
+		fmt.Fprintf(buffer, "%v_sum%v %v\n", self.name, self.formatLabels(values.labels, ""), formatFloat(values.value))
+		fmt.Fprintf(buffer, "%v_count%v %v\n", self.name, self.formatLabels(values.labels, ""), values.count)
+	}
+}
+
+// Format label values as {name="value",...}, with a histogram bucket's "le"
+// label last if it's set
+func (self *family) formatLabels(values []string, le string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", self.labels[i], escapeLabel(value)))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=\"%v\"", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func exposition(metrics *Prometheus) string {
+	var builder strings.Builder
+	metrics.WriteTo(&builder)
+	return builder.String()
+}
+
+func TestPrometheusEmpty(t *T) {
+	assertEqual(exposition(NewPrometheus(Config{})), "", t)
+}
+
+func TestPrometheusCountersAndGauges(t *T) {
+	metrics := NewPrometheus(Config{})
+	metrics.EventReceived("System")
+	metrics.EventReceived("System")
+	metrics.EventReceived("Application")
+	metrics.EventDelivered("System")
+	metrics.EventDropped("System", winlog.DropReasonRenderError)
+	metrics.CallbackError(15007)
+	metrics.QueueDepth("System", 3)
+	metrics.QueueDepth("System", 1)
+	assertEqual(exposition(metrics), `# HELP winlog_events_received_total Events received from each subscribed channel.
+# TYPE winlog_events_received_total counter
+winlog_events_received_total{channel="Application"} 1
+winlog_events_received_total{channel="System"} 2
+# HELP winlog_events_delivered_total Events sent to the consumer from each subscribed channel.
+# TYPE winlog_events_delivered_total counter
+winlog_events_delivered_total{channel="System"} 1
+# HELP winlog_events_dropped_total Events discarded instead of being delivered, by reason.
+# TYPE winlog_events_dropped_total counter
+winlog_events_dropped_total{channel="System",reason="render_error"} 1
+# HELP winlog_callback_errors_total Errors reported by the event log callback, by system error code.
+# TYPE winlog_callback_errors_total counter
+winlog_callback_errors_total{code="15007"} 1
+# HELP winlog_queue_depth Events from each channel waiting for a render worker or the consumer.
+# TYPE winlog_queue_depth gauge
+winlog_queue_depth{channel="System"} 1
+`, t)
+}
+
+func TestPrometheusHistograms(t *T) {
+	metrics := NewPrometheus(Config{
+		Namespace:     "shipper",
+		RenderBuckets: []float64{0.01, 0.001},
+		LagBuckets:    []float64{60},
+	})
+	metrics.RenderDuration(winlog.RenderPhaseXml, 500*time.Microsecond)
+	metrics.RenderDuration(winlog.RenderPhaseXml, 5*time.Millisecond)
+	metrics.RenderDuration(winlog.RenderPhaseXml, time.Second)
+	metrics.EventLag(`Microsoft-Windows-"Quoted"\Operational`, 90*time.Second)
+	assertEqual(exposition(metrics), `# HELP shipper_render_duration_seconds Time taken by each phase of rendering an event.
+# TYPE shipper_render_duration_seconds histogram
+shipper_render_duration_seconds_bucket{phase="xml",le="0.001"} 1
+shipper_render_duration_seconds_bucket{phase="xml",le="0.01"} 2
+shipper_render_duration_seconds_bucket{phase="xml",le="+Inf"} 3
+shipper_render_duration_seconds_sum{phase="xml"} 1.0055
+shipper_render_duration_seconds_count{phase="xml"} 3
+# HELP shipper_event_lag_seconds Time from an event being created to it being delivered.
+# TYPE shipper_event_lag_seconds histogram
+shipper_event_lag_seconds_bucket{channel="Microsoft-Windows-\"Quoted\"\\Operational",le="60"} 0
+shipper_event_lag_seconds_bucket{channel="Microsoft-Windows-\"Quoted\"\\Operational",le="+Inf"} 1
+shipper_event_lag_seconds_sum{channel="Microsoft-Windows-\"Quoted\"\\Operational"} 90
+shipper_event_lag_seconds_count{channel="Microsoft-Windows-\"Quoted\"\\Operational"} 1
+`, t)
+}
+
+func TestPrometheusHandler(t *T) {
+	metrics := NewPrometheus(Config{})
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				metrics.EventReceived("Security")
+			}
+		}()
+	}
+	wait.Wait()
+
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+	response, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	assertEqual(response.Header.Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8", t)
+	assertEqual(strings.Contains(string(body), "winlog_events_received_total{channel=\"Security\"} 400\n"), true, t)
+}
@@ -0,0 +1,131 @@
+// Package otlp exports events to an OpenTelemetry collector as OTLP log
+// records, over HTTP (JSON or protobuf) or gRPC.
+package otlp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Instrumentation scope name for exported records
+const ScopeName = "github.com/scalingdata/gowinlog"
+
+// OpenTelemetry severity numbers for the event levels
+const (
+	SeverityTrace = 1
+	SeverityDebug = 5
+	SeverityInfo  = 9
+	SeverityWarn  = 13
+	SeverityError = 17
+	SeverityFatal = 21
+)
+
+// Map an event Level to an OpenTelemetry SeverityNumber and SeverityText.
+// LogAlways (0) and unknown levels are INFO.
+func Severity(level uint64) (int, string) {
+	switch level {
+	case 1:
+		return SeverityFatal, "FATAL"
+	case 2:
+		return SeverityError, "ERROR"
+	case 3:
+		return SeverityWarn, "WARN"
+	case 5:
+		return SeverityDebug, "DEBUG"
+	default:
+		return SeverityInfo, "INFO"
+	}
+}
+
+// Convert an event to a log record. The System properties are attributes
+// under winlog.*, and payload fields under winlog.event_data.*, with unnamed
+// fields keyed by position as param1, param2...
+func convertEvent(event *winlog.WinLogEvent, observed time.Time) logRecord {
+	record := logRecord{
+		ObservedTimeUnixNano: uint64(observed.UnixNano()),
+		EventName:            event.ProviderName + "/" + strconv.FormatUint(event.EventId, 10),
+	}
+	if !event.Created.IsZero() {
+		record.TimeUnixNano = uint64(event.Created.UnixNano())
+	}
+	record.SeverityNumber, record.SeverityText = Severity(event.Level)
+	if event.LevelText != "" {
+		record.SeverityText = event.LevelText
+	}
+	if message := strings.TrimSpace(event.Msg); message != "" {
+		body := stringValue(message)
+		record.Body = &body
+	}
+
+	attributes := []keyValue{
+		stringAttribute("winlog.channel", event.Channel),
+		stringAttribute("winlog.provider_name", event.ProviderName),
+		intAttribute("winlog.event_id", event.EventId),
+		intAttribute("winlog.record_id", event.RecordId),
+		intAttribute("winlog.level", event.Level),
+		intAttribute("winlog.task", event.Task),
+		intAttribute("winlog.opcode", event.Opcode),
+		intAttribute("winlog.version", event.Version),
+	}
+	if event.ProcessId != 0 {
+		attributes = append(attributes,
+			intAttribute("process.pid", event.ProcessId),
+			intAttribute("thread.id", event.ThreadId))
+	}
+	if event.TaskText != "" {
+		attributes = append(attributes, stringAttribute("winlog.task_text", event.TaskText))
+	}
+	if event.OpcodeText != "" {
+		attributes = append(attributes, stringAttribute("winlog.opcode_text", event.OpcodeText))
+	}
+	if len(event.Keywords) > 0 {
+		keywords := &arrayValue{}
+		for _, keyword := range event.Keywords {
+			keywords.Values = append(keywords.Values, stringValue(keyword))
+		}
+		attributes = append(attributes, keyValue{"winlog.keywords", anyValue{ArrayValue: keywords}})
+	}
+	seen := make(map[string]bool)
+	for i, field := range winlog.EventPayload(event) {
+		name := field.Name
+		if name == "" {
+			name = "param" + strconv.Itoa(i+1)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		attributes = append(attributes, stringAttribute("winlog.event_data."+name, field.Value))
+	}
+	record.Attributes = attributes
+	return record
+}
+
+// Build an export request, with a ResourceLogs for each computer
+func buildRequest(events []*winlog.WinLogEvent, scopeVersion string, observed time.Time) *exportLogsRequest {
+	request := &exportLogsRequest{}
+	byHost := make(map[string]int)
+	for _, event := range events {
+		index, ok := byHost[event.ComputerName]
+		if !ok {
+			index = len(request.ResourceLogs)
+			byHost[event.ComputerName] = index
+			attributes := []keyValue{stringAttribute("os.type", "windows")}
+			if event.ComputerName != "" {
+				attributes = append(attributes, stringAttribute("host.name", event.ComputerName))
+			}
+			request.ResourceLogs = append(request.ResourceLogs, resourceLogs{
+				Resource: resource{Attributes: attributes},
+				ScopeLogs: []scopeLogs{{
+					Scope: instrumentationScope{Name: ScopeName, Version: scopeVersion},
+				}},
+			})
+		}
+		scope := &request.ResourceLogs[index].ScopeLogs[0]
+		scope.LogRecords = append(scope.LogRecords, convertEvent(event, observed))
+	}
+	return request
+}
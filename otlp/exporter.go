@@ -0,0 +1,362 @@
+package otlp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Transport and encoding for exported logs
+type Protocol int
+
+const (
+	// OTLP/HTTP with protobuf bodies, the OTLP default
+	ProtocolHTTPProtobuf Protocol = iota
+	// OTLP/HTTP with JSON bodies
+	ProtocolHTTPJSON
+	// OTLP/gRPC. gRPC is spoken directly over HTTP/2, without a gRPC library.
+	ProtocolGRPC
+)
+
+const grpcExportPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// gRPC status codes which the OTLP specification says to retry
+var retryableGrpcCodes = map[int]bool{
+	1:  true, // CANCELLED
+	4:  true, // DEADLINE_EXCEEDED
+	8:  true, // RESOURCE_EXHAUSTED
+	10: true, // ABORTED
+	11: true, // OUT_OF_RANGE
+	14: true, // UNAVAILABLE
+	15: true, // DATA_LOSS
+}
+
+// Settings for an Exporter. Only Endpoint is required.
+type Config struct {
+	// For OTLP/HTTP, the URL of the logs endpoint, such as
+	// http://collector:4318/v1/logs. For gRPC, the URL of the server, such as
+	// http://collector:4317, where "http" means HTTP/2 without TLS.
+	Endpoint string
+	Protocol Protocol
+	// Extra request headers, such as for authentication
+	Headers   map[string]string
+	TLSConfig *tls.Config
+	// Timeout for each request, 10 seconds by default
+	Timeout time.Duration
+
+	// Run sends a batch when it has BatchSize events, or FlushInterval after
+	// the first event in the batch. 512 events and 1 second by default.
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// Attempts to resend a batch that failed with a retryable error, 5 by
+	// default. Negative values retry forever, until the exporter is closed.
+	Retries int
+	// Delay before the first retry, doubling up to MaxRetryDelay, unless the
+	// collector asks for a delay with Retry-After. 1 and 30 seconds by default.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// Bookmarks are saved here after each batch is accepted, if it's set
+	Bookmarks winlog.BookmarkStore
+
+	// Version reported in the instrumentation scope
+	ScopeVersion string
+}
+
+// An error from the collector, with whether the batch can be resent
+type exportError struct {
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+func (self *exportError) Error() string {
+	return self.err.Error()
+}
+
+// Sends events to an OpenTelemetry collector as log records
+type Exporter struct {
+	config Config
+	client *http.Client
+	url    string
+
+	closed    chan interface{}
+	closeOnce sync.Once
+}
+
+func NewExporter(config Config) (*Exporter, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid OTLP endpoint: %v", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported OTLP endpoint scheme %q", endpoint.Scheme)
+	}
+	if config.Protocol < ProtocolHTTPProtobuf || config.Protocol > ProtocolGRPC {
+		return nil, fmt.Errorf("Unknown OTLP protocol %v", config.Protocol)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Retries == 0 {
+		config.Retries = 5
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = time.Second
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = 30 * time.Second
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.TLSConfig
+	requestUrl := config.Endpoint
+	if config.Protocol == ProtocolGRPC {
+		transport.Protocols = new(http.Protocols)
+		if endpoint.Scheme == "http" {
+			transport.Protocols.SetUnencryptedHTTP2(true)
+		} else {
+			transport.Protocols.SetHTTP2(true)
+		}
+		requestUrl = strings.TrimSuffix(config.Endpoint, "/") + grpcExportPath
+	}
+	return &Exporter{
+		config: config,
+		client: &http.Client{Transport: transport, Timeout: config.Timeout},
+		url:    requestUrl,
+		closed: make(chan interface{}),
+	}, nil
+}
+
+// Export events from the channel, such as WinLogWatcher.Event(), in batches
+// until it's closed. Returns the first error from Export.
+func (self *Exporter) Run(events <-chan *winlog.WinLogEvent) error {
+	var batch []*winlog.WinLogEvent
+	var flush <-chan time.Time
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				if len(batch) > 0 {
+					return self.Export(batch)
+				}
+				return nil
+			}
+			batch = append(batch, event)
+			if len(batch) == 1 {
+				timer = time.NewTimer(self.config.FlushInterval)
+				flush = timer.C
+			}
+			if len(batch) < self.config.BatchSize {
+				continue
+			}
+			timer.Stop()
+		case <-flush:
+		case <-self.closed:
+			return fmt.Errorf("OTLP exporter is closed")
+		}
+		flush = nil
+		if err := self.Export(batch); err != nil {
+			return err
+		}
+		batch = nil
+	}
+}
+
+// Send the events as one request, retrying as configured. Bookmarks are saved
+// once the collector has accepted the request.
+func (self *Exporter) Export(events []*winlog.WinLogEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	body, contentType, err := self.encode(buildRequest(events, self.config.ScopeVersion, time.Now()))
+	if err != nil {
+		return err
+	}
+	delay := self.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err = self.send(body, contentType)
+		if err == nil {
+			return self.commitBookmarks(events)
+		}
+		exportErr, ok := err.(*exportError)
+		if ok && !exportErr.retryable {
+			return err
+		}
+		if self.config.Retries >= 0 && attempt >= self.config.Retries {
+			return err
+		}
+		wait := delay
+		if ok && exportErr.retryAfter > 0 {
+			wait = exportErr.retryAfter
+		}
+		delay *= 2
+		if delay > self.config.MaxRetryDelay {
+			delay = self.config.MaxRetryDelay
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-self.closed:
+			timer.Stop()
+			return fmt.Errorf("OTLP exporter is closed: %v", err)
+		}
+	}
+}
+
+// Save the last bookmark for each channel in the batch
+func (self *Exporter) commitBookmarks(events []*winlog.WinLogEvent) error {
+	if self.config.Bookmarks == nil {
+		return nil
+	}
+	var channels []string
+	latest := make(map[string]*winlog.WinLogEvent)
+	for _, event := range events {
+		if event.Bookmark == "" {
+			continue
+		}
+		if _, ok := latest[event.SubscribedChannel]; !ok {
+			channels = append(channels, event.SubscribedChannel)
+		}
+		latest[event.SubscribedChannel] = event
+	}
+	for _, channel := range channels {
+		if err := winlog.CommitBookmark(self.config.Bookmarks, latest[channel]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Exporter) encode(request *exportLogsRequest) ([]byte, string, error) {
+	switch self.config.Protocol {
+	case ProtocolHTTPJSON:
+		body, err := json.Marshal(request)
+		return body, "application/json", err
+	case ProtocolGRPC:
+		message := request.marshal(nil)
+		// Uncompressed flag and big-endian length prefix
+		frame := make([]byte, 5, 5+len(message))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+		return append(frame, message...), "application/grpc", nil
+	default:
+		return request.marshal(nil), "application/x-protobuf", nil
+	}
+}
+
+func (self *Exporter) send(body []byte, contentType string) error {
+	request, err := http.NewRequest("POST", self.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	if self.config.Protocol == ProtocolGRPC {
+		request.Header.Set("TE", "trailers")
+	}
+	for name, value := range self.config.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := self.client.Do(request)
+	if err != nil {
+		// Network errors are retryable
+		return &exportError{err: err, retryable: true}
+	}
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return &exportError{err: err, retryable: true}
+	}
+	if self.config.Protocol == ProtocolGRPC {
+		return grpcResult(response)
+	}
+	return httpResult(response, responseBody)
+}
+
+func httpResult(response *http.Response, body []byte) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	err := &exportError{
+		err: fmt.Errorf("OTLP collector returned %v: %s", response.Status, bytes.TrimSpace(body)),
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		err.retryable = true
+		err.retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+	}
+	return err
+}
+
+func grpcResult(response *http.Response) error {
+	if response.StatusCode != http.StatusOK {
+		return &exportError{
+			err:       fmt.Errorf("OTLP gRPC server returned HTTP %v", response.Status),
+			retryable: response.StatusCode == http.StatusServiceUnavailable,
+		}
+	}
+	// The status is a trailer, or a header for responses without a body
+	status := response.Trailer.Get("Grpc-Status")
+	message := response.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = response.Header.Get("Grpc-Status")
+		message = response.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return &exportError{err: fmt.Errorf("OTLP gRPC response has no valid grpc-status: %q", status)}
+	}
+	if code == 0 {
+		return nil
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	return &exportError{
+		err:       fmt.Errorf("OTLP gRPC export failed with code %v: %v", code, message),
+		retryable: retryableGrpcCodes[code],
+	}
+}
+
+// Parse Retry-After as seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// Stop retrying and fail any export in progress
+func (self *Exporter) Close() error {
+	self.closeOnce.Do(func() {
+		close(self.closed)
+	})
+	self.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,302 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func testEvents() []*winlog.WinLogEvent {
+	created := time.Date(2016, 1, 19, 19, 37, 48, 123456789, time.UTC)
+	return []*winlog.WinLogEvent{
+		{
+			ProviderName:      "Microsoft-Windows-Security-Auditing",
+			EventId:           4624,
+			Created:           created,
+			RecordId:          10811,
+			ProcessId:         560,
+			ThreadId:          4884,
+			Channel:           "Security",
+			ComputerName:      "WIN-HOST",
+			Msg:               "An account was successfully logged on.\r\n",
+			Keywords:          []string{"Audit Success"},
+			EventData:         []winlog.EventDataField{{Name: "TargetUserName", Value: "alice"}, {Value: "unnamed"}},
+			Bookmark:          "<BookmarkList>1</BookmarkList>",
+			SubscribedChannel: "Security",
+		},
+		{
+			ProviderName:      "Application Error",
+			EventId:           1000,
+			Level:             2,
+			Created:           created,
+			RecordId:          5,
+			Channel:           "Application",
+			ComputerName:      "OTHER-HOST",
+			Bookmark:          "<BookmarkList>2</BookmarkList>",
+			SubscribedChannel: "Application",
+		},
+	}
+}
+
+// Find an attribute by key
+func attribute(attributes []keyValue, key string) *anyValue {
+	for i := range attributes {
+		if attributes[i].Key == key {
+			return &attributes[i].Value
+		}
+	}
+	return nil
+}
+
+func TestConvertEvent(t *T) {
+	observed := time.Unix(1500000000, 0)
+	request := buildRequest(testEvents(), "1.0", observed)
+	assertEqual(len(request.ResourceLogs), 2, t)
+	resource := request.ResourceLogs[0]
+	assertEqual(*attribute(resource.Resource.Attributes, "host.name").StringValue, "WIN-HOST", t)
+	assertEqual(resource.ScopeLogs[0].Scope.Name, ScopeName, t)
+
+	record := resource.ScopeLogs[0].LogRecords[0]
+	assertEqual(record.TimeUnixNano, uint64(1453232268123456789), t)
+	assertEqual(record.ObservedTimeUnixNano, uint64(observed.UnixNano()), t)
+	assertEqual(record.SeverityNumber, SeverityInfo, t)
+	assertEqual(record.SeverityText, "INFO", t)
+	assertEqual(*record.Body.StringValue, "An account was successfully logged on.", t)
+	assertEqual(record.EventName, "Microsoft-Windows-Security-Auditing/4624", t)
+	assertEqual(*attribute(record.Attributes, "winlog.event_id").IntValue, int64(4624), t)
+	assertEqual(*attribute(record.Attributes, "winlog.channel").StringValue, "Security", t)
+	assertEqual(*attribute(record.Attributes, "process.pid").IntValue, int64(560), t)
+	assertEqual(*attribute(record.Attributes, "winlog.keywords").ArrayValue.Values[0].StringValue, "Audit Success", t)
+	assertEqual(*attribute(record.Attributes, "winlog.event_data.TargetUserName").StringValue, "alice", t)
+	assertEqual(*attribute(record.Attributes, "winlog.event_data.param2").StringValue, "unnamed", t)
+
+	record = request.ResourceLogs[1].ScopeLogs[0].LogRecords[0]
+	assertEqual(record.SeverityNumber, SeverityError, t)
+	if record.Body != nil || attribute(record.Attributes, "process.pid") != nil {
+		t.Fatal("Empty fields were converted")
+	}
+}
+
+func TestConvertEventPayloadFromXml(t *T) {
+	// Rendered with the Xml but not the EventData
+	event := &winlog.WinLogEvent{
+		ProviderName: "Microsoft-Windows-Security-Auditing",
+		EventId:      4624,
+		Xml:          `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><EventID>4624</EventID></System><EventData><Data Name='TargetUserName'>alice</Data><Data>unnamed</Data></EventData></Event>`,
+	}
+	record := convertEvent(event, time.Unix(1500000000, 0))
+	assertEqual(*attribute(record.Attributes, "winlog.event_data.TargetUserName").StringValue, "alice", t)
+	assertEqual(*attribute(record.Attributes, "winlog.event_data.param2").StringValue, "unnamed", t)
+}
+
+// A decoded protobuf message: the values of each field, as varints or bytes
+type protoMessage map[int][]interface{}
+
+func decodeProto(data []byte, t *T) protoMessage {
+	message := protoMessage{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		data = data[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case wireVarint:
+			value, n := binary.Uvarint(data)
+			message[field] = append(message[field], value)
+			data = data[n:]
+		case wireFixed64:
+			message[field] = append(message[field], binary.LittleEndian.Uint64(data))
+			data = data[8:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			data = data[n:]
+			message[field] = append(message[field], data[:length])
+			data = data[length:]
+		default:
+			t.Fatalf("Unexpected wire type %v", tag&7)
+		}
+	}
+	return message
+}
+
+func (self protoMessage) message(t *T, fields ...int) protoMessage {
+	message := self
+	for _, field := range fields {
+		message = decodeProto(message[field][0].([]byte), t)
+	}
+	return message
+}
+
+func TestProtobufEncoding(t *T) {
+	request := buildRequest(testEvents()[:1], "", time.Unix(1, 0))
+	decoded := decodeProto(request.marshal(nil), t)
+	// ExportLogsServiceRequest.resource_logs.scope_logs.log_records
+	record := decoded.message(t, 1, 2, 2)
+	assertEqual(record[1][0], uint64(1453232268123456789), t)
+	assertEqual(record[2][0], uint64(SeverityInfo), t)
+	assertEqual(string(record[3][0].([]byte)), "INFO", t)
+	assertEqual(string(record.message(t, 5)[1][0].([]byte)), "An account was successfully logged on.", t)
+	assertEqual(record[11][0], uint64(1e9), t)
+	// The first attribute is winlog.channel, and the third winlog.event_id
+	attr := decodeProto(record[6][0].([]byte), t)
+	assertEqual(string(attr[1][0].([]byte)), "winlog.channel", t)
+	assertEqual(string(attr.message(t, 2)[1][0].([]byte)), "Security", t)
+	attr = decodeProto(record[6][2].([]byte), t)
+	assertEqual(attr.message(t, 2)[3][0], uint64(4624), t)
+}
+
+func TestExportHTTPJSON(t *T) {
+	var received exportLogsRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertEqual(r.URL.Path, "/v1/logs", t)
+		assertEqual(r.Header.Get("Content-Type"), "application/json", t)
+		assertEqual(r.Header.Get("Authorization"), "Bearer token", t)
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	store := winlog.NewMemoryBookmarkStore()
+	exporter, err := NewExporter(Config{
+		Endpoint:  server.URL + "/v1/logs",
+		Protocol:  ProtocolHTTPJSON,
+		Headers:   map[string]string{"Authorization": "Bearer token"},
+		Bookmarks: store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	events := make(chan *winlog.WinLogEvent, 2)
+	for _, event := range testEvents() {
+		events <- event
+	}
+	close(events)
+	if err := exporter.Run(events); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(len(received.ResourceLogs), 2, t)
+	record := received.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	assertEqual(record.TimeUnixNano, uint64(1453232268123456789), t)
+	assertEqual(*attribute(record.Attributes, "winlog.record_id").IntValue, int64(10811), t)
+	bookmark, _ := store.Load("Application")
+	assertEqual(bookmark, "<BookmarkList>2</BookmarkList>", t)
+}
+
+func TestExportRetries(t *T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertEqual(r.Header.Get("Content-Type"), "application/x-protobuf", t)
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}))
+	defer server.Close()
+
+	store := winlog.NewMemoryBookmarkStore()
+	exporter, err := NewExporter(Config{Endpoint: server.URL, Bookmarks: store, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	if err := exporter.Export(testEvents()); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(atomic.LoadInt32(&requests), int32(2), t)
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "<BookmarkList>1</BookmarkList>", t)
+}
+
+func TestExportPermanentFailure(t *T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	store := winlog.NewMemoryBookmarkStore()
+	exporter, err := NewExporter(Config{Endpoint: server.URL, Bookmarks: store, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	if err := exporter.Export(testEvents()); err == nil {
+		t.Fatal("No error for a rejected batch")
+	}
+	// 400 isn't retryable, and the bookmark isn't saved
+	assertEqual(atomic.LoadInt32(&requests), int32(1), t)
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "", t)
+}
+
+// An OTLP/gRPC receiver over HTTP/2 without TLS, returning the given status
+func grpcServer(status string, received chan<- protoMessage, t *T) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertEqual(r.ProtoMajor, 2, t)
+		assertEqual(r.URL.Path, grpcExportPath, t)
+		assertEqual(r.Header.Get("Content-Type"), "application/grpc", t)
+		body, _ := io.ReadAll(r.Body)
+		length := binary.BigEndian.Uint32(body[1:5])
+		assertEqual(int(length), len(body)-5, t)
+		received <- decodeProto(body[5:], t)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", status)
+		w.Header().Set("Grpc-Message", "not%20now")
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	return server
+}
+
+func TestExportGRPC(t *T) {
+	received := make(chan protoMessage, 1)
+	server := grpcServer("0", received, t)
+	defer server.Close()
+	exporter, err := NewExporter(Config{Endpoint: server.URL, Protocol: ProtocolGRPC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	if err := exporter.Export(testEvents()); err != nil {
+		t.Fatal(err)
+	}
+	request := <-received
+	assertEqual(len(request[1]), 2, t)
+}
+
+func TestExportGRPCError(t *T) {
+	received := make(chan protoMessage, 10)
+	server := grpcServer("3", received, t)
+	defer server.Close()
+	exporter, err := NewExporter(Config{Endpoint: server.URL, Protocol: ProtocolGRPC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	err = exporter.Export(testEvents())
+	if err == nil {
+		t.Fatal("No error for INVALID_ARGUMENT")
+	}
+	assertEqual(err.Error(), "OTLP gRPC export failed with code 3: not now", t)
+	assertEqual(len(received), 1, t)
+}
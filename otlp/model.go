@@ -0,0 +1,205 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The OTLP logs data model, as the subset of ExportLogsServiceRequest that
+// events use. Each type encodes to the OTLP JSON mapping with encoding/json,
+// and to protobuf with its marshal method.
+
+type exportLogsRequest struct {
+	ResourceLogs []resourceLogs `json:"resourceLogs"`
+}
+
+type resourceLogs struct {
+	Resource  resource    `json:"resource"`
+	ScopeLogs []scopeLogs `json:"scopeLogs"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeLogs struct {
+	Scope      instrumentationScope `json:"scope"`
+	LogRecords []logRecord          `json:"logRecords"`
+}
+
+type instrumentationScope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type logRecord struct {
+	TimeUnixNano         uint64     `json:"timeUnixNano,string,omitempty"`
+	ObservedTimeUnixNano uint64     `json:"observedTimeUnixNano,string,omitempty"`
+	SeverityNumber       int        `json:"severityNumber,omitempty"`
+	SeverityText         string     `json:"severityText,omitempty"`
+	Body                 *anyValue  `json:"body,omitempty"`
+	Attributes           []keyValue `json:"attributes,omitempty"`
+	EventName            string     `json:"eventName,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	IntValue    *int64      `json:"intValue,string,omitempty"`
+	DoubleValue *float64    `json:"doubleValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	ArrayValue  *arrayValue `json:"arrayValue,omitempty"`
+}
+
+type arrayValue struct {
+	Values []anyValue `json:"values"`
+}
+
+func stringValue(value string) anyValue {
+	return anyValue{StringValue: &value}
+}
+
+func intValue(value int64) anyValue {
+	return anyValue{IntValue: &value}
+}
+
+func stringAttribute(key, value string) keyValue {
+	return keyValue{key, stringValue(value)}
+}
+
+func intAttribute(key string, value uint64) keyValue {
+	return keyValue{key, intValue(int64(value))}
+}
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendTag(buf []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(buf, uint64(field<<3|wireType))
+}
+
+func appendVarintField(buf []byte, field int, value uint64) []byte {
+	buf = appendTag(buf, field, wireVarint)
+	return binary.AppendUvarint(buf, value)
+}
+
+func appendFixed64Field(buf []byte, field int, value uint64) []byte {
+	buf = appendTag(buf, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(buf, value)
+}
+
+func appendStringField(buf []byte, field int, value string) []byte {
+	buf = appendTag(buf, field, wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// Append an embedded message, encoded by marshal
+func appendMessageField(buf []byte, field int, marshal func([]byte) []byte) []byte {
+	return appendStringField(buf, field, string(marshal(nil)))
+}
+
+func (self *exportLogsRequest) marshal(buf []byte) []byte {
+	for i := range self.ResourceLogs {
+		buf = appendMessageField(buf, 1, self.ResourceLogs[i].marshal)
+	}
+	return buf
+}
+
+func (self *resourceLogs) marshal(buf []byte) []byte {
+	buf = appendMessageField(buf, 1, self.Resource.marshal)
+	for i := range self.ScopeLogs {
+		buf = appendMessageField(buf, 2, self.ScopeLogs[i].marshal)
+	}
+	return buf
+}
+
+func (self *resource) marshal(buf []byte) []byte {
+	for i := range self.Attributes {
+		buf = appendMessageField(buf, 1, self.Attributes[i].marshal)
+	}
+	return buf
+}
+
+func (self *scopeLogs) marshal(buf []byte) []byte {
+	buf = appendMessageField(buf, 1, self.Scope.marshal)
+	for i := range self.LogRecords {
+		buf = appendMessageField(buf, 2, self.LogRecords[i].marshal)
+	}
+	return buf
+}
+
+func (self *instrumentationScope) marshal(buf []byte) []byte {
+	if self.Name != "" {
+		buf = appendStringField(buf, 1, self.Name)
+	}
+	if self.Version != "" {
+		buf = appendStringField(buf, 2, self.Version)
+	}
+	return buf
+}
+
+func (self *logRecord) marshal(buf []byte) []byte {
+	if self.TimeUnixNano != 0 {
+		buf = appendFixed64Field(buf, 1, self.TimeUnixNano)
+	}
+	if self.SeverityNumber != 0 {
+		buf = appendVarintField(buf, 2, uint64(self.SeverityNumber))
+	}
+	if self.SeverityText != "" {
+		buf = appendStringField(buf, 3, self.SeverityText)
+	}
+	if self.Body != nil {
+		buf = appendMessageField(buf, 5, self.Body.marshal)
+	}
+	for i := range self.Attributes {
+		buf = appendMessageField(buf, 6, self.Attributes[i].marshal)
+	}
+	if self.ObservedTimeUnixNano != 0 {
+		buf = appendFixed64Field(buf, 11, self.ObservedTimeUnixNano)
+	}
+	if self.EventName != "" {
+		buf = appendStringField(buf, 12, self.EventName)
+	}
+	return buf
+}
+
+func (self *keyValue) marshal(buf []byte) []byte {
+	buf = appendStringField(buf, 1, self.Key)
+	return appendMessageField(buf, 2, self.Value.marshal)
+}
+
+func (self *anyValue) marshal(buf []byte) []byte {
+	switch {
+	case self.StringValue != nil:
+		return appendStringField(buf, 1, *self.StringValue)
+	case self.BoolValue != nil:
+		value := uint64(0)
+		if *self.BoolValue {
+			value = 1
+		}
+		return appendVarintField(buf, 2, value)
+	case self.IntValue != nil:
+		return appendVarintField(buf, 3, uint64(*self.IntValue))
+	case self.DoubleValue != nil:
+		return appendFixed64Field(buf, 4, math.Float64bits(*self.DoubleValue))
+	case self.ArrayValue != nil:
+		return appendMessageField(buf, 5, self.ArrayValue.marshal)
+	}
+	return buf
+}
+
+func (self *arrayValue) marshal(buf []byte) []byte {
+	for i := range self.Values {
+		buf = appendMessageField(buf, 1, self.Values[i].marshal)
+	}
+	return buf
+}
@@ -0,0 +1,154 @@
+package winlog
+
+import (
+	"time"
+)
+
+// A set of System properties to include in each WinLogEvent
+type SystemFields uint32
+
+const (
+	SystemFieldProviderName SystemFields = 1 << iota
+	SystemFieldEventId
+	SystemFieldQualifiers
+	SystemFieldLevel
+	SystemFieldTask
+	SystemFieldOpcode
+	SystemFieldCreated
+	SystemFieldRecordId
+	SystemFieldProcessId
+	SystemFieldThreadId
+	SystemFieldChannel
+	SystemFieldComputerName
+	SystemFieldVersion
+
+	AllSystemFields = SystemFieldVersion<<1 - 1
+)
+
+// Whether all the fields in `fields` are in the set
+func (self SystemFields) Has(fields SystemFields) bool {
+	return self&fields == fields
+}
+
+// Zero any System properties of the event which aren't in the set
+func (self SystemFields) filter(event *WinLogEvent) {
+	if !self.Has(SystemFieldProviderName) {
+		event.ProviderName = ""
+	}
+	if !self.Has(SystemFieldEventId) {
+		event.EventId = 0
+	}
+	if !self.Has(SystemFieldQualifiers) {
+		event.Qualifiers = 0
+	}
+	if !self.Has(SystemFieldLevel) {
+		event.Level = 0
+	}
+	if !self.Has(SystemFieldTask) {
+		event.Task = 0
+	}
+	if !self.Has(SystemFieldOpcode) {
+		event.Opcode = 0
+	}
+	if !self.Has(SystemFieldCreated) {
+		event.Created = time.Time{}
+	}
+	if !self.Has(SystemFieldRecordId) {
+		event.RecordId = 0
+	}
+	if !self.Has(SystemFieldProcessId) {
+		event.ProcessId = 0
+	}
+	if !self.Has(SystemFieldThreadId) {
+		event.ThreadId = 0
+	}
+	if !self.Has(SystemFieldChannel) {
+		event.Channel = ""
+	}
+	if !self.Has(SystemFieldComputerName) {
+		event.ComputerName = ""
+	}
+	if !self.Has(SystemFieldVersion) {
+		event.Version = 0
+	}
+}
+
+// Controls which parts of each event are rendered. Rendering the localized fields
+// (each a call to EvtFormatMessage), the XML and the bookmark are the expensive
+// parts of converting an event, so turning off what isn't needed is a big speedup.
+type RenderProfile struct {
+	// System properties from EvtRender
+	SystemFields SystemFields
+
+	// Localized fields from EvtFormatMessage
+	Message      bool
+	LevelText    bool
+	TaskText     bool
+	ProviderText bool
+	OpcodeText   bool
+	ChannelText  bool
+	IdText       bool
+
+	// Include the event XML in the Xml field
+	Xml bool
+	// Extract the payload into the EventData field
+	EventData bool
+
+	// Render the bookmark into every Nth event from each channel. Events in
+	// between have an empty Bookmark. 1 renders it into every event, and 0
+	// never renders it.
+	BookmarkInterval int
+}
+
+var (
+	// Only the System properties, with a bookmark every 100 events
+	RenderProfileMinimal = RenderProfile{
+		SystemFields:     AllSystemFields,
+		BookmarkInterval: 100,
+	}
+
+	// The System properties, common localized fields and the payload, with
+	// a bookmark in every event
+	RenderProfileStandard = RenderProfile{
+		SystemFields:     AllSystemFields,
+		Message:          true,
+		LevelText:        true,
+		TaskText:         true,
+		ProviderText:     true,
+		OpcodeText:       true,
+		ChannelText:      true,
+		Xml:              true,
+		EventData:        true,
+		BookmarkInterval: 1,
+	}
+
+	// Everything, which is the default
+	RenderProfileFull = RenderProfile{
+		SystemFields:     AllSystemFields,
+		Message:          true,
+		LevelText:        true,
+		TaskText:         true,
+		ProviderText:     true,
+		OpcodeText:       true,
+		ChannelText:      true,
+		IdText:           true,
+		Xml:              true,
+		EventData:        true,
+		BookmarkInterval: 1,
+	}
+)
+
+// Whether any EvtFormatMessage fields are enabled
+func (self RenderProfile) localized() bool {
+	return self.Message || self.LevelText || self.TaskText || self.ProviderText ||
+		self.OpcodeText || self.ChannelText || self.IdText
+}
+
+// Whether the bookmark should be rendered into the nth event (counting from 1)
+// since the subscription started.
+func (self RenderProfile) renderBookmark(n uint64) bool {
+	if self.BookmarkInterval <= 0 {
+		return false
+	}
+	return n%uint64(self.BookmarkInterval) == 0
+}
@@ -0,0 +1,56 @@
+package winlog
+
+import (
+	. "testing"
+	"time"
+)
+
+func TestSystemFieldsFilter(t *T) {
+	event := &WinLogEvent{
+		ProviderName: "provider",
+		EventId:      4624,
+		Level:        4,
+		Created:      time.Unix(1453232268, 0),
+		RecordId:     10811,
+		Channel:      "Security",
+		ComputerName: "host",
+	}
+	(SystemFieldEventId | SystemFieldRecordId | SystemFieldChannel).filter(event)
+	assertEqual(event.ProviderName, "", t)
+	assertEqual(event.EventId, uint64(4624), t)
+	assertEqual(event.Level, uint64(0), t)
+	assertEqual(event.Created.IsZero(), true, t)
+	assertEqual(event.RecordId, uint64(10811), t)
+	assertEqual(event.Channel, "Security", t)
+	assertEqual(event.ComputerName, "", t)
+
+	event.ComputerName = "host"
+	AllSystemFields.filter(event)
+	assertEqual(event.ComputerName, "host", t)
+}
+
+func TestSystemFieldsHas(t *T) {
+	assertEqual(AllSystemFields.Has(SystemFieldVersion|SystemFieldProviderName), true, t)
+	assertEqual(SystemFieldLevel.Has(SystemFieldLevel|SystemFieldTask), false, t)
+}
+
+func TestRenderProfileBookmarkInterval(t *T) {
+	profile := RenderProfile{BookmarkInterval: 3}
+	var rendered []uint64
+	for n := uint64(1); n <= 9; n++ {
+		if profile.renderBookmark(n) {
+			rendered = append(rendered, n)
+		}
+	}
+	assertEqual(len(rendered), 3, t)
+	assertEqual(rendered[0], uint64(3), t)
+	assertEqual(RenderProfileFull.renderBookmark(1), true, t)
+	assertEqual(RenderProfile{}.renderBookmark(1), false, t)
+}
+
+func TestRenderProfilePresets(t *T) {
+	assertEqual(RenderProfileMinimal.localized(), false, t)
+	assertEqual(RenderProfileStandard.localized(), true, t)
+	assertEqual(RenderProfileStandard.IdText, false, t)
+	assertEqual(RenderProfileFull.IdText, true, t)
+}
@@ -0,0 +1,193 @@
+// +build windows
+
+// Stub functions for reading publisher metadata. Properties are returned as
+// heap-allocated EVT_VARIANTs, so they can be decoded with the same accessors
+// as rendered event values.
+
+#include "publisher.h"
+
+ULONGLONG OpenPublisherMetadata(char* publisher) {
+	size_t widePublisherLen = mbstowcs(NULL, publisher, 0) + 1;
+	LPWSTR lPublisher = malloc(widePublisherLen * sizeof(wchar_t));
+	if (!lPublisher) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return 0;
+	}
+	mbstowcs(lPublisher, publisher, widePublisherLen);
+	EVT_HANDLE hPublisher = EvtOpenPublisherMetadata(NULL, lPublisher, NULL, 0, 0);
+	free(lPublisher);
+	return (ULONGLONG)hPublisher;
+}
+
+PVOID GetPublisherMetadataProperty(ULONGLONG hPublisher, int propertyId) {
+	DWORD dwUsed = 0;
+	EvtGetPublisherMetadataProperty((EVT_HANDLE)hPublisher, propertyId, 0, 0, NULL, &dwUsed);
+	if (GetLastError() != ERROR_INSUFFICIENT_BUFFER) {
+		return NULL;
+	}
+	PEVT_VARIANT pProperty = malloc(dwUsed);
+	if (!pProperty) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	if (!EvtGetPublisherMetadataProperty((EVT_HANDLE)hPublisher, propertyId, 0, dwUsed, pProperty, &dwUsed)) {
+		free(pProperty);
+		return NULL;
+	}
+	return pProperty;
+}
+
+int GetObjectArraySize(ULONGLONG hArray) {
+	DWORD dwSize = 0;
+	if (!EvtGetObjectArraySize((EVT_OBJECT_ARRAY_PROPERTY_HANDLE)hArray, &dwSize)) {
+		return -1;
+	}
+	return (int)dwSize;
+}
+
+PVOID GetObjectArrayProperty(ULONGLONG hArray, int propertyId, int index) {
+	DWORD dwUsed = 0;
+	EvtGetObjectArrayProperty((EVT_OBJECT_ARRAY_PROPERTY_HANDLE)hArray, propertyId, index, 0, 0, NULL, &dwUsed);
+	if (GetLastError() != ERROR_INSUFFICIENT_BUFFER) {
+		return NULL;
+	}
+	PEVT_VARIANT pProperty = malloc(dwUsed);
+	if (!pProperty) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	if (!EvtGetObjectArrayProperty((EVT_OBJECT_ARRAY_PROPERTY_HANDLE)hArray, propertyId, index, 0, dwUsed, pProperty, &dwUsed)) {
+		free(pProperty);
+		return NULL;
+	}
+	return pProperty;
+}
+
+ULONGLONG OpenEventMetadataEnum(ULONGLONG hPublisher) {
+	return (ULONGLONG)EvtOpenEventMetadataEnum((EVT_HANDLE)hPublisher, 0);
+}
+
+ULONGLONG NextEventMetadata(ULONGLONG hEnum) {
+	return (ULONGLONG)EvtNextEventMetadata((EVT_HANDLE)hEnum, 0);
+}
+
+PVOID GetEventMetadataProperty(ULONGLONG hEvent, int propertyId) {
+	DWORD dwUsed = 0;
+	EvtGetEventMetadataProperty((EVT_HANDLE)hEvent, propertyId, 0, 0, NULL, &dwUsed);
+	if (GetLastError() != ERROR_INSUFFICIENT_BUFFER) {
+		return NULL;
+	}
+	PEVT_VARIANT pProperty = malloc(dwUsed);
+	if (!pProperty) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	if (!EvtGetEventMetadataProperty((EVT_HANDLE)hEvent, propertyId, 0, dwUsed, pProperty, &dwUsed)) {
+		free(pProperty);
+		return NULL;
+	}
+	return pProperty;
+}
+
+ULONGLONG GetRenderedHandleValue(PVOID pRenderedValues, int property) {
+	return (ULONGLONG)((PEVT_VARIANT)pRenderedValues)[property].EvtHandleVal;
+}
+
+ULONGLONG OpenPublisherEnum() {
+	return (ULONGLONG)EvtOpenPublisherEnum(NULL, 0);
+}
+
+char* NextPublisherId(ULONGLONG hEnum, int* done) {
+	DWORD dwUsed = 0;
+	*done = 0;
+	if (!EvtNextPublisherId((EVT_HANDLE)hEnum, 0, NULL, &dwUsed)) {
+		DWORD status = GetLastError();
+		if (status == ERROR_NO_MORE_ITEMS) {
+			*done = 1;
+			return NULL;
+		}
+		if (status != ERROR_INSUFFICIENT_BUFFER) {
+			return NULL;
+		}
+	}
+	LPWSTR publisherWide = malloc(dwUsed * sizeof(wchar_t));
+	if (!publisherWide) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	if (!EvtNextPublisherId((EVT_HANDLE)hEnum, dwUsed, publisherWide, &dwUsed)) {
+		free(publisherWide);
+		return NULL;
+	}
+	size_t lenPublisher = wcstombs(NULL, publisherWide, 0) + 1;
+	char* publisher = malloc(lenPublisher);
+	if (!publisher) {
+		free(publisherWide);
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	wcstombs(publisher, publisherWide, lenPublisher);
+	free(publisherWide);
+	return publisher;
+}
+
+char* GetRenderedGuidValue(PVOID pRenderedValues, int property) {
+	GUID* guid = ((PEVT_VARIANT)pRenderedValues)[property].GuidVal;
+	// "{" + 36 characters + "}" + NUL
+	char* value = malloc(39);
+	if (!value) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	snprintf(value, 39, "{%08lX-%04X-%04X-%02X%02X-%02X%02X%02X%02X%02X%02X}",
+		(unsigned long)guid->Data1, (unsigned int)guid->Data2, (unsigned int)guid->Data3,
+		(unsigned int)guid->Data4[0], (unsigned int)guid->Data4[1], (unsigned int)guid->Data4[2], (unsigned int)guid->Data4[3],
+		(unsigned int)guid->Data4[4], (unsigned int)guid->Data4[5], (unsigned int)guid->Data4[6], (unsigned int)guid->Data4[7]);
+	return value;
+}
+
+PVOID LoadMessageTable(char* path, DWORD* size, ULONGLONG* module) {
+	size_t widePathLen = mbstowcs(NULL, path, 0) + 1;
+	LPWSTR lPath = malloc(widePathLen * sizeof(wchar_t));
+	if (!lPath) {
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	mbstowcs(lPath, path, widePathLen);
+	DWORD expandedLen = ExpandEnvironmentStringsW(lPath, NULL, 0);
+	if (expandedLen == 0) {
+		free(lPath);
+		return NULL;
+	}
+	LPWSTR lExpanded = malloc(expandedLen * sizeof(wchar_t));
+	if (!lExpanded) {
+		free(lPath);
+		SetLastError(ERROR_NOT_ENOUGH_MEMORY);
+		return NULL;
+	}
+	ExpandEnvironmentStringsW(lPath, lExpanded, expandedLen);
+	free(lPath);
+	HMODULE hModule = LoadLibraryExW(lExpanded, NULL, LOAD_LIBRARY_AS_DATAFILE | LOAD_LIBRARY_AS_IMAGE_RESOURCE);
+	free(lExpanded);
+	if (!hModule) {
+		return NULL;
+	}
+	HRSRC hResource = FindResourceW(hModule, MAKEINTRESOURCEW(1), MAKEINTRESOURCEW(11));
+	if (!hResource) {
+		FreeLibrary(hModule);
+		return NULL;
+	}
+	*size = SizeofResource(hModule, hResource);
+	HGLOBAL hData = LoadResource(hModule, hResource);
+	PVOID data = hData ? LockResource(hData) : NULL;
+	if (!data) {
+		FreeLibrary(hModule);
+		return NULL;
+	}
+	*module = (ULONGLONG)hModule;
+	return data;
+}
+
+void FreeMessageModule(ULONGLONG module) {
+	FreeLibrary((HMODULE)module);
+}
@@ -0,0 +1,456 @@
+// +build windows
+
+package winlog
+
+/*
+#cgo LDFLAGS: -l wevtapi
+#include "publisher.h"
+*/
+import "C"
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+/* Properties that can be read with GetPublisherMetadataProperty and GetObjectArrayProperty */
+type EVT_PUBLISHER_METADATA_PROPERTY_ID int
+
+const (
+	EvtPublisherMetadataPublisherGuid = iota
+	EvtPublisherMetadataResourceFilePath
+	EvtPublisherMetadataParameterFilePath
+	EvtPublisherMetadataMessageFilePath
+	EvtPublisherMetadataHelpLink
+	EvtPublisherMetadataPublisherMessageID
+	EvtPublisherMetadataChannelReferences
+	EvtPublisherMetadataChannelReferencePath
+	EvtPublisherMetadataChannelReferenceIndex
+	EvtPublisherMetadataChannelReferenceID
+	EvtPublisherMetadataChannelReferenceFlags
+	EvtPublisherMetadataChannelReferenceMessageID
+	EvtPublisherMetadataLevels
+	EvtPublisherMetadataLevelName
+	EvtPublisherMetadataLevelValue
+	EvtPublisherMetadataLevelMessageID
+	EvtPublisherMetadataTasks
+	EvtPublisherMetadataTaskName
+	EvtPublisherMetadataTaskEventGuid
+	EvtPublisherMetadataTaskValue
+	EvtPublisherMetadataTaskMessageID
+	EvtPublisherMetadataOpcodes
+	EvtPublisherMetadataOpcodeName
+	EvtPublisherMetadataOpcodeValue
+	EvtPublisherMetadataOpcodeMessageID
+	EvtPublisherMetadataKeywords
+	EvtPublisherMetadataKeywordName
+	EvtPublisherMetadataKeywordValue
+	EvtPublisherMetadataKeywordMessageID
+)
+
+/* Properties that can be read with GetEventMetadataProperty */
+type EVT_EVENT_METADATA_PROPERTY_ID int
+
+const (
+	EventMetadataEventID = iota
+	EventMetadataEventVersion
+	EventMetadataEventChannel
+	EventMetadataEventLevel
+	EventMetadataEventOpcode
+	EventMetadataEventTask
+	EventMetadataEventKeyword
+	EventMetadataEventMessageID
+	EventMetadataEventTemplate
+)
+
+// Message IDs are -1 if the metadata has no message
+const noMessageId = 0xFFFFFFFF
+
+// Get an enumerator over the publishers registered on the local computer.
+// The resulting handle must be closed with CloseEventHandle.
+func OpenPublisherEnum() (PublisherEnumHandle, error) {
+	handle := PublisherEnumHandle(C.OpenPublisherEnum())
+	if handle == 0 {
+		return 0, GetLastError()
+	}
+	return handle, nil
+}
+
+// Get the next publisher name from the enumerator. Returns false when there
+// are no more.
+func NextPublisherId(enumHandle PublisherEnumHandle) (string, bool, error) {
+	var done C.int
+	cString := C.NextPublisherId(C.ULONGLONG(enumHandle), &done)
+	if done != 0 {
+		return "", false, nil
+	}
+	if cString == nil {
+		return "", false, GetLastError()
+	}
+	value := C.GoString(cString)
+	C.free(unsafe.Pointer(cString))
+	return value, true, nil
+}
+
+// The names of every publisher registered on the local computer, which can
+// be passed to ExportProviderManifest.
+func ListPublishers() ([]string, error) {
+	enumHandle, err := OpenPublisherEnum()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to enumerate publishers: %v", err)
+	}
+	defer CloseEventHandle(uint64(enumHandle))
+	var publishers []string
+	for {
+		publisher, ok, err := NextPublisherId(enumHandle)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to enumerate publishers: %v", err)
+		}
+		if !ok {
+			return publishers, nil
+		}
+		publishers = append(publishers, publisher)
+	}
+}
+
+// Open the metadata for the named publisher. The resulting handle must be closed
+// with CloseEventHandle.
+func OpenPublisherMetadata(publisher string) (PublisherHandle, error) {
+	cPublisher := C.CString(publisher)
+	handle := PublisherHandle(C.OpenPublisherMetadata(cPublisher))
+	C.free(unsafe.Pointer(cPublisher))
+	if handle == 0 {
+		return 0, GetLastError()
+	}
+	return handle, nil
+}
+
+// Load the messages in a provider's message or parameter file, such as the
+// publisher's EvtPublisherMetadataParameterFilePath. The path may list several
+// files separated by semicolons, and may contain environment variables.
+func LoadMessageFile(path string) (map[uint64]string, error) {
+	messages := make(map[uint64]string)
+	for _, file := range strings.Split(path, ";") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		cFile := C.CString(file)
+		var size C.DWORD
+		var module C.ULONGLONG
+		data := C.LoadMessageTable(cFile, &size, &module)
+		C.free(unsafe.Pointer(cFile))
+		if data == nil {
+			return nil, fmt.Errorf("Failed to load the message table from %q: %v", file, GetLastError())
+		}
+		table, err := ParseMessageTable(C.GoBytes(unsafe.Pointer(data), C.int(size)))
+		C.FreeMessageModule(module)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse the message table from %q: %v", file, err)
+		}
+		for id, message := range table {
+			messages[id] = message
+		}
+	}
+	return messages, nil
+}
+
+// Load the parameter messages (%%n) of the publisher from its parameter file.
+// Returns an error if the publisher has no parameter file.
+func LoadPublisherParameters(publisherHandle PublisherHandle) (map[uint64]string, error) {
+	path := publisherStringProperty(publisherHandle, EvtPublisherMetadataParameterFilePath)
+	if path == "" {
+		return nil, fmt.Errorf("Publisher has no parameter file")
+	}
+	return LoadMessageFile(path)
+}
+
+// Get a property of the publisher. The result holds a single value at index 0,
+// and must be freed after use.
+func GetPublisherMetadataProperty(publisherHandle PublisherHandle, property EVT_PUBLISHER_METADATA_PROPERTY_ID) (RenderedFields, error) {
+	value := RenderedFields(C.GetPublisherMetadataProperty(C.ULONGLONG(publisherHandle), C.int(property)))
+	if value == nil {
+		return nil, GetLastError()
+	}
+	return value, nil
+}
+
+// Get the number of elements in an array property of the publisher, such as
+// EvtPublisherMetadataLevels.
+func GetObjectArraySize(arrayHandle ObjectArrayHandle) (int, error) {
+	size := int(C.GetObjectArraySize(C.ULONGLONG(arrayHandle)))
+	if size < 0 {
+		return 0, GetLastError()
+	}
+	return size, nil
+}
+
+// Get a property of the element at `index` in an array property. The result
+// holds a single value at index 0, and must be freed after use.
+func GetObjectArrayProperty(arrayHandle ObjectArrayHandle, property EVT_PUBLISHER_METADATA_PROPERTY_ID, index int) (RenderedFields, error) {
+	value := RenderedFields(C.GetObjectArrayProperty(C.ULONGLONG(arrayHandle), C.int(property), C.int(index)))
+	if value == nil {
+		return nil, GetLastError()
+	}
+	return value, nil
+}
+
+// Get an enumerator over the events declared by the publisher. The resulting
+// handle must be closed with CloseEventHandle.
+func OpenEventMetadataEnum(publisherHandle PublisherHandle) (EventMetadataEnumHandle, error) {
+	handle := EventMetadataEnumHandle(C.OpenEventMetadataEnum(C.ULONGLONG(publisherHandle)))
+	if handle == 0 {
+		return 0, GetLastError()
+	}
+	return handle, nil
+}
+
+// Get the next event definition from the enumerator, or 0 if there are no
+// more. The resulting handle must be closed with CloseEventHandle.
+func NextEventMetadata(enumHandle EventMetadataEnumHandle) EventMetadataHandle {
+	return EventMetadataHandle(C.NextEventMetadata(C.ULONGLONG(enumHandle)))
+}
+
+// Get a property of an event definition. The result holds a single value at
+// index 0, and must be freed after use.
+func GetEventMetadataProperty(eventHandle EventMetadataHandle, property EVT_EVENT_METADATA_PROPERTY_ID) (RenderedFields, error) {
+	value := RenderedFields(C.GetEventMetadataProperty(C.ULONGLONG(eventHandle), C.int(property)))
+	if value == nil {
+		return nil, GetLastError()
+	}
+	return value, nil
+}
+
+// Get the handle at the given index. Returns false if the type of the field
+// isn't EvtVarTypeEvtHandle.
+func RenderHandleField(fields RenderedFields, fieldIndex EVT_SYSTEM_PROPERTY_ID) (uint64, bool) {
+	if C.GetRenderedValueType(C.PVOID(fields), C.int(fieldIndex)) != EvtVarTypeEvtHandle {
+		return 0, false
+	}
+	return uint64(C.GetRenderedHandleValue(C.PVOID(fields), C.int(fieldIndex))), true
+}
+
+// Get the GUID at the given index, formatted with braces. Returns false if the
+// type of the field isn't EvtVarTypeGuid.
+func RenderGuidField(fields RenderedFields, fieldIndex EVT_SYSTEM_PROPERTY_ID) (string, bool) {
+	if C.GetRenderedValueType(C.PVOID(fields), C.int(fieldIndex)) != EvtVarTypeGuid {
+		return "", false
+	}
+	cString := C.GetRenderedGuidValue(C.PVOID(fields), C.int(fieldIndex))
+	if cString == nil {
+		return "", false
+	}
+	value := C.GoString(cString)
+	C.free(unsafe.Pointer(cString))
+	return value, true
+}
+
+// Read properties of the publisher metadata, ignoring errors.
+func publisherStringProperty(publisherHandle PublisherHandle, property EVT_PUBLISHER_METADATA_PROPERTY_ID) string {
+	value, err := GetPublisherMetadataProperty(publisherHandle, property)
+	if err != nil {
+		return ""
+	}
+	defer Free(unsafe.Pointer(value))
+	str, _ := RenderStringField(value, 0)
+	return str
+}
+
+func publisherGuidProperty(publisherHandle PublisherHandle, property EVT_PUBLISHER_METADATA_PROPERTY_ID) string {
+	value, err := GetPublisherMetadataProperty(publisherHandle, property)
+	if err != nil {
+		return ""
+	}
+	defer Free(unsafe.Pointer(value))
+	guid, _ := RenderGuidField(value, 0)
+	return guid
+}
+
+func publisherUIntProperty(publisherHandle PublisherHandle, property EVT_PUBLISHER_METADATA_PROPERTY_ID) (uint64, bool) {
+	value, err := GetPublisherMetadataProperty(publisherHandle, property)
+	if err != nil {
+		return 0, false
+	}
+	defer Free(unsafe.Pointer(value))
+	return RenderUIntField(value, 0)
+}
+
+func arrayStringProperty(arrayHandle ObjectArrayHandle, property EVT_PUBLISHER_METADATA_PROPERTY_ID, index int) string {
+	value, err := GetObjectArrayProperty(arrayHandle, property, index)
+	if err != nil {
+		return ""
+	}
+	defer Free(unsafe.Pointer(value))
+	str, _ := RenderStringField(value, 0)
+	return str
+}
+
+func arrayUIntProperty(arrayHandle ObjectArrayHandle, property EVT_PUBLISHER_METADATA_PROPERTY_ID, index int) (uint64, bool) {
+	value, err := GetObjectArrayProperty(arrayHandle, property, index)
+	if err != nil {
+		return 0, false
+	}
+	defer Free(unsafe.Pointer(value))
+	return RenderUIntField(value, 0)
+}
+
+func eventUIntProperty(eventHandle EventMetadataHandle, property EVT_EVENT_METADATA_PROPERTY_ID) uint64 {
+	value, err := GetEventMetadataProperty(eventHandle, property)
+	if err != nil {
+		return 0
+	}
+	defer Free(unsafe.Pointer(value))
+	number, _ := RenderUIntField(value, 0)
+	return number
+}
+
+func eventStringProperty(eventHandle EventMetadataHandle, property EVT_EVENT_METADATA_PROPERTY_ID) string {
+	value, err := GetEventMetadataProperty(eventHandle, property)
+	if err != nil {
+		return ""
+	}
+	defer Free(unsafe.Pointer(value))
+	str, _ := RenderStringField(value, 0)
+	return str
+}
+
+// Get the message with the given ID from the publisher, or "" if there isn't one.
+func publisherMessage(publisherHandle PublisherHandle, messageId uint64, ok bool) string {
+	if !ok || messageId == noMessageId {
+		return ""
+	}
+	msg, _ := GetMessageString(publisherHandle, messageId)
+	return msg
+}
+
+// Read one of the publisher's array properties (levels, tasks, opcodes, keywords
+// or channels) as a list of names.
+func exportMetadataNames(publisherHandle PublisherHandle, arrayProperty, nameProperty, valueProperty, messageProperty EVT_PUBLISHER_METADATA_PROPERTY_ID) ([]MetadataName, error) {
+	arrayValue, err := GetPublisherMetadataProperty(publisherHandle, arrayProperty)
+	if err != nil {
+		return nil, err
+	}
+	handle, ok := RenderHandleField(arrayValue, 0)
+	Free(unsafe.Pointer(arrayValue))
+	if !ok {
+		return nil, fmt.Errorf("Publisher metadata property %v is not an array", arrayProperty)
+	}
+	arrayHandle := ObjectArrayHandle(handle)
+	defer CloseEventHandle(uint64(arrayHandle))
+	size, err := GetObjectArraySize(arrayHandle)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]MetadataName, 0, size)
+	for i := 0; i < size; i++ {
+		value, _ := arrayUIntProperty(arrayHandle, valueProperty, i)
+		messageId, ok := arrayUIntProperty(arrayHandle, messageProperty, i)
+		names = append(names, MetadataName{
+			Value:   value,
+			Name:    arrayStringProperty(arrayHandle, nameProperty, i),
+			Message: publisherMessage(publisherHandle, messageId, ok),
+		})
+	}
+	return names, nil
+}
+
+// Read the events declared by the publisher, with their message and payload
+// templates.
+func exportEventDefinitions(publisherHandle PublisherHandle) ([]EventDefinition, error) {
+	enumHandle, err := OpenEventMetadataEnum(publisherHandle)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseEventHandle(uint64(enumHandle))
+	var events []EventDefinition
+	for {
+		eventHandle := NextEventMetadata(enumHandle)
+		if eventHandle == 0 {
+			return events, nil
+		}
+		messageId := eventUIntProperty(eventHandle, EventMetadataEventMessageID)
+		events = append(events, EventDefinition{
+			Id:       eventUIntProperty(eventHandle, EventMetadataEventID) & 0xFFFF,
+			Version:  eventUIntProperty(eventHandle, EventMetadataEventVersion),
+			Channel:  eventUIntProperty(eventHandle, EventMetadataEventChannel),
+			Level:    eventUIntProperty(eventHandle, EventMetadataEventLevel),
+			Task:     eventUIntProperty(eventHandle, EventMetadataEventTask),
+			Opcode:   eventUIntProperty(eventHandle, EventMetadataEventOpcode),
+			Keywords: eventUIntProperty(eventHandle, EventMetadataEventKeyword),
+			Message:  publisherMessage(publisherHandle, messageId, true),
+			Template: eventStringProperty(eventHandle, EventMetadataEventTemplate),
+		})
+		CloseEventHandle(uint64(eventHandle))
+	}
+}
+
+// Read the localized metadata for a provider from the local system, for use
+// with ProviderCatalog on another machine.
+func ExportProviderManifest(provider string) (*ProviderManifest, error) {
+	publisherHandle, err := OpenPublisherMetadata(provider)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open publisher metadata for %q: %v", provider, err)
+	}
+	defer CloseEventHandle(uint64(publisherHandle))
+
+	manifest := &ProviderManifest{
+		Name:              provider,
+		Guid:              publisherGuidProperty(publisherHandle, EvtPublisherMetadataPublisherGuid),
+		ResourceFilePath:  publisherStringProperty(publisherHandle, EvtPublisherMetadataResourceFilePath),
+		MessageFilePath:   publisherStringProperty(publisherHandle, EvtPublisherMetadataMessageFilePath),
+		ParameterFilePath: publisherStringProperty(publisherHandle, EvtPublisherMetadataParameterFilePath),
+		HelpLink:          publisherStringProperty(publisherHandle, EvtPublisherMetadataHelpLink),
+	}
+	displayId, ok := publisherUIntProperty(publisherHandle, EvtPublisherMetadataPublisherMessageID)
+	manifest.DisplayName = publisherMessage(publisherHandle, displayId, ok)
+
+	// Classic providers don't declare all of these, so missing arrays aren't an error
+	manifest.Levels, _ = exportMetadataNames(publisherHandle, EvtPublisherMetadataLevels,
+		EvtPublisherMetadataLevelName, EvtPublisherMetadataLevelValue, EvtPublisherMetadataLevelMessageID)
+	manifest.Tasks, _ = exportMetadataNames(publisherHandle, EvtPublisherMetadataTasks,
+		EvtPublisherMetadataTaskName, EvtPublisherMetadataTaskValue, EvtPublisherMetadataTaskMessageID)
+	manifest.Opcodes, _ = exportMetadataNames(publisherHandle, EvtPublisherMetadataOpcodes,
+		EvtPublisherMetadataOpcodeName, EvtPublisherMetadataOpcodeValue, EvtPublisherMetadataOpcodeMessageID)
+	manifest.Keywords, _ = exportMetadataNames(publisherHandle, EvtPublisherMetadataKeywords,
+		EvtPublisherMetadataKeywordName, EvtPublisherMetadataKeywordValue, EvtPublisherMetadataKeywordMessageID)
+	manifest.Channels, _ = exportMetadataNames(publisherHandle, EvtPublisherMetadataChannelReferences,
+		EvtPublisherMetadataChannelReferencePath, EvtPublisherMetadataChannelReferenceID, EvtPublisherMetadataChannelReferenceMessageID)
+	manifest.Events, _ = exportEventDefinitions(publisherHandle)
+	// Parameter messages (%%n) in the event messages, from the parameter file
+	manifest.Parameters, _ = LoadPublisherParameters(publisherHandle)
+	return manifest, nil
+}
+
+// Export the metadata for each of the given providers into a new catalog.
+func ExportProviderCatalog(providers []string) (*ProviderCatalog, error) {
+	catalog := NewProviderCatalog()
+	for _, provider := range providers {
+		manifest, err := ExportProviderManifest(provider)
+		if err != nil {
+			return nil, err
+		}
+		catalog.Add(manifest)
+	}
+	return catalog, nil
+}
+
+// Export the metadata for every publisher on the local computer into a new
+// catalog. Publishers whose metadata can't be read, such as those whose
+// resource files are missing, are left out and returned with their errors.
+func ExportAllProviders() (*ProviderCatalog, map[string]error, error) {
+	publishers, err := ListPublishers()
+	if err != nil {
+		return nil, nil, err
+	}
+	catalog := NewProviderCatalog()
+	failed := make(map[string]error)
+	for _, publisher := range publishers {
+		manifest, err := ExportProviderManifest(publisher)
+		if err != nil {
+			failed[publisher] = err
+			continue
+		}
+		catalog.Add(manifest)
+	}
+	return catalog, failed, nil
+}
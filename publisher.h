@@ -0,0 +1,59 @@
+#define _WIN32_WINNT 0x0602
+
+#include <windows.h>
+#include "winevt.h"
+#include <stdio.h>
+#include <stdlib.h>
+
+// Open the metadata for a publisher by name. The handle must be closed by the caller.
+ULONGLONG OpenPublisherMetadata(char* publisher);
+
+// Get a property of the publisher metadata. Returns a single EVT_VARIANT
+// which can be read with GetRendered<type>Value at index 0, and must be freed
+// by the caller.
+PVOID GetPublisherMetadataProperty(ULONGLONG hPublisher, int propertyId);
+
+// Get the number of elements in an array property such as EvtPublisherMetadataLevels.
+int GetObjectArraySize(ULONGLONG hArray);
+
+// Get a property of the element at the given index in an array property.
+// Returns a single EVT_VARIANT which must be freed by the caller.
+PVOID GetObjectArrayProperty(ULONGLONG hArray, int propertyId, int index);
+
+// Get an enumerator over the events a publisher declares. The handle must be closed by the caller.
+ULONGLONG OpenEventMetadataEnum(ULONGLONG hPublisher);
+
+// Get the next event from the enumerator, or 0 when there are no more. The
+// handle must be closed by the caller.
+ULONGLONG NextEventMetadata(ULONGLONG hEnum);
+
+// Get a property of an event definition. Returns a single EVT_VARIANT which
+// must be freed by the caller.
+PVOID GetEventMetadataProperty(ULONGLONG hEvent, int propertyId);
+
+// Get the handle value of the variable at the given index.
+ULONGLONG GetRenderedHandleValue(PVOID pRenderedValues, int property);
+
+// Get an enumerator over the publishers registered on the local computer. The
+// handle must be closed by the caller.
+ULONGLONG OpenPublisherEnum();
+
+// Get the next publisher name from the enumerator as a newly allocated
+// string, which must be freed by the caller. Returns NULL on error, or with
+// *done set when there are no more publishers.
+char* NextPublisherId(ULONGLONG hEnum, int* done);
+
+// Format the GUID of the variable at the given index as a newly allocated
+// string, such as "{555908D1-A6D7-4695-8E1E-26931D2012F4}", which must be
+// freed by the caller.
+char* GetRenderedGuidValue(PVOID pRenderedValues, int property);
+
+// Load the RT_MESSAGETABLE resource of a message or parameter file, such as
+// "%SystemRoot%\system32\msobjs.dll", as a datafile. Environment variables in
+// the path are expanded. Returns the resource data and sets *module to the
+// loaded module, which must be freed with FreeMessageModule once the data has
+// been copied. Returns NULL on error.
+PVOID LoadMessageTable(char* path, DWORD* size, ULONGLONG* module);
+
+// Free a module loaded by LoadMessageTable.
+void FreeMessageModule(ULONGLONG module);
@@ -0,0 +1,107 @@
+// +build windows
+
+package winlog
+
+import (
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	. "testing"
+)
+
+const securityProvider = "Microsoft-Windows-Security-Auditing"
+
+func TestExportProviderManifestParameters(t *T) {
+	manifest, err := ExportProviderManifest(securityProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ParameterFilePath == "" {
+		t.Fatal("No parameter file for the Security provider")
+	}
+	// From msobjs.dll
+	assertEqual(strings.TrimRight(manifest.Parameters[1537], "\r\n"), "DELETE", t)
+	assertEqual(strings.TrimRight(manifest.Parameters[4416], "\r\n"), "ReadData (or ListDirectory)", t)
+}
+
+// Render an event with %%n inserts offline, from an exported manifest which
+// has been through a save and load.
+func TestExportedManifestRendersParameters(t *T) {
+	exported, err := ExportProviderManifest(securityProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := NewProviderCatalog()
+	catalog.Add(exported)
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	if err := catalog.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err = LoadProviderCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := catalog.Providers[securityProvider]
+
+	// An attempt was made to access an object
+	var definition *EventDefinition
+	for i := range manifest.Events {
+		if manifest.Events[i].Id == 4663 {
+			definition = &manifest.Events[i]
+		}
+	}
+	if definition == nil {
+		t.Fatal("No definition for event 4663")
+	}
+	fields, err := definition.TemplateFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data strings.Builder
+	for _, field := range fields {
+		value := "value"
+		if field.Name == "AccessList" {
+			value = "%%1537"
+		}
+		data.WriteString("<Data Name='" + field.Name + "'>")
+		xml.EscapeText(&data, []byte(value))
+		data.WriteString("</Data>")
+	}
+	event := &WinLogEvent{
+		ProviderName: securityProvider,
+		EventId:      4663,
+		Version:      definition.Version,
+		Xml:          "<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='" + securityProvider + "'/></System><EventData>" + data.String() + "</EventData></Event>",
+	}
+	if err := catalog.RenderEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(event.Msg, "DELETE") || strings.Contains(event.Msg, "%%1537") {
+		t.Fatalf("Access list not resolved in %q", event.Msg)
+	}
+	assertEqual(event.IdText, event.Msg, t)
+}
+
+func TestExportAllProvidersIncludesParameters(t *T) {
+	publishers, err := ListPublishers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, publisher := range publishers {
+		found = found || publisher == securityProvider
+	}
+	assertEqual(found, true, t)
+
+	catalog, _, err := ExportAllProviders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, ok := catalog.Providers[securityProvider]
+	if !ok {
+		t.Fatal("Security provider not exported")
+	}
+	if len(manifest.Parameters) == 0 {
+		t.Fatal("No parameter messages exported for the Security provider")
+	}
+}
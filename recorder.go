@@ -0,0 +1,154 @@
+package winlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// One line of a recording: an event or an error, and when it was delivered
+type recordingEntry struct {
+	Time  string       `json:"time"`
+	Event *WinLogEvent `json:"event,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// Writes the events and errors delivered by a watcher to a recording, which
+// can be replayed with ReplayWatcher. Each line of a recording is a JSON object
+// with the delivery time and either an event, in its JSON encoding with the
+// XML, or an error message.
+type Recorder struct {
+	writer io.Writer
+	closer io.Closer
+	mutex  sync.Mutex
+}
+
+func NewRecorder(writer io.Writer) *Recorder {
+	return &Recorder{writer: writer}
+}
+
+// Create a recording file, replacing any existing file
+func CreateRecording(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{writer: file, closer: file}, nil
+}
+
+func (self *Recorder) RecordEvent(event *WinLogEvent) error {
+	return self.write(recordingEntry{Event: event})
+}
+
+func (self *Recorder) RecordError(err error) error {
+	return self.write(recordingEntry{Error: err.Error()})
+}
+
+func (self *Recorder) write(entry recordingEntry) error {
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	_, err = self.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Close the file, if the recorder was created with CreateRecording
+func (self *Recorder) Close() error {
+	if self.closer == nil {
+		return nil
+	}
+	return self.closer.Close()
+}
+
+// Wrap a watcher so that everything it delivers is recorded first. Shutting
+// down the returned watcher shuts down the original. If the recording can't be
+// written, the error is delivered on Error() and recording stops, but events
+// are still passed on.
+func (self *Recorder) Record(watcher Watcher) Watcher {
+	recording := &recordingWatcher{
+		Watcher:   watcher,
+		recorder:  self,
+		eventChan: make(chan *WinLogEvent),
+		errChan:   make(chan error),
+		shutdown:  make(chan interface{}),
+		done:      make(chan interface{}),
+	}
+	go recording.forward()
+	return recording
+}
+
+type recordingWatcher struct {
+	Watcher
+	recorder  *Recorder
+	eventChan chan *WinLogEvent
+	errChan   chan error
+	shutdown  chan interface{}
+	done      chan interface{}
+}
+
+func (self *recordingWatcher) Event() <-chan *WinLogEvent {
+	return self.eventChan
+}
+
+func (self *recordingWatcher) Error() <-chan error {
+	return self.errChan
+}
+
+// Record and pass on events and errors until the watcher's channels close
+func (self *recordingWatcher) forward() {
+	defer close(self.done)
+	events := self.Watcher.Event()
+	errs := self.Watcher.Error()
+	recording := true
+	record := func(write func() error) {
+		if !recording {
+			return
+		}
+		if err := write(); err != nil {
+			recording = false
+			select {
+			case self.errChan <- fmt.Errorf("Failed to write the recording, recording stopped: %v", err):
+			case <-self.shutdown:
+			}
+		}
+	}
+	for events != nil || errs != nil {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			record(func() error { return self.recorder.RecordEvent(event) })
+			select {
+			case self.eventChan <- event:
+			case <-self.shutdown:
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			record(func() error { return self.recorder.RecordError(err) })
+			select {
+			case self.errChan <- err:
+			case <-self.shutdown:
+			}
+		}
+	}
+}
+
+func (self *recordingWatcher) Shutdown() {
+	close(self.shutdown)
+	self.Watcher.Shutdown()
+	<-self.done
+	close(self.eventChan)
+	close(self.errChan)
+}
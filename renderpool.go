@@ -0,0 +1,168 @@
+package winlog
+
+import (
+	"sync"
+)
+
+// A unit of work for the render pool. `render` runs on any worker, `finish`
+// runs after it in submission order relative to other jobs with the same key.
+type renderJob struct {
+	render func()
+	finish func()
+	done   chan struct{}
+}
+
+// Renders events on a pool of workers while preserving the order of results
+// for each key (the subscribed channel). Each key can have at most queueSize
+// jobs outstanding, after which Submit blocks, so a slow consumer pushes back
+// on the subscription rather than buffering without bound.
+type renderPool struct {
+	jobs      chan *renderJob
+	queueSize int
+
+	mutex   sync.Mutex
+	ordered map[string]chan *renderJob
+	closed  bool
+
+	workers    sync.WaitGroup
+	sequencers sync.WaitGroup
+}
+
+func newRenderPool(workers, queueSize int) *renderPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	pool := &renderPool{
+		jobs:      make(chan *renderJob, workers),
+		queueSize: queueSize,
+		ordered:   make(map[string]chan *renderJob),
+	}
+	pool.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+func (self *renderPool) work() {
+	defer self.workers.Done()
+	for job := range self.jobs {
+		job.render()
+		close(job.done)
+	}
+}
+
+// Run the finish step of each job for one key, in order.
+func (self *renderPool) sequence(ordered chan *renderJob) {
+	defer self.sequencers.Done()
+	for job := range ordered {
+		<-job.done
+		job.finish()
+	}
+}
+
+// Get the ordered queue for the key, starting its sequencer if needed.
+func (self *renderPool) queue(key string) (chan *renderJob, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return nil, false
+	}
+	ordered, ok := self.ordered[key]
+	if !ok {
+		ordered = make(chan *renderJob, self.queueSize)
+		self.ordered[key] = ordered
+		self.sequencers.Add(1)
+		go self.sequence(ordered)
+	}
+	return ordered, true
+}
+
+// Queue a job. Blocks while `key` has queueSize jobs outstanding. Returns
+// false without running anything if the pool has been closed.
+func (self *renderPool) Submit(key string, render, finish func()) bool {
+	ordered, ok := self.queue(key)
+	if !ok {
+		return false
+	}
+	job := &renderJob{render: render, finish: finish, done: make(chan struct{})}
+	// Reserve the job's place in the output order before it can be rendered
+	ordered <- job
+	self.jobs <- job
+	return true
+}
+
+// The number of jobs for `key` which haven't been finished yet, not counting
+// one whose finish step is running.
+func (self *renderPool) Len(key string) int {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return len(self.ordered[key])
+}
+
+// What the pool needs from a watcher to deliver an event: render it, publish
+// it or its error, and release its handle. WinLogWatcher implements it with
+// the event log API.
+type eventRenderer interface {
+	convertEvent(handle EventHandle, subscribedChannel string) (*WinLogEvent, error)
+	publishConverted(handle EventHandle, subscribedChannel string, event *WinLogEvent)
+	publishRenderError(subscribedChannel string, err error)
+	closeEvent(handle EventHandle)
+}
+
+// Queue an event to be rendered, using the subscribed channel as the key. The
+// event, or its render error, is published in submission order, and then its
+// handle is closed. If the pool has been closed the event is dropped, its
+// handle closed, and false returned.
+func (self *renderPool) SubmitEvent(renderer eventRenderer, metrics Metrics, handle EventHandle, subscribedChannel string) bool {
+	var event *WinLogEvent
+	var err error
+	submitted := self.Submit(subscribedChannel, func() {
+		event, err = renderer.convertEvent(handle, subscribedChannel)
+	}, func() {
+		defer renderer.closeEvent(handle)
+		metrics.QueueDepth(subscribedChannel, self.Len(subscribedChannel))
+		if err != nil {
+			metrics.EventDropped(subscribedChannel, DropReasonRenderError)
+			renderer.publishRenderError(subscribedChannel, err)
+			return
+		}
+		renderer.publishConverted(handle, subscribedChannel, event)
+	})
+	if !submitted {
+		metrics.EventDropped(subscribedChannel, DropReasonShutdown)
+		renderer.closeEvent(handle)
+		return false
+	}
+	metrics.QueueDepth(subscribedChannel, self.Len(subscribedChannel))
+	return true
+}
+
+// Block until every job submitted so far for `key` has finished.
+func (self *renderPool) Wait(key string) {
+	finished := make(chan struct{})
+	if self.Submit(key, func() {}, func() { close(finished) }) {
+		<-finished
+	}
+}
+
+// Finish all outstanding jobs and stop the workers. Submit must not be
+// called concurrently with Close.
+func (self *renderPool) Close() {
+	self.mutex.Lock()
+	if self.closed {
+		self.mutex.Unlock()
+		return
+	}
+	self.closed = true
+	for _, ordered := range self.ordered {
+		close(ordered)
+	}
+	self.mutex.Unlock()
+	self.sequencers.Wait()
+	close(self.jobs)
+	self.workers.Wait()
+}
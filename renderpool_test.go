@@ -0,0 +1,305 @@
+package winlog
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	. "testing"
+	"time"
+)
+
+// These tests stand in for the event log: each job's render step simulates a
+// slow EvtFormatMessage, and its finish step records the publish order.
+
+func TestRenderPoolPreservesOrderPerChannel(t *T) {
+	pool := newRenderPool(4, 8)
+	var mutex sync.Mutex
+	published := make(map[string][]int)
+	channels := []string{"Application", "System", "Security"}
+	for i := 0; i < 300; i++ {
+		channel := channels[i%len(channels)]
+		recordId := i
+		delay := time.Duration(rand.Intn(200)) * time.Microsecond
+		pool.Submit(channel, func() {
+			time.Sleep(delay)
+		}, func() {
+			mutex.Lock()
+			published[channel] = append(published[channel], recordId)
+			mutex.Unlock()
+		})
+	}
+	pool.Close()
+
+	for _, channel := range channels {
+		records := published[channel]
+		assertEqual(len(records), 100, t)
+		for i := 1; i < len(records); i++ {
+			if records[i] < records[i-1] {
+				t.Fatalf("Events on %v published out of order: %v", channel, records)
+			}
+		}
+	}
+}
+
+func TestRenderPoolSlowChannelDoesNotBlockOthers(t *T) {
+	pool := newRenderPool(2, 4)
+	defer pool.Close()
+	slowRender := make(chan struct{})
+	pool.Submit("slow", func() { <-slowRender }, func() {})
+
+	fastPublished := make(chan struct{})
+	pool.Submit("fast", func() {}, func() { close(fastPublished) })
+	select {
+	case <-fastPublished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event on fast channel was blocked by slow channel")
+	}
+	close(slowRender)
+}
+
+func TestRenderPoolBackPressure(t *T) {
+	const queueSize = 2
+	pool := newRenderPool(2, queueSize)
+	// A consumer which isn't reading blocks the first publish
+	consumer := make(chan struct{})
+	var submitted sync.WaitGroup
+	var count int
+	var mutex sync.Mutex
+	submitted.Add(1)
+	go func() {
+		defer submitted.Done()
+		for i := 0; i < 10; i++ {
+			pool.Submit("Application", func() {}, func() { <-consumer })
+			mutex.Lock()
+			count++
+			mutex.Unlock()
+		}
+	}()
+
+	// The sequencer holds one job, and queueSize more fit in the queue
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	blockedAt := count
+	mutex.Unlock()
+	if blockedAt > queueSize+1 {
+		t.Fatalf("Submitted %v jobs with a blocked consumer, expected at most %v", blockedAt, queueSize+1)
+	}
+
+	close(consumer)
+	submitted.Wait()
+	pool.Close()
+	assertEqual(count, 10, t)
+}
+
+func TestRenderPoolWait(t *T) {
+	pool := newRenderPool(3, 10)
+	defer pool.Close()
+	var finished []string
+	var mutex sync.Mutex
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprint(i)
+		pool.Submit("System", func() { time.Sleep(time.Millisecond) }, func() {
+			mutex.Lock()
+			finished = append(finished, id)
+			mutex.Unlock()
+		})
+	}
+	pool.Wait("System")
+	mutex.Lock()
+	assertEqual(len(finished), 10, t)
+	mutex.Unlock()
+}
+
+func TestRenderPoolSubmitAfterClose(t *T) {
+	pool := newRenderPool(1, 1)
+	pool.Close()
+	if pool.Submit("System", func() {}, func() {}) {
+		t.Fatal("Submit succeeded on a closed pool")
+	}
+	// Waiting on a closed pool doesn't block
+	pool.Wait("System")
+}
+
+func TestRenderPoolLen(t *T) {
+	pool := newRenderPool(1, 4)
+	defer pool.Close()
+	assertEqual(pool.Len("System"), 0, t)
+	consumer := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit("System", func() {}, func() {
+		close(started)
+		<-consumer
+	})
+	<-started
+	pool.Submit("System", func() {}, func() {})
+	pool.Submit("System", func() {}, func() {})
+	// The job being finished isn't counted
+	assertEqual(pool.Len("System"), 2, t)
+	assertEqual(pool.Len("Application"), 0, t)
+	close(consumer)
+	pool.Wait("System")
+	assertEqual(pool.Len("System"), 0, t)
+}
+
+// A fake event source for SubmitEvent. Handle N renders as record N after a
+// random delay, or fails if N is in `failing`. Published events and errors
+// are recorded for each channel in order, after waiting for `consumer` if
+// it's set, like a watcher whose Event channel isn't being read.
+type fakeRenderer struct {
+	failing  map[EventHandle]bool
+	consumer chan struct{}
+
+	mutex     sync.Mutex
+	published map[string][]string
+	closed    map[EventHandle]int
+}
+
+func newFakeRenderer() *fakeRenderer {
+	return &fakeRenderer{
+		failing:   make(map[EventHandle]bool),
+		published: make(map[string][]string),
+		closed:    make(map[EventHandle]int),
+	}
+}
+
+func (self *fakeRenderer) convertEvent(handle EventHandle, subscribedChannel string) (*WinLogEvent, error) {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	if self.failing[handle] {
+		return nil, fmt.Errorf("%v", handle)
+	}
+	return &WinLogEvent{RecordId: uint64(handle), SubscribedChannel: subscribedChannel}, nil
+}
+
+func (self *fakeRenderer) publishConverted(handle EventHandle, subscribedChannel string, event *WinLogEvent) {
+	if self.consumer != nil {
+		<-self.consumer
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.published[subscribedChannel] = append(self.published[subscribedChannel], fmt.Sprint(event.RecordId))
+}
+
+func (self *fakeRenderer) publishRenderError(subscribedChannel string, err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.published[subscribedChannel] = append(self.published[subscribedChannel], "error "+err.Error())
+}
+
+func (self *fakeRenderer) closeEvent(handle EventHandle) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.closed[handle]++
+}
+
+// Counts dropped events by reason
+type dropMetrics struct {
+	NopMetrics
+	mutex   sync.Mutex
+	dropped map[string]int
+}
+
+func (self *dropMetrics) EventDropped(channel, reason string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.dropped == nil {
+		self.dropped = make(map[string]int)
+	}
+	self.dropped[reason]++
+}
+
+func TestSubmitEventPublishesInOrder(t *T) {
+	pool := newRenderPool(4, 8)
+	renderer := newFakeRenderer()
+	metrics := &dropMetrics{}
+	channels := map[string]EventHandle{"Application": 1, "System": 1001}
+	for _, first := range channels {
+		for handle := first; handle < first+100; handle += 10 {
+			renderer.failing[handle] = true
+		}
+	}
+	// Each channel is read on its own goroutine, as by the watcher
+	var submitters sync.WaitGroup
+	for channel, first := range channels {
+		submitters.Add(1)
+		go func(channel string, first EventHandle) {
+			defer submitters.Done()
+			for handle := first; handle < first+100; handle++ {
+				pool.SubmitEvent(renderer, metrics, handle, channel)
+			}
+		}(channel, first)
+	}
+	submitters.Wait()
+	pool.Close()
+
+	for channel, first := range channels {
+		published := renderer.published[channel]
+		assertEqual(len(published), 100, t)
+		for i, entry := range published {
+			handle := first + EventHandle(i)
+			expected := fmt.Sprint(handle)
+			if renderer.failing[handle] {
+				expected = "error " + expected
+			}
+			if entry != expected {
+				t.Fatalf("Published %q at position %v of %v, expected %q", entry, i, channel, expected)
+			}
+			assertEqual(renderer.closed[handle], 1, t)
+		}
+	}
+	assertEqual(metrics.dropped[DropReasonRenderError], 20, t)
+}
+
+func TestSubmitEventBackPressure(t *T) {
+	const queueSize = 2
+	pool := newRenderPool(2, queueSize)
+	renderer := newFakeRenderer()
+	renderer.consumer = make(chan struct{})
+	var count int
+	var mutex sync.Mutex
+	var submitted sync.WaitGroup
+	submitted.Add(1)
+	go func() {
+		defer submitted.Done()
+		for handle := EventHandle(1); handle <= 10; handle++ {
+			pool.SubmitEvent(renderer, NopMetrics{}, handle, "Security")
+			mutex.Lock()
+			count++
+			mutex.Unlock()
+		}
+	}()
+
+	// The first event waits for the consumer, and queueSize more are queued
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	blockedAt := count
+	mutex.Unlock()
+	if blockedAt > queueSize+1 {
+		t.Fatalf("Submitted %v events with a blocked consumer, expected at most %v", blockedAt, queueSize+1)
+	}
+	renderer.mutex.Lock()
+	assertEqual(len(renderer.closed), 0, t)
+	renderer.mutex.Unlock()
+
+	for i := 0; i < 10; i++ {
+		renderer.consumer <- struct{}{}
+	}
+	submitted.Wait()
+	pool.Close()
+	assertEqual(count, 10, t)
+	assertEqual(fmt.Sprint(renderer.published["Security"]), "[1 2 3 4 5 6 7 8 9 10]", t)
+	assertEqual(len(renderer.closed), 10, t)
+}
+
+func TestSubmitEventAfterClose(t *T) {
+	pool := newRenderPool(1, 1)
+	pool.Close()
+	renderer := newFakeRenderer()
+	metrics := &dropMetrics{}
+	if pool.SubmitEvent(renderer, metrics, 7, "System") {
+		t.Fatal("SubmitEvent succeeded on a closed pool")
+	}
+	// The event is dropped, but its handle is still closed
+	assertEqual(len(renderer.published), 0, t)
+	assertEqual(renderer.closed[7], 1, t)
+	assertEqual(metrics.dropped[DropReasonShutdown], 1, t)
+}
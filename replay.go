@@ -0,0 +1,556 @@
+package winlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scalingdata/gowinlog/internal/xpath"
+)
+
+// Longest line in a recording
+const maxRecordingLine = 64 * 1024 * 1024
+
+// File extensions replayed from a directory
+var replayExtensions = map[string]bool{
+	".evtx":   true,
+	".xml":    true,
+	".json":   true,
+	".jsonl":  true,
+	".ndjson": true,
+}
+
+type ReplayConfig struct {
+	// Files to replay, in order: recordings written by Recorder, EVTX files,
+	// XML files of one or more <Event> elements, or directories of them, which
+	// are replayed in name order.
+	Paths []string
+	// The pace relative to the original: 1 keeps the original gaps between
+	// events and 10 is ten times faster. 0 replays as fast as possible.
+	Speed float64
+	// If set, fills in the localized fields of events from EVTX and XML files
+	Catalog *ProviderCatalog
+}
+
+// Replays recorded events through the same API as WinLogWatcher, on any OS.
+// Subscribe to the channels to replay, then call Start. Errors in recordings
+// are delivered on Error() at the point they were recorded, so failures can
+// be reproduced, or injected by adding them to a recording. Since a replay
+// has no present, SubscribeFromNow replays the whole channel like
+// SubscribeFromBeginning.
+type ReplayWatcher struct {
+	config    ReplayConfig
+	paths     []string
+	eventChan chan *WinLogEvent
+	errChan   chan error
+	shutdown  chan interface{}
+	done      chan interface{}
+
+	subscriptions map[string]*replaySubscription
+	started       bool
+	logger        Logger
+	mutex         sync.Mutex
+}
+
+type replaySubscription struct {
+	channel string
+	// nil for "*"
+	query *xpath.XPath
+	// Only events after this record are replayed
+	after uint64
+	stats *subscriptionStats
+}
+
+// An event or error read from a replay file, and when it originally happened
+type replayItem struct {
+	time  time.Time
+	event *WinLogEvent
+	err   error
+}
+
+// Reads replay items from a file, returning io.EOF at the end. Other errors
+// are delivered on Error() and reading continues.
+type replaySource interface {
+	next() (*replayItem, error)
+	close() error
+}
+
+func NewReplayWatcher(config ReplayConfig) (*ReplayWatcher, error) {
+	if config.Speed < 0 {
+		return nil, fmt.Errorf("Replay speed must not be negative")
+	}
+	var paths []string
+	for _, path := range config.Paths {
+		expanded, err := replayPaths(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, expanded...)
+	}
+	return &ReplayWatcher{
+		config:        config,
+		paths:         paths,
+		eventChan:     make(chan *WinLogEvent),
+		errChan:       make(chan error),
+		shutdown:      make(chan interface{}),
+		done:          make(chan interface{}),
+		subscriptions: make(map[string]*replaySubscription),
+		logger:        NopLogger{},
+	}, nil
+}
+
+// Expand a directory into the files to replay from it
+func replayPaths(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && replayExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			paths = append(paths, filepath.Join(path, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (self *ReplayWatcher) Event() <-chan *WinLogEvent {
+	return self.eventChan
+}
+
+func (self *ReplayWatcher) Error() <-chan error {
+	return self.errChan
+}
+
+// Closed when every file has been replayed
+func (self *ReplayWatcher) Done() <-chan interface{} {
+	return self.done
+}
+
+// Log the replay's lifecycle to `logger`, such as a *slog.Logger. Must be
+// called before Start; nil discards the messages, which is the default.
+func (self *ReplayWatcher) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	self.logger = logger
+}
+
+// Replay the events from the channel which match the query.
+func (self *ReplayWatcher) SubscribeFromBeginning(channel, query string) error {
+	return self.subscribe(channel, query, 0)
+}
+
+// Same as SubscribeFromBeginning, since a replay starts from the beginning
+// of the recording.
+func (self *ReplayWatcher) SubscribeFromNow(channel, query string) error {
+	return self.subscribe(channel, query, 0)
+}
+
+// Replay the events from the channel which match the query and come after
+// the bookmarked record.
+func (self *ReplayWatcher) SubscribeFromBookmark(channel, query, bookmark string) error {
+	positions, err := ParseBookmarkXml(bookmark)
+	if err != nil {
+		return fmt.Errorf("Failed to create new bookmark handle: %v", err)
+	}
+	for _, position := range positions {
+		if strings.EqualFold(position.Channel, channel) {
+			return self.subscribe(channel, query, position.RecordId)
+		}
+	}
+	return fmt.Errorf("Bookmark has no position for channel %q", channel)
+}
+
+func (self *ReplayWatcher) subscribe(channel, query string, after uint64) error {
+	subscription := &replaySubscription{channel: channel, after: after, stats: newSubscriptionStats(channel, time.Now())}
+	if query != "" && query != "*" {
+		compiled, err := xpath.Compile(query)
+		if err != nil {
+			return err
+		}
+		subscription.query = compiled
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	key := strings.ToLower(channel)
+	if _, ok := self.subscriptions[key]; ok {
+		return fmt.Errorf("A watcher for channel %q already exists", channel)
+	}
+	self.subscriptions[key] = subscription
+	self.logger.Info("Subscribed to channel", "channel", channel, "query", query, "after", after)
+	return nil
+}
+
+// Stop replaying events from the channel
+func (self *ReplayWatcher) Unsubscribe(channel string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	key := strings.ToLower(channel)
+	if _, ok := self.subscriptions[key]; !ok {
+		return fmt.Errorf("No watcher for channel %q", channel)
+	}
+	delete(self.subscriptions, key)
+	self.logger.Info("Unsubscribed from channel", "channel", channel)
+	return nil
+}
+
+// Get the stats of every subscription, in channel order.
+func (self *ReplayWatcher) Stats() []SubscriptionStats {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	now := time.Now()
+	stats := make([]SubscriptionStats, 0, len(self.subscriptions))
+	for _, subscription := range self.subscriptions {
+		stats = append(stats, subscription.stats.snapshot(now))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Channel < stats[j].Channel })
+	return stats
+}
+
+// Get the stats of the subscription to a channel, or false if there isn't one.
+func (self *ReplayWatcher) ChannelStats(channel string) (SubscriptionStats, bool) {
+	self.mutex.Lock()
+	subscription, ok := self.subscriptions[strings.ToLower(channel)]
+	self.mutex.Unlock()
+	if !ok {
+		return SubscriptionStats{}, false
+	}
+	return subscription.stats.snapshot(time.Now()), true
+}
+
+// Start replaying. Subscriptions made afterwards get the events replayed from
+// then on.
+func (self *ReplayWatcher) Start() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.started {
+		return
+	}
+	self.started = true
+	self.logger.Info("Starting replay", "files", len(self.paths))
+	go self.replay()
+}
+
+// Stop replaying and close the event and error channels.
+func (self *ReplayWatcher) Shutdown() {
+	self.logger.Info("Shutting down watcher")
+	close(self.shutdown)
+	self.mutex.Lock()
+	started := self.started
+	self.mutex.Unlock()
+	if started {
+		<-self.done
+	}
+	close(self.errChan)
+	close(self.eventChan)
+}
+
+func (self *ReplayWatcher) replay() {
+	defer close(self.done)
+	defer self.logger.Debug("Replay finished")
+	pacer := &replayPacer{speed: self.config.Speed}
+	for _, path := range self.paths {
+		source, err := self.openSource(path)
+		if err != nil {
+			self.logger.Warn("Failed to open replay file", "path", path, "error", err)
+			if !self.publishError(err) {
+				return
+			}
+			continue
+		}
+		ok := self.replaySource(source, pacer)
+		source.close()
+		if !ok {
+			return
+		}
+	}
+}
+
+// Replay one file, returning false if the watcher was shut down
+func (self *ReplayWatcher) replaySource(source replaySource, pacer *replayPacer) bool {
+	for {
+		item, err := source.next()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			self.logger.Warn("Failed to read replay file", "error", err)
+			if !self.publishError(err) {
+				return false
+			}
+			continue
+		}
+		var event *WinLogEvent
+		var subscription *replaySubscription
+		if item.err == nil {
+			if event, subscription = self.route(item.event); event == nil {
+				continue
+			}
+		}
+		if !pacer.wait(item.time, self.shutdown) {
+			return false
+		}
+		if item.err != nil {
+			if !self.publishError(item.err) {
+				return false
+			}
+			continue
+		}
+		if !self.publishEvent(event) {
+			return false
+		}
+		subscription.stats.delivered(event, time.Now())
+	}
+}
+
+// Get the event as delivered to its subscription, and the subscription, or
+// nil if it has none
+func (self *ReplayWatcher) route(event *WinLogEvent) (*WinLogEvent, *replaySubscription) {
+	channel := event.SubscribedChannel
+	if channel == "" {
+		channel = event.Channel
+	}
+	self.mutex.Lock()
+	subscription, ok := self.subscriptions[strings.ToLower(channel)]
+	self.mutex.Unlock()
+	if !ok || subscription.after != 0 && event.RecordId <= subscription.after {
+		return nil, nil
+	}
+	if subscription.query != nil && !subscription.query.Match(event.Xml) {
+		return nil, nil
+	}
+	routed := *event
+	routed.SubscribedChannel = subscription.channel
+	if routed.Bookmark == "" {
+		routed.Bookmark = FormatBookmarkXml(BookmarkPosition{Channel: channel, RecordId: event.RecordId, IsCurrent: true})
+	}
+	return &routed, subscription
+}
+
+func (self *ReplayWatcher) publishEvent(event *WinLogEvent) bool {
+	select {
+	case self.eventChan <- event:
+		return true
+	case <-self.shutdown:
+		return false
+	}
+}
+
+func (self *ReplayWatcher) publishError(err error) bool {
+	select {
+	case self.errChan <- err:
+		return true
+	case <-self.shutdown:
+		return false
+	}
+}
+
+// Open a file by its contents
+func (self *ReplayWatcher) openSource(path string) (replaySource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	head, _ := reader.Peek(512)
+	if bytes.HasPrefix(head, []byte(evtxFileMagic)) {
+		file.Close()
+		evtx, err := OpenEvtx(path)
+		if err != nil {
+			return nil, err
+		}
+		return &evtxReplaySource{reader: evtx, catalog: self.config.Catalog}, nil
+	}
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		data, err := io.ReadAll(reader)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		return newXmlReplaySource(path, data, self.config.Catalog), nil
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(nil, maxRecordingLine)
+		return &recordingReplaySource{path: path, file: file, scanner: scanner}, nil
+	}
+	file.Close()
+	return nil, fmt.Errorf("%v isn't a recording, EVTX or XML file", path)
+}
+
+// Sleeps between events to keep the original pace, scaled by the speed
+type replayPacer struct {
+	speed float64
+	// The time of the event the pace is measured from, and when it was replayed
+	origin  time.Time
+	started time.Time
+	last    time.Time
+}
+
+// Wait until it's time to replay an event, returning false if shut down first
+func (self *replayPacer) wait(at time.Time, shutdown <-chan interface{}) bool {
+	if self.speed == 0 || at.IsZero() {
+		return true
+	}
+	// Start again when time goes backwards, such as at the start of a file
+	if self.origin.IsZero() || at.Before(self.last) {
+		self.origin = at
+		self.started = time.Now()
+	}
+	self.last = at
+	delay := time.Duration(float64(at.Sub(self.origin))/self.speed) - time.Since(self.started)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-shutdown:
+		return false
+	}
+}
+
+type recordingReplaySource struct {
+	path    string
+	file    *os.File
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (self *recordingReplaySource) next() (*replayItem, error) {
+	for self.scanner.Scan() {
+		self.line++
+		line := bytes.TrimSpace(self.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry recordingEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", self.path, self.line, err)
+		}
+		item := &replayItem{event: entry.Event}
+		if entry.Time != "" {
+			var err error
+			if item.time, err = time.Parse(time.RFC3339Nano, entry.Time); err != nil {
+				return nil, fmt.Errorf("%v:%v: Invalid time: %v", self.path, self.line, err)
+			}
+		}
+		if entry.Event == nil {
+			if entry.Error == "" {
+				return nil, fmt.Errorf("%v:%v: Entry has no event or error", self.path, self.line)
+			}
+			item.err = errors.New(entry.Error)
+		}
+		return item, nil
+	}
+	if err := self.scanner.Err(); err != nil {
+		return nil, self.fail(err)
+	}
+	return nil, io.EOF
+}
+
+// Report a read error once, then end the file
+func (self *recordingReplaySource) fail(err error) error {
+	self.scanner = bufio.NewScanner(bytes.NewReader(nil))
+	return fmt.Errorf("%v: %v", self.path, err)
+}
+
+func (self *recordingReplaySource) close() error {
+	return self.file.Close()
+}
+
+type evtxReplaySource struct {
+	reader  *EvtxReader
+	catalog *ProviderCatalog
+}
+
+func (self *evtxReplaySource) next() (*replayItem, error) {
+	record, err := self.reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	event, err := record.Event()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse record %v: %v", record.RecordId, err)
+	}
+	return eventReplayItem(event, self.catalog), nil
+}
+
+func (self *evtxReplaySource) close() error {
+	return self.reader.Close()
+}
+
+// Reads the <Event> elements of an XML file, such as the output of
+// wevtutil qe /f:xml, wherever they are in the document
+type xmlReplaySource struct {
+	path    string
+	data    []byte
+	decoder *xml.Decoder
+	catalog *ProviderCatalog
+}
+
+func newXmlReplaySource(path string, data []byte, catalog *ProviderCatalog) *xmlReplaySource {
+	return &xmlReplaySource{path: path, data: data, decoder: xml.NewDecoder(bytes.NewReader(data)), catalog: catalog}
+}
+
+func (self *xmlReplaySource) next() (*replayItem, error) {
+	for {
+		start := self.decoder.InputOffset()
+		token, err := self.decoder.Token()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			self.decoder = xml.NewDecoder(bytes.NewReader(nil))
+			return nil, fmt.Errorf("%v: %v", self.path, err)
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "Event" {
+			continue
+		}
+		if err := self.decoder.Skip(); err != nil {
+			self.decoder = xml.NewDecoder(bytes.NewReader(nil))
+			return nil, fmt.Errorf("%v: %v", self.path, err)
+		}
+		eventXml := string(self.data[start:self.decoder.InputOffset()])
+		event, err := ParseEventXml(eventXml)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", self.path, err)
+		}
+		event.EventData, _ = ParseEventData(eventXml)
+		return eventReplayItem(event, self.catalog), nil
+	}
+}
+
+func (self *xmlReplaySource) close() error {
+	return nil
+}
+
+func eventReplayItem(event *WinLogEvent, catalog *ProviderCatalog) *replayItem {
+	if catalog != nil {
+		catalog.RenderEvent(event)
+	}
+	return &replayItem{time: event.Created, event: event}
+}
@@ -0,0 +1,314 @@
+package winlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	. "testing"
+	"time"
+)
+
+const replayXml = `<Events>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='App'/><EventID>1</EventID><TimeCreated SystemTime='2020-01-02T03:04:05.000000000Z'/><EventRecordID>1</EventRecordID><Channel>Application</Channel></System><EventData><Data>one</Data></EventData></Event>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='App'/><EventID>2</EventID><TimeCreated SystemTime='2020-01-02T03:04:06.000000000Z'/><EventRecordID>2</EventRecordID><Channel>Application</Channel></System></Event>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='App'/><EventID>3</EventID><TimeCreated SystemTime='2020-01-02T03:04:07.000000000Z'/><EventRecordID>3</EventRecordID><Channel>Application</Channel></System></Event>
+</Events>`
+
+// Read what the watcher delivers until it's done, as "channel/record" for
+// events and "error: message" for errors
+func collectReplay(watcher *ReplayWatcher, t *T) []string {
+	var delivered []string
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-watcher.Event():
+			delivered = append(delivered, fmt.Sprintf("%v/%v", event.SubscribedChannel, event.RecordId))
+		case err := <-watcher.Error():
+			delivered = append(delivered, "error: "+err.Error())
+		case <-watcher.Done():
+			return delivered
+		case <-timeout:
+			t.Fatalf("Replay didn't finish, got %v", delivered)
+		}
+	}
+}
+
+func testReplayWatcher(config ReplayConfig, t *T) *ReplayWatcher {
+	watcher, err := NewReplayWatcher(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return watcher
+}
+
+func TestReplayRecording(t *T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	recorder, err := CreateRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.RecordEvent(&WinLogEvent{Channel: "Application", SubscribedChannel: "Application", RecordId: 1, Bookmark: "<BookmarkList/>"})
+	recorder.RecordError(errors.New("Failed to render event"))
+	recorder.RecordEvent(&WinLogEvent{Channel: "System", SubscribedChannel: "System", RecordId: 7})
+	recorder.RecordEvent(&WinLogEvent{Channel: "Application", SubscribedChannel: "Application", RecordId: 2})
+	recorder.Close()
+
+	watcher := testReplayWatcher(ReplayConfig{Paths: []string{path}}, t)
+	defer watcher.Shutdown()
+	watcher.SubscribeFromBeginning("application", "*")
+	watcher.Start()
+	delivered := collectReplay(watcher, t)
+	assertEqual(fmt.Sprint(delivered), "[application/1 error: Failed to render event application/2]", t)
+}
+
+func TestReplayBookmarkAndQuery(t *T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.xml"), []byte(replayXml), 0644)
+	os.WriteFile(filepath.Join(dir, "b.evtx"), evtxFile(testEvtxChunk(100)), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644)
+
+	watcher := testReplayWatcher(ReplayConfig{Paths: []string{dir}}, t)
+	defer watcher.Shutdown()
+	bookmark := FormatBookmarkXml(BookmarkPosition{Channel: "Application", RecordId: 1, IsCurrent: true})
+	if err := watcher.SubscribeFromBookmark("Application", "*", bookmark); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.SubscribeFromNow("Security", "*[System[EventID=4625]]"); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.SubscribeFromNow("Security", "*"); err == nil {
+		t.Fatal("Expected an error subscribing to a channel twice")
+	}
+	if err := watcher.SubscribeFromBookmark("System", "*", bookmark); err == nil {
+		t.Fatal("Expected an error for a bookmark without the channel")
+	}
+	watcher.Start()
+	delivered := collectReplay(watcher, t)
+	assertEqual(fmt.Sprint(delivered), "[Application/2 Application/3 Security/101]", t)
+}
+
+func TestReplayUnsubscribe(t *T) {
+	path := filepath.Join(t.TempDir(), "events.xml")
+	os.WriteFile(path, []byte(replayXml), 0644)
+	watcher := testReplayWatcher(ReplayConfig{Paths: []string{path}}, t)
+	defer watcher.Shutdown()
+	watcher.SubscribeFromBeginning("Application", "*")
+	if err := watcher.Unsubscribe("application"); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Unsubscribe("Application"); err == nil {
+		t.Fatal("Expected an error unsubscribing from a channel twice")
+	}
+	watcher.SubscribeFromBeginning("Application", "*[System[EventID=3]]")
+	watcher.Start()
+	assertEqual(fmt.Sprint(collectReplay(watcher, t)), "[Application/3]", t)
+}
+
+func TestReplayStats(t *T) {
+	path := filepath.Join(t.TempDir(), "events.xml")
+	os.WriteFile(path, []byte(replayXml), 0644)
+	watcher := testReplayWatcher(ReplayConfig{Paths: []string{path}}, t)
+	defer watcher.Shutdown()
+	watcher.SubscribeFromBeginning("Application", "*")
+	watcher.SubscribeFromBeginning("System", "*")
+	watcher.Start()
+	collectReplay(watcher, t)
+
+	stats, ok := watcher.ChannelStats("application")
+	assertEqual(ok, true, t)
+	assertEqual(stats.Channel, "Application", t)
+	assertEqual(stats.Delivered, uint64(3), t)
+	assertEqual(stats.LastRecordId, uint64(3), t)
+	assertEqual(stats.LastCreated, time.Date(2020, 1, 2, 3, 4, 7, 0, time.UTC), t)
+	assertEqual(stats.Lag, stats.LastDelivered.Sub(stats.LastCreated), t)
+	all := watcher.Stats()
+	assertEqual(len(all), 2, t)
+	assertEqual(all[1].Channel, "System", t)
+	assertEqual(all[1].Delivered, uint64(0), t)
+	_, ok = watcher.ChannelStats("Security")
+	assertEqual(ok, false, t)
+}
+
+func TestReplayLogging(t *T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "events.xml"), []byte(replayXml), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not events"), 0644)
+	watcher := testReplayWatcher(ReplayConfig{Paths: []string{filepath.Join(dir, "events.xml"), filepath.Join(dir, "notes.txt")}}, t)
+	var buffer bytes.Buffer
+	watcher.SetLogger(slog.New(slog.NewTextHandler(&buffer, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, attribute slog.Attr) slog.Attr {
+			if attribute.Key == slog.TimeKey || attribute.Key == "error" || attribute.Key == "path" {
+				return slog.Attr{}
+			}
+			return attribute
+		},
+	})))
+	watcher.SubscribeFromBeginning("Application", "*[System[EventID=2]]")
+	watcher.SubscribeFromNow("System", "*")
+	watcher.Unsubscribe("System")
+	watcher.Start()
+	collectReplay(watcher, t)
+	watcher.Shutdown()
+	assertEqual(buffer.String(), `level=INFO msg="Subscribed to channel" channel=Application query="*[System[EventID=2]]" after=0
+level=INFO msg="Subscribed to channel" channel=System query=* after=0
+level=INFO msg="Unsubscribed from channel" channel=System
+level=INFO msg="Starting replay" files=2
+level=WARN msg="Failed to open replay file"
+level=DEBUG msg="Replay finished"
+level=INFO msg="Shutting down watcher"
+`, t)
+}
+
+func TestReplayEventFields(t *T) {
+	path := filepath.Join(t.TempDir(), "events.xml")
+	os.WriteFile(path, []byte(replayXml), 0644)
+	watcher := testReplayWatcher(ReplayConfig{Paths: []string{path}}, t)
+	defer watcher.Shutdown()
+	watcher.SubscribeFromBeginning("Application", "*[EventData[Data='one']]")
+	watcher.Start()
+	event := <-watcher.Event()
+	assertEqual(event.EventId, uint64(1), t)
+	assertEqual(event.ProviderName, "App", t)
+	assertEqual(event.EventData[0].Value, "one", t)
+	assertEqual(event.Bookmark, FormatBookmarkXml(BookmarkPosition{Channel: "Application", RecordId: 1, IsCurrent: true}), t)
+}
+
+func writeTimedRecording(path string, gap time.Duration) {
+	var buf bytes.Buffer
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		entry := recordingEntry{
+			Time:  start.Add(time.Duration(i) * gap).Format(time.RFC3339Nano),
+			Event: &WinLogEvent{Channel: "Application", RecordId: uint64(i + 1)},
+		}
+		line, _ := json.Marshal(entry)
+		buf.Write(append(line, '\n'))
+	}
+	os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func TestReplaySpeed(t *T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	writeTimedRecording(path, 200*time.Millisecond)
+
+	replayTime := func(speed float64) time.Duration {
+		watcher := testReplayWatcher(ReplayConfig{Paths: []string{path}, Speed: speed}, t)
+		defer watcher.Shutdown()
+		watcher.SubscribeFromBeginning("Application", "*")
+		start := time.Now()
+		watcher.Start()
+		assertEqual(len(collectReplay(watcher, t)), 3, t)
+		return time.Since(start)
+	}
+	if elapsed := replayTime(2); elapsed < 190*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("Replay at double speed took %v", elapsed)
+	}
+	if elapsed := replayTime(0); elapsed > 100*time.Millisecond {
+		t.Fatalf("Replay as fast as possible took %v", elapsed)
+	}
+}
+
+func TestReplayShutdown(t *T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	writeTimedRecording(path, time.Hour)
+	watcher := testReplayWatcher(ReplayConfig{Paths: []string{path}, Speed: 1}, t)
+	watcher.SubscribeFromBeginning("Application", "*")
+	watcher.Start()
+	<-watcher.Event()
+	// Shutting down interrupts the wait for the next event
+	done := make(chan interface{})
+	go func() {
+		watcher.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't stop the replay")
+	}
+	_, ok := <-watcher.Event()
+	assertEqual(ok, false, t)
+}
+
+func TestReplayInvalidFiles(t *T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.json"), []byte("{\"time\":\"2020-01-02T03:04:05Z\"}\nnot json\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.xml"), []byte("<Events><Event><System>"), 0644)
+	os.WriteFile(filepath.Join(dir, "c.jsonl"), []byte("plain text"), 0644)
+	watcher := testReplayWatcher(ReplayConfig{Paths: []string{dir}}, t)
+	defer watcher.Shutdown()
+	watcher.Start()
+	delivered := collectReplay(watcher, t)
+	assertEqual(len(delivered), 4, t)
+	assertEqual(delivered[3], "error: "+filepath.Join(dir, "c.jsonl")+" isn't a recording, EVTX or XML file", t)
+
+	if _, err := NewReplayWatcher(ReplayConfig{Paths: []string{filepath.Join(dir, "missing")}}); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
+
+func TestRecorderRecord(t *T) {
+	source := filepath.Join(t.TempDir(), "events.xml")
+	os.WriteFile(source, []byte(replayXml), 0644)
+	replay := testReplayWatcher(ReplayConfig{Paths: []string{source}}, t)
+	replay.SubscribeFromBeginning("Application", "*")
+
+	var recording bytes.Buffer
+	watcher := NewRecorder(&recording).Record(replay)
+	replay.Start()
+	for i := 1; i <= 3; i++ {
+		event := <-watcher.Event()
+		assertEqual(event.RecordId, uint64(i), t)
+	}
+	watcher.Shutdown()
+
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	os.WriteFile(path, recording.Bytes(), 0644)
+	replayed := testReplayWatcher(ReplayConfig{Paths: []string{path}}, t)
+	defer replayed.Shutdown()
+	replayed.SubscribeFromBeginning("Application", "*[System[EventID=3]]")
+	replayed.Start()
+	event := <-replayed.Event()
+	assertEqual(event.RecordId, uint64(3), t)
+	assertEqual(event.ProviderName, "App", t)
+}
+
+// Fails every write
+type fullWriter struct{}
+
+func (fullWriter) Write(data []byte) (int, error) {
+	return 0, errors.New("Disk full")
+}
+
+func TestRecorderWriteError(t *T) {
+	source := filepath.Join(t.TempDir(), "events.xml")
+	os.WriteFile(source, []byte(replayXml), 0644)
+	replay := testReplayWatcher(ReplayConfig{Paths: []string{source}}, t)
+	replay.SubscribeFromBeginning("Application", "*")
+
+	watcher := NewRecorder(fullWriter{}).Record(replay)
+	defer watcher.Shutdown()
+	replay.Start()
+	select {
+	case err := <-watcher.Error():
+		assertEqual(err.Error(), "Failed to write the recording, recording stopped: Disk full", t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No error for the failed write")
+	}
+	// Events are still delivered, and the error is only reported once
+	for i := 1; i <= 3; i++ {
+		select {
+		case event := <-watcher.Event():
+			assertEqual(event.RecordId, uint64(i), t)
+		case err := <-watcher.Error():
+			t.Fatalf("Unexpected error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("No event after the failed write")
+		}
+	}
+}
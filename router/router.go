@@ -0,0 +1,360 @@
+// Package router fans events from a WinLogWatcher out to several sinks, each
+// with its own filter, queue and bookmarks.
+//
+// A route's queue absorbs bursts, but not a sink that stays slower than the
+// watcher or stops. What happens then depends on the route's Overflow:
+//
+//   - OverflowBlock, the default, loses nothing, but once the route's queue
+//     is full the router waits for it, so every other route stops receiving
+//     events too. Use it for sinks that are as fast as the others, or when
+//     holding everything up is preferable to missing events.
+//   - OverflowDrop keeps the other routes going, but the events it drops are
+//     lost for that sink: they count as committed, so the router's bookmark
+//     moves past them and they aren't delivered again on restart. Route.Dropped
+//     counts them.
+//
+// To decouple a slow sink without losing events, give it its own spool.Reader
+// rather than a route.
+//
+// With a RenderProfile.BookmarkInterval above 1, most events have no bookmark,
+// so a sink can't commit them on their own. Only the events with a bookmark
+// hold back a route: the others are committed along with the next bookmarked
+// event the route sends. A filtered route whose events between bookmarks
+// haven't been sent yet when the process stops may miss them, if the other
+// routes have committed a later bookmark.
+package router
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/scalingdata/gowinlog"
+	"github.com/scalingdata/gowinlog/internal/xpath"
+)
+
+// Selects the events sent to a route. Every set field must match; an empty
+// filter matches everything.
+type Filter struct {
+	// Event channels, case-insensitive
+	Channels []string
+	// Provider names, case-insensitive
+	Providers []string
+	EventIds  []uint64
+	Levels    []uint64
+	// An XPath filter on the event XML, in the syntax of Windows event log
+	// queries such as "*[System[(EventID=4624 or EventID=4625)]]". Events
+	// without XML don't match.
+	XPath string
+	// Any other test
+	Predicate func(*winlog.WinLogEvent) bool
+}
+
+// What a route does with events when its queue is full
+type Overflow int
+
+const (
+	// Wait for room in the queue, which holds up every route
+	OverflowBlock Overflow = iota
+	// Drop the event for this route only. Dropped events count as committed,
+	// so they're never delivered to the route.
+	OverflowDrop
+)
+
+// Settings for a route
+type RouteConfig struct {
+	Name   string
+	Filter Filter
+	// Events queued for the sink, 1000 by default
+	QueueSize int
+	Overflow  Overflow
+}
+
+type Config struct {
+	// Where to save, for each channel, the bookmark that every route has
+	// committed up to. Resume the watcher from it, and each sink gets every
+	// event it hasn't committed, and possibly some it has.
+	Bookmarks winlog.BookmarkStore
+}
+
+// An event which some route hasn't committed yet
+type inflightEvent struct {
+	seq      uint64
+	bookmark string
+}
+
+// Dispatches events to routes
+type Router struct {
+	config Config
+	routes []*Route
+	// Sequence number of the last dispatched event
+	seq uint64
+	// Dispatched events for each subscribed channel, in order
+	inflight map[string][]inflightEvent
+	started  bool
+	mutex    sync.Mutex
+}
+
+func New(config Config) *Router {
+	return &Router{
+		config:   config,
+		inflight: make(map[string][]inflightEvent),
+	}
+}
+
+// Add a route. Routes must be added before Run.
+func (self *Router) AddRoute(config RouteConfig) (*Route, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.started {
+		return nil, fmt.Errorf("Routes can't be added once the router is running")
+	}
+	for _, route := range self.routes {
+		if route.name == config.Name {
+			return nil, fmt.Errorf("Route %q already exists", config.Name)
+		}
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	route := &Route{
+		router:    self,
+		name:      config.Name,
+		filter:    config.Filter,
+		overflow:  config.Overflow,
+		events:    make(chan *winlog.WinLogEvent, config.QueueSize),
+		pending:   make(map[string][]uint64),
+		committed: make(map[string]string),
+	}
+	if config.Filter.XPath != "" {
+		compiled, err := xpath.Compile(config.Filter.XPath)
+		if err != nil {
+			return nil, err
+		}
+		route.xpath = compiled
+	}
+	self.routes = append(self.routes, route)
+	return route, nil
+}
+
+// Dispatch events from the channel, such as WinLogWatcher.Event(), until it's
+// closed, then close every route's Events channel. Stops early and returns
+// the error if a bookmark can't be saved to the router's store.
+func (self *Router) Run(events <-chan *winlog.WinLogEvent) error {
+	self.mutex.Lock()
+	self.started = true
+	routes := self.routes
+	self.mutex.Unlock()
+	defer func() {
+		for _, route := range routes {
+			close(route.events)
+		}
+	}()
+
+	for event := range events {
+		// The XML is parsed at most once, and only if a route needs it
+		var tree *xpath.Node
+		parsed := false
+		xmlTree := func() *xpath.Node {
+			if !parsed {
+				parsed = true
+				tree, _ = xpath.ParseTree(event.Xml)
+			}
+			return tree
+		}
+		var matched []*Route
+		for _, route := range routes {
+			if route.match(event, xmlTree) {
+				matched = append(matched, route)
+			}
+		}
+		if err := self.dispatch(event, matched); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Router) dispatch(event *winlog.WinLogEvent, routes []*Route) error {
+	channel := event.SubscribedChannel
+	self.mutex.Lock()
+	self.seq++
+	seq := self.seq
+	self.inflight[channel] = append(self.inflight[channel], inflightEvent{seq, event.Bookmark})
+	// Events without a bookmark can't be committed, so they would hold back
+	// the channel until the route sent a bookmarked event, maybe never
+	if event.Bookmark != "" {
+		for _, route := range routes {
+			route.pending[channel] = append(route.pending[channel], seq)
+		}
+	}
+	self.mutex.Unlock()
+
+	for _, route := range routes {
+		if route.overflow == OverflowDrop {
+			select {
+			case route.events <- event:
+			default:
+				self.mutex.Lock()
+				route.pending[channel] = removeSeq(route.pending[channel], seq)
+				route.dropped++
+				self.mutex.Unlock()
+			}
+		} else {
+			route.events <- event
+		}
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if err := self.advance(channel); err != nil {
+		return fmt.Errorf("Failed to save the bookmark for %q: %v", channel, err)
+	}
+	return nil
+}
+
+func removeSeq(seqs []uint64, seq uint64) []uint64 {
+	for i := len(seqs) - 1; i >= 0; i-- {
+		if seqs[i] == seq {
+			return append(seqs[:i], seqs[i+1:]...)
+		}
+	}
+	return seqs
+}
+
+// Save the bookmark of the latest event in the channel which every route has
+// committed, and forget the events before it.
+func (self *Router) advance(channel string) error {
+	safe := self.seq
+	for _, route := range self.routes {
+		if pending := route.pending[channel]; len(pending) > 0 && pending[0]-1 < safe {
+			safe = pending[0] - 1
+		}
+	}
+	inflight := self.inflight[channel]
+	bookmark := ""
+	done := 0
+	for done < len(inflight) && inflight[done].seq <= safe {
+		if inflight[done].bookmark != "" {
+			bookmark = inflight[done].bookmark
+		}
+		done++
+	}
+	self.inflight[channel] = inflight[done:]
+	if bookmark == "" || self.config.Bookmarks == nil {
+		return nil
+	}
+	return self.config.Bookmarks.Save(channel, bookmark)
+}
+
+// A destination for some of the router's events. Pass Events() to the sink's
+// Run, and the route as the sink's BookmarkStore, so the router knows what the
+// sink has delivered.
+type Route struct {
+	router   *Router
+	name     string
+	filter   Filter
+	xpath    *xpath.XPath
+	overflow Overflow
+	events   chan *winlog.WinLogEvent
+	// Sequence numbers of the route's uncommitted events with a bookmark, for
+	// each channel
+	pending   map[string][]uint64
+	committed map[string]string
+	dropped   uint64
+}
+
+func (self *Route) Name() string {
+	return self.name
+}
+
+// Events matching the route's filter, closed when the router's input is
+func (self *Route) Events() <-chan *winlog.WinLogEvent {
+	return self.events
+}
+
+// Events dropped because the queue was full, with OverflowDrop
+func (self *Route) Dropped() uint64 {
+	self.router.mutex.Lock()
+	defer self.router.mutex.Unlock()
+	return self.dropped
+}
+
+func (self *Route) match(event *winlog.WinLogEvent, xmlTree func() *xpath.Node) bool {
+	filter := &self.filter
+	if len(filter.Channels) > 0 {
+		channel := event.Channel
+		if channel == "" {
+			channel = event.SubscribedChannel
+		}
+		if !containsFold(filter.Channels, channel) {
+			return false
+		}
+	}
+	if len(filter.Providers) > 0 && !containsFold(filter.Providers, event.ProviderName) {
+		return false
+	}
+	if len(filter.EventIds) > 0 && !containsUint(filter.EventIds, event.EventId) {
+		return false
+	}
+	if len(filter.Levels) > 0 && !containsUint(filter.Levels, event.Level) {
+		return false
+	}
+	if self.xpath != nil {
+		tree := xmlTree()
+		if tree == nil || !self.xpath.MatchTree(tree) {
+			return false
+		}
+	}
+	if filter.Predicate != nil && !filter.Predicate(event) {
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint(values []uint64, value uint64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Record that the sink has delivered every event in the channel up to the one
+// with this bookmark.
+func (self *Route) Save(channel, bookmark string) error {
+	self.router.mutex.Lock()
+	defer self.router.mutex.Unlock()
+	inflight := self.router.inflight[channel]
+	for i := len(inflight) - 1; i >= 0; i-- {
+		if inflight[i].bookmark != bookmark {
+			continue
+		}
+		pending := self.pending[channel]
+		done := 0
+		for done < len(pending) && pending[done] <= inflight[i].seq {
+			done++
+		}
+		self.pending[channel] = pending[done:]
+		break
+	}
+	self.committed[channel] = bookmark
+	return self.router.advance(channel)
+}
+
+// The last bookmark the sink committed for the channel
+func (self *Route) Load(channel string) (string, error) {
+	self.router.mutex.Lock()
+	defer self.router.mutex.Unlock()
+	return self.committed[channel], nil
+}
@@ -0,0 +1,235 @@
+package router
+
+import (
+	"fmt"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func testEvent(record uint64, eventId uint64, provider string) *winlog.WinLogEvent {
+	return &winlog.WinLogEvent{
+		ProviderName:      provider,
+		EventId:           eventId,
+		Level:             4,
+		Channel:           "Security",
+		SubscribedChannel: "Security",
+		RecordId:          record,
+		Bookmark:          fmt.Sprintf("bookmark-%v", record),
+	}
+}
+
+const logonXml = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'>
+<System><Provider Name='Microsoft-Windows-Security-Auditing'/><EventID>4624</EventID></System>
+<EventData><Data Name='LogonType'>10</Data></EventData>
+</Event>`
+
+func receive(route *Route, t *T) *winlog.WinLogEvent {
+	select {
+	case event := <-route.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event for route %v", route.Name())
+		return nil
+	}
+}
+
+func TestRouterFilters(t *T) {
+	router := New(Config{})
+	all, _ := router.AddRoute(RouteConfig{Name: "all"})
+	logons, _ := router.AddRoute(RouteConfig{Name: "logons", Filter: Filter{
+		Channels:  []string{"security"},
+		Providers: []string{"microsoft-windows-security-auditing"},
+		EventIds:  []uint64{4624, 4625},
+	}})
+	xpath, err := router.AddRoute(RouteConfig{Name: "xpath", Filter: Filter{
+		XPath: "*[EventData[Data[@Name='LogonType']='10']]",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	predicate, _ := router.AddRoute(RouteConfig{Name: "predicate", Filter: Filter{
+		Levels:    []uint64{4},
+		Predicate: func(event *winlog.WinLogEvent) bool { return event.RecordId%2 == 0 },
+	}})
+	_, err = router.AddRoute(RouteConfig{Name: "all"})
+	if err == nil {
+		t.Fatal("Duplicate route should fail")
+	}
+	_, err = router.AddRoute(RouteConfig{Name: "bad", Filter: Filter{XPath: "*["}})
+	if err == nil {
+		t.Fatal("Invalid XPath should fail")
+	}
+
+	events := make(chan *winlog.WinLogEvent, 3)
+	remote := testEvent(1, 4624, "Microsoft-Windows-Security-Auditing")
+	remote.Xml = logonXml
+	events <- remote
+	events <- testEvent(2, 4688, "Microsoft-Windows-Security-Auditing")
+	events <- testEvent(3, 4625, "Microsoft-Windows-Sysmon")
+	close(events)
+	router.Run(events)
+
+	records := func(route *Route) string {
+		var ids []uint64
+		for event := range route.Events() {
+			ids = append(ids, event.RecordId)
+		}
+		return fmt.Sprint(ids)
+	}
+	assertEqual(records(all), "[1 2 3]", t)
+	assertEqual(records(logons), "[1]", t)
+	assertEqual(records(xpath), "[1]", t)
+	assertEqual(records(predicate), "[2]", t)
+
+	_, err = router.AddRoute(RouteConfig{Name: "late"})
+	if err == nil {
+		t.Fatal("Adding a route to a running router should fail")
+	}
+}
+
+func TestRouterDrop(t *T) {
+	router := New(Config{})
+	fast, _ := router.AddRoute(RouteConfig{Name: "fast"})
+	slow, _ := router.AddRoute(RouteConfig{Name: "slow", QueueSize: 1, Overflow: OverflowDrop})
+
+	events := make(chan *winlog.WinLogEvent)
+	go router.Run(events)
+	for i := uint64(1); i <= 5; i++ {
+		events <- testEvent(i, 4624, "Microsoft-Windows-Security-Auditing")
+		assertEqual(receive(fast, t).RecordId, i, t)
+	}
+	close(events)
+	assertEqual(receive(slow, t).RecordId, uint64(1), t)
+	assertEqual(slow.Dropped(), uint64(4), t)
+	assertEqual(fast.Dropped(), uint64(0), t)
+}
+
+func TestRouterBlock(t *T) {
+	router := New(Config{})
+	fast, _ := router.AddRoute(RouteConfig{Name: "fast"})
+	slow, _ := router.AddRoute(RouteConfig{Name: "slow", QueueSize: 1})
+
+	events := make(chan *winlog.WinLogEvent, 3)
+	go router.Run(events)
+	for i := uint64(1); i <= 3; i++ {
+		events <- testEvent(i, 4624, "Microsoft-Windows-Security-Auditing")
+	}
+	// The slow route's queue holds event 1, so the router waits to give it
+	// event 2, and the fast route doesn't get event 3 in the meantime
+	assertEqual(receive(fast, t).RecordId, uint64(1), t)
+	assertEqual(receive(fast, t).RecordId, uint64(2), t)
+	select {
+	case event := <-fast.Events():
+		t.Fatalf("Fast route got event %v while the slow route was full", event.RecordId)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assertEqual(receive(slow, t).RecordId, uint64(1), t)
+	assertEqual(receive(fast, t).RecordId, uint64(3), t)
+	assertEqual(receive(slow, t).RecordId, uint64(2), t)
+	assertEqual(receive(slow, t).RecordId, uint64(3), t)
+	close(events)
+}
+
+// A bookmark store which fails to save
+type failingStore struct {
+	winlog.BookmarkStore
+}
+
+func (failingStore) Save(channel, bookmark string) error {
+	return fmt.Errorf("Disk full")
+}
+
+func TestRouterBookmarkError(t *T) {
+	router := New(Config{Bookmarks: failingStore{winlog.NewMemoryBookmarkStore()}})
+	// Events no route wants are saved straight away
+	router.AddRoute(RouteConfig{Name: "none", Filter: Filter{EventIds: []uint64{1}}})
+	events := make(chan *winlog.WinLogEvent, 2)
+	events <- testEvent(1, 4624, "Microsoft-Windows-Security-Auditing")
+	events <- testEvent(2, 4624, "Microsoft-Windows-Security-Auditing")
+	err := router.Run(events)
+	if err == nil {
+		t.Fatal("No error when the bookmark couldn't be saved")
+	}
+	assertEqual(err.Error(), `Failed to save the bookmark for "Security": Disk full`, t)
+}
+
+func TestRouterBookmarks(t *T) {
+	store := winlog.NewMemoryBookmarkStore()
+	router := New(Config{Bookmarks: store})
+	first, _ := router.AddRoute(RouteConfig{Name: "first"})
+	second, _ := router.AddRoute(RouteConfig{Name: "second", Filter: Filter{EventIds: []uint64{4625}}})
+
+	events := make(chan *winlog.WinLogEvent, 4)
+	events <- testEvent(1, 4624, "Microsoft-Windows-Security-Auditing")
+	events <- testEvent(2, 4625, "Microsoft-Windows-Security-Auditing")
+	events <- testEvent(3, 4624, "Microsoft-Windows-Security-Auditing")
+	events <- testEvent(4, 4624, "Microsoft-Windows-Security-Auditing")
+	close(events)
+	router.Run(events)
+
+	bookmark := func() string {
+		bookmark, _ := store.Load("Security")
+		return bookmark
+	}
+	// Event 1 doesn't go to the second route, but the first hasn't sent it
+	assertEqual(bookmark(), "", t)
+	winlog.CommitBookmark(first, receive(first, t))
+	assertEqual(bookmark(), "bookmark-1", t)
+
+	// The second route holds back event 2
+	receive(first, t)
+	event := receive(first, t)
+	winlog.CommitBookmark(first, event)
+	assertEqual(bookmark(), "bookmark-1", t)
+	loaded, _ := first.Load("Security")
+	assertEqual(loaded, "bookmark-3", t)
+
+	winlog.CommitBookmark(second, receive(second, t))
+	assertEqual(bookmark(), "bookmark-3", t)
+	winlog.CommitBookmark(first, receive(first, t))
+	assertEqual(bookmark(), "bookmark-4", t)
+}
+
+func TestRouterSparseBookmarks(t *T) {
+	store := winlog.NewMemoryBookmarkStore()
+	router := New(Config{Bookmarks: store})
+	all, _ := router.AddRoute(RouteConfig{Name: "all"})
+	// Only gets events without a bookmark, so never commits
+	failures, _ := router.AddRoute(RouteConfig{Name: "failures", Filter: Filter{EventIds: []uint64{4625}}})
+
+	// Every 100th event has a bookmark, as with a BookmarkInterval of 100
+	events := make(chan *winlog.WinLogEvent, 1000)
+	for record := uint64(1); record <= 1000; record++ {
+		eventId := uint64(4624)
+		if record%100 == 50 {
+			eventId = 4625
+		}
+		event := testEvent(record, eventId, "Microsoft-Windows-Security-Auditing")
+		if record%100 != 0 {
+			event.Bookmark = ""
+		}
+		events <- event
+	}
+	close(events)
+	router.Run(events)
+
+	for i := 0; i < 1000; i++ {
+		winlog.CommitBookmark(all, receive(all, t))
+	}
+	for i := 0; i < 10; i++ {
+		receive(failures, t)
+	}
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "bookmark-1000", t)
+	router.mutex.Lock()
+	assertEqual(len(router.inflight["Security"]), 0, t)
+	router.mutex.Unlock()
+}
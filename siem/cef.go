@@ -0,0 +1,93 @@
+package siem
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Escape '\' and '|' in CEF and LEEF header fields, and drop line breaks
+var headerEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+
+// Escape '\' and '=' in CEF extension values, and encode line breaks
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\r", `\r`, "\n", `\n`)
+
+// Formats events as ArcSight Common Event Format (CEF) version 0
+type CEFFormatter struct {
+	// Device vendor, product and version in the header
+	Vendor  string
+	Product string
+	Version string
+	// Payload mappings, or nil for DefaultMappings
+	Mappings Mappings
+}
+
+func NewCEFFormatter(vendor, product, version string) *CEFFormatter {
+	return &CEFFormatter{
+		Vendor:  vendor,
+		Product: product,
+		Version: version,
+	}
+}
+
+// Format the event as a CEF string. The header has the event ID as the class
+// ID, and the extension has the System properties (rt, dvchost, externalId...)
+// followed by the mapped payload fields.
+func (self *CEFFormatter) Format(event *winlog.WinLogEvent) string {
+	var out strings.Builder
+	out.WriteString("CEF:0|")
+	for _, field := range []string{
+		self.Vendor,
+		self.Product,
+		self.Version,
+		strconv.FormatUint(event.EventId, 10),
+		eventName(event),
+		strconv.Itoa(Severity(event.Level)),
+	} {
+		out.WriteString(headerEscaper.Replace(field))
+		out.WriteByte('|')
+	}
+
+	var extension []attribute
+	if !event.Created.IsZero() {
+		extension = append(extension, attribute{"rt", strconv.FormatInt(event.Created.UnixNano()/1e6, 10)})
+	}
+	if event.ComputerName != "" {
+		extension = append(extension, attribute{"dvchost", event.ComputerName})
+	}
+	if event.ProcessId != 0 {
+		extension = append(extension, attribute{"dvcpid", strconv.FormatUint(event.ProcessId, 10)})
+	}
+	extension = append(extension, attribute{"externalId", strconv.FormatUint(event.RecordId, 10)})
+	if event.Channel != "" {
+		extension = append(extension, attribute{"cat", event.Channel})
+	}
+	if event.ProviderName != "" {
+		extension = append(extension, attribute{"cs1Label", "Provider"}, attribute{"cs1", event.ProviderName})
+	}
+	if hasKeyword(event, "Audit Success") {
+		extension = append(extension, attribute{"outcome", "success"})
+	} else if hasKeyword(event, "Audit Failure") {
+		extension = append(extension, attribute{"outcome", "failure"})
+	}
+	for _, mapped := range self.Mappings.attributes(event, true) {
+		if label, ok := customLabels[mapped.key]; ok {
+			extension = append(extension, attribute{label[0], label[1]})
+		}
+		extension = append(extension, mapped)
+	}
+	if message := strings.TrimSpace(event.Msg); message != "" {
+		extension = append(extension, attribute{"msg", message})
+	}
+
+	for i, attr := range extension {
+		if i > 0 {
+			out.WriteByte(' ')
+		}
+		out.WriteString(attr.key)
+		out.WriteByte('=')
+		out.WriteString(cefValueEscaper.Replace(attr.value))
+	}
+	return out.String()
+}
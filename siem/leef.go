@@ -0,0 +1,118 @@
+package siem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Formats events as IBM QRadar Log Event Extended Format (LEEF)
+type LEEFFormatter struct {
+	// Vendor, product and version in the header
+	Vendor  string
+	Product string
+	Version string
+	// "1.0" or "2.0". Version 1.0 always separates attributes with tabs.
+	LEEFVersion string
+	// Attribute separator for LEEF 2.0, a tab by default
+	Delimiter byte
+	// Payload mappings, or nil for DefaultMappings
+	Mappings Mappings
+}
+
+// A LEEF 2.0 formatter with tab-separated attributes
+func NewLEEFFormatter(vendor, product, version string) *LEEFFormatter {
+	return &LEEFFormatter{
+		Vendor:      vendor,
+		Product:     product,
+		Version:     version,
+		LEEFVersion: "2.0",
+		Delimiter:   '\t',
+	}
+}
+
+func (self *LEEFFormatter) delimiter() byte {
+	if self.LEEFVersion == "1.0" || self.Delimiter == 0 {
+		return '\t'
+	}
+	return self.Delimiter
+}
+
+// LEEF has no escaping for attribute values, so the delimiter and line breaks
+// are replaced with spaces.
+func (self *LEEFFormatter) value(value string) string {
+	delimiter := self.delimiter()
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == rune(delimiter) {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// Format the event as a LEEF string. The header has the event ID, and the
+// attributes have the System properties (devTime in epoch milliseconds, sev,
+// cat...) followed by the mapped payload fields.
+func (self *LEEFFormatter) Format(event *winlog.WinLogEvent) string {
+	var out strings.Builder
+	version := self.LEEFVersion
+	if version == "" {
+		version = "2.0"
+	}
+	out.WriteString("LEEF:" + version + "|")
+	for _, field := range []string{
+		self.Vendor,
+		self.Product,
+		self.Version,
+		strconv.FormatUint(event.EventId, 10),
+	} {
+		out.WriteString(headerEscaper.Replace(field))
+		out.WriteByte('|')
+	}
+	delimiter := self.delimiter()
+	if version != "1.0" {
+		if delimiter == '\t' {
+			out.WriteString("x09")
+		} else {
+			out.WriteString(fmt.Sprintf("x%02X", delimiter))
+		}
+		out.WriteByte('|')
+	}
+
+	var attributes []attribute
+	if !event.Created.IsZero() {
+		attributes = append(attributes, attribute{"devTime", strconv.FormatInt(event.Created.UnixNano()/1e6, 10)})
+	}
+	attributes = append(attributes, attribute{"sev", strconv.Itoa(Severity(event.Level))})
+	if event.Channel != "" {
+		attributes = append(attributes, attribute{"cat", event.Channel})
+	}
+	if event.ComputerName != "" {
+		attributes = append(attributes, attribute{"computerName", event.ComputerName})
+	}
+	if event.ProviderName != "" {
+		attributes = append(attributes, attribute{"provider", event.ProviderName})
+	}
+	attributes = append(attributes, attribute{"recordId", strconv.FormatUint(event.RecordId, 10)})
+	if hasKeyword(event, "Audit Success") {
+		attributes = append(attributes, attribute{"outcome", "success"})
+	} else if hasKeyword(event, "Audit Failure") {
+		attributes = append(attributes, attribute{"outcome", "failure"})
+	}
+	attributes = append(attributes, self.Mappings.attributes(event, false)...)
+	if message := strings.TrimSpace(event.Msg); message != "" {
+		attributes = append(attributes, attribute{"msg", message})
+	}
+
+	for i, attr := range attributes {
+		if i > 0 {
+			out.WriteByte(delimiter)
+		}
+		out.WriteString(attr.key)
+		out.WriteByte('=')
+		out.WriteString(self.value(attr.value))
+	}
+	return out.String()
+}
@@ -0,0 +1,217 @@
+// Package siem formats events as CEF (ArcSight) and LEEF (QRadar) strings.
+package siem
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Identifies an event by its provider and ID
+type EventKey struct {
+	Provider string
+	EventId  uint64
+}
+
+// Maps a field of the event payload to a CEF extension key and a LEEF attribute
+type FieldMapping struct {
+	// EventData field name
+	Field string
+	// CEF extension key, or "" to leave it out of CEF
+	Cef string
+	// LEEF attribute, or "" to leave it out of LEEF
+	Leef string
+	// Parse the value as a decimal or 0x-prefixed hex number, such as a PID,
+	// and format it as decimal. Values that aren't numbers are dropped.
+	Number bool
+}
+
+// Payload mappings for each event
+type Mappings map[EventKey][]FieldMapping
+
+const (
+	securityProvider = "Microsoft-Windows-Security-Auditing"
+	sysmonProvider   = "Microsoft-Windows-Sysmon"
+)
+
+var (
+	logonMappings = []FieldMapping{
+		{Field: "SubjectUserName", Cef: "suser"},
+		{Field: "SubjectDomainName", Cef: "sntdom"},
+		{Field: "TargetUserName", Cef: "duser", Leef: "usrName"},
+		{Field: "TargetDomainName", Cef: "dntdom", Leef: "domain"},
+		{Field: "IpAddress", Cef: "src", Leef: "src"},
+		{Field: "IpPort", Cef: "spt", Leef: "srcPort", Number: true},
+		{Field: "WorkstationName", Cef: "shost", Leef: "identHostName"},
+		{Field: "ProcessName", Cef: "sproc"},
+		{Field: "LogonType", Cef: "cn1", Leef: "logonType", Number: true},
+	}
+	logoffMappings = []FieldMapping{
+		{Field: "TargetUserName", Cef: "duser", Leef: "usrName"},
+		{Field: "TargetDomainName", Cef: "dntdom", Leef: "domain"},
+		{Field: "LogonType", Cef: "cn1", Leef: "logonType", Number: true},
+	}
+	accountMappings = []FieldMapping{
+		{Field: "SubjectUserName", Cef: "suser", Leef: "usrName"},
+		{Field: "SubjectDomainName", Cef: "sntdom", Leef: "domain"},
+		{Field: "TargetUserName", Cef: "duser", Leef: "accountName"},
+		{Field: "TargetDomainName", Cef: "dntdom"},
+	}
+	subjectMappings = []FieldMapping{
+		{Field: "SubjectUserName", Cef: "suser", Leef: "usrName"},
+		{Field: "SubjectDomainName", Cef: "sntdom", Leef: "domain"},
+	}
+)
+
+// Mappings for well-known Security and Sysmon events, used when a formatter's
+// Mappings are nil. Copy it to add or change events.
+var DefaultMappings = Mappings{
+	{securityProvider, 4624}: logonMappings,
+	{securityProvider, 4625}: logonMappings,
+	{securityProvider, 4634}: logoffMappings,
+	{securityProvider, 4647}: logoffMappings,
+	{securityProvider, 4648}: append([]FieldMapping{
+		{Field: "TargetServerName", Cef: "dhost", Leef: "dstHostName"},
+	}, logonMappings...),
+	{securityProvider, 4672}: subjectMappings,
+	{securityProvider, 4688}: {
+		{Field: "SubjectUserName", Cef: "suser", Leef: "usrName"},
+		{Field: "SubjectDomainName", Cef: "sntdom", Leef: "domain"},
+		{Field: "NewProcessName", Cef: "dproc", Leef: "proc"},
+		{Field: "NewProcessId", Cef: "dpid", Leef: "pid", Number: true},
+		{Field: "ParentProcessName", Cef: "sproc", Leef: "parentProc"},
+		{Field: "ProcessId", Cef: "spid", Leef: "parentPid", Number: true},
+		{Field: "CommandLine", Cef: "cs2", Leef: "cmdLine"},
+	},
+	{securityProvider, 4689}: {
+		{Field: "SubjectUserName", Cef: "suser", Leef: "usrName"},
+		{Field: "SubjectDomainName", Cef: "sntdom", Leef: "domain"},
+		{Field: "ProcessName", Cef: "dproc", Leef: "proc"},
+		{Field: "ProcessId", Cef: "dpid", Leef: "pid", Number: true},
+	},
+	{securityProvider, 4720}: accountMappings,
+	{securityProvider, 4726}: accountMappings,
+	{securityProvider, 4740}: append([]FieldMapping{
+		{Field: "TargetDomainName", Cef: "shost", Leef: "srcHostName"},
+	}, accountMappings[:3]...),
+	{securityProvider, 1102}: subjectMappings,
+	{sysmonProvider, 1}: {
+		{Field: "User", Cef: "suser", Leef: "usrName"},
+		{Field: "Image", Cef: "dproc", Leef: "proc"},
+		{Field: "ProcessId", Cef: "dpid", Leef: "pid", Number: true},
+		{Field: "ParentImage", Cef: "sproc", Leef: "parentProc"},
+		{Field: "ParentProcessId", Cef: "spid", Leef: "parentPid", Number: true},
+		{Field: "CommandLine", Cef: "cs2", Leef: "cmdLine"},
+		{Field: "Hashes", Cef: "fileHash", Leef: "hashes"},
+	},
+	{sysmonProvider, 3}: {
+		{Field: "User", Cef: "suser", Leef: "usrName"},
+		{Field: "Image", Cef: "sproc", Leef: "proc"},
+		{Field: "ProcessId", Cef: "spid", Leef: "pid", Number: true},
+		{Field: "Protocol", Cef: "proto", Leef: "proto"},
+		{Field: "SourceIp", Cef: "src", Leef: "src"},
+		{Field: "SourcePort", Cef: "spt", Leef: "srcPort", Number: true},
+		{Field: "SourceHostname", Cef: "shost", Leef: "srcHostName"},
+		{Field: "DestinationIp", Cef: "dst", Leef: "dst"},
+		{Field: "DestinationPort", Cef: "dpt", Leef: "dstPort", Number: true},
+		{Field: "DestinationHostname", Cef: "dhost", Leef: "dstHostName"},
+	},
+	{sysmonProvider, 22}: {
+		{Field: "Image", Cef: "sproc", Leef: "proc"},
+		{Field: "ProcessId", Cef: "spid", Leef: "pid", Number: true},
+		{Field: "QueryName", Cef: "dhost", Leef: "dstHostName"},
+	},
+}
+
+// Labels for the custom CEF fields used in DefaultMappings
+var customLabels = map[string][2]string{
+	"cn1": {"cn1Label", "LogonType"},
+	"cs2": {"cs2Label", "CommandLine"},
+}
+
+// A key and value to add to the output
+type attribute struct {
+	key, value string
+}
+
+// Apply the mappings for the event to its payload. CEF keys are used if cef
+// is set, otherwise LEEF attributes.
+func (self Mappings) attributes(event *winlog.WinLogEvent, cef bool) []attribute {
+	if self == nil {
+		self = DefaultMappings
+	}
+	mappings := self[EventKey{event.ProviderName, event.EventId}]
+	if len(mappings) == 0 {
+		return nil
+	}
+	fields := winlog.EventPayload(event)
+	data := make(map[string]string, len(fields))
+	for _, field := range fields {
+		if _, ok := data[field.Name]; !ok {
+			data[field.Name] = field.Value
+		}
+	}
+	var attributes []attribute
+	for _, mapping := range mappings {
+		key := mapping.Leef
+		if cef {
+			key = mapping.Cef
+		}
+		value, ok := data[mapping.Field]
+		value = strings.TrimSpace(value)
+		if key == "" || !ok || value == "" || value == "-" {
+			continue
+		}
+		if mapping.Number {
+			number, err := strconv.ParseUint(value, 0, 64)
+			if err != nil {
+				continue
+			}
+			value = strconv.FormatUint(number, 10)
+		}
+		attributes = append(attributes, attribute{key, value})
+	}
+	return attributes
+}
+
+// Map an event Level to the 0-10 severity of CEF and LEEF. LogAlways (0) and
+// unknown levels are informational.
+func Severity(level uint64) int {
+	switch level {
+	case 1:
+		return 10
+	case 2:
+		return 7
+	case 3:
+		return 5
+	case 5:
+		return 1
+	default:
+		return 3
+	}
+}
+
+// The event name: the first line of the message, or the task, or the ID
+func eventName(event *winlog.WinLogEvent) string {
+	message := strings.TrimSpace(event.Msg)
+	if newline := strings.IndexAny(message, "\r\n"); newline >= 0 {
+		message = strings.TrimSpace(message[:newline])
+	}
+	if message != "" {
+		return message
+	}
+	if event.TaskText != "" {
+		return event.TaskText
+	}
+	return event.ProviderName + " " + strconv.FormatUint(event.EventId, 10)
+}
+
+func hasKeyword(event *winlog.WinLogEvent, keyword string) bool {
+	for _, k := range event.Keywords {
+		if k == keyword {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package siem
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func logonEvent() *winlog.WinLogEvent {
+	return &winlog.WinLogEvent{
+		ProviderName: "Microsoft-Windows-Security-Auditing",
+		EventId:      4624,
+		Created:      time.Date(2016, 1, 19, 19, 37, 48, 123456789, time.UTC),
+		RecordId:     10811,
+		ProcessId:    560,
+		Channel:      "Security",
+		ComputerName: "WIN-HOST",
+		Msg:          "An account was successfully logged on.\r\n\r\nSubject:\tS-1-5-18",
+		Keywords:     []string{"Audit Success"},
+		EventData: []winlog.EventDataField{
+			{Name: "SubjectUserName", Value: "WIN-HOST$"},
+			{Name: "SubjectDomainName", Value: "CORP"},
+			{Name: "TargetUserName", Value: `al=ice\|`},
+			{Name: "TargetDomainName", Value: "CORP"},
+			{Name: "LogonType", Value: "10"},
+			{Name: "WorkstationName", Value: "-"},
+			{Name: "ProcessName", Value: `C:\Windows\System32\svchost.exe`},
+			{Name: "IpAddress", Value: "10.1.2.3"},
+			{Name: "IpPort", Value: "51234"},
+		},
+	}
+}
+
+func TestSeverity(t *T) {
+	assertEqual(Severity(0), 3, t)
+	assertEqual(Severity(1), 10, t)
+	assertEqual(Severity(2), 7, t)
+	assertEqual(Severity(3), 5, t)
+	assertEqual(Severity(4), 3, t)
+	assertEqual(Severity(5), 1, t)
+}
+
+func TestCEFLogon(t *T) {
+	formatter := NewCEFFormatter("Micro|soft", `Win\dows`, "10")
+	expected := `CEF:0|Micro\|soft|Win\\dows|10|4624|An account was successfully logged on.|3|` +
+		`rt=1453232268123 dvchost=WIN-HOST dvcpid=560 externalId=10811 cat=Security ` +
+		`cs1Label=Provider cs1=Microsoft-Windows-Security-Auditing outcome=success ` +
+		`suser=WIN-HOST$ sntdom=CORP duser=al\=ice\\| dntdom=CORP src=10.1.2.3 spt=51234 ` +
+		`sproc=C:\\Windows\\System32\\svchost.exe cn1Label=LogonType cn1=10 ` +
+		`msg=An account was successfully logged on.\n\nSubject:` + "\tS-1-5-18"
+	assertEqual(formatter.Format(logonEvent()), expected, t)
+}
+
+func TestCEFUnmappedEvent(t *T) {
+	formatter := NewCEFFormatter("Microsoft", "Windows", "")
+	event := &winlog.WinLogEvent{
+		ProviderName: "Application Error",
+		EventId:      1000,
+		Level:        2,
+		TaskText:     "Application Crashing Events",
+		EventData:    []winlog.EventDataField{{Name: "", Value: "app.exe"}},
+	}
+	expected := `CEF:0|Microsoft|Windows||1000|Application Crashing Events|7|` +
+		`externalId=0 cs1Label=Provider cs1=Application Error`
+	assertEqual(formatter.Format(event), expected, t)
+}
+
+func TestCEFMapsPayloadFromXml(t *T) {
+	// Rendered with the Xml but not the EventData
+	event := &winlog.WinLogEvent{
+		ProviderName: "Microsoft-Windows-Security-Auditing",
+		EventId:      4624,
+		Xml:          `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><EventID>4624</EventID></System><EventData><Data Name='TargetUserName'>alice</Data><Data Name='IpAddress'>10.1.2.3</Data></EventData></Event>`,
+	}
+	formatted := NewCEFFormatter("Microsoft", "Windows", "10").Format(event)
+	if !strings.Contains(formatted, "duser=alice ") || !strings.Contains(formatted, "src=10.1.2.3") {
+		t.Fatalf("Payload not mapped from the XML: %v", formatted)
+	}
+}
+
+func TestCEFCustomMappings(t *T) {
+	formatter := NewCEFFormatter("Microsoft", "Windows", "")
+	formatter.Mappings = Mappings{
+		{"Microsoft-Windows-Security-Auditing", 4624}: {
+			{Field: "IpPort", Cef: "spt", Number: true},
+			{Field: "TargetUserName", Cef: "duser", Number: true},
+		},
+	}
+	formatted := formatter.Format(logonEvent())
+	if !strings.Contains(formatted, " spt=51234 ") {
+		t.Fatalf("Custom mapping is missing: %v", formatted)
+	}
+	// The user name isn't a number, and the default mappings aren't used
+	if strings.Contains(formatted, "duser=") || strings.Contains(formatted, "src=") {
+		t.Fatalf("Unexpected mapping: %v", formatted)
+	}
+}
+
+func TestLEEF2Logon(t *T) {
+	formatter := NewLEEFFormatter("Microsoft", "Windows|Security", "10")
+	expected := "LEEF:2.0|Microsoft|Windows\\|Security|10|4624|x09|" +
+		"devTime=1453232268123\tsev=3\tcat=Security\tcomputerName=WIN-HOST\t" +
+		"provider=Microsoft-Windows-Security-Auditing\trecordId=10811\toutcome=success\t" +
+		"usrName=al=ice\\|\tdomain=CORP\tsrc=10.1.2.3\tsrcPort=51234\tlogonType=10\t" +
+		"msg=An account was successfully logged on.    Subject: S-1-5-18"
+	assertEqual(formatter.Format(logonEvent()), expected, t)
+}
+
+func TestLEEFDelimiters(t *T) {
+	event := &winlog.WinLogEvent{EventId: 1, Msg: "a^b\tc"}
+	formatter := NewLEEFFormatter("V", "P", "1")
+	formatter.Delimiter = '^'
+	assertEqual(formatter.Format(event), "LEEF:2.0|V|P|1|1|x5E|sev=3^recordId=0^msg=a b\tc", t)
+
+	formatter.LEEFVersion = "1.0"
+	assertEqual(formatter.Format(event), "LEEF:1.0|V|P|1|1|sev=3\trecordId=0\tmsg=a^b c", t)
+}
+
+func TestSysmonNetworkMappings(t *T) {
+	event := &winlog.WinLogEvent{
+		ProviderName: "Microsoft-Windows-Sysmon",
+		EventId:      3,
+		Level:        4,
+		EventData: []winlog.EventDataField{
+			{Name: "ProcessId", Value: "6452"},
+			{Name: "Protocol", Value: "tcp"},
+			{Name: "SourceIp", Value: "10.1.2.3"},
+			{Name: "SourcePort", Value: "50123"},
+			{Name: "DestinationIp", Value: "93.184.216.34"},
+			{Name: "DestinationPort", Value: "443"},
+		},
+	}
+	cef := NewCEFFormatter("Microsoft", "Sysmon", "").Format(event)
+	if !strings.HasSuffix(cef, "spid=6452 proto=tcp src=10.1.2.3 spt=50123 dst=93.184.216.34 dpt=443") {
+		t.Fatalf("Unexpected CEF extension: %v", cef)
+	}
+	leef := NewLEEFFormatter("Microsoft", "Sysmon", "").Format(event)
+	if !strings.HasSuffix(leef, "pid=6452\tproto=tcp\tsrc=10.1.2.3\tsrcPort=50123\tdst=93.184.216.34\tdstPort=443") {
+		t.Fatalf("Unexpected LEEF attributes: %v", leef)
+	}
+}
@@ -0,0 +1,238 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Reads events from the spool for one sink. The reader's position is saved
+// under its name, so it resumes where it left off when the spool is reopened.
+//
+// Events from a Reader carry their spool position in the Bookmark field, in
+// place of the Windows bookmark, which the spool keeps itself (see
+// LastBookmark). The Reader is also a BookmarkStore: pass it as the sink's
+// bookmark store, and the sink's commits after each successful write advance
+// the reader's saved position.
+type Reader struct {
+	spool  *Spool
+	name   string
+	events chan *winlog.WinLogEvent
+
+	stop      chan interface{}
+	done      chan interface{}
+	closeOnce sync.Once
+}
+
+// Open the named reader, starting from its saved position, or from the oldest
+// event in the spool for a new reader. Names are letters, digits, '-' and '_'.
+func (self *Spool) Reader(name string) (*Reader, error) {
+	if !readerNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("Invalid spool reader name %q", name)
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return nil, fmt.Errorf("Spool is closed")
+	}
+	if _, ok := self.readers[name]; ok {
+		return nil, fmt.Errorf("Spool reader %q is already open", name)
+	}
+	start, ok := self.cursors[name]
+	if !ok {
+		start = position{Segment: self.segments[0].id}
+		self.cursors[name] = start
+		if err := self.saveCursor(name, start); err != nil {
+			delete(self.cursors, name)
+			return nil, err
+		}
+	}
+	reader := &Reader{
+		spool:  self,
+		name:   name,
+		events: make(chan *winlog.WinLogEvent, 64),
+		stop:   make(chan interface{}),
+		done:   make(chan interface{}),
+	}
+	self.readers[name] = reader
+	go reader.run(start)
+	return reader, nil
+}
+
+// Stop tracking a reader, so the spool no longer keeps segments for it
+func (self *Spool) RemoveReader(name string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if _, ok := self.readers[name]; ok {
+		return fmt.Errorf("Spool reader %q is open", name)
+	}
+	delete(self.cursors, name)
+	if err := os.Remove(self.path(name + cursorSuffix)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	self.retain()
+	return nil
+}
+
+func (self *Spool) saveCursor(name string, cursor position) error {
+	contents, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(self.path(name+cursorSuffix), contents)
+}
+
+// Events in the order they were appended. The channel is closed when the
+// reader or the spool is closed.
+func (self *Reader) Events() <-chan *winlog.WinLogEvent {
+	return self.events
+}
+
+// Acknowledge every event up to and including the one with the given
+// bookmark, saving the reader's position. The channel is ignored, since
+// positions are ordered across channels.
+func (self *Reader) Save(channel, bookmark string) error {
+	committed, err := parsePosition(bookmark)
+	if err != nil {
+		return err
+	}
+	self.spool.mutex.Lock()
+	defer self.spool.mutex.Unlock()
+	if !self.spool.cursors[self.name].before(committed) {
+		return nil
+	}
+	if err := self.spool.saveCursor(self.name, committed); err != nil {
+		return err
+	}
+	self.spool.cursors[self.name] = committed
+	self.spool.retain()
+	return nil
+}
+
+// The reader's saved position, as a bookmark
+func (self *Reader) Load(channel string) (string, error) {
+	self.spool.mutex.Lock()
+	defer self.spool.mutex.Unlock()
+	return self.spool.cursors[self.name].String(), nil
+}
+
+// Stop reading and close the Events channel. The saved position is kept.
+func (self *Reader) Close() error {
+	self.closeOnce.Do(func() {
+		close(self.stop)
+	})
+	<-self.done
+	self.spool.mutex.Lock()
+	if self.spool.readers[self.name] == self {
+		delete(self.spool.readers, self.name)
+	}
+	self.spool.mutex.Unlock()
+	return nil
+}
+
+// Where to read next: the readable end of the segment, whether it's the last,
+// the segment after it, and a channel closed on the next append.
+func (self *Reader) next(current position) (position, int64, bool, chan interface{}, bool) {
+	self.spool.mutex.Lock()
+	defer self.spool.mutex.Unlock()
+	segments := self.spool.segments
+	// Segments behind the reader may have been deleted by retention
+	if current.Segment < segments[0].id {
+		current = position{Segment: segments[0].id}
+	}
+	for i, seg := range segments {
+		if seg.id == current.Segment {
+			return current, seg.size, i == len(segments)-1, self.spool.notify, self.spool.closed
+		}
+		if seg.id > current.Segment {
+			return position{Segment: seg.id}, seg.size, i == len(segments)-1, self.spool.notify, self.spool.closed
+		}
+	}
+	// Past the end, which only happens with a cursor from a deleted spool
+	last := segments[len(segments)-1]
+	return position{Segment: last.id, Offset: last.size}, last.size, true, self.spool.notify, self.spool.closed
+}
+
+func (self *Reader) run(current position) {
+	defer close(self.done)
+	defer close(self.events)
+	var file *os.File
+	var fileSegment uint64
+	var reader *bufio.Reader
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	for {
+		var end int64
+		var last, closed bool
+		var notify chan interface{}
+		current, end, last, notify, closed = self.next(current)
+		if current.Offset >= end {
+			if !last {
+				current = position{Segment: current.Segment + 1}
+				continue
+			}
+			if closed {
+				return
+			}
+			select {
+			case <-notify:
+			case <-self.stop:
+				return
+			}
+			continue
+		}
+
+		if file == nil || fileSegment != current.Segment {
+			if file != nil {
+				file.Close()
+			}
+			var err error
+			file, err = os.Open(self.spool.path(segmentName(current.Segment)))
+			if err != nil {
+				// Deleted by retention; next() moves on to the oldest segment
+				file = nil
+				current = position{Segment: current.Segment + 1}
+				continue
+			}
+			fileSegment = current.Segment
+			if _, err := file.Seek(current.Offset, io.SeekStart); err != nil {
+				return
+			}
+			reader = bufio.NewReaderSize(io.LimitReader(file, end-current.Offset), 64*1024)
+		} else {
+			// Pick up records appended since the last read
+			if _, err := file.Seek(current.Offset, io.SeekStart); err != nil {
+				return
+			}
+			reader.Reset(io.LimitReader(file, end-current.Offset))
+		}
+
+		for current.Offset < end {
+			payload, size, err := readRecord(reader)
+			if err != nil {
+				// A corrupt record in a closed segment; skip the rest of it
+				current.Offset = end
+				break
+			}
+			current.Offset += size
+			event := &winlog.WinLogEvent{}
+			if err := json.Unmarshal(payload, event); err != nil {
+				continue
+			}
+			event.Bookmark = current.String()
+			select {
+			case self.events <- event:
+			case <-self.stop:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Each record is a little-endian payload length and CRC-32C of the payload,
+// followed by the payload, which is the event in its JSON encoding.
+const recordHeaderSize = 8
+
+// Records larger than this are treated as corrupt
+const maxRecordSize = 64 * 1024 * 1024
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("Corrupt spool record")
+
+func encodeRecord(event *winlog.WinLogEvent) ([]byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.Checksum(payload, crcTable))
+	return append(record, payload...), nil
+}
+
+// Read the record at the reader's position, returning its payload and size on
+// disk. Returns io.EOF at the end, errCorruptRecord for a torn or damaged
+// record.
+func readRecord(reader io.Reader) ([]byte, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errCorruptRecord
+		}
+		return nil, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[:])
+	if length > maxRecordSize {
+		return nil, 0, errCorruptRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, errCorruptRecord
+		}
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, 0, errCorruptRecord
+	}
+	return payload, int64(recordHeaderSize + len(payload)), nil
+}
+
+// A position in the spool: a segment and a byte offset in it
+type position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+func (self position) before(other position) bool {
+	return self.Segment < other.Segment ||
+		(self.Segment == other.Segment && self.Offset < other.Offset)
+}
+
+const positionPrefix = "spool:"
+
+// The bookmark a Reader puts in events, which is the position after the event
+func (self position) String() string {
+	return positionPrefix + strconv.FormatUint(self.Segment, 10) + ":" + strconv.FormatInt(self.Offset, 10)
+}
+
+func parsePosition(bookmark string) (position, error) {
+	parts := strings.Split(strings.TrimPrefix(bookmark, positionPrefix), ":")
+	if !strings.HasPrefix(bookmark, positionPrefix) || len(parts) != 2 {
+		return position{}, fmt.Errorf("Invalid spool position %q", bookmark)
+	}
+	segment, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return position{}, fmt.Errorf("Invalid spool position %q", bookmark)
+	}
+	offset, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return position{}, fmt.Errorf("Invalid spool position %q", bookmark)
+	}
+	return position{segment, offset}, nil
+}
@@ -0,0 +1,495 @@
+// Package spool is a disk-backed write-ahead log between a WinLogWatcher and
+// its sinks. Events are appended to segment files as fast as the watcher
+// produces them, and each sink reads them back at its own pace through a
+// named Reader, so a slow or failed sink doesn't block the watcher.
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+const (
+	segmentSuffix  = ".segment"
+	cursorSuffix   = ".cursor"
+	checkpointName = "checkpoint.json"
+)
+
+var readerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// Settings for a Spool. Only Dir is required.
+type Config struct {
+	Dir string
+	// Size at which a new segment is started, 16MB by default
+	SegmentSize int64
+	// Segments are deleted once every reader has read past them, or when
+	// the spool is larger than MaxBytes, or when they're older than MaxAge,
+	// even if readers haven't read them yet. 1GB and no age limit by default.
+	MaxBytes int64
+	MaxAge   time.Duration
+	// How often appended events are flushed to disk, 1 second by default.
+	// Negative values flush after every event. Events from the last interval
+	// may be lost in a power failure, but the watcher resumes from
+	// LastBookmark, which only covers flushed events, so they're read again.
+	SyncInterval time.Duration
+}
+
+type segment struct {
+	id       uint64
+	size     int64
+	modified time.Time
+}
+
+// The last flushed position and bookmarks, so recovery only has to scan
+// records after it
+type checkpoint struct {
+	Position  position          `json:"position"`
+	Bookmarks map[string]string `json:"bookmarks"`
+}
+
+// A segmented, append-only store of events
+type Spool struct {
+	config   Config
+	segments []*segment
+	active   *os.File
+	// The last appended bookmark for each subscribed channel
+	bookmarks map[string]string
+	// The last bookmark for each channel as of the last sync
+	syncedBookmarks map[string]string
+	// Committed positions of every reader, open or not
+	cursors map[string]position
+	readers map[string]*Reader
+
+	unsynced bool
+	lastSync time.Time
+	// Closed and replaced whenever events are appended
+	notify chan interface{}
+	closed bool
+	stop   chan interface{}
+	done   chan interface{}
+	mutex  sync.Mutex
+}
+
+// Open the spool in config.Dir, creating it if needed. Torn records at the end
+// of the last segment, from a crash during a write, are truncated.
+func Open(config Config) (*Spool, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("No spool directory")
+	}
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = 16 * 1024 * 1024
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 1024 * 1024 * 1024
+	}
+	if config.SyncInterval == 0 {
+		config.SyncInterval = time.Second
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	self := &Spool{
+		config:          config,
+		bookmarks:       make(map[string]string),
+		syncedBookmarks: make(map[string]string),
+		cursors:         make(map[string]position),
+		readers:         make(map[string]*Reader),
+		notify:          make(chan interface{}),
+		stop:            make(chan interface{}),
+		done:            make(chan interface{}),
+	}
+	if err := self.load(); err != nil {
+		return nil, err
+	}
+	if err := self.recover(); err != nil {
+		return nil, err
+	}
+	if err := self.openActive(); err != nil {
+		return nil, err
+	}
+	if err := self.writeCheckpoint(); err != nil {
+		self.active.Close()
+		return nil, err
+	}
+	// Recovered records are already on disk
+	self.markSynced()
+	go self.syncLoop()
+	return self, nil
+}
+
+func (self *Spool) path(name string) string {
+	return filepath.Join(self.config.Dir, name)
+}
+
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%020d%s", id, segmentSuffix)
+}
+
+// Find the segments and reader cursors in the directory
+func (self *Spool) load() error {
+	entries, err := os.ReadDir(self.config.Dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, segmentSuffix):
+			id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+			if err != nil {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			self.segments = append(self.segments, &segment{id, info.Size(), info.ModTime()})
+		case strings.HasSuffix(name, cursorSuffix):
+			contents, err := os.ReadFile(self.path(name))
+			if err != nil {
+				return err
+			}
+			var cursor position
+			if err := json.Unmarshal(contents, &cursor); err != nil {
+				return fmt.Errorf("Invalid spool cursor %v: %v", name, err)
+			}
+			self.cursors[strings.TrimSuffix(name, cursorSuffix)] = cursor
+		}
+	}
+	sort.Slice(self.segments, func(i, j int) bool {
+		return self.segments[i].id < self.segments[j].id
+	})
+	return nil
+}
+
+// Rebuild the last bookmarks by scanning the records after the checkpoint, and
+// cut each segment off at its first corrupt record.
+func (self *Spool) recover() error {
+	var saved checkpoint
+	if contents, err := os.ReadFile(self.path(checkpointName)); err == nil {
+		if err := json.Unmarshal(contents, &saved); err != nil {
+			return fmt.Errorf("Invalid spool checkpoint: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for channel, bookmark := range saved.Bookmarks {
+		self.bookmarks[channel] = bookmark
+	}
+	for i, seg := range self.segments {
+		var start int64
+		if seg.id < saved.Position.Segment {
+			continue
+		} else if seg.id == saved.Position.Segment && saved.Position.Offset <= seg.size {
+			start = saved.Position.Offset
+		}
+		valid, err := self.scan(seg, start)
+		if err != nil {
+			return err
+		}
+		if valid < seg.size {
+			if i == len(self.segments)-1 {
+				// A torn write at the end of the log
+				if err := os.Truncate(self.path(segmentName(seg.id)), valid); err != nil {
+					return err
+				}
+			}
+			seg.size = valid
+		}
+	}
+	return nil
+}
+
+// Read the records of a segment from start, updating the bookmarks, and
+// return the offset after the last valid record.
+func (self *Spool) scan(seg *segment, start int64) (int64, error) {
+	file, err := os.Open(self.path(segmentName(seg.id)))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReaderSize(file, 64*1024)
+	offset := start
+	for {
+		payload, size, err := readRecord(reader)
+		if err == io.EOF || err == errCorruptRecord {
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		var event winlog.WinLogEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return offset, nil
+		}
+		if event.Bookmark != "" {
+			self.bookmarks[event.SubscribedChannel] = event.Bookmark
+		}
+		offset += size
+	}
+}
+
+// Open the last segment for appending, or start the first one
+func (self *Spool) openActive() error {
+	if len(self.segments) == 0 {
+		self.segments = append(self.segments, &segment{id: 1, modified: time.Now()})
+	}
+	last := self.segments[len(self.segments)-1]
+	file, err := os.OpenFile(self.path(segmentName(last.id)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	self.active = file
+	return nil
+}
+
+func (self *Spool) writeCheckpoint() error {
+	last := self.segments[len(self.segments)-1]
+	saved := checkpoint{
+		Position:  position{last.id, last.size},
+		Bookmarks: self.bookmarks,
+	}
+	contents, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(self.path(checkpointName), contents)
+}
+
+// Write through a temporary file and a rename, so the file is never half-written
+func writeFileAtomic(path string, contents []byte) error {
+	temp := path + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// The bookmark of the last event flushed to disk for a subscribed channel,
+// or "" if there isn't one. Resume the watcher's subscription from it when
+// the spool is reopened. Events appended since the last sync aren't covered
+// until the next one; call Sync to flush them.
+func (self *Spool) LastBookmark(channel string) string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.syncedBookmarks[channel]
+}
+
+// Append events from the channel, such as WinLogWatcher.Event(), until it's
+// closed. Returns the first error from Append.
+func (self *Spool) Run(events <-chan *winlog.WinLogEvent) error {
+	for event := range events {
+		if err := self.Append(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write an event to the end of the spool
+func (self *Spool) Append(event *winlog.WinLogEvent) error {
+	record, err := encodeRecord(event)
+	if err != nil {
+		return err
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return fmt.Errorf("Spool is closed")
+	}
+	last := self.segments[len(self.segments)-1]
+	if last.size > 0 && last.size+int64(len(record)) > self.config.SegmentSize {
+		if err := self.rotate(); err != nil {
+			return err
+		}
+		last = self.segments[len(self.segments)-1]
+	}
+	n, err := self.active.Write(record)
+	if err != nil {
+		// Drop the partial record, so the next append starts cleanly
+		self.active.Truncate(last.size)
+		return err
+	}
+	last.size += int64(n)
+	last.modified = time.Now()
+	if event.Bookmark != "" {
+		self.bookmarks[event.SubscribedChannel] = event.Bookmark
+	}
+	self.unsynced = true
+	if self.config.SyncInterval < 0 || time.Since(self.lastSync) >= self.config.SyncInterval {
+		if err := self.sync(); err != nil {
+			return err
+		}
+	}
+	close(self.notify)
+	self.notify = make(chan interface{})
+	return nil
+}
+
+// Flush the active segment and record a checkpoint
+func (self *Spool) sync() error {
+	if !self.unsynced {
+		return nil
+	}
+	if err := self.active.Sync(); err != nil {
+		return err
+	}
+	if err := self.writeCheckpoint(); err != nil {
+		return err
+	}
+	self.markSynced()
+	self.unsynced = false
+	self.lastSync = time.Now()
+	return nil
+}
+
+// Record that the appended bookmarks are on disk
+func (self *Spool) markSynced() {
+	for channel, bookmark := range self.bookmarks {
+		self.syncedBookmarks[channel] = bookmark
+	}
+}
+
+// Flush any unsynced events to disk
+func (self *Spool) Sync() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.sync()
+}
+
+func (self *Spool) syncLoop() {
+	defer close(self.done)
+	interval := self.config.SyncInterval
+	if interval < 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			self.mutex.Lock()
+			self.sync()
+			self.retain()
+			self.mutex.Unlock()
+		case <-self.stop:
+			return
+		}
+	}
+}
+
+// Close the active segment and start a new one
+func (self *Spool) rotate() error {
+	self.unsynced = true
+	if err := self.sync(); err != nil {
+		return err
+	}
+	if err := self.active.Close(); err != nil {
+		return err
+	}
+	last := self.segments[len(self.segments)-1]
+	self.segments = append(self.segments, &segment{id: last.id + 1, modified: time.Now()})
+	if err := self.openActive(); err != nil {
+		return err
+	}
+	if err := self.writeCheckpoint(); err != nil {
+		return err
+	}
+	self.retain()
+	return nil
+}
+
+// Delete segments that every reader has finished with, and old segments past
+// the size and age limits. The active segment is never deleted.
+func (self *Spool) retain() {
+	var total int64
+	for _, seg := range self.segments {
+		total += seg.size
+	}
+	for len(self.segments) > 1 {
+		oldest := self.segments[0]
+		consumed := len(self.cursors) > 0
+		for _, cursor := range self.cursors {
+			if cursor.Segment <= oldest.id {
+				consumed = false
+			}
+		}
+		expired := self.config.MaxAge > 0 && time.Since(oldest.modified) > self.config.MaxAge
+		if !consumed && !expired && total <= self.config.MaxBytes {
+			return
+		}
+		if err := os.Remove(self.path(segmentName(oldest.id))); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		total -= oldest.size
+		self.segments = self.segments[1:]
+	}
+}
+
+// Total size of the segments on disk
+func (self *Spool) Size() int64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	var total int64
+	for _, seg := range self.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// Flush and close the spool, and stop its readers
+func (self *Spool) Close() error {
+	self.mutex.Lock()
+	if self.closed {
+		self.mutex.Unlock()
+		return nil
+	}
+	self.closed = true
+	err := self.sync()
+	close(self.notify)
+	readers := make([]*Reader, 0, len(self.readers))
+	for _, reader := range self.readers {
+		readers = append(readers, reader)
+	}
+	self.mutex.Unlock()
+
+	close(self.stop)
+	<-self.done
+	for _, reader := range readers {
+		reader.Close()
+	}
+	if closeErr := self.active.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
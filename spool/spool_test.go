@@ -0,0 +1,266 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func testEvent(record uint64) *winlog.WinLogEvent {
+	return &winlog.WinLogEvent{
+		ProviderName:      "Microsoft-Windows-Security-Auditing",
+		EventId:           4624,
+		RecordId:          record,
+		Channel:           "Security",
+		Msg:               "An account was successfully logged on.",
+		Bookmark:          "<Bookmark RecordId='" + strconv.FormatUint(record, 10) + "'/>",
+		SubscribedChannel: "Security",
+	}
+}
+
+func openSpool(config Config, t *T) *Spool {
+	spool, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spool
+}
+
+func appendEvents(spool *Spool, first, count uint64, t *T) {
+	for i := first; i < first+count; i++ {
+		if err := spool.Append(testEvent(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func receive(reader *Reader, t *T) *winlog.WinLogEvent {
+	select {
+	case event, ok := <-reader.Events():
+		if !ok {
+			t.Fatal("Reader was closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return nil
+}
+
+func TestAppendAndRead(t *T) {
+	spool := openSpool(Config{Dir: t.TempDir()}, t)
+	defer spool.Close()
+	reader, err := spool.Reader("sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(spool, 1, 3, t)
+	for i := uint64(1); i <= 3; i++ {
+		event := receive(reader, t)
+		assertEqual(event.RecordId, i, t)
+		assertEqual(event.Msg, "An account was successfully logged on.", t)
+	}
+	// The reader blocks until more events are appended
+	appendEvents(spool, 4, 1, t)
+	assertEqual(receive(reader, t).RecordId, uint64(4), t)
+	if err := spool.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(spool.LastBookmark("Security"), "<Bookmark RecordId='4'/>", t)
+
+	if _, err := spool.Reader("sink"); err == nil {
+		t.Fatal("No error opening a reader twice")
+	}
+	if _, err := spool.Reader("../sink"); err == nil {
+		t.Fatal("No error for an invalid reader name")
+	}
+}
+
+func TestReaderResumesAfterCommit(t *T) {
+	dir := t.TempDir()
+	spool := openSpool(Config{Dir: dir}, t)
+	reader, err := spool.Reader("sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(spool, 1, 5, t)
+	var third *winlog.WinLogEvent
+	for i := 0; i < 5; i++ {
+		event := receive(reader, t)
+		if event.RecordId == 3 {
+			third = event
+		}
+	}
+	// A sink commits the bookmark of the third event after writing it
+	if err := winlog.CommitBookmark(reader, third); err != nil {
+		t.Fatal(err)
+	}
+	// Earlier positions don't move the reader back
+	if err := reader.Save("Security", "spool:1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Save("Security", "<BookmarkList/>"); err == nil {
+		t.Fatal("No error for a bookmark that isn't a spool position")
+	}
+	spool.Close()
+
+	spool = openSpool(Config{Dir: dir}, t)
+	defer spool.Close()
+	assertEqual(spool.LastBookmark("Security"), "<Bookmark RecordId='5'/>", t)
+	reader, err = spool.Reader("sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(receive(reader, t).RecordId, uint64(4), t)
+	assertEqual(receive(reader, t).RecordId, uint64(5), t)
+}
+
+func TestLastBookmarkWaitsForSync(t *T) {
+	spool := openSpool(Config{Dir: t.TempDir(), SyncInterval: time.Hour}, t)
+	defer spool.Close()
+	// The first append syncs, since there hasn't been one yet
+	appendEvents(spool, 1, 1, t)
+	assertEqual(spool.LastBookmark("Security"), "<Bookmark RecordId='1'/>", t)
+	appendEvents(spool, 2, 2, t)
+	assertEqual(spool.LastBookmark("Security"), "<Bookmark RecordId='1'/>", t)
+	if err := spool.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(spool.LastBookmark("Security"), "<Bookmark RecordId='3'/>", t)
+}
+
+func TestRecoverTornWrite(t *T) {
+	dir := t.TempDir()
+	spool := openSpool(Config{Dir: dir, SyncInterval: time.Hour}, t)
+	appendEvents(spool, 1, 3, t)
+	spool.Close()
+
+	// A crash part way through writing a record
+	segmentPath := filepath.Join(dir, segmentName(1))
+	info, _ := os.Stat(segmentPath)
+	record, _ := encodeRecord(testEvent(4))
+	file, _ := os.OpenFile(segmentPath, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write(record[:len(record)-5])
+	file.Close()
+	// A checkpoint from before the last two events
+	os.WriteFile(filepath.Join(dir, checkpointName), []byte(`{"position":{"segment":1,"offset":0},"bookmarks":{}}`), 0644)
+
+	spool = openSpool(Config{Dir: dir}, t)
+	defer spool.Close()
+	assertEqual(spool.LastBookmark("Security"), "<Bookmark RecordId='3'/>", t)
+	info2, _ := os.Stat(segmentPath)
+	assertEqual(info2.Size(), info.Size(), t)
+
+	appendEvents(spool, 5, 1, t)
+	reader, err := spool.Reader("sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []uint64{1, 2, 3, 5} {
+		assertEqual(receive(reader, t).RecordId, expected, t)
+	}
+}
+
+func TestCorruptRecordSkipsSegment(t *T) {
+	dir := t.TempDir()
+	spool := openSpool(Config{Dir: dir, SegmentSize: 600}, t)
+	appendEvents(spool, 1, 6, t)
+	assertEqual(len(spool.segments) > 2, true, t)
+	spool.Close()
+
+	// Damage the payload of the first record
+	segmentPath := filepath.Join(dir, segmentName(1))
+	contents, _ := os.ReadFile(segmentPath)
+	contents[recordHeaderSize+10] ^= 0xff
+	os.WriteFile(segmentPath, contents, 0644)
+
+	spool = openSpool(Config{Dir: dir, SegmentSize: 600}, t)
+	defer spool.Close()
+	reader, err := spool.Reader("sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading continues from the next segment
+	first := receive(reader, t)
+	if first.RecordId <= 1 {
+		t.Fatalf("Read a corrupt record %v", first.RecordId)
+	}
+}
+
+func TestRetention(t *T) {
+	dir := t.TempDir()
+	spool := openSpool(Config{Dir: dir, SegmentSize: 600}, t)
+	defer spool.Close()
+	reader, err := spool.Reader("sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(spool, 1, 10, t)
+	segments := len(spool.segments)
+	assertEqual(segments > 3, true, t)
+
+	// Segments are deleted once the reader has committed past them
+	var last *winlog.WinLogEvent
+	for i := 0; i < 10; i++ {
+		last = receive(reader, t)
+	}
+	if err := winlog.CommitBookmark(reader, last); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	assertEqual(len(files), 1, t)
+}
+
+func TestRetentionLimits(t *T) {
+	dir := t.TempDir()
+	spool := openSpool(Config{Dir: dir, SegmentSize: 600, MaxBytes: 1500}, t)
+	defer spool.Close()
+	// A reader which never commits doesn't stop the size limit
+	if _, err := spool.Reader("slow"); err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(spool, 1, 20, t)
+	if spool.Size() > 1500+600 {
+		t.Fatalf("Spool is %v bytes, over the limit", spool.Size())
+	}
+
+	spool.mutex.Lock()
+	spool.config.MaxAge = time.Millisecond
+	for _, seg := range spool.segments {
+		seg.modified = time.Now().Add(-time.Hour)
+	}
+	spool.retain()
+	remaining := len(spool.segments)
+	spool.mutex.Unlock()
+	assertEqual(remaining, 1, t)
+}
+
+func TestRemoveReader(t *T) {
+	dir := t.TempDir()
+	spool := openSpool(Config{Dir: dir}, t)
+	defer spool.Close()
+	reader, err := spool.Reader("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.RemoveReader("old"); err == nil {
+		t.Fatal("No error removing an open reader")
+	}
+	reader.Close()
+	if err := spool.RemoveReader("old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old"+cursorSuffix)); !os.IsNotExist(err) {
+		t.Fatal("Cursor file was not removed")
+	}
+}
@@ -0,0 +1,100 @@
+package winlog
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// The time constant of the moving average of events per second
+const statsRateWindow = time.Minute
+
+// How a subscription is keeping up with its channel, for health checks which
+// alert on stalled or lagging channels
+type SubscriptionStats struct {
+	Channel string
+	// When the subscription was made
+	Subscribed time.Time
+	// Events delivered on the Event channel
+	Delivered uint64
+
+	// The RecordId and Created time of the last event delivered. LastCreated
+	// is zero if the render profile leaves out the creation time.
+	LastRecordId uint64
+	LastCreated  time.Time
+	// When the last event was delivered
+	LastDelivered time.Time
+	// How far behind the last event was when it was delivered, from its
+	// creation. 0 if LastCreated isn't known.
+	Lag time.Duration
+	// Time since the last event was delivered, or since the subscription was
+	// made if there hasn't been one
+	Idle time.Duration
+	// Events delivered per second, as a moving average over about a minute
+	EventsPerSecond float64
+
+	// The last error reading or rendering the channel's events, and when it
+	// happened
+	LastError     error
+	LastErrorTime time.Time
+}
+
+// Keeps a subscription's stats up to date as events are delivered
+type subscriptionStats struct {
+	stats SubscriptionStats
+	// The moving average of the rate as of the last event
+	rate  float64
+	mutex sync.Mutex
+}
+
+func newSubscriptionStats(channel string, now time.Time) *subscriptionStats {
+	return &subscriptionStats{stats: SubscriptionStats{Channel: channel, Subscribed: now}}
+}
+
+// Decay the rate from the last event to `now`. Each event adds 1/window, so
+// a steady stream of events converges on its rate.
+func (self *subscriptionStats) decayedRate(now time.Time) float64 {
+	if self.stats.LastDelivered.IsZero() {
+		return 0
+	}
+	elapsed := now.Sub(self.stats.LastDelivered)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return self.rate * math.Exp(-elapsed.Seconds()/statsRateWindow.Seconds())
+}
+
+func (self *subscriptionStats) delivered(event *WinLogEvent, now time.Time) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.rate = self.decayedRate(now) + 1/statsRateWindow.Seconds()
+	self.stats.Delivered++
+	self.stats.LastRecordId = event.RecordId
+	self.stats.LastCreated = event.Created
+	self.stats.LastDelivered = now
+	self.stats.Lag = 0
+	if !event.Created.IsZero() {
+		self.stats.Lag = now.Sub(event.Created)
+	}
+}
+
+func (self *subscriptionStats) failed(err error, now time.Time) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.stats.LastError = err
+	self.stats.LastErrorTime = now
+}
+
+// The stats as of `now`
+func (self *subscriptionStats) snapshot(now time.Time) SubscriptionStats {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	stats := self.stats
+	stats.EventsPerSecond = self.decayedRate(now)
+	if stats.LastDelivered.IsZero() {
+		stats.Idle = now.Sub(stats.Subscribed)
+	} else {
+		stats.Idle = now.Sub(stats.LastDelivered)
+	}
+	return stats
+}
@@ -0,0 +1,51 @@
+package winlog
+
+import (
+	"errors"
+	"math"
+	. "testing"
+	"time"
+)
+
+func TestSubscriptionStats(t *T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stats := newSubscriptionStats("System", start)
+	snapshot := stats.snapshot(start.Add(time.Minute))
+	assertEqual(snapshot.Idle, time.Minute, t)
+	assertEqual(snapshot.EventsPerSecond, 0.0, t)
+
+	// Ten events a second for ten minutes converges on the rate
+	now := start
+	for i := 1; i <= 6000; i++ {
+		now = start.Add(time.Duration(i) * 100 * time.Millisecond)
+		stats.delivered(&WinLogEvent{RecordId: uint64(i), Created: now.Add(-2 * time.Second)}, now)
+	}
+	snapshot = stats.snapshot(now)
+	assertEqual(snapshot.Delivered, uint64(6000), t)
+	assertEqual(snapshot.LastRecordId, uint64(6000), t)
+	assertEqual(snapshot.LastCreated, now.Add(-2*time.Second), t)
+	assertEqual(snapshot.Lag, 2*time.Second, t)
+	assertEqual(snapshot.Idle, time.Duration(0), t)
+	if math.Abs(snapshot.EventsPerSecond-10) > 0.1 {
+		t.Fatalf("Expected about 10 events per second, got %v", snapshot.EventsPerSecond)
+	}
+
+	// The rate decays while the channel is idle
+	snapshot = stats.snapshot(now.Add(time.Minute))
+	assertEqual(snapshot.Idle, time.Minute, t)
+	if math.Abs(snapshot.EventsPerSecond-10/math.E) > 0.1 {
+		t.Fatalf("Expected about %v events per second, got %v", 10/math.E, snapshot.EventsPerSecond)
+	}
+
+	// Lag isn't known without the created time
+	err := errors.New("Failed to render event")
+	stats.failed(err, now)
+	stats.delivered(&WinLogEvent{RecordId: 6001}, now.Add(time.Second))
+	snapshot = stats.snapshot(now.Add(time.Second))
+	assertEqual(snapshot.Lag, time.Duration(0), t)
+	assertEqual(snapshot.LastCreated.IsZero(), true, t)
+	assertEqual(snapshot.LastError, err, t)
+	assertEqual(snapshot.LastErrorTime, now, t)
+	assertEqual(snapshot.Channel, "System", t)
+	assertEqual(snapshot.Subscribed, start, t)
+}
@@ -6,6 +6,9 @@ import (
 	"unsafe"
 )
 
+// The LCID for US English, for use with SetLocale
+const LocaleEnglishUS = 0x0409
+
 // Stores the common fields from a log event
 type WinLogEvent struct {
 	// From EvtRender
@@ -34,11 +37,18 @@ type WinLogEvent struct {
 	ProviderText       string
 	IdText             string
 	PublisherHandleErr error
+	// The US English message, if enabled with SetRenderEnglishMessage
+	EnglishMsg string
+	// The locale (LCID) the localized fields were rendered in
+	Locale uint32
 
 	// XML body
 	Xml    string
 	XmlErr error
 
+	// Payload from the EventData or UserData section
+	EventData []EventDataField
+
 	// Serialied XML bookmark to
 	// restart at this event
 	Bookmark string
@@ -52,6 +62,17 @@ type channelWatcher struct {
 	subscription ListenerHandle
 	callback     *LogEventCallbackWrapper
 	bookmark     BookmarkHandle
+	// Events published, for rendering the bookmark every N events
+	published uint64
+	// Identifies the subscription in log messages
+	id uint64
+	// Delivery and error statistics, for health checks
+	stats *subscriptionStats
+
+	// Set for pull subscriptions, which are used with render workers
+	signal SignalHandle
+	stop   chan interface{}
+	done   chan interface{}
 }
 
 // Watches one or more event log channels
@@ -65,16 +86,31 @@ type WinLogWatcher struct {
 	watches       map[string]*channelWatcher
 	watchMutex    sync.Mutex
 	shutdown      chan interface{}
+	// The ID of the last subscription made
+	subscriptionIds uint64
+
+	// What to render for each event, by default and for specific channels.
+	// These can be changed while events are being rendered.
+	profile         RenderProfile
+	channelProfiles map[string]RenderProfile
+	profileMutex    sync.Mutex
+
+	// Settings for localized fields, also guarded by profileMutex.
+	// Locale (LCID) for localized fields, or 0 for the default UI language
+	locale uint32
+	// Also render the message in US English when using another locale
+	renderEnglishMessage bool
+	// If set, event messages are expanded in Go from cached templates
+	// instead of calling EvtFormatMessage for every event.
+	messageTemplates *MessageTemplateCache
+
+	// If set, events are rendered on a pool of workers
+	renderPool *renderPool
 
-	// Optionally render localized fields. EvtFormatMessage() is slow, so
-	// skipping these fields provides a big speedup.
-	renderMessage  bool
-	renderLevel    bool
-	renderTask     bool
-	renderProvider bool
-	renderOpcode   bool
-	renderChannel  bool
-	renderId       bool
+	// Receives measurements of events received, rendered and delivered
+	metrics Metrics
+	// Receives diagnostic messages about subscriptions and failures
+	logger Logger
 }
 
 type SysRenderContext uint64
@@ -83,6 +119,13 @@ type PublisherHandle uint64
 type EventHandle uint64
 type RenderedFields unsafe.Pointer
 type BookmarkHandle uint64
+type ObjectArrayHandle uint64
+type EventMetadataEnumHandle uint64
+type PublisherEnumHandle uint64
+type ChannelEnumHandle uint64
+type ChannelConfigHandle uint64
+type EventMetadataHandle uint64
+type SignalHandle uint64
 
 type LogEventCallback interface {
 	PublishError(error)
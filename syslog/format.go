@@ -0,0 +1,249 @@
+package syslog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Message format, selected with Config.Format
+type Format int
+
+const (
+	// RFC 5424, with System properties and EventData as structured data
+	RFC5424 Format = iota
+	// The older BSD format from RFC 3164, which has no structured data
+	RFC3164
+)
+
+// Syslog facilities, for Config.Facility
+const (
+	FacilityUser     = 1
+	FacilityDaemon   = 3
+	FacilityAuth     = 4
+	FacilityAuthPriv = 10
+	FacilityLocal0   = 16
+	FacilityLocal7   = 23
+)
+
+// Syslog severities
+const (
+	SeverityEmergency = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInformational
+	SeverityDebug
+)
+
+// The example enterprise number from RFC 5612, used in SD-IDs by default
+const DefaultEnterpriseId = 32473
+
+const nilValue = "-"
+
+// Map an event Level to a syslog severity. LogAlways (0) and unknown levels
+// are informational.
+func Severity(level uint64) int {
+	switch level {
+	case 1:
+		return SeverityCritical
+	case 2:
+		return SeverityError
+	case 3:
+		return SeverityWarning
+	case 5:
+		return SeverityDebug
+	default:
+		return SeverityInformational
+	}
+}
+
+// Keep printable ASCII other than the excluded characters, up to maxLen.
+func printableAscii(value string, maxLen int, exclude string) string {
+	var out strings.Builder
+	for i := 0; i < len(value) && out.Len() < maxLen; i++ {
+		c := value[i]
+		if c < 33 || c > 126 || strings.IndexByte(exclude, c) >= 0 {
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// A header field, or the NILVALUE if it's empty
+func headerField(value string, maxLen int) string {
+	value = printableAscii(value, maxLen, "")
+	if value == "" {
+		return nilValue
+	}
+	return value
+}
+
+// Escape '"', '\' and ']' in an SD-PARAM value
+var paramEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+type sdElement struct {
+	id     string
+	params []sdParam
+}
+
+type sdParam struct {
+	name, value string
+}
+
+func (self *sdElement) add(name, value string) {
+	name = printableAscii(name, 32, `= ]"`)
+	if name == "" {
+		return
+	}
+	self.params = append(self.params, sdParam{name, value})
+}
+
+func (self *sdElement) write(out *strings.Builder) {
+	out.WriteByte('[')
+	out.WriteString(self.id)
+	for _, param := range self.params {
+		out.WriteByte(' ')
+		out.WriteString(param.name)
+		out.WriteString(`="`)
+		out.WriteString(paramEscaper.Replace(param.value))
+		out.WriteByte('"')
+	}
+	out.WriteByte(']')
+}
+
+// Builds syslog messages from events
+type formatter struct {
+	format       Format
+	facility     int
+	hostname     string
+	appName      string
+	enterpriseId int
+}
+
+func (self *formatter) priority(event *winlog.WinLogEvent) string {
+	return "<" + strconv.Itoa(self.facility*8+Severity(event.Level)) + ">"
+}
+
+func (self *formatter) eventHostname(event *winlog.WinLogEvent) string {
+	if event.ComputerName != "" {
+		return event.ComputerName
+	}
+	return self.hostname
+}
+
+func (self *formatter) eventAppName(event *winlog.WinLogEvent) string {
+	if self.appName != "" {
+		return self.appName
+	}
+	return event.ProviderName
+}
+
+func (self *formatter) eventTime(event *winlog.WinLogEvent) time.Time {
+	if event.Created.IsZero() {
+		return time.Now()
+	}
+	return event.Created
+}
+
+// Format the event as a single syslog message, without framing
+func (self *formatter) Format(event *winlog.WinLogEvent) []byte {
+	if self.format == RFC3164 {
+		return self.formatRFC3164(event)
+	}
+	return self.formatRFC5424(event)
+}
+
+func (self *formatter) formatRFC5424(event *winlog.WinLogEvent) []byte {
+	var out strings.Builder
+	out.WriteString(self.priority(event))
+	out.WriteString("1 ")
+	out.WriteString(self.eventTime(event).UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	out.WriteByte(' ')
+	out.WriteString(headerField(self.eventHostname(event), 255))
+	out.WriteByte(' ')
+	out.WriteString(headerField(self.eventAppName(event), 48))
+	out.WriteByte(' ')
+	if event.ProcessId != 0 {
+		out.WriteString(strconv.FormatUint(event.ProcessId, 10))
+	} else {
+		out.WriteString(nilValue)
+	}
+	out.WriteByte(' ')
+	out.WriteString(strconv.FormatUint(event.EventId, 10))
+	out.WriteByte(' ')
+
+	suffix := "@" + strconv.Itoa(self.enterpriseId)
+	system := sdElement{id: "winlog" + suffix}
+	system.add("channel", event.Channel)
+	system.add("provider", event.ProviderName)
+	system.add("eventId", strconv.FormatUint(event.EventId, 10))
+	system.add("recordId", strconv.FormatUint(event.RecordId, 10))
+	system.add("level", strconv.FormatUint(event.Level, 10))
+	system.add("task", strconv.FormatUint(event.Task, 10))
+	system.add("opcode", strconv.FormatUint(event.Opcode, 10))
+	if event.ThreadId != 0 {
+		system.add("threadId", strconv.FormatUint(event.ThreadId, 10))
+	}
+	if event.LevelText != "" {
+		system.add("levelText", event.LevelText)
+	}
+	if event.TaskText != "" {
+		system.add("taskText", event.TaskText)
+	}
+	if len(event.Keywords) > 0 {
+		system.add("keywords", strings.Join(event.Keywords, ","))
+	}
+	system.write(&out)
+	if len(event.EventData) > 0 {
+		data := sdElement{id: "eventData" + suffix}
+		for i, field := range event.EventData {
+			name := field.Name
+			if name == "" {
+				name = "param" + strconv.Itoa(i+1)
+			}
+			data.add(name, field.Value)
+		}
+		data.write(&out)
+	}
+
+	if message := strings.TrimSpace(event.Msg); message != "" {
+		out.WriteByte(' ')
+		out.WriteString(message)
+	}
+	return []byte(out.String())
+}
+
+func (self *formatter) formatRFC3164(event *winlog.WinLogEvent) []byte {
+	var out strings.Builder
+	out.WriteString(self.priority(event))
+	out.WriteString(self.eventTime(event).Format(time.Stamp))
+	out.WriteByte(' ')
+	out.WriteString(headerField(self.eventHostname(event), 255))
+	out.WriteByte(' ')
+	tag := printableAscii(self.eventAppName(event), 32, "[]:")
+	if tag == "" {
+		tag = "winlog"
+	}
+	out.WriteString(tag)
+	if event.ProcessId != 0 {
+		out.WriteString("[" + strconv.FormatUint(event.ProcessId, 10) + "]")
+	}
+	out.WriteString(": ")
+	out.WriteString("EventID=" + strconv.FormatUint(event.EventId, 10))
+	if event.Channel != "" {
+		out.WriteString(" Channel=" + event.Channel)
+	}
+	// RFC 3164 has no structured data, and messages are often read line by line
+	message := strings.Join(strings.Fields(event.Msg), " ")
+	if message != "" {
+		out.WriteString(" ")
+		out.WriteString(message)
+	}
+	return []byte(out.String())
+}
@@ -0,0 +1,70 @@
+package syslog
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+func assertEqual(a, b interface{}, t *T) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func testEvent() *winlog.WinLogEvent {
+	return &winlog.WinLogEvent{
+		ProviderName: "Microsoft-Windows-Security-Auditing",
+		EventId:      4625,
+		Level:        0,
+		Task:         12544,
+		Created:      time.Date(2016, 1, 19, 19, 37, 48, 123456789, time.UTC),
+		RecordId:     10811,
+		ProcessId:    560,
+		ThreadId:     4884,
+		Channel:      "Security",
+		ComputerName: "WIN-HOST",
+		Msg:          "An account failed to log on.\r\n\r\nSubject:\r\n",
+		Keywords:     []string{"Audit Failure"},
+		EventData: []winlog.EventDataField{
+			{Name: "TargetUserName", Value: `a"b\c]d`},
+			{Name: "", Value: "unnamed"},
+			{Name: "Bad Name=", Value: "x"},
+		},
+		Bookmark:          "<BookmarkList/>",
+		SubscribedChannel: "Security",
+	}
+}
+
+func TestSeverity(t *T) {
+	assertEqual(Severity(0), SeverityInformational, t)
+	assertEqual(Severity(1), SeverityCritical, t)
+	assertEqual(Severity(2), SeverityError, t)
+	assertEqual(Severity(3), SeverityWarning, t)
+	assertEqual(Severity(4), SeverityInformational, t)
+	assertEqual(Severity(5), SeverityDebug, t)
+}
+
+func TestFormatRFC5424(t *T) {
+	f := formatter{format: RFC5424, facility: FacilityAuth, enterpriseId: DefaultEnterpriseId}
+	expected := `<38>1 2016-01-19T19:37:48.123456Z WIN-HOST Microsoft-Windows-Security-Auditing 560 4625 ` +
+		`[winlog@32473 channel="Security" provider="Microsoft-Windows-Security-Auditing" eventId="4625" recordId="10811" level="0" task="12544" opcode="0" threadId="4884" keywords="Audit Failure"]` +
+		`[eventData@32473 TargetUserName="a\"b\\c\]d" param2="unnamed" BadName="x"] ` +
+		"An account failed to log on.\r\n\r\nSubject:"
+	assertEqual(string(f.Format(testEvent())), expected, t)
+
+	// Empty header fields are the NILVALUE
+	event := &winlog.WinLogEvent{EventId: 1, Level: 2, Created: testEvent().Created}
+	f = formatter{format: RFC5424, facility: FacilityUser, enterpriseId: 1}
+	expected = `<11>1 2016-01-19T19:37:48.123456Z - - - 1 [winlog@1 channel="" provider="" eventId="1" recordId="0" level="2" task="0" opcode="0"]`
+	assertEqual(string(f.Format(event)), expected, t)
+}
+
+func TestFormatRFC3164(t *T) {
+	f := formatter{format: RFC3164, facility: FacilityAuth, hostname: "fallback", appName: "winlog[agent]"}
+	event := testEvent()
+	event.ComputerName = ""
+	expected := "<38>Jan 19 19:37:48 fallback winlogagent[560]: EventID=4625 Channel=Security An account failed to log on. Subject:"
+	assertEqual(string(f.Format(event)), expected, t)
+}
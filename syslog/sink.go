@@ -0,0 +1,148 @@
+// Package syslog sends events from a WinLogWatcher to a syslog server over
+// UDP, TCP or TLS, formatted as RFC 5424 (or RFC 3164) messages.
+package syslog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+	"github.com/scalingdata/gowinlog/internal/netconn"
+)
+
+// Settings for a Sink. Only Network and Address are required.
+type Config struct {
+	// "udp", "tcp" or "tls"
+	Network string
+	// Server address as host:port
+	Address string
+	// TLS settings, when Network is "tls"
+	TLSConfig *tls.Config
+
+	Format Format
+	// Facility for every message. Zero (kern) can't be sent by user processes,
+	// so it means FacilityUser.
+	Facility int
+	// Hostname for events without a ComputerName, from os.Hostname by default
+	Hostname string
+	// APP-NAME (or TAG for RFC 3164), which is the event's provider by default
+	AppName string
+	// Enterprise number for the SD-IDs, DefaultEnterpriseId by default
+	EnterpriseId int
+
+	// Bookmarks are saved here after each successful write, if it's set
+	Bookmarks winlog.BookmarkStore
+
+	// Timeouts for connecting and for each write, 10 seconds by default
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Attempts to reconnect and resend a message before Write fails, 3 by
+	// default. Negative values retry forever, until the sink is closed.
+	Retries int
+	// Delay before the first reconnect, doubling up to MaxReconnectDelay.
+	// 500ms and 30 seconds by default.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
+
+// Writes events to a syslog server, reconnecting when the connection fails.
+// Framing is one message per datagram for UDP, and octet counting (RFC 6587)
+// for TCP and TLS.
+type Sink struct {
+	config    Config
+	formatter formatter
+	writer    *netconn.Writer
+}
+
+func NewSink(config Config) (*Sink, error) {
+	switch config.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("Unsupported syslog network %q", config.Network)
+	}
+	if config.Address == "" {
+		return nil, fmt.Errorf("No syslog server address")
+	}
+	if config.Format != RFC5424 && config.Format != RFC3164 {
+		return nil, fmt.Errorf("Unknown syslog format %v", config.Format)
+	}
+	if config.Facility < 0 || config.Facility > FacilityLocal7 {
+		return nil, fmt.Errorf("Invalid syslog facility %v", config.Facility)
+	}
+	if config.Facility == 0 {
+		config.Facility = FacilityUser
+	}
+	if config.Hostname == "" {
+		config.Hostname, _ = os.Hostname()
+	}
+	if config.EnterpriseId == 0 {
+		config.EnterpriseId = DefaultEnterpriseId
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 10 * time.Second
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = 10 * time.Second
+	}
+	if config.Retries == 0 {
+		config.Retries = 3
+	}
+	if config.ReconnectDelay == 0 {
+		config.ReconnectDelay = 500 * time.Millisecond
+	}
+	if config.MaxReconnectDelay == 0 {
+		config.MaxReconnectDelay = 30 * time.Second
+	}
+	return &Sink{
+		config: config,
+		formatter: formatter{
+			format:       config.Format,
+			facility:     config.Facility,
+			hostname:     config.Hostname,
+			appName:      config.AppName,
+			enterpriseId: config.EnterpriseId,
+		},
+		writer: netconn.NewWriter(netconn.Config{
+			Network:           config.Network,
+			Address:           config.Address,
+			TLSConfig:         config.TLSConfig,
+			DialTimeout:       config.DialTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			Retries:           config.Retries,
+			ReconnectDelay:    config.ReconnectDelay,
+			MaxReconnectDelay: config.MaxReconnectDelay,
+		}),
+	}, nil
+}
+
+// Write events from the channel, such as WinLogWatcher.Event(), until it's
+// closed. Returns the first error from Write.
+func (self *Sink) Run(events <-chan *winlog.WinLogEvent) error {
+	for event := range events {
+		if err := self.Write(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send one event, reconnecting and retrying as configured. The event's
+// bookmark is saved only once the message has been written.
+func (self *Sink) Write(event *winlog.WinLogEvent) error {
+	message := self.formatter.Format(event)
+	if self.config.Network != "udp" {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	if err := self.writer.Write(message); err != nil {
+		return err
+	}
+	return winlog.CommitBookmark(self.config.Bookmarks, event)
+}
+
+// Close the connection. Writes in progress stop retrying and fail.
+func (self *Sink) Close() error {
+	return self.writer.Close()
+}
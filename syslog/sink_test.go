@@ -0,0 +1,257 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/scalingdata/gowinlog"
+)
+
+// Read one octet-counted message
+func readFrame(reader *bufio.Reader) (string, error) {
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil {
+		return "", err
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(reader, message); err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
+
+// Accept connections and publish each message they receive. A connection is
+// closed after closeAfter messages, if it's positive.
+func serveFrames(listener net.Listener, closeAfter int) <-chan string {
+	messages := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for count := 0; closeAfter <= 0 || count < closeAfter; count++ {
+					message, err := readFrame(reader)
+					if err != nil {
+						return
+					}
+					messages <- message
+				}
+			}()
+		}
+	}()
+	return messages
+}
+
+func receive(messages <-chan string, t *T) string {
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	return ""
+}
+
+func TestSinkTCP(t *T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	messages := serveFrames(listener, 0)
+
+	store := winlog.NewMemoryBookmarkStore()
+	sink, err := NewSink(Config{Network: "tcp", Address: listener.Addr().String(), Bookmarks: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	events := make(chan *winlog.WinLogEvent, 2)
+	events <- testEvent()
+	second := testEvent()
+	second.RecordId = 10812
+	second.Bookmark = "<BookmarkList>2</BookmarkList>"
+	events <- second
+	close(events)
+	if err := sink.Run(events); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages with newlines are framed intact
+	first := receive(messages, t)
+	if !strings.HasPrefix(first, "<14>1 ") || !strings.HasSuffix(first, "log on.\r\n\r\nSubject:") {
+		t.Fatalf("Unexpected message %q", first)
+	}
+	if !strings.Contains(receive(messages, t), `recordId="10812"`) {
+		t.Fatal("Second message has the wrong record ID")
+	}
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, second.Bookmark, t)
+}
+
+func TestSinkUDP(t *T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sink, err := NewSink(Config{Network: "udp", Address: conn.LocalAddr().String(), Format: RFC3164})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Datagrams aren't octet counted
+	if !strings.HasPrefix(string(buf[:n]), "<14>Jan 19 19:37:48 WIN-HOST ") {
+		t.Fatalf("Unexpected message %q", buf[:n])
+	}
+}
+
+func selfSignedCertificate(t *T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSinkTLS(t *T) {
+	certificate := selfSignedCertificate(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	messages := serveFrames(listener, 0)
+
+	roots := x509.NewCertPool()
+	parsed, _ := x509.ParseCertificate(certificate.Certificate[0])
+	roots.AddCert(parsed)
+	sink, err := NewSink(Config{
+		Network:   "tls",
+		Address:   listener.Addr().String(),
+		TLSConfig: &tls.Config{RootCAs: roots},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(receive(messages, t), "[winlog@32473 ") {
+		t.Fatal("Message has no structured data")
+	}
+}
+
+func TestSinkReconnects(t *T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// The server drops every connection after one message
+	messages := serveFrames(listener, 1)
+	sink, err := NewSink(Config{
+		Network:        "tcp",
+		Address:        listener.Addr().String(),
+		ReconnectDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 3; i++ {
+		event := testEvent()
+		event.RecordId = uint64(i)
+		if err := sink.Write(event); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(receive(messages, t), `recordId="`+strconv.Itoa(i)+`"`) {
+			t.Fatalf("Message %v has the wrong record ID", i)
+		}
+		// Let the sink see the connection close before the next write
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSinkFailureKeepsBookmark(t *T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	store := winlog.NewMemoryBookmarkStore()
+	store.Save("Security", "<BookmarkList>old</BookmarkList>")
+	sink, err := NewSink(Config{
+		Network:        "tcp",
+		Address:        address,
+		Bookmarks:      store,
+		Retries:        2,
+		ReconnectDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.Write(testEvent()); err == nil {
+		t.Fatal("No error writing to a closed port")
+	}
+	bookmark, _ := store.Load("Security")
+	assertEqual(bookmark, "<BookmarkList>old</BookmarkList>", t)
+}
+
+func TestNewSinkValidation(t *T) {
+	if _, err := NewSink(Config{Network: "unix", Address: "x"}); err == nil {
+		t.Fatal("No error for an unsupported network")
+	}
+	if _, err := NewSink(Config{Network: "tcp"}); err == nil {
+		t.Fatal("No error without an address")
+	}
+	if _, err := NewSink(Config{Network: "tcp", Address: "x", Facility: 24}); err == nil {
+		t.Fatal("No error for an invalid facility")
+	}
+}
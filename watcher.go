@@ -0,0 +1,13 @@
+package winlog
+
+// The API shared by WinLogWatcher and ReplayWatcher, so code can consume live
+// or replayed events alike.
+type Watcher interface {
+	Event() <-chan *WinLogEvent
+	Error() <-chan error
+	SubscribeFromBeginning(channel, query string) error
+	SubscribeFromNow(channel, query string) error
+	SubscribeFromBookmark(channel, query, bookmark string) error
+	Unsubscribe(channel string) error
+	Shutdown()
+}
@@ -4,10 +4,20 @@ package winlog
 
 import (
 	"fmt"
+	"sort"
 	"time"
 	"unsafe"
 )
 
+const (
+	// Events read from a pull subscription at a time
+	pullBatchSize = 64
+	// How often a pull subscription checks if it has been stopped
+	pullWaitTimeout = time.Second
+)
+
+var _ Watcher = (*WinLogWatcher)(nil)
+
 func (self *WinLogWatcher) Event() <-chan *WinLogEvent {
 	return self.eventChan
 }
@@ -23,68 +33,222 @@ func NewWinLogWatcher() (*WinLogWatcher, error) {
 		return nil, err
 	}
 	return &WinLogWatcher{
-		shutdown:       make(chan interface{}),
-		errChan:        make(chan error),
-		eventChan:      make(chan *WinLogEvent),
-		renderContext:  cHandle,
-		watches:        make(map[string]*channelWatcher),
-		renderMessage:  true,
-		renderLevel:    true,
-		renderTask:     true,
-		renderProvider: true,
-		renderOpcode:   true,
-		renderChannel:  true,
-		renderId:       true,
+		shutdown:        make(chan interface{}),
+		errChan:         make(chan error),
+		eventChan:       make(chan *WinLogEvent),
+		renderContext:   cHandle,
+		watches:         make(map[string]*channelWatcher),
+		profile:         RenderProfileFull,
+		channelProfiles: make(map[string]RenderProfile),
+		metrics:         NopMetrics{},
+		logger:          NopLogger{},
 	}, nil
 }
 
-// Whether to use EvtFormatMessage to render the event message
+// Set the default RenderProfile, which controls what is rendered for events
+// from channels that don't have their own profile. This can be changed at any
+// time, and applies to the next event rendered.
+func (self *WinLogWatcher) SetRenderProfile(profile RenderProfile) {
+	self.profileMutex.Lock()
+	self.profile = profile
+	self.profileMutex.Unlock()
+}
+
+// Get the default RenderProfile.
+func (self *WinLogWatcher) RenderProfile() RenderProfile {
+	self.profileMutex.Lock()
+	defer self.profileMutex.Unlock()
+	return self.profile
+}
+
+// Set the RenderProfile for events from a subscribed channel, overriding the
+// default. A nil profile reverts to the default. This can be changed at any
+// time, including before subscribing to the channel.
+func (self *WinLogWatcher) SetChannelRenderProfile(channel string, profile *RenderProfile) {
+	self.profileMutex.Lock()
+	defer self.profileMutex.Unlock()
+	if profile == nil {
+		delete(self.channelProfiles, channel)
+	} else {
+		self.channelProfiles[channel] = *profile
+	}
+}
+
+// Get the RenderProfile in effect for a subscribed channel.
+func (self *WinLogWatcher) ChannelRenderProfile(channel string) RenderProfile {
+	self.profileMutex.Lock()
+	defer self.profileMutex.Unlock()
+	if profile, ok := self.channelProfiles[channel]; ok {
+		return profile
+	}
+	return self.profile
+}
+
+// Change one setting of the default profile.
+func (self *WinLogWatcher) updateRenderProfile(update func(*RenderProfile)) {
+	self.profileMutex.Lock()
+	update(&self.profile)
+	self.profileMutex.Unlock()
+}
+
+// Whether to use EvtFormatMessage to render the event message.
+//
+// Deprecated: use SetRenderProfile.
 func (self *WinLogWatcher) SetRenderMessage(render bool) {
-	self.renderMessage = render
+	self.updateRenderProfile(func(profile *RenderProfile) { profile.Message = render })
 }
 
-// Whether to use EvtFormatMessage to render the event level
+// Whether to use EvtFormatMessage to render the event level.
+//
+// Deprecated: use SetRenderProfile.
 func (self *WinLogWatcher) SetRenderLevel(render bool) {
-	self.renderLevel = render
+	self.updateRenderProfile(func(profile *RenderProfile) { profile.LevelText = render })
 }
 
-// Whether to use EvtFormatMessage to render the event task
+// Whether to use EvtFormatMessage to render the event task.
+//
+// Deprecated: use SetRenderProfile.
 func (self *WinLogWatcher) SetRenderTask(render bool) {
-	self.renderTask = render
+	self.updateRenderProfile(func(profile *RenderProfile) { profile.TaskText = render })
 }
 
-// Whether to use EvtFormatMessage to render the event provider
+// Whether to use EvtFormatMessage to render the event provider.
+//
+// Deprecated: use SetRenderProfile.
 func (self *WinLogWatcher) SetRenderProvider(render bool) {
-	self.renderProvider = render
+	self.updateRenderProfile(func(profile *RenderProfile) { profile.ProviderText = render })
 }
 
-// Whether to use EvtFormatMessage to render the event opcode
+// Whether to use EvtFormatMessage to render the event opcode.
+//
+// Deprecated: use SetRenderProfile.
 func (self *WinLogWatcher) SetRenderOpcode(render bool) {
-	self.renderOpcode = render
+	self.updateRenderProfile(func(profile *RenderProfile) { profile.OpcodeText = render })
 }
 
-// Whether to use EvtFormatMessage to render the event channel
+// Whether to use EvtFormatMessage to render the event channel.
+//
+// Deprecated: use SetRenderProfile.
 func (self *WinLogWatcher) SetRenderChannel(render bool) {
-	self.renderChannel = render
+	self.updateRenderProfile(func(profile *RenderProfile) { profile.ChannelText = render })
 }
 
-// Whether to use EvtFormatMessage to render the event ID
+// Whether to use EvtFormatMessage to render the event ID.
+//
+// Deprecated: use SetRenderProfile.
 func (self *WinLogWatcher) SetRenderId(render bool) {
-	self.renderId = render
+	self.updateRenderProfile(func(profile *RenderProfile) { profile.IdText = render })
+}
+
+// Set the locale (LCID) used to render localized fields, such as LocaleEnglishUS.
+// The default of 0 uses the UI language of the system. If a provider has no
+// resources for the locale, its events are rendered in the default language.
+func (self *WinLogWatcher) SetLocale(locale uint32) {
+	self.profileMutex.Lock()
+	defer self.profileMutex.Unlock()
+	self.locale = locale
+	// Cached templates are in the old language
+	if self.messageTemplates != nil {
+		self.messageTemplates = NewMessageTemplateCache()
+	}
+}
+
+// Whether to also render the event message in US English, in the EnglishMsg field.
+// This requires an extra EvtFormatMessage call if the locale isn't US English.
+func (self *WinLogWatcher) SetRenderEnglishMessage(render bool) {
+	self.profileMutex.Lock()
+	self.renderEnglishMessage = render
+	self.profileMutex.Unlock()
+}
+
+// The settings for localized fields which aren't part of the render profile.
+func (self *WinLogWatcher) localeSettings() (uint32, bool, *MessageTemplateCache) {
+	self.profileMutex.Lock()
+	defer self.profileMutex.Unlock()
+	return self.locale, self.renderEnglishMessage, self.messageTemplates
+}
+
+// Render events on a pool of `workers` goroutines instead of in the event log
+// callback, so a slow provider doesn't hold up the rest of a channel. Events from
+// each channel are still published in order. At most `queueSize` events per
+// channel are rendered ahead of the consumer. Must be called before subscribing;
+// a value of 0 workers renders in the callback, which is the default.
+func (self *WinLogWatcher) SetRenderWorkers(workers, queueSize int) error {
+	self.watchMutex.Lock()
+	defer self.watchMutex.Unlock()
+	if len(self.watches) > 0 {
+		return fmt.Errorf("Render workers must be set before subscribing")
+	}
+	if self.renderPool != nil {
+		self.renderPool.Close()
+		self.renderPool = nil
+	}
+	if workers > 0 {
+		self.renderPool = newRenderPool(workers, queueSize)
+	}
+	return nil
+}
+
+// Report events received, rendered and delivered to `metrics`, such as a
+// Prometheus adapter from the metrics package. Must be called before
+// subscribing; nil discards the measurements, which is the default.
+func (self *WinLogWatcher) SetMetrics(metrics Metrics) error {
+	self.watchMutex.Lock()
+	defer self.watchMutex.Unlock()
+	if len(self.watches) > 0 {
+		return fmt.Errorf("Metrics must be set before subscribing")
+	}
+	if metrics == nil {
+		metrics = NopMetrics{}
+	}
+	self.metrics = metrics
+	return nil
+}
+
+// Log the watcher's lifecycle to `logger`, such as a *slog.Logger: channels
+// being subscribed and unsubscribed, events failing to render, and shutdown.
+// Must be called before subscribing; nil discards the messages, which is the
+// default.
+func (self *WinLogWatcher) SetLogger(logger Logger) error {
+	self.watchMutex.Lock()
+	defer self.watchMutex.Unlock()
+	if len(self.watches) > 0 {
+		return fmt.Errorf("The logger must be set before subscribing")
+	}
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	self.logger = logger
+	return nil
+}
+
+// Whether to cache each provider's message templates and format event messages
+// in Go. Parameter references (%%n) are looked up in the provider's parameter
+// file, such as msobjs.dll for Security, when rendering in the default locale.
+// EvtFormatMessage is still used for any message that can't be expanded
+// unambiguously, including those with parameters in other locales.
+func (self *WinLogWatcher) SetCacheMessageTemplates(cache bool) {
+	self.profileMutex.Lock()
+	defer self.profileMutex.Unlock()
+	if cache {
+		self.messageTemplates = NewMessageTemplateCache()
+	} else {
+		self.messageTemplates = nil
+	}
 }
 
 // Subscribe to a Windows Event Log channel, starting with the first event
 // in the log. `query` is an XPath expression for filtering events: to recieve
 // all events on the channel, use "*" as the query.
 func (self *WinLogWatcher) SubscribeFromBeginning(channel, query string) error {
-	return self.subscribeWithoutBookmark(channel, query, EvtSubscribeStartAtOldestRecord)
+	return self.logSubscribe(channel, query, "beginning", self.subscribeWithoutBookmark(channel, query, EvtSubscribeStartAtOldestRecord))
 }
 
 // Subscribe to a Windows Event Log channel, starting with the next event
 // that arrives. `query` is an XPath expression for filtering events: to recieve
 // all events on the channel, use "*" as the query.
 func (self *WinLogWatcher) SubscribeFromNow(channel, query string) error {
-	return self.subscribeWithoutBookmark(channel, query, EvtSubscribeToFutureEvents)
+	return self.logSubscribe(channel, query, "now", self.subscribeWithoutBookmark(channel, query, EvtSubscribeToFutureEvents))
 }
 
 func (self *WinLogWatcher) subscribeWithoutBookmark(channel, query string, flags EVT_SUBSCRIBE_FLAGS) error {
@@ -97,6 +261,15 @@ func (self *WinLogWatcher) subscribeWithoutBookmark(channel, query string, flags
 	if err != nil {
 		return fmt.Errorf("Failed to create new bookmark handle: %v", err)
 	}
+	if self.renderPool != nil {
+		watch, err := self.createPullSubscription(channel, query, flags, newBookmark)
+		if err != nil {
+			CloseEventHandle(uint64(newBookmark))
+			return err
+		}
+		self.watches[channel] = watch
+		return nil
+	}
 	callback := &LogEventCallbackWrapper{callback: self, subscribedChannel: channel}
 	subscription, err := CreateListener(channel, query, flags, callback)
 	if err != nil {
@@ -107,6 +280,8 @@ func (self *WinLogWatcher) subscribeWithoutBookmark(channel, query string, flags
 		bookmark:     newBookmark,
 		subscription: subscription,
 		callback:     callback,
+		id:           self.newSubscriptionId(),
+		stats:        newSubscriptionStats(channel, time.Now()),
 	}
 	return nil
 }
@@ -116,6 +291,10 @@ func (self *WinLogWatcher) subscribeWithoutBookmark(channel, query string, flags
 // is an XPath expression for filtering events: to recieve all events on the channel,
 // use "*" as the query
 func (self *WinLogWatcher) SubscribeFromBookmark(channel, query string, xmlString string) error {
+	return self.logSubscribe(channel, query, "bookmark", self.subscribeFromBookmark(channel, query, xmlString))
+}
+
+func (self *WinLogWatcher) subscribeFromBookmark(channel, query string, xmlString string) error {
 	self.watchMutex.Lock()
 	defer self.watchMutex.Unlock()
 	if _, ok := self.watches[channel]; ok {
@@ -126,6 +305,15 @@ func (self *WinLogWatcher) SubscribeFromBookmark(channel, query string, xmlStrin
 	if err != nil {
 		return fmt.Errorf("Failed to create new bookmark handle: %v", err)
 	}
+	if self.renderPool != nil {
+		watch, err := self.createPullSubscription(channel, query, EvtSubscribeStartAfterBookmark, bookmark)
+		if err != nil {
+			CloseEventHandle(uint64(bookmark))
+			return fmt.Errorf("Failed to add listener: %v", err)
+		}
+		self.watches[channel] = watch
+		return nil
+	}
 	subscription, err := CreateListenerFromBookmark(channel, query, callback, bookmark)
 	if err != nil {
 		CloseEventHandle(uint64(bookmark))
@@ -135,21 +323,161 @@ func (self *WinLogWatcher) SubscribeFromBookmark(channel, query string, xmlStrin
 		bookmark:     bookmark,
 		subscription: subscription,
 		callback:     callback,
+		id:           self.newSubscriptionId(),
+		stats:        newSubscriptionStats(channel, time.Now()),
 	}
 	return nil
 }
 
+// Get an ID for a new subscription. Called with watchMutex held.
+func (self *WinLogWatcher) newSubscriptionId() uint64 {
+	self.subscriptionIds++
+	return self.subscriptionIds
+}
+
+// Log the outcome of subscribing to a channel, returning the error if it failed
+func (self *WinLogWatcher) logSubscribe(channel, query, start string, err error) error {
+	if err != nil {
+		self.logger.Warn("Failed to subscribe to channel", "channel", channel, "query", query, "start", start, "error", err)
+		return err
+	}
+	self.logger.Info("Subscribed to channel", self.subscriptionAttributes(channel, "query", query, "start", start, "render_workers", self.renderPool != nil)...)
+	return nil
+}
+
+// The attributes identifying a channel's subscription for logging, followed
+// by `attributes`
+func (self *WinLogWatcher) subscriptionAttributes(channel string, attributes ...interface{}) []interface{} {
+	self.watchMutex.Lock()
+	watch, ok := self.watches[channel]
+	self.watchMutex.Unlock()
+	identity := []interface{}{"channel", channel}
+	if ok {
+		identity = append(identity, "subscription", watch.id)
+	}
+	return append(identity, attributes...)
+}
+
+// Create a subscription which is read by a goroutine instead of calling back, so
+// that event handles outlive the read and can be rendered by the worker pool.
+func (self *WinLogWatcher) createPullSubscription(channel, query string, flags EVT_SUBSCRIBE_FLAGS, bookmark BookmarkHandle) (*channelWatcher, error) {
+	signal, err := CreateSignalEvent()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create signal event: %v", err)
+	}
+	subscription, err := CreatePullListener(channel, query, flags, bookmark, signal)
+	if err != nil {
+		CloseSignalEvent(signal)
+		return nil, err
+	}
+	watch := &channelWatcher{
+		bookmark:     bookmark,
+		subscription: subscription,
+		signal:       signal,
+		stop:         make(chan interface{}),
+		done:         make(chan interface{}),
+		id:           self.newSubscriptionId(),
+		stats:        newSubscriptionStats(channel, time.Now()),
+	}
+	go self.pullEvents(channel, watch)
+	return watch, nil
+}
+
+// Read events from a pull subscription and queue them for rendering until
+// the subscription is stopped.
+func (self *WinLogWatcher) pullEvents(channel string, watch *channelWatcher) {
+	defer close(watch.done)
+	handles := make([]EventHandle, pullBatchSize)
+	for {
+		select {
+		case <-watch.stop:
+			return
+		default:
+		}
+		if !WaitForSignalEvent(watch.signal, pullWaitTimeout) {
+			continue
+		}
+		// Reset before reading so events which arrive during the read aren't missed
+		ResetSignalEvent(watch.signal)
+		for {
+			count, err := NextEvents(watch.subscription, handles)
+			if err != nil {
+				self.logger.Error("Failed to read events", self.subscriptionAttributes(channel, "error", err)...)
+				self.publishChannelError(channel, fmt.Errorf("Failed to read events from channel %q: %v", channel, err))
+				break
+			}
+			if count == 0 {
+				break
+			}
+			for _, handle := range handles[:count] {
+				self.submitEvent(handle, channel)
+			}
+			select {
+			case <-watch.stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// Queue an event to be rendered by the worker pool. Events are published, and
+// the channel's bookmark updated, in the order they were submitted.
+func (self *WinLogWatcher) submitEvent(handle EventHandle, subscribedChannel string) {
+	self.metrics.EventReceived(subscribedChannel)
+	self.renderPool.SubmitEvent(self, self.metrics, handle, subscribedChannel)
+}
+
+// Report an event which couldn't be rendered.
+func (self *WinLogWatcher) publishRenderError(subscribedChannel string, err error) {
+	self.logger.Warn("Failed to render event", self.subscriptionAttributes(subscribedChannel, "error", err)...)
+	self.publishChannelError(subscribedChannel, err)
+}
+
+func (self *WinLogWatcher) closeEvent(handle EventHandle) {
+	CloseEventHandle(uint64(handle))
+}
+
+// Remove the subscription to a channel. With render workers, events already
+// read from the channel are published first.
+func (self *WinLogWatcher) Unsubscribe(channel string) error {
+	self.watchMutex.Lock()
+	watch, ok := self.watches[channel]
+	self.watchMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("No watcher for channel %q", channel)
+	}
+	return self.removeSubscription(channel, watch)
+}
+
 func (self *WinLogWatcher) removeSubscription(channel string, watch *channelWatcher) error {
+	if watch.stop != nil {
+		// Stop reading, then let events already queued for this channel be
+		// published while the bookmark is still open
+		close(watch.stop)
+		SetSignalEvent(watch.signal)
+		<-watch.done
+		self.renderPool.Wait(channel)
+	}
 	cancelErr := CancelEventHandle(uint64(watch.subscription))
 	closeErr := CloseEventHandle(uint64(watch.subscription))
+	if watch.signal != 0 {
+		CloseSignalEvent(watch.signal)
+	}
 	CloseEventHandle(uint64(watch.bookmark))
 	self.watchMutex.Lock()
 	delete(self.watches, channel)
 	self.watchMutex.Unlock()
-	if cancelErr != nil {
-		return cancelErr
+	err := cancelErr
+	if err == nil {
+		err = closeErr
 	}
-	return closeErr
+	if err != nil {
+		self.logger.Warn("Failed to close subscription", "channel", channel, "subscription", watch.id, "error", err)
+	} else {
+		self.logger.Info("Unsubscribed from channel", "channel", channel, "subscription", watch.id)
+	}
+	return err
 }
 
 // Remove all subscriptions from this watcher and shut down.
@@ -157,13 +485,53 @@ func (self *WinLogWatcher) Shutdown() {
 	self.watchMutex.Lock()
 	watches := self.watches
 	self.watchMutex.Unlock()
+	self.logger.Info("Shutting down watcher", "subscriptions", len(watches))
 	close(self.shutdown)
 	for channel, watch := range watches {
 		self.removeSubscription(channel, watch)
 	}
+	if self.renderPool != nil {
+		self.renderPool.Close()
+	}
 	CloseEventHandle(uint64(self.renderContext))
 	close(self.errChan)
 	close(self.eventChan)
+	self.logger.Debug("Watcher shut down")
+}
+
+// Get the stats of every subscription, in channel order.
+func (self *WinLogWatcher) Stats() []SubscriptionStats {
+	self.watchMutex.Lock()
+	defer self.watchMutex.Unlock()
+	now := time.Now()
+	stats := make([]SubscriptionStats, 0, len(self.watches))
+	for _, watch := range self.watches {
+		stats = append(stats, watch.stats.snapshot(now))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Channel < stats[j].Channel })
+	return stats
+}
+
+// Get the stats of the subscription to a channel, or false if there isn't one.
+func (self *WinLogWatcher) ChannelStats(channel string) (SubscriptionStats, bool) {
+	self.watchMutex.Lock()
+	watch, ok := self.watches[channel]
+	self.watchMutex.Unlock()
+	if !ok {
+		return SubscriptionStats{}, false
+	}
+	return watch.stats.snapshot(time.Now()), true
+}
+
+// Record an error with a channel's events in its stats, and publish it.
+func (self *WinLogWatcher) publishChannelError(channel string, err error) {
+	self.watchMutex.Lock()
+	watch, ok := self.watches[channel]
+	self.watchMutex.Unlock()
+	if ok {
+		watch.stats.failed(err, time.Now())
+	}
+	self.PublishError(err)
 }
 
 func (self *WinLogWatcher) PublishError(err error) {
@@ -182,18 +550,37 @@ func (self *WinLogWatcher) convertEvent(handle EventHandle, subscribedChannel st
 	var created time.Time
 
 	// Localized fields
-	var msgText, lvlText, taskText, providerText, opcodeText, channelText, idText string
+	var msgText, lvlText, taskText, providerText, opcodeText, channelText, idText, englishMsgText string
+	var locale uint32
 
 	// Publisher fields
-	var publisherHandle PublisherHandle
+	var publisher *localizedPublisher
 	var publisherHandleErr error
 
-	// Render XML, any error is stored in the returned WinLogEvent
-	xml, xmlErr := RenderEventXML(handle)
+	profile := self.ChannelRenderProfile(subscribedChannel)
+	configuredLocale, renderEnglishMessage, messageTemplates := self.localeSettings()
+
+	// Render XML, any error is stored in the returned WinLogEvent. The XML is also
+	// needed for the payload, and to format messages from cached templates.
+	var xml string
+	var xmlErr error
+	renderXml := profile.Xml || profile.EventData || (profile.Message && messageTemplates != nil)
+	if renderXml {
+		started := time.Now()
+		xml, xmlErr = RenderEventXML(handle)
+		self.metrics.RenderDuration(RenderPhaseXml, time.Since(started))
+	}
 
 	// Render the values
-	renderedFields, renderedFieldsErr := RenderEventValues(self.renderContext, handle)
-	if renderedFieldsErr == nil {
+	var renderedFields RenderedFields
+	var renderedFieldsErr error
+	renderValues := profile.SystemFields != 0 || profile.localized()
+	if renderValues {
+		started := time.Now()
+		renderedFields, renderedFieldsErr = RenderEventValues(self.renderContext, handle)
+		self.metrics.RenderDuration(RenderPhaseValues, time.Since(started))
+	}
+	if renderValues && renderedFieldsErr == nil {
 		// If fields don't exist we include the nil value
 		computerName, _ = RenderStringField(renderedFields, EvtSystemComputer)
 		providerName, _ = RenderStringField(renderedFields, EvtSystemProviderName)
@@ -210,43 +597,58 @@ func (self *WinLogWatcher) convertEvent(handle EventHandle, subscribedChannel st
 		created, _ = RenderFileTimeField(renderedFields, EvtSystemTimeCreated)
 
 		// Render localized fields
-		publisherHandle, publisherHandleErr = GetEventPublisherHandle(renderedFields)
-		if publisherHandleErr == nil {
-			if self.renderMessage {
-				msgText, _ = FormatMessage(publisherHandle, handle, EvtFormatMessageEvent)
+		started := time.Now()
+		if profile.localized() {
+			publisher, publisherHandleErr = openLocalizedPublisher(renderedFields, configuredLocale)
+		}
+		if profile.localized() && publisherHandleErr == nil {
+			if profile.Message {
+				msgText, _ = formatEventMessage(messageTemplates, publisher, handle, providerName, eventId, version, xml, xmlErr)
+			}
+
+			if profile.LevelText {
+				lvlText, _ = publisher.formatMessage(handle, EvtFormatMessageLevel)
 			}
 
-			if self.renderLevel {
-				lvlText, _ = FormatMessage(publisherHandle, handle, EvtFormatMessageLevel)
+			if profile.TaskText {
+				taskText, _ = publisher.formatMessage(handle, EvtFormatMessageTask)
 			}
 
-			if self.renderTask {
-				taskText, _ = FormatMessage(publisherHandle, handle, EvtFormatMessageTask)
+			if profile.ProviderText {
+				providerText, _ = publisher.formatMessage(handle, EvtFormatMessageProvider)
 			}
 
-			if self.renderProvider {
-				providerText, _ = FormatMessage(publisherHandle, handle, EvtFormatMessageProvider)
+			if profile.OpcodeText {
+				opcodeText, _ = publisher.formatMessage(handle, EvtFormatMessageOpcode)
 			}
 
-			if self.renderOpcode {
-				opcodeText, _ = FormatMessage(publisherHandle, handle, EvtFormatMessageOpcode)
+			if profile.ChannelText {
+				channelText, _ = publisher.formatMessage(handle, EvtFormatMessageChannel)
 			}
 
-			if self.renderChannel {
-				channelText, _ = FormatMessage(publisherHandle, handle, EvtFormatMessageChannel)
+			if profile.IdText {
+				idText, _ = publisher.formatMessage(handle, EvtFormatMessageId)
 			}
 
-			if self.renderId {
-				idText, _ = FormatMessage(publisherHandle, handle, EvtFormatMessageId)
+			// Only known once the fields have been formatted
+			locale = publisher.Locale()
+			if profile.Message && renderEnglishMessage {
+				englishMsgText, _ = formatEnglishMessage(renderedFields, handle, locale, msgText)
 			}
+
+			publisher.Close()
+		}
+		if profile.localized() {
+			self.metrics.RenderDuration(RenderPhaseFormatMessage, time.Since(started))
 		}
 
-		CloseEventHandle(uint64(publisherHandle))
 		Free(unsafe.Pointer(renderedFields))
 	}
 
 	// Return an error if we couldn't render anything useful
-	if xmlErr != nil && renderedFieldsErr != nil {
+	renderedXml := renderXml && xmlErr == nil
+	renderedValues := renderValues && renderedFieldsErr == nil
+	if (renderXml || renderValues) && !renderedXml && !renderedValues {
 		return nil, fmt.Errorf("Failed to render event values and XML: %v", []error{renderedFieldsErr, xmlErr})
 	}
 
@@ -277,46 +679,186 @@ func (self *WinLogWatcher) convertEvent(handle EventHandle, subscribedChannel st
 		ProviderText:       providerText,
 		IdText:             idText,
 		PublisherHandleErr: publisherHandleErr,
+		EnglishMsg:         englishMsgText,
+		Locale:             locale,
 
 		SubscribedChannel: subscribedChannel,
 	}
+	profile.SystemFields.filter(&event)
+	if profile.EventData && renderedXml {
+		event.EventData, _ = ParseEventData(xml)
+	}
+	if !profile.Xml {
+		event.Xml = ""
+		event.XmlErr = nil
+	}
 	return &event, nil
 }
 
+// The publisher metadata used to format an event's localized fields.
+// EvtOpenPublisherMetadata accepts any locale, and only EvtFormatMessage
+// finds out that the provider has no resources for it, so that's where it
+// falls back to the default locale.
+type localizedPublisher struct {
+	handle         PublisherHandle
+	renderedFields RenderedFields
+	// The requested locale, or 0 once it has fallen back to the default
+	locale uint32
+}
+
+// Open the publisher of the event in the given locale.
+func openLocalizedPublisher(renderedFields RenderedFields, locale uint32) (*localizedPublisher, error) {
+	handle, err := GetEventPublisherHandleForLocale(renderedFields, locale)
+	if err != nil {
+		return nil, err
+	}
+	return &localizedPublisher{handle: handle, renderedFields: renderedFields, locale: locale}, nil
+}
+
+// Call `format` with the publisher handle. If the provider has no messages
+// in the locale, reopen the publisher in the default locale and try again.
+func (self *localizedPublisher) format(format func(PublisherHandle) (string, error)) (string, error) {
+	text, err := format(self.handle)
+	if self.locale == 0 || !IsSystemError(err, ERROR_EVT_MESSAGE_LOCALE_NOT_FOUND) {
+		return text, err
+	}
+	handle, openErr := GetEventPublisherHandleForLocale(self.renderedFields, 0)
+	if openErr != nil {
+		return text, err
+	}
+	CloseEventHandle(uint64(self.handle))
+	self.handle, self.locale = handle, 0
+	return format(self.handle)
+}
+
+func (self *localizedPublisher) formatMessage(eventHandle EventHandle, flags EVT_FORMAT_MESSAGE_FLAGS) (string, error) {
+	return self.format(func(publisherHandle PublisherHandle) (string, error) {
+		return FormatMessage(publisherHandle, eventHandle, flags)
+	})
+}
+
+// The locale that messages have actually been formatted in.
+func (self *localizedPublisher) Locale() uint32 {
+	if self.locale == 0 {
+		return GetDefaultLocale()
+	}
+	return self.locale
+}
+
+func (self *localizedPublisher) Close() {
+	CloseEventHandle(uint64(self.handle))
+}
+
+// Get the US English version of the event message, re-using the localized one
+// if it's already in English.
+func formatEnglishMessage(renderedFields RenderedFields, handle EventHandle, locale uint32, msgText string) (string, error) {
+	if locale == LocaleEnglishUS {
+		return msgText, nil
+	}
+	publisherHandle, err := GetEventPublisherHandleForLocale(renderedFields, LocaleEnglishUS)
+	if err != nil {
+		return "", err
+	}
+	defer CloseEventHandle(uint64(publisherHandle))
+	return FormatMessage(publisherHandle, handle, EvtFormatMessageEvent)
+}
+
+// Format the event message from the cached template if possible, falling back
+// to EvtFormatMessage.
+func formatEventMessage(cache *MessageTemplateCache, publisher *localizedPublisher, handle EventHandle, providerName string, eventId, version uint64, xml string, xmlErr error) (string, error) {
+	if cache == nil || xmlErr != nil {
+		return publisher.formatMessage(handle, EvtFormatMessageEvent)
+	}
+	template, ok := cache.Template(providerName, eventId, version, func() (string, error) {
+		return publisher.format(func(publisherHandle PublisherHandle) (string, error) {
+			return GetMessageTemplate(publisherHandle, eventId, version)
+		})
+	})
+	if !ok {
+		return publisher.formatMessage(handle, EvtFormatMessageEvent)
+	}
+	fields, err := ParseEventData(xml)
+	if err != nil {
+		return publisher.formatMessage(handle, EvtFormatMessageEvent)
+	}
+	// Parameters come from the provider's parameter file (msobjs.dll for
+	// Security), not its message file. The file is loaded in the UI language,
+	// so in other locales, or if a message refers to a parameter that isn't
+	// there, EvtFormatMessage resolves it instead.
+	parameterTable := cache.Parameters(providerName, func() (map[uint64]string, error) {
+		if publisher.locale != 0 {
+			return nil, fmt.Errorf("Parameter files are only loaded in the default locale")
+		}
+		return LoadPublisherParameters(publisher.handle)
+	})
+	parameters := func(id uint64) (string, bool) {
+		text, ok := parameterTable[id]
+		return text, ok
+	}
+	msg, err := FormatMessageTemplate(template, eventDataValues(fields), parameters)
+	if err != nil {
+		return publisher.formatMessage(handle, EvtFormatMessageEvent)
+	}
+	return msg, nil
+}
+
 func (self *WinLogWatcher) PublishEvent(handle EventHandle, subscribedChannel string) {
+	self.metrics.EventReceived(subscribedChannel)
 
 	// Convert the event from the event log schema
 	event, err := self.convertEvent(handle, subscribedChannel)
 	if err != nil {
-		self.PublishError(err)
+		self.metrics.EventDropped(subscribedChannel, DropReasonRenderError)
+		self.publishRenderError(subscribedChannel, err)
 		return
 	}
+	self.publishConverted(handle, subscribedChannel, event)
+}
 
+// Update the channel's bookmark with a converted event and publish it.
+func (self *WinLogWatcher) publishConverted(handle EventHandle, subscribedChannel string, event *WinLogEvent) {
 	// Get the bookmark for the channel
 	self.watchMutex.Lock()
 	watch, ok := self.watches[subscribedChannel]
 	self.watchMutex.Unlock()
 	if !ok {
+		self.metrics.EventDropped(subscribedChannel, DropReasonUnsubscribed)
 		self.errChan <- fmt.Errorf("No handle for channel bookmark %q", subscribedChannel)
 		return
 	}
 
 	// Update the bookmark with the current event
+	started := time.Now()
 	UpdateBookmark(watch.bookmark, handle)
 
-	// Serialize the boomark as XML and include it in the event
-	bookmarkXml, err := RenderBookmark(watch.bookmark)
-	if err != nil {
-		self.PublishError(fmt.Errorf("Error rendering bookmark for event - %v", err))
-		return
+	// Serialize the boomark as XML and include it in the event, if it's due.
+	// Events are published one at a time per channel, so the count doesn't need a lock.
+	watch.published++
+	if self.ChannelRenderProfile(subscribedChannel).renderBookmark(watch.published) {
+		bookmarkXml, err := RenderBookmark(watch.bookmark)
+		if err != nil {
+			self.metrics.EventDropped(subscribedChannel, DropReasonBookmarkError)
+			watch.stats.failed(err, time.Now())
+			self.logger.Error("Failed to render bookmark", "channel", subscribedChannel, "subscription", watch.id, "error", err)
+			self.PublishError(fmt.Errorf("Error rendering bookmark for event - %v", err))
+			return
+		}
+		event.Bookmark = bookmarkXml
 	}
-	event.Bookmark = bookmarkXml
+	self.metrics.RenderDuration(RenderPhaseBookmark, time.Since(started))
 
 	// Don't block when shutting down if the consumer has gone away
 	select {
 	case self.eventChan <- event:
 	case <-self.shutdown:
+		self.metrics.EventDropped(subscribedChannel, DropReasonShutdown)
 		return
 	}
+	watch.stats.delivered(event, time.Now())
+	self.metrics.EventDelivered(subscribedChannel)
+	// Lag can only be measured if the profile includes the creation time
+	if !event.Created.IsZero() {
+		self.metrics.EventLag(subscribedChannel, time.Since(event.Created))
+	}
 
 }
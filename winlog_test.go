@@ -4,6 +4,7 @@ package winlog
 
 import (
 	. "testing"
+	"time"
 )
 
 func TestWinlogWatcherConfiguresRendering(t *T) {
@@ -18,13 +19,13 @@ func TestWinlogWatcherConfiguresRendering(t *T) {
 	watcher.SetRenderChannel(false)
 	watcher.SetRenderId(false)
 
-	assertEqual(watcher.renderMessage, false, t)
-	assertEqual(watcher.renderLevel, false, t)
-	assertEqual(watcher.renderTask, false, t)
-	assertEqual(watcher.renderProvider, false, t)
-	assertEqual(watcher.renderOpcode, false, t)
-	assertEqual(watcher.renderChannel, false, t)
-	assertEqual(watcher.renderId, false, t)
+	assertEqual(watcher.profile.Message, false, t)
+	assertEqual(watcher.profile.LevelText, false, t)
+	assertEqual(watcher.profile.TaskText, false, t)
+	assertEqual(watcher.profile.ProviderText, false, t)
+	assertEqual(watcher.profile.OpcodeText, false, t)
+	assertEqual(watcher.profile.ChannelText, false, t)
+	assertEqual(watcher.profile.IdText, false, t)
 
 	watcher.SetRenderMessage(true)
 	watcher.SetRenderLevel(true)
@@ -34,13 +35,158 @@ func TestWinlogWatcherConfiguresRendering(t *T) {
 	watcher.SetRenderChannel(true)
 	watcher.SetRenderId(true)
 
-	assertEqual(watcher.renderMessage, true, t)
-	assertEqual(watcher.renderLevel, true, t)
-	assertEqual(watcher.renderTask, true, t)
-	assertEqual(watcher.renderProvider, true, t)
-	assertEqual(watcher.renderOpcode, true, t)
-	assertEqual(watcher.renderChannel, true, t)
-	assertEqual(watcher.renderId, true, t)
+	assertEqual(watcher.profile.Message, true, t)
+	assertEqual(watcher.profile.LevelText, true, t)
+	assertEqual(watcher.profile.TaskText, true, t)
+	assertEqual(watcher.profile.ProviderText, true, t)
+	assertEqual(watcher.profile.OpcodeText, true, t)
+	assertEqual(watcher.profile.ChannelText, true, t)
+	assertEqual(watcher.profile.IdText, true, t)
 
 	watcher.Shutdown()
 }
+
+func TestWinlogWatcherConfiguresLocale(t *T) {
+	watcher, err := NewWinLogWatcher()
+	assertEqual(err, nil, t)
+	assertEqual(watcher.locale, uint32(0), t)
+
+	watcher.SetCacheMessageTemplates(true)
+	cache := watcher.messageTemplates
+	watcher.SetLocale(LocaleEnglishUS)
+	watcher.SetRenderEnglishMessage(true)
+
+	assertEqual(watcher.locale, uint32(LocaleEnglishUS), t)
+	assertEqual(watcher.renderEnglishMessage, true, t)
+	// Templates cached for the old locale are discarded
+	if watcher.messageTemplates == cache {
+		t.Fatal("Message template cache was not reset when changing locale")
+	}
+
+	watcher.Shutdown()
+}
+
+func TestConvertEventReportsLocale(t *T) {
+	testEvent, err := getTestEventHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseEventHandle(uint64(testEvent))
+	watcher, err := NewWinLogWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Shutdown()
+	watcher.SetLocale(LocaleEnglishUS)
+	watcher.SetRenderEnglishMessage(true)
+	event, err := watcher.convertEvent(testEvent, SUBSCRIBED_CHANNEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.PublisherHandleErr != nil {
+		t.Fatal(event.PublisherHandleErr)
+	}
+	if event.Msg == "" {
+		t.Fatal("No message rendered")
+	}
+	// Hosts without US English resources fall back to the default locale
+	if event.Locale == LocaleEnglishUS {
+		assertEqual(event.EnglishMsg, event.Msg, t)
+	} else {
+		assertEqual(event.Locale, GetDefaultLocale(), t)
+	}
+}
+
+func TestConvertEventFallsBackToDefaultLocale(t *T) {
+	// Afrikaans, which providers rarely have resources for
+	const locale = 0x0436
+	if GetDefaultLocale() == locale {
+		t.Skip("The default locale is the one being tested")
+	}
+	testEvent, err := getTestEventHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseEventHandle(uint64(testEvent))
+	watcher, err := NewWinLogWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Shutdown()
+	watcher.SetLocale(locale)
+	event, err := watcher.convertEvent(testEvent, SUBSCRIBED_CHANNEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.PublisherHandleErr != nil {
+		t.Fatal(event.PublisherHandleErr)
+	}
+	if event.Msg == "" {
+		t.Fatal("No message rendered")
+	}
+	assertEqual(event.Locale, GetDefaultLocale(), t)
+}
+
+func TestWinlogWatcherRenderWorkers(t *T) {
+	watcher, err := NewWinLogWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Shutdown()
+	if err := watcher.SetRenderWorkers(4, 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.SubscribeFromBeginning("Application", "*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.SetRenderWorkers(2, 2); err == nil {
+		t.Fatal("No error setting render workers after subscribing")
+	}
+
+	// Record IDs from a single channel are published in order
+	var lastRecordId uint64
+	for i := 0; i < 10; i++ {
+		select {
+		case event := <-watcher.Event():
+			if event.RecordId <= lastRecordId {
+				t.Fatalf("Record %v published after %v", event.RecordId, lastRecordId)
+			}
+			lastRecordId = event.RecordId
+		case err := <-watcher.Error():
+			t.Fatal(err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Only %v events were published from the Application log", i)
+		}
+	}
+}
+
+func TestWinlogWatcherChannelRenderProfile(t *T) {
+	watcher, err := NewWinLogWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Shutdown()
+	assertEqual(watcher.RenderProfile(), RenderProfileFull, t)
+
+	watcher.SetChannelRenderProfile(SUBSCRIBED_CHANNEL, &RenderProfileMinimal)
+	assertEqual(watcher.ChannelRenderProfile(SUBSCRIBED_CHANNEL), RenderProfileMinimal, t)
+	assertEqual(watcher.ChannelRenderProfile("Other"), RenderProfileFull, t)
+
+	testEvent, err := getTestEventHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseEventHandle(uint64(testEvent))
+	event, err := watcher.convertEvent(testEvent, SUBSCRIBED_CHANNEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(event.Xml, "", t)
+	assertEqual(event.Msg, "", t)
+	if event.RecordId == 0 {
+		t.Fatal("System fields were not rendered with the minimal profile")
+	}
+
+	watcher.SetChannelRenderProfile(SUBSCRIBED_CHANNEL, nil)
+	assertEqual(watcher.ChannelRenderProfile(SUBSCRIBED_CHANNEL), RenderProfileFull, t)
+}